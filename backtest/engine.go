@@ -0,0 +1,249 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package backtest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/saniales/golang-crypto-trading-bot/exchanges"
+	"github.com/saniales/golang-crypto-trading-bot/strategies"
+	"github.com/shopspring/decimal"
+)
+
+// Config contains the parameters of a backtest run.
+type Config struct {
+	QuoteCurrency string                 // Represents the currency used to express equity and P&L (e.g. BTC).
+	Step          time.Duration          // Represents the simulated clock step, if zero the strategy interval (or the candle period) is used.
+	Params        map[string]interface{} // Represents the parameters the strategy is applied with, as in the configuration file.
+}
+
+// EquityPoint represents the value of the portfolio at a simulated time.
+type EquityPoint struct {
+	Time   time.Time       // Represents the simulated time.
+	Equity decimal.Decimal // Represents the total equity, in quote currency.
+}
+
+// Result contains the outcome of a backtest run.
+type Result struct {
+	Start         time.Time       // Represents the simulated time when the run started.
+	End           time.Time       // Represents the simulated time when the run ended.
+	QuoteCurrency string          // Represents the currency used to express equity and P&L.
+	InitialEquity decimal.Decimal // Represents the equity before the strategy ran.
+	FinalEquity   decimal.Decimal // Represents the equity after the strategy ran.
+	Trades        []Trade         // Represents the trades filled during the run, in chronological order.
+	EquityCurve   []EquityPoint   // Represents the equity after each strategy update.
+}
+
+// PnL returns the profit (or loss, if negative) of the run, in quote currency.
+func (result *Result) PnL() decimal.Decimal {
+	return result.FinalEquity.Sub(result.InitialEquity)
+}
+
+// String returns the string representation of the object.
+func (result *Result) String() string {
+	ret := fmt.Sprintln("Period:", result.Start.Format(time.RFC3339), "-", result.End.Format(time.RFC3339))
+	ret += fmt.Sprintln("Initial Equity:", result.InitialEquity, result.QuoteCurrency)
+	ret += fmt.Sprintln("Final Equity:", result.FinalEquity, result.QuoteCurrency)
+	ret += fmt.Sprintln("P&L:", result.PnL(), result.QuoteCurrency)
+	ret += fmt.Sprintln("Trades:", len(result.Trades))
+	return strings.TrimSpace(ret)
+}
+
+// modelOf extracts the model and the update interval of a strategy.
+func modelOf(strategy strategies.Strategy) (strategies.StrategyModel, time.Duration, error) {
+	switch s := strategy.(type) {
+	case strategies.IntervalStrategy:
+		return s.Model, s.Interval, nil
+	case *strategies.IntervalStrategy:
+		return s.Model, s.Interval, nil
+	case strategies.WebsocketStrategy:
+		return s.Model, 0, nil
+	case *strategies.WebsocketStrategy:
+		return s.Model, 0, nil
+	default:
+		return strategies.StrategyModel{}, 0, fmt.Errorf("Cannot backtest strategy %s: it does not expose a strategy model", strategy.Name())
+	}
+}
+
+// Run replays the candle series of the simulated exchanges through a strategy.
+//
+// The strategy model is driven by a simulated clock: on each step OnUpdate is called
+// and the pending limit orders are matched against the candles closed in the meantime.
+// As in IntervalStrategy, the run stops at the first error returned by the model.
+func Run(strategy strategies.Strategy, simulatedExchanges []*Exchange, markets []*environment.Market, config Config) (*Result, error) {
+	model, interval, err := modelOf(strategy)
+	if err != nil {
+		return nil, err
+	}
+	setup, update, tearDown := model.SetupFunc(), model.UpdateFunc(), model.TearDownFunc()
+	if update == nil {
+		return nil, errors.New("OnUpdate func cannot be empty")
+	}
+	tactic, err := strategies.NewTactic(strategy, markets, config.Params)
+	if err != nil {
+		return nil, err
+	}
+	if tacticInterval := tactic.Params.Duration(strategies.IntervalParam); interval > 0 && tacticInterval > 0 {
+		interval = tacticInterval
+	}
+
+	start, end, period, err := timeBounds(simulatedExchanges)
+	if err != nil {
+		return nil, err
+	}
+
+	step := config.Step
+	if step == 0 {
+		step = interval
+	}
+	if step == 0 {
+		step = period
+	}
+	if step <= 0 {
+		return nil, errors.New("Cannot infer the simulated clock step, please specify one")
+	}
+
+	clock := exchanges.NewVirtualClock(start)
+	wrappers := make([]exchanges.ExchangeWrapper, len(simulatedExchanges))
+	for i, exchange := range simulatedExchanges {
+		exchange.clock = clock
+		wrappers[i] = exchange
+	}
+
+	result := &Result{
+		Start:         start,
+		QuoteCurrency: config.QuoteCurrency,
+		InitialEquity: equity(simulatedExchanges, markets, config.QuoteCurrency),
+	}
+
+	hasErrorFunc := model.OnError != nil
+	if setup != nil {
+		err = setup(wrappers, markets, tactic)
+		if err != nil && hasErrorFunc {
+			model.OnError(err)
+		}
+	}
+
+	for err == nil && !clock.Now().After(end) {
+		for _, exchange := range simulatedExchanges {
+			exchange.matchOrders()
+		}
+
+		err = update(wrappers, markets, tactic)
+		if err != nil && hasErrorFunc {
+			model.OnError(err)
+		}
+
+		result.EquityCurve = append(result.EquityCurve, EquityPoint{
+			Time:   clock.Now(),
+			Equity: equity(simulatedExchanges, markets, config.QuoteCurrency),
+		})
+		clock.Advance(step)
+	}
+	strategyErr := err
+
+	if tearDown != nil {
+		err = tearDown(wrappers, markets, tactic)
+		if err != nil && hasErrorFunc {
+			model.OnError(err)
+		}
+	}
+
+	if n := len(result.EquityCurve); n > 0 {
+		result.End = result.EquityCurve[n-1].Time
+		result.FinalEquity = result.EquityCurve[n-1].Equity
+	} else {
+		result.End = start
+		result.FinalEquity = result.InitialEquity
+	}
+
+	for _, exchange := range simulatedExchanges {
+		result.Trades = append(result.Trades, exchange.Trades()...)
+	}
+	sort.SliceStable(result.Trades, func(i, j int) bool {
+		return result.Trades[i].Time.Before(result.Trades[j].Time)
+	})
+
+	if strategyErr != nil {
+		return result, fmt.Errorf("Strategy stopped at %s: %s", result.End.Format(time.RFC3339), strategyErr)
+	}
+	return result, nil
+}
+
+// timeBounds returns the time when the first candle closes, the time when the last candle closes and
+// the smallest candle period among all the series.
+func timeBounds(simulatedExchanges []*Exchange) (time.Time, time.Time, time.Duration, error) {
+	var start, end time.Time
+	var period time.Duration
+	found := false
+
+	for _, exchange := range simulatedExchanges {
+		for _, series := range exchange.series {
+			if series.Len() == 0 {
+				continue
+			}
+			first, last := series.CloseTime(0), series.CloseTime(series.Len()-1)
+			if !found || first.Before(start) {
+				start = first
+			}
+			if !found || last.After(end) {
+				end = last
+			}
+			if series.Period > 0 && (period == 0 || series.Period < period) {
+				period = series.Period
+			}
+			found = true
+		}
+	}
+
+	if !found {
+		return start, end, period, errors.New("No candle data to backtest")
+	}
+	return start, end, period, nil
+}
+
+// equity returns the value of all the balances in the simulated exchanges, expressed in quote currency.
+//
+// Balances of coins without a market priced in quote currency are ignored.
+func equity(simulatedExchanges []*Exchange, markets []*environment.Market, quoteCurrency string) decimal.Decimal {
+	total := decimal.Zero
+	for _, exchange := range simulatedExchanges {
+		for coin, balance := range exchange.totalBalances() {
+			if coin == quoteCurrency {
+				total = total.Add(balance)
+				continue
+			}
+
+			for _, market := range markets {
+				if market.BaseCurrency != quoteCurrency || market.MarketCurrency != coin {
+					continue
+				}
+				price, err := exchange.lastPrice(market)
+				if err != nil {
+					continue
+				}
+				total = total.Add(balance.Mul(price))
+				break
+			}
+		}
+	}
+	return total
+}
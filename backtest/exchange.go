@@ -0,0 +1,486 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package backtest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/saniales/golang-crypto-trading-bot/exchanges"
+	"github.com/shopspring/decimal"
+)
+
+// Trade represents a filled order during a backtest.
+type Trade struct {
+	Time     time.Time             // Represents the simulated time of the fill.
+	Exchange string                // Represents the name of the exchange.
+	Market   string                // Represents the market name as seen from the exchange.
+	Type     environment.OrderType // Represents the side of the trade (Bid for buys, Ask for sells).
+	Price    decimal.Decimal       // Represents the fill price, in base currency.
+	Quantity decimal.Decimal       // Represents the filled quantity, in market currency.
+	Fee      decimal.Decimal       // Represents the paid fee, in base currency.
+	OrderID  string                // Represents the ID of the filled order.
+}
+
+// pendingOrder represents a limit order waiting to be filled.
+type pendingOrder struct {
+	id        string
+	market    *environment.Market
+	orderType environment.OrderType
+	quantity  decimal.Decimal
+	limit     decimal.Decimal
+	placedAt  time.Time
+}
+
+// Exchange is a simulated exchange which serves recorded candles and fills orders against them.
+//
+//	Prices are the close of the last candle closed at the current simulated time.
+type Exchange struct {
+	name     string
+	clock    *exchanges.VirtualClock
+	fee      decimal.Decimal
+	mutex    *sync.Mutex
+	series   map[string]*exchanges.CandleSeries // exchange market name -> candles
+	balances map[string]decimal.Decimal
+	reserved map[string]decimal.Decimal
+	pending  []*pendingOrder
+	orders   map[string]*environment.OrderInfo
+	trades   []Trade
+	orderSeq int
+}
+
+// NewExchange creates a new simulated exchange with the specified name (used to resolve market names),
+// starting balances and fee rate (e.g. 0.001 for 0.1%).
+func NewExchange(name string, initialBalances map[string]decimal.Decimal, fee decimal.Decimal) *Exchange {
+	balances := make(map[string]decimal.Decimal, len(initialBalances))
+	for coin, balance := range initialBalances {
+		balances[coin] = balance
+	}
+
+	return &Exchange{
+		name:     name,
+		clock:    exchanges.NewVirtualClock(time.Time{}),
+		fee:      fee,
+		mutex:    &sync.Mutex{},
+		series:   make(map[string]*exchanges.CandleSeries),
+		balances: balances,
+		reserved: make(map[string]decimal.Decimal),
+		orders:   make(map[string]*environment.OrderInfo),
+	}
+}
+
+// AddSeries binds a candle series to a market, identified by its name as seen from the exchange.
+func (exchange *Exchange) AddSeries(marketName string, series *exchanges.CandleSeries) {
+	exchange.series[marketName] = series
+}
+
+// Trades returns the trades filled so far.
+func (exchange *Exchange) Trades() []Trade {
+	exchange.mutex.Lock()
+	defer exchange.mutex.Unlock()
+	return append([]Trade(nil), exchange.trades...)
+}
+
+// Name returns the name of the simulated exchange.
+func (exchange *Exchange) Name() string {
+	return exchange.name
+}
+
+// String returns a string representation of the simulated exchange.
+func (exchange *Exchange) String() string {
+	return fmt.Sprint(exchange.name, "backtest")
+}
+
+// GetMarkets is not supported in backtests, since the currencies of the candle series are not known.
+func (exchange *Exchange) GetMarkets() ([]*environment.Market, error) {
+	return nil, errors.New("Markets info not available in backtest")
+}
+
+// closedCandles returns the candles of a market closed at the current simulated time.
+func (exchange *Exchange) closedCandles(market *environment.Market) ([]environment.CandleStick, error) {
+	series, exists := exchange.series[exchanges.MarketNameFor(market, exchange)]
+	if !exists {
+		return nil, fmt.Errorf("No candle data for market %s", market)
+	}
+
+	n := series.ClosedBefore(exchange.clock.Now())
+	if n == 0 {
+		return nil, errors.New("No candle data yet")
+	}
+
+	return series.Candles[:n], nil
+}
+
+// lastPrice returns the close of the last closed candle of a market.
+func (exchange *Exchange) lastPrice(market *environment.Market) (decimal.Decimal, error) {
+	candles, err := exchange.closedCandles(market)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return candles[len(candles)-1].Close, nil
+}
+
+// GetCandles gets the candles closed at the current simulated time.
+//
+// If a time_frame is configured for the market, the candles are aggregated into candles of that timeframe.
+func (exchange *Exchange) GetCandles(market *environment.Market) ([]environment.CandleStick, error) {
+	candles, err := exchange.closedCandles(market)
+	if err != nil {
+		return nil, err
+	}
+	candles, err = exchanges.AggregateCandles(candles, exchanges.MarketTimeFrameFor(market, exchange))
+	if err != nil {
+		return nil, err
+	}
+	return append([]environment.CandleStick(nil), candles...), nil
+}
+
+// GetMarketSummary gets the market summary of the last 24 hours of simulated time.
+func (exchange *Exchange) GetMarketSummary(market *environment.Market) (*environment.MarketSummary, error) {
+	series := exchange.series[exchanges.MarketNameFor(market, exchange)]
+	candles, err := exchange.closedCandles(market)
+	if err != nil {
+		return nil, err
+	}
+
+	last := candles[len(candles)-1]
+	summary := &environment.MarketSummary{
+		High: last.High,
+		Low:  last.Low,
+		Ask:  last.Close,
+		Bid:  last.Close,
+		Last: last.Close,
+	}
+
+	from := exchange.clock.Now().Add(-24 * time.Hour)
+	for i := len(candles) - 1; i >= 0 && series.CloseTime(i).After(from); i-- {
+		summary.High = decimal.Max(summary.High, candles[i].High)
+		summary.Low = decimal.Min(summary.Low, candles[i].Low)
+		summary.Volume = summary.Volume.Add(candles[i].Volume)
+	}
+
+	return summary, nil
+}
+
+// GetOrderBook gets a synthetic order book made of a single level per side at the last close price,
+// with the volume of the last candle as depth.
+func (exchange *Exchange) GetOrderBook(market *environment.Market) (*environment.OrderBook, error) {
+	candles, err := exchange.closedCandles(market)
+	if err != nil {
+		return nil, err
+	}
+
+	last := candles[len(candles)-1]
+	level := environment.Order{
+		Value:     last.Close,
+		Quantity:  last.Volume,
+		Timestamp: exchange.clock.Now(),
+	}
+
+	return &environment.OrderBook{
+		Asks: []environment.Order{level},
+		Bids: []environment.Order{level},
+	}, nil
+}
+
+// BuyLimit places a limit buy order, filled as soon as a candle trades at or below the limit.
+func (exchange *Exchange) BuyLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return exchange.placeLimit(market, environment.Bid, amount, limit)
+}
+
+// SellLimit places a limit sell order, filled as soon as a candle trades at or above the limit.
+func (exchange *Exchange) SellLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return exchange.placeLimit(market, environment.Ask, amount, limit)
+}
+
+// BuyMarket buys at the last close price.
+func (exchange *Exchange) BuyMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
+	return exchange.placeMarket(market, environment.Bid, amount)
+}
+
+// SellMarket sells at the last close price.
+func (exchange *Exchange) SellMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
+	return exchange.placeMarket(market, environment.Ask, amount)
+}
+
+func (exchange *Exchange) placeMarket(market *environment.Market, orderType environment.OrderType, quantity decimal.Decimal) (string, error) {
+	if !quantity.IsPositive() {
+		return "", errors.New("Order amount must be > 0")
+	}
+
+	price, err := exchange.lastPrice(market)
+	if err != nil {
+		return "", err
+	}
+
+	exchange.mutex.Lock()
+	defer exchange.mutex.Unlock()
+
+	if err := exchange.checkBalance(market, orderType, quantity, price); err != nil {
+		return "", err
+	}
+
+	id := exchange.nextOrderID(orderType)
+	exchange.orders[id] = &environment.OrderInfo{
+		ID:        id,
+		Market:    market,
+		Type:      orderType,
+		Status:    environment.OrderNew,
+		Quantity:  quantity,
+		Timestamp: exchange.clock.Now(),
+	}
+	exchange.fill(market, orderType, quantity, price, id)
+	return id, nil
+}
+
+func (exchange *Exchange) placeLimit(market *environment.Market, orderType environment.OrderType, quantity decimal.Decimal, limit decimal.Decimal) (string, error) {
+	if !quantity.IsPositive() || !limit.IsPositive() {
+		return "", errors.New("Order amount and limit must be > 0")
+	}
+
+	if _, err := exchange.closedCandles(market); err != nil {
+		return "", err
+	}
+
+	exchange.mutex.Lock()
+	defer exchange.mutex.Unlock()
+
+	if err := exchange.checkBalance(market, orderType, quantity, limit); err != nil {
+		return "", err
+	}
+
+	order := &pendingOrder{
+		id:        exchange.nextOrderID(orderType),
+		market:    market,
+		orderType: orderType,
+		quantity:  quantity,
+		limit:     limit,
+		placedAt:  exchange.clock.Now(),
+	}
+	coin, amount := order.reservation(exchange.fee)
+	exchange.balances[coin] = exchange.balances[coin].Sub(amount)
+	exchange.reserved[coin] = exchange.reserved[coin].Add(amount)
+	exchange.pending = append(exchange.pending, order)
+	exchange.orders[order.id] = &environment.OrderInfo{
+		ID:        order.id,
+		Market:    market,
+		Type:      orderType,
+		Status:    environment.OrderNew,
+		Quantity:  quantity,
+		Limit:     limit,
+		Timestamp: order.placedAt,
+	}
+
+	return order.id, nil
+}
+
+// reservation returns the coin and the amount locked by a pending order.
+func (order *pendingOrder) reservation(fee decimal.Decimal) (string, decimal.Decimal) {
+	if order.orderType == environment.Bid {
+		cost := order.quantity.Mul(order.limit)
+		return order.market.BaseCurrency, cost.Add(cost.Mul(fee))
+	}
+	return order.market.MarketCurrency, order.quantity
+}
+
+// checkBalance checks whether the free balance is enough to place an order.
+func (exchange *Exchange) checkBalance(market *environment.Market, orderType environment.OrderType, quantity decimal.Decimal, price decimal.Decimal) error {
+	if orderType == environment.Bid {
+		cost := quantity.Mul(price)
+		if cost.Add(cost.Mul(exchange.fee)).GreaterThan(exchange.balances[market.BaseCurrency]) {
+			return fmt.Errorf("Cannot Buy: not enough %s balance", market.BaseCurrency)
+		}
+		return nil
+	}
+
+	if quantity.GreaterThan(exchange.balances[market.MarketCurrency]) {
+		return fmt.Errorf("Cannot Sell: not enough %s balance", market.MarketCurrency)
+	}
+	return nil
+}
+
+// fill moves the balances of a filled order, records the trade and marks the order as filled, the caller must hold the lock.
+func (exchange *Exchange) fill(market *environment.Market, orderType environment.OrderType, quantity decimal.Decimal, price decimal.Decimal, id string) {
+	value := quantity.Mul(price)
+	fee := value.Mul(exchange.fee)
+
+	if orderType == environment.Bid {
+		exchange.balances[market.BaseCurrency] = exchange.balances[market.BaseCurrency].Sub(value).Sub(fee)
+		exchange.balances[market.MarketCurrency] = exchange.balances[market.MarketCurrency].Add(quantity)
+	} else {
+		exchange.balances[market.MarketCurrency] = exchange.balances[market.MarketCurrency].Sub(quantity)
+		exchange.balances[market.BaseCurrency] = exchange.balances[market.BaseCurrency].Add(value).Sub(fee)
+	}
+
+	if info, exists := exchange.orders[id]; exists {
+		info.Status = environment.OrderFilled
+		info.FilledQuantity = quantity
+		info.AveragePrice = price
+		info.Fee = fee
+		info.FeeCurrency = market.BaseCurrency
+	}
+
+	exchange.trades = append(exchange.trades, Trade{
+		Time:     exchange.clock.Now(),
+		Exchange: exchange.name,
+		Market:   exchanges.MarketNameFor(market, exchange),
+		Type:     orderType,
+		Price:    price,
+		Quantity: quantity,
+		Fee:      fee,
+		OrderID:  id,
+	})
+}
+
+func (exchange *Exchange) nextOrderID(orderType environment.OrderType) string {
+	exchange.orderSeq++
+	if orderType == environment.Bid {
+		return fmt.Sprintf("BACKTEST_BUY-%d", exchange.orderSeq)
+	}
+	return fmt.Sprintf("BACKTEST_SELL-%d", exchange.orderSeq)
+}
+
+// matchOrders fills the pending limit orders crossed by the candles closed since they were placed.
+func (exchange *Exchange) matchOrders() {
+	exchange.mutex.Lock()
+	defer exchange.mutex.Unlock()
+
+	now := exchange.clock.Now()
+	stillPending := exchange.pending[:0]
+	for _, order := range exchange.pending {
+		series := exchange.series[exchanges.MarketNameFor(order.market, exchange)]
+		filled := false
+		for i := series.ClosedBefore(order.placedAt); i < series.ClosedBefore(now); i++ {
+			candle := series.Candles[i]
+			if order.orderType == environment.Bid && candle.Low.LessThanOrEqual(order.limit) ||
+				order.orderType == environment.Ask && candle.High.GreaterThanOrEqual(order.limit) {
+				filled = true
+				break
+			}
+		}
+
+		if !filled {
+			stillPending = append(stillPending, order)
+			continue
+		}
+
+		coin, amount := order.reservation(exchange.fee)
+		exchange.reserved[coin] = exchange.reserved[coin].Sub(amount)
+		exchange.balances[coin] = exchange.balances[coin].Add(amount)
+		exchange.fill(order.market, order.orderType, order.quantity, order.limit, order.id)
+	}
+	exchange.pending = stillPending
+}
+
+// GetOrder gets the status of an order placed during the backtest.
+func (exchange *Exchange) GetOrder(market *environment.Market, orderID string) (*environment.OrderInfo, error) {
+	exchange.mutex.Lock()
+	defer exchange.mutex.Unlock()
+
+	info, exists := exchange.orders[orderID]
+	if !exists {
+		return nil, exchanges.ErrOrderNotFound
+	}
+	ret := *info
+	return &ret, nil
+}
+
+// GetOpenOrders gets the pending limit orders of a market.
+func (exchange *Exchange) GetOpenOrders(market *environment.Market) ([]*environment.OrderInfo, error) {
+	exchange.mutex.Lock()
+	defer exchange.mutex.Unlock()
+
+	ret := make([]*environment.OrderInfo, 0, len(exchange.pending))
+	for _, order := range exchange.pending {
+		if order.market.Name == market.Name {
+			info := *exchange.orders[order.id]
+			ret = append(ret, &info)
+		}
+	}
+	return ret, nil
+}
+
+// CancelOrder cancels a pending limit order, releasing its reserved balance.
+func (exchange *Exchange) CancelOrder(market *environment.Market, orderID string) error {
+	exchange.mutex.Lock()
+	defer exchange.mutex.Unlock()
+
+	for i, order := range exchange.pending {
+		if order.id != orderID {
+			continue
+		}
+
+		coin, amount := order.reservation(exchange.fee)
+		exchange.reserved[coin] = exchange.reserved[coin].Sub(amount)
+		exchange.balances[coin] = exchange.balances[coin].Add(amount)
+		exchange.pending = append(exchange.pending[:i], exchange.pending[i+1:]...)
+		exchange.orders[orderID].Status = environment.OrderCancelled
+		return nil
+	}
+
+	if _, exists := exchange.orders[orderID]; exists {
+		return fmt.Errorf("Cannot cancel order %s: order is closed", orderID)
+	}
+	return exchanges.ErrOrderNotFound
+}
+
+// CalculateTradingFees calculates the trading fees for an order on a specified market.
+func (exchange *Exchange) CalculateTradingFees(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal, orderType exchanges.TradeType) decimal.Decimal {
+	return amount.Mul(limit).Mul(exchange.fee)
+}
+
+// CalculateWithdrawFees calculates the withdrawal fees on a specified market.
+func (exchange *Exchange) CalculateWithdrawFees(market *environment.Market, amount decimal.Decimal) decimal.Decimal {
+	return decimal.Zero
+}
+
+// GetBalance gets the free balance of the specified currency.
+func (exchange *Exchange) GetBalance(symbol string) (*decimal.Decimal, error) {
+	exchange.mutex.Lock()
+	defer exchange.mutex.Unlock()
+	bal := exchange.balances[symbol]
+	return &bal, nil
+}
+
+// totalBalances returns the balances including the amounts reserved by pending orders.
+func (exchange *Exchange) totalBalances() map[string]decimal.Decimal {
+	exchange.mutex.Lock()
+	defer exchange.mutex.Unlock()
+
+	ret := make(map[string]decimal.Decimal, len(exchange.balances))
+	for coin, balance := range exchange.balances {
+		ret[coin] = balance.Add(exchange.reserved[coin])
+	}
+	return ret
+}
+
+// GetDepositAddress gets the deposit address for the specified coin on the exchange.
+func (exchange *Exchange) GetDepositAddress(coinTicker string) (string, bool) {
+	return "", false
+}
+
+// FeedConnect does nothing, as data is driven by the simulated clock.
+func (exchange *Exchange) FeedConnect(markets []*environment.Market) error {
+	return nil
+}
+
+// Withdraw is not supported during a backtest.
+func (exchange *Exchange) Withdraw(destinationAddress string, coinTicker string, amount decimal.Decimal) error {
+	return errors.New("Withdraw is not supported during a backtest")
+}
@@ -4,39 +4,67 @@ import (
 	"github.com/saniales/golang-crypto-trading-bot/environment"
 	"github.com/saniales/golang-crypto-trading-bot/exchanges"
 	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
 )
 
 //InitExchange initialize a new ExchangeWrapper binded to the specified exchange provided.
+//
+//The exchange is created by the factory registered with its name (see exchanges.Register).
 func InitExchange(exchangeConfig environment.ExchangeConfig, simulatedMode bool, fakeBalances map[string]decimal.Decimal, depositAddresses map[string]string) exchanges.ExchangeWrapper {
 	if depositAddresses == nil && !simulatedMode {
 		return nil
 	}
 
-	var exch exchanges.ExchangeWrapper
-	switch exchangeConfig.ExchangeName {
-	case "bittrex":
-		exch = exchanges.NewBittrexWrapper(exchangeConfig.PublicKey, exchangeConfig.SecretKey, depositAddresses)
-	case "bittrexV2":
-		exch = exchanges.NewBittrexV2Wrapper(exchangeConfig.PublicKey, exchangeConfig.SecretKey, depositAddresses)
-	case "poloniex":
-		exch = exchanges.NewPoloniexWrapper(exchangeConfig.PublicKey, exchangeConfig.SecretKey, depositAddresses)
-	case "binance":
-		exch = exchanges.NewBinanceWrapper(exchangeConfig.PublicKey, exchangeConfig.SecretKey, depositAddresses)
-	case "bitfinex":
-		exch = exchanges.NewBitfinexWrapper(exchangeConfig.PublicKey, exchangeConfig.SecretKey, depositAddresses)
-	case "hitbtc":
-		exch = exchanges.NewHitBtcV2Wrapper(exchangeConfig.PublicKey, exchangeConfig.SecretKey, depositAddresses)
-	case "kucoin":
-		exch = exchanges.NewKucoinWrapper(exchangeConfig.PublicKey, exchangeConfig.SecretKey, depositAddresses)
-	default:
+	factory, exists := exchanges.GetFactory(exchangeConfig.ExchangeName)
+	if !exists {
 		return nil
 	}
 
+	exchangeConfig.DepositAddresses = depositAddresses
+	exch, err := factory(exchangeConfig)
+	if err != nil {
+		logrus.Errorf("Cannot create exchange %s: %s", exchangeConfig.ExchangeName, err)
+		return nil
+	}
+
+	if exchangeConfig.RateLimit != nil {
+		if limiter := exchanges.GetRateLimiter(exch); limiter != nil {
+			limiter.Configure(*exchangeConfig.RateLimit)
+		} else {
+			logrus.Warnf("Exchange %s does not limit the rate of its calls, rate_limit ignored", exchangeConfig.ExchangeName)
+		}
+	}
+
+	if exchangeConfig.Feed != nil {
+		if supervisor := exchanges.GetFeedSupervisor(exch); supervisor != nil {
+			supervisor.Configure(*exchangeConfig.Feed)
+		} else {
+			logrus.Warnf("Exchange %s does not supervise its feeds, feed ignored", exchangeConfig.ExchangeName)
+		}
+	}
+
+	exch = exchanges.NewContextWrapper(exch, exchangeConfig.RequestTimeout)
+	if exchangeConfig.Retry != nil {
+		exch = exchanges.NewRetryWrapper(exch, *exchangeConfig.Retry)
+	}
+
 	if simulatedMode {
 		if fakeBalances == nil {
 			return nil
 		}
-		exch = exchanges.NewExchangeWrapperSimulator(exch, fakeBalances)
+		simulator := exchanges.NewExchangeWrapperSimulator(exch, fakeBalances)
+		if exchangeConfig.SimulatedFees != nil {
+			err := simulator.SetFeeSchedule(*exchangeConfig.SimulatedFees)
+			if err != nil {
+				logrus.Errorf("Cannot simulate exchange %s: %s", exchangeConfig.ExchangeName, err)
+				return nil
+			}
+		}
+		exch = simulator
+	}
+
+	if exchangeConfig.Risk != nil {
+		exch = exchanges.NewRiskManager(exch, *exchangeConfig.Risk)
 	}
 
 	return exch
@@ -0,0 +1,170 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package bot
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/saniales/golang-crypto-trading-bot/backtest"
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/saniales/golang-crypto-trading-bot/exchanges"
+	"github.com/saniales/golang-crypto-trading-bot/strategies"
+	"github.com/shopspring/decimal"
+	"github.com/spf13/cobra"
+)
+
+// backtestCmd represents the backtest command
+var backtestCmd = &cobra.Command{
+	Use:   "backtest",
+	Short: "Tests the configured strategies against historical candles",
+	Long: `Tests the configured strategies against historical candles, using a simulated clock.
+	Candles of each market binding are read from <data-dir>/<exchange>/<market_name>.csv,
+	fake balances of the exchange configs are used as starting balances.`,
+	Run: executeBacktestCommand,
+}
+
+func init() {
+	RootCmd.AddCommand(backtestCmd)
+
+	backtestCmd.Flags().StringVar(&backtestFlags.DataDir, "data-dir", "./data", "directory containing the recorded candles")
+	backtestCmd.Flags().StringVar(&backtestFlags.QuoteCurrency, "quote", "BTC", "currency used to express equity and P&L")
+	backtestCmd.Flags().Float64Var(&backtestFlags.Fee, "fee", 0.001, "fee rate applied to each simulated fill (e.g. 0.001 for 0.1%)")
+	backtestCmd.Flags().StringVar(&backtestFlags.OutputDir, "output", "", "if specified, writes trades and equity curve of each strategy as CSV files in this directory")
+}
+
+func executeBacktestCommand(cmd *cobra.Command, args []string) {
+	fmt.Print("Getting configurations ... ")
+	if err := initConfigs(); err != nil {
+		fmt.Println("Cannot read from configuration file, please create or replace the current one using gobot init")
+		return
+	}
+	fmt.Println("DONE")
+
+	for _, strategyConf := range botConfig.Strategies {
+		strategy, exists := strategies.GetStrategy(strategyConf.Strategy)
+		if !exists {
+			fmt.Printf("Strategy %s does not exist, SKIPPED\n", strategyConf.Strategy)
+			continue
+		}
+
+		fmt.Printf("Loading candles for %s ... ", strategyConf.Strategy)
+		mkts := initMarkets(strategyConf)
+		simulatedExchanges, err := initBacktestExchanges(mkts)
+		if err != nil {
+			fmt.Println("Cannot load candles :", err)
+			continue
+		}
+		fmt.Println("DONE")
+
+		fmt.Printf("Backtesting %s ... ", strategyConf.Strategy)
+		result, err := backtest.Run(strategy, simulatedExchanges, mkts, backtest.Config{
+			QuoteCurrency: backtestFlags.QuoteCurrency,
+			Params:        strategyConf.Params,
+		})
+		if result == nil {
+			fmt.Println("Cannot run backtest :", err)
+			continue
+		}
+		fmt.Println("DONE")
+		if err != nil {
+			fmt.Println(err)
+		}
+		fmt.Println(result)
+
+		if backtestFlags.OutputDir != "" {
+			err = writeBacktestResult(strategyConf.Strategy, result)
+			if err != nil {
+				fmt.Println("Cannot write backtest result :", err)
+			}
+		}
+	}
+}
+
+// initBacktestExchanges creates the simulated exchanges and loads the candles of the markets.
+func initBacktestExchanges(mkts []*environment.Market) ([]*backtest.Exchange, error) {
+	simulatedExchanges := make([]*backtest.Exchange, 0, len(botConfig.ExchangeConfigs))
+	for _, config := range botConfig.ExchangeConfigs {
+		exchange := backtest.NewExchange(config.ExchangeName, config.FakeBalances, decimal.NewFromFloat(backtestFlags.Fee))
+		for _, mkt := range mkts {
+			marketName, binded := mkt.ExchangeNames[config.ExchangeName]
+			if !binded {
+				continue
+			}
+			series, err := exchanges.LoadCandles(filepath.Join(backtestFlags.DataDir, config.ExchangeName, marketName+".csv"))
+			if err != nil {
+				return nil, err
+			}
+			exchange.AddSeries(marketName, series)
+		}
+		simulatedExchanges = append(simulatedExchanges, exchange)
+	}
+	return simulatedExchanges, nil
+}
+
+// writeBacktestResult writes trades and equity curve of a backtest as CSV files.
+func writeBacktestResult(strategyName string, result *backtest.Result) error {
+	err := os.MkdirAll(backtestFlags.OutputDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	trades := [][]string{{"time", "exchange", "market", "side", "price", "quantity", "fee", "order_id"}}
+	for _, trade := range result.Trades {
+		side := "buy"
+		if trade.Type == environment.Ask {
+			side = "sell"
+		}
+		trades = append(trades, []string{
+			trade.Time.Format(time.RFC3339),
+			trade.Exchange,
+			trade.Market,
+			side,
+			trade.Price.String(),
+			trade.Quantity.String(),
+			trade.Fee.String(),
+			trade.OrderID,
+		})
+	}
+	err = writeCSV(filepath.Join(backtestFlags.OutputDir, strategyName+"-trades.csv"), trades)
+	if err != nil {
+		return err
+	}
+
+	equity := [][]string{{"time", "equity"}}
+	for _, point := range result.EquityCurve {
+		equity = append(equity, []string{point.Time.Format(time.RFC3339), point.Equity.String()})
+	}
+	return writeCSV(filepath.Join(backtestFlags.OutputDir, strategyName+"-equity.csv"), equity)
+}
+
+func writeCSV(path string, rows [][]string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	err = writer.WriteAll(rows)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
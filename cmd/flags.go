@@ -15,6 +15,8 @@
 
 package bot
 
+import "time"
+
 //GlobalFlags provides flag definitions valid for the whole system.
 var GlobalFlags struct {
 	Verbose    int    //Tells the program to print everything to screen (used multiple times for better verbosity).
@@ -39,5 +41,24 @@ var initFlags struct {
 
 // startFlags provdes flag definition for start command.
 var startFlags struct {
-	Simulate bool
+	Simulate    bool
+	ReplayDir   string
+	ReplaySpeed float64
+}
+
+// backtestFlags provides flag definition for backtest command.
+var backtestFlags struct {
+	DataDir       string
+	QuoteCurrency string
+	Fee           float64
+	OutputDir     string
+}
+
+// recordFlags provides flag definition for record command.
+var recordFlags struct {
+	OutputDir string
+	Interval  time.Duration
+	Rotation  time.Duration
+	Compress  bool
+	Candles   bool
 }
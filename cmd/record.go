@@ -0,0 +1,114 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package bot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	helpers "github.com/saniales/golang-crypto-trading-bot/bot_helpers"
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/saniales/golang-crypto-trading-bot/exchanges"
+	"github.com/spf13/cobra"
+)
+
+// recordCmd represents the record command
+var recordCmd = &cobra.Command{
+	Use:   "record",
+	Short: "Records market data of the configured markets",
+	Long: `Connects to the feeds of the configured exchanges and periodically writes snapshots
+	of summaries, order books and (optionally) candles of the configured markets to disk,
+	in the format read by start --replay.`,
+	Run: executeRecordCommand,
+}
+
+func init() {
+	RootCmd.AddCommand(recordCmd)
+
+	recordCmd.Flags().StringVar(&recordFlags.OutputDir, "output-dir", "./recordings", "directory where recordings are written, in a subdirectory per exchange")
+	recordCmd.Flags().DurationVar(&recordFlags.Interval, "interval", 10*time.Second, "time between two snapshots")
+	recordCmd.Flags().DurationVar(&recordFlags.Rotation, "rotate", time.Hour, "time after which a new recording file is started (0 to disable rotation)")
+	recordCmd.Flags().BoolVar(&recordFlags.Compress, "compress", true, "gzip compresses the recording files")
+	recordCmd.Flags().BoolVar(&recordFlags.Candles, "candles", false, "records candles along with summaries and order books")
+}
+
+func executeRecordCommand(cmd *cobra.Command, args []string) {
+	fmt.Print("Getting configurations ... ")
+	if err := initConfigs(); err != nil {
+		fmt.Println("Cannot read from configuration file, please create or replace the current one using gobot init")
+		return
+	}
+	fmt.Println("DONE")
+
+	fmt.Print("Getting exchange info ... ")
+	wrappers := make([]exchanges.ExchangeWrapper, 0, len(botConfig.ExchangeConfigs))
+	for _, config := range botConfig.ExchangeConfigs {
+		depositAddresses := config.DepositAddresses
+		if depositAddresses == nil {
+			depositAddresses = make(map[string]string)
+		}
+		wrapper := helpers.InitExchange(config, false, nil, depositAddresses)
+		if wrapper == nil {
+			fmt.Printf("Exchange %s not supported, SKIPPED\n", config.ExchangeName)
+			continue
+		}
+		wrappers = append(wrappers, wrapper)
+	}
+	fmt.Println("DONE")
+
+	// the same market can be binded to more strategies, record it once.
+	var mkts []*environment.Market
+	recorded := make(map[string]*environment.Market)
+	for _, strategyConf := range botConfig.Strategies {
+		for _, mkt := range initMarkets(strategyConf) {
+			if existing, exists := recorded[mkt.Name]; exists {
+				for exchangeName, marketName := range mkt.ExchangeNames {
+					existing.ExchangeNames[exchangeName] = marketName
+				}
+				continue
+			}
+			recorded[mkt.Name] = mkt
+			mkts = append(mkts, mkt)
+		}
+	}
+
+	feedCtx, closeFeeds := context.WithCancel(context.Background())
+	defer closeFeeds()
+	wrappers = exchanges.BindFeedContext(feedCtx, wrappers)
+
+	recorder := exchanges.NewRecorder(wrappers, mkts, exchanges.RecorderConfig{
+		OutputDir:     recordFlags.OutputDir,
+		Interval:      recordFlags.Interval,
+		Rotation:      recordFlags.Rotation,
+		Compress:      recordFlags.Compress,
+		RecordCandles: recordFlags.Candles,
+	})
+
+	fmt.Print("Connecting to feeds ... ")
+	if err := recorder.Start(); err != nil {
+		fmt.Println("Cannot connect to feeds :", err)
+		return
+	}
+	fmt.Println("DONE")
+
+	fmt.Printf("Recording to %s ... \n", recordFlags.OutputDir)
+	if err := recorder.Run(cmd.Context().Done()); err != nil {
+		fmt.Println("Recording stopped :", err)
+		return
+	}
+	fmt.Println("EXIT, good bye :)")
+}
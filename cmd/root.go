@@ -16,9 +16,11 @@
 package bot
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"github.com/spf13/cobra"
 )
@@ -35,28 +37,31 @@ var RootCmd = &cobra.Command{
 	Run:   executeRootCommand,
 }
 
-var signals chan os.Signal
-
 // Execute adds all child commands to the root command sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
+//
+// The context of the commands is cancelled on SIGINT or SIGTERM, a second signal forces the exit.
 func Execute() {
-	if err := RootCmd.Execute(); err != nil {
-		os.Exit(1)
-	}
-}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-func init() {
 	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 
 	go func() {
 		<-signals
-		signal.Stop(signals)
+		signal.Stop(signals) // restore the default behaviour, so that a second signal kills the bot.
 		fmt.Println()
-		fmt.Println("CTRL-C command received. Exiting...")
-		os.Exit(0)
+		fmt.Println("Stop signal received. Exiting...")
+		cancel()
 	}()
 
+	if err := RootCmd.ExecuteContext(ctx); err != nil {
+		os.Exit(1)
+	}
+}
+
+func init() {
 	RootCmd.Flags().BoolVarP(&rootFlags.Version, "version", "V", false, "show version information.")
 
 	RootCmd.PersistentFlags().CountVarP(&GlobalFlags.Verbose, "verbose", "v", "show verbose information when trading : use multiple times to increase verbosity level.")
@@ -16,14 +16,19 @@
 package bot
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	helpers "github.com/saniales/golang-crypto-trading-bot/bot_helpers"
 	"github.com/saniales/golang-crypto-trading-bot/environment"
 	"github.com/saniales/golang-crypto-trading-bot/exchanges"
+	"github.com/saniales/golang-crypto-trading-bot/portfolio"
+	"github.com/saniales/golang-crypto-trading-bot/state"
 	"github.com/saniales/golang-crypto-trading-bot/strategies"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v2"
@@ -39,10 +44,15 @@ var startCmd = &cobra.Command{
 
 var botConfig environment.BotConfig
 
+// defaultGraceTimeout is the time given to the strategies to stop, when not configured.
+const defaultGraceTimeout = 30 * time.Second
+
 func init() {
 	RootCmd.AddCommand(startCmd)
 
 	startCmd.Flags().BoolVarP(&startFlags.Simulate, "simulate", "s", false, "Simulates the trades instead of actually doing them")
+	startCmd.Flags().StringVar(&startFlags.ReplayDir, "replay", "", "Simulates the trades replaying the market data recorded in <replay>/<exchange> instead of connecting to exchanges")
+	startCmd.Flags().Float64Var(&startFlags.ReplaySpeed, "replay-speed", 1, "Speed of the replay, relative to real time")
 }
 
 func initConfigs() error {
@@ -71,42 +81,230 @@ func executeStartCommand(cmd *cobra.Command, args []string) {
 
 	fmt.Print("Getting exchange info ... ")
 	wrappers := make([]exchanges.ExchangeWrapper, len(botConfig.ExchangeConfigs))
-	for i, config := range botConfig.ExchangeConfigs {
-		wrappers[i] = helpers.InitExchange(config, botConfig.SimulationModeOn, config.FakeBalances, config.DepositAddresses)
+	if startFlags.ReplayDir != "" {
+		err := initReplayExchanges(wrappers)
+		if err != nil {
+			fmt.Println("Cannot load replay data :", err)
+			return
+		}
+	} else {
+		for i, config := range botConfig.ExchangeConfigs {
+			wrappers[i] = helpers.InitExchange(config, botConfig.SimulationModeOn, config.FakeBalances, config.DepositAddresses)
+		}
 	}
 	fmt.Println("DONE")
 
+	var tracker *portfolio.Portfolio
+	if botConfig.Portfolio.QuoteCurrency != "" {
+		tracker = portfolio.New(botConfig.Portfolio.QuoteCurrency)
+		wrappers = tracker.TrackAll(wrappers)
+	}
+
+	// replayed and simulated runs must not change the state of the live strategies.
+	if botConfig.StateFile != "" && startFlags.ReplayDir == "" && !botConfig.SimulationModeOn {
+		fmt.Print("Getting strategies state ... ")
+		store, err := state.Open(botConfig.StateFile)
+		if err != nil {
+			fmt.Println("Cannot open state file :", err)
+			return
+		}
+		strategies.SetStateStore(store)
+		fmt.Println("DONE")
+	}
+
 	fmt.Print("Getting markets cold info ... ")
+	var allMarkets []*environment.Market
 	for _, strategyConf := range botConfig.Strategies {
-		mkts := make([]*environment.Market, len(strategyConf.Markets))
-		for i, mkt := range strategyConf.Markets {
-			currencies := strings.SplitN(mkt.Name, "-", 2)
-			mkts[i] = &environment.Market{
-				Name:           mkt.Name,
-				BaseCurrency:   currencies[0],
-				MarketCurrency: currencies[1],
+		mkts := initMarkets(strategyConf)
+		err := strategies.MatchWithMarketsBinding(strategyConf.Strategy, strategyConf.ID, mkts, strategyConf.Params)
+		if err != nil {
+			fmt.Println("Cannot add tactic : ", err)
+		}
+		allMarkets = append(allMarkets, mkts...)
+	}
+	fmt.Println("DONE")
+
+	fmt.Println("Starting bot ... ")
+	executeBotLoop(cmd.Context(), wrappers, allMarkets, tracker)
+	fmt.Println("EXIT, good bye :)")
+}
+
+// initReplayExchanges creates simulated wrappers replaying the market data recorded for each exchange,
+// all driven by the same virtual clock.
+func initReplayExchanges(wrappers []exchanges.ExchangeWrapper) error {
+	clock := exchanges.NewVirtualClock(time.Time{})
+	var start time.Time
+	for i, config := range botConfig.ExchangeConfigs {
+		replay := exchanges.NewReplayWrapper(config.ExchangeName, clock)
+		err := replay.LoadDir(filepath.Join(startFlags.ReplayDir, config.ExchangeName))
+		if err != nil {
+			return err
+		}
+
+		first, _, err := replay.Bounds()
+		if err != nil {
+			return fmt.Errorf("%s: %s", config.ExchangeName, err)
+		}
+		if i == 0 || first.Before(start) {
+			start = first
+		}
+
+		simulator := exchanges.NewExchangeWrapperSimulator(replay, config.FakeBalances)
+		if config.SimulatedFees != nil {
+			err := simulator.SetFeeSchedule(*config.SimulatedFees)
+			if err != nil {
+				return fmt.Errorf("%s: %s", config.ExchangeName, err)
 			}
+		}
+		wrappers[i] = simulator
+		if config.Risk != nil {
+			wrappers[i] = exchanges.NewRiskManager(simulator, *config.Risk)
+		}
+	}
+
+	clock.Set(start)
+	clock.Play(startFlags.ReplaySpeed)
+	return nil
+}
+
+// initMarkets creates the markets binded to a strategy.
+func initMarkets(strategyConf environment.StrategyConfig) []*environment.Market {
+	mkts := make([]*environment.Market, len(strategyConf.Markets))
+	for i, mkt := range strategyConf.Markets {
+		currencies := strings.SplitN(mkt.Name, "-", 2)
+		mkts[i] = &environment.Market{
+			Name:           mkt.Name,
+			BaseCurrency:   currencies[0],
+			MarketCurrency: currencies[1],
+		}
 
-			mkts[i].ExchangeNames = make(map[string]string, len(wrappers))
-			mkts[i].ExchangeTimeFrames = make(map[string]string, len(wrappers))
+		mkts[i].ExchangeNames = make(map[string]string, len(mkt.Exchanges))
+		mkts[i].ExchangeTimeFrames = make(map[string]environment.TimeFrame, len(mkt.Exchanges))
 
-			for _, exName := range mkt.Exchanges {
-				mkts[i].ExchangeNames[exName.Name] = exName.MarketName
-				mkts[i].ExchangeTimeFrames[exName.Name] = exName.TimeFrame
+		for _, exName := range mkt.Exchanges {
+			mkts[i].ExchangeNames[exName.Name] = exName.MarketName
+			mkts[i].ExchangeTimeFrames[exName.Name] = exName.TimeFrame
+			if exName.TimeFrame.IsZero() {
+				mkts[i].ExchangeTimeFrames[exName.Name] = mkt.TimeFrame
 			}
 		}
-		err := strategies.MatchWithMarkets(strategyConf.Strategy, mkts)
+	}
+	return mkts
+}
+
+// executeBotLoop applies the strategies until the context of the command is done, then shuts the bot down:
+// strategies are torn down, open orders are cancelled (if enabled) and feeds are closed, within the grace timeout.
+//
+// If the portfolio is tracked, it is reported periodically and when the bot stops.
+func executeBotLoop(ctx context.Context, wrappers []exchanges.ExchangeWrapper, mkts []*environment.Market, tracker *portfolio.Portfolio) {
+	feedCtx, closeFeeds := context.WithCancel(context.Background())
+	defer closeFeeds()
+	wrappers = exchanges.BindFeedContext(feedCtx, wrappers)
+
+	if tracker != nil && botConfig.Portfolio.ReportInterval > 0 {
+		go reportPortfolioEvery(ctx, tracker, mkts, botConfig.Portfolio.ReportInterval)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		strategies.ApplyAllStrategiesContext(ctx, wrappers)
+		close(stopped)
+	}()
+
+	graceTimeout := botConfig.Shutdown.GraceTimeout
+	if graceTimeout <= 0 {
+		graceTimeout = defaultGraceTimeout
+	}
+
+	var deadline time.Time
+	select {
+	case <-stopped:
+		deadline = time.Now().Add(graceTimeout)
+	case <-ctx.Done():
+		deadline = time.Now().Add(graceTimeout)
+
+		fmt.Print("Tearing down strategies ... ")
+		select {
+		case <-stopped:
+			fmt.Println("DONE")
+		case <-time.After(time.Until(deadline)):
+			fmt.Println("TIMEOUT")
+			fmt.Printf("Strategies did not stop within %s, forcing exit\n", graceTimeout)
+			os.Exit(1)
+		}
+	}
+
+	if botConfig.Shutdown.CancelOpenOrders {
+		fmt.Print("Cancelling open orders ... ")
+		shutdownCtx, cancel := context.WithDeadline(context.Background(), deadline)
+		err := cancelOpenOrders(shutdownCtx, wrappers, mkts)
+		cancel()
 		if err != nil {
-			fmt.Println("Cannot add tactic : ", err)
+			fmt.Println("Cannot cancel all open orders :", err)
+		} else {
+			fmt.Println("DONE")
 		}
 	}
+
+	if tracker != nil {
+		reportPortfolio(tracker, mkts)
+	}
+
+	fmt.Print("Closing feeds ... ")
+	closeFeeds()
 	fmt.Println("DONE")
+}
 
-	fmt.Println("Starting bot ... ")
-	executeBotLoop(wrappers)
-	fmt.Println("EXIT, good bye :)")
+// reportPortfolioEvery reports the portfolio at the specified interval, until the context is done.
+func reportPortfolioEvery(ctx context.Context, tracker *portfolio.Portfolio, mkts []*environment.Market, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reportPortfolio(tracker, mkts)
+		}
+	}
+}
+
+// reportPortfolio records the new fills of the open orders and prints the report of the portfolio.
+func reportPortfolio(tracker *portfolio.Portfolio, mkts []*environment.Market) {
+	if err := tracker.Sync(); err != nil {
+		fmt.Println("Cannot sync portfolio :", err)
+	}
+	report, err := tracker.Report(mkts)
+	if err != nil {
+		fmt.Println("Cannot get all portfolio values :", err)
+	}
+	fmt.Println(report)
 }
 
-func executeBotLoop(wrappers []exchanges.ExchangeWrapper) {
-	strategies.ApplyAllStrategies(wrappers)
+// cancelOpenOrders cancels the open orders on the markets binded to each exchange, returning the first error.
+func cancelOpenOrders(ctx context.Context, wrappers []exchanges.ExchangeWrapper, mkts []*environment.Market) error {
+	var firstErr error
+	for _, wrapper := range exchanges.BindContext(ctx, wrappers) {
+		for _, mkt := range mkts {
+			if !exchanges.IsBinded(mkt, wrapper) {
+				continue
+			}
+
+			orders, err := wrapper.GetOpenOrders(mkt)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s %s: %s", wrapper.Name(), mkt.Name, err)
+				}
+				continue
+			}
+
+			for _, order := range orders {
+				err := wrapper.CancelOrder(mkt, order.ID)
+				if err != nil && firstErr == nil {
+					firstErr = fmt.Errorf("%s %s: %s", wrapper.Name(), mkt.Name, err)
+				}
+			}
+		}
+	}
+	return firstErr
 }
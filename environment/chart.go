@@ -17,6 +17,7 @@ package environment
 
 //CandleStick represents a single candle in the graph.
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -31,6 +32,15 @@ type CandleStick struct {
 	Close  decimal.Decimal //Represents the last value of the candle period.
 	Low    decimal.Decimal //Represents the lowest value obtained during candle period.
 	Volume decimal.Decimal //Represents the volume of trades during the candle period.
+
+	OpenTime  time.Time     //Represents the start of the candle period.
+	CloseTime time.Time     //Represents the end of the candle period (OpenTime + Period).
+	Period    time.Duration //Represents the length of the candle period.
+}
+
+//IsClosedAt returns true if the candle period is over at the specified time.
+func (cs CandleStick) IsClosedAt(t time.Time) bool {
+	return !cs.CloseTime.IsZero() && !t.Before(cs.CloseTime)
 }
 
 // String returns the string representation of the object.
@@ -44,6 +54,9 @@ func (cs CandleStick) String() string {
 		color = "Neutral"
 	}
 	ret := fmt.Sprintln(color, "Candle")
+	if !cs.OpenTime.IsZero() {
+		ret += fmt.Sprintln("Time:", cs.OpenTime.UTC().Format(time.RFC3339), "-", cs.CloseTime.UTC().Format(time.RFC3339))
+	}
 	ret += fmt.Sprintln("High:", cs.High)
 	ret += fmt.Sprintln("Open:", cs.Open)
 	ret += fmt.Sprintln("Close:", cs.Close)
@@ -58,3 +71,55 @@ type CandleStickChart struct {
 	CandleSticks []CandleStick //Represents the last Candle Sticks used for evaluation of current state.
 	OrderBook    []Order       //Represents the Book of current trades.
 }
+
+//Resample aggregates the candles of the chart into candles of a larger period (see ResampleCandles).
+func (chart CandleStickChart) Resample(period time.Duration) (CandleStickChart, error) {
+	candles, err := ResampleCandles(chart.CandleSticks, period)
+	if err != nil {
+		return CandleStickChart{}, err
+	}
+
+	return CandleStickChart{
+		CandlePeriod: period,
+		CandleSticks: candles,
+		OrderBook:    chart.OrderBook,
+	}, nil
+}
+
+//ResampleCandles aggregates candles, sorted by open time, into candles of a larger period aligned to multiples of the period
+//(e.g. 1h candles start at the hour, 1d candles at midnight UTC).
+//
+//     The period must be a multiple of the period of the candles; the last candle is partial if its period is not over.
+func ResampleCandles(candles []CandleStick, period time.Duration) ([]CandleStick, error) {
+	if period <= 0 {
+		return nil, errors.New("Resample period must be positive")
+	}
+
+	ret := make([]CandleStick, 0, len(candles))
+	for _, candle := range candles {
+		if candle.OpenTime.IsZero() {
+			return nil, errors.New("Cannot resample candles without open time")
+		}
+		if candle.Period <= 0 || period%candle.Period != 0 {
+			return nil, fmt.Errorf("Cannot resample candles of %s into candles of %s", candle.Period, period)
+		}
+
+		openTime := candle.OpenTime.Truncate(period)
+		last := len(ret) - 1
+		if last >= 0 && ret[last].OpenTime.Equal(openTime) {
+			ret[last].High = decimal.Max(ret[last].High, candle.High)
+			ret[last].Low = decimal.Min(ret[last].Low, candle.Low)
+			ret[last].Close = candle.Close
+			ret[last].Volume = ret[last].Volume.Add(candle.Volume)
+			continue
+		}
+
+		resampled := candle
+		resampled.OpenTime = openTime
+		resampled.CloseTime = openTime.Add(period)
+		resampled.Period = period
+		ret = append(ret, resampled)
+	}
+
+	return ret, nil
+}
@@ -16,6 +16,8 @@
 package environment
 
 import (
+	"time"
+
 	"github.com/shopspring/decimal"
 )
 
@@ -28,25 +30,96 @@ type ExchangeConfig struct {
 	SecretKey        string                     `yaml:"secret_key"`        // Represents the secret key used to connect to Exchange API.
 	DepositAddresses map[string]string          `yaml:"deposit_addresses"` // Represents the bindings between coins and deposit address on the exchange.
 	FakeBalances     map[string]decimal.Decimal `yaml:"fake_balances"`     // Used only in simulation mode, fake starting balance [coin:balance].
+	SimulatedFees    *FeeConfig                 `yaml:"simulated_fees"`    // Used only in simulation mode, fee schedule of the simulated fills.
+	RequestTimeout   time.Duration              `yaml:"request_timeout"`   // Represents the deadline of each call to the exchange (e.g. 10s), no deadline if not set.
+	Risk             *RiskConfig                `yaml:"risk"`              // Represents the risk limits enforced on the orders and withdraws of the exchange, no limits if not set.
+	RateLimit        *RateLimitConfig           `yaml:"rate_limit"`        // Represents the budget of the calls to the exchange API, the default one of the exchange if not set.
+	Retry            *RetryConfig               `yaml:"retry"`             // Represents how the calls failed for transient errors are retried, never retried if not set.
+	Feed             *FeedConfig                `yaml:"feed"`              // Represents how the websocket feeds are supervised, with the defaults if not set.
+	Extra            map[string]interface{}     `yaml:",inline"`           // Represents the other fields of the configuration, read by custom exchanges (e.g. sandbox URLs or passphrases).
+}
+
+const (
+	// FeeCurrencyBase charges the fees in the base currency of the market.
+	FeeCurrencyBase = "base"
+	// FeeCurrencyMarket charges the fees in the market currency of the market.
+	FeeCurrencyMarket = "market"
+	// FeeCurrencyReceived charges the fees in the currency received by the fill (market currency on buys, base currency on sells).
+	FeeCurrencyReceived = "received"
+)
+
+// FeeConfig represents the fee schedule applied to simulated fills.
+//
+//     When a rate is omitted, the fees calculated by the exchange wrapper are used.
+type FeeConfig struct {
+	Maker    *decimal.Decimal `yaml:"maker"`    // Represents the fee rate of the fills of resting orders (e.g. 0.001 for 0.1%).
+	Taker    *decimal.Decimal `yaml:"taker"`    // Represents the fee rate of the fills of market orders (e.g. 0.001 for 0.1%).
+	Currency string           `yaml:"currency"` // Represents the currency fees are charged in: base (default), market or received.
+}
+
+// RiskConfig represents the risk limits enforced on an exchange.
+//
+//     A limit is not enforced when omitted (or zero).
+type RiskConfig struct {
+	MaxOrderNotional  map[string]decimal.Decimal `yaml:"max_order_notional"`  // Represents the maximum value of an order, by base currency (e.g. BTC: 0.5).
+	MaxPosition       map[string]decimal.Decimal `yaml:"max_position"`        // Represents the maximum quantity held of the market currency, including open buys, by market name (e.g. ETH-BTC: 10).
+	DailyLossLimit    map[string]decimal.Decimal `yaml:"daily_loss_limit"`    // Represents the maximum loss realised in a day by the orders of the bot, by base currency, before refusing buys.
+	MaxOpenOrders     int                        `yaml:"max_open_orders"`     // Represents the maximum number of open orders on a market.
+	MaxPriceDeviation decimal.Decimal            `yaml:"max_price_deviation"` // Represents the maximum deviation of a limit price from the last price, against the order (e.g. 0.05 for 5%).
+	MaxWithdraw       map[string]decimal.Decimal `yaml:"max_withdraw"`        // Represents the maximum amount of a withdraw, by currency.
+}
+
+// RateLimitConfig represents the budget of the calls to an exchange API: each call consumes the weight
+// of its endpoint, and the calls exceeding the budget of the interval wait for the next one (or are refused).
+//
+//     Omitted (or zero) fields keep the default of the exchange.
+type RateLimitConfig struct {
+	Weight   int            `yaml:"weight"`   // Represents the total weight of the calls allowed in an interval (e.g. 1200).
+	Interval time.Duration  `yaml:"interval"` // Represents the duration of the interval (e.g. 1m).
+	Weights  map[string]int `yaml:"weights"`  // Represents the weight of the endpoints, by wrapper method name (e.g. GetOrderBook: 5).
+	Reject   bool           `yaml:"reject"`   // Represents whether the calls exceeding the budget are refused instead of waiting.
+}
+
+// RetryConfig represents how the calls to an exchange failed for transient errors (e.g. timeouts) are retried,
+// waiting a random delay between half and all of an exponential backoff.
+//
+//     Omitted (or zero) fields use the defaults: 3 attempts, 200ms minimum and 5s maximum delay.
+type RetryConfig struct {
+	Attempts int           `yaml:"attempts"`  // Represents the maximum number of attempts of a call, the first one included.
+	MinDelay time.Duration `yaml:"min_delay"` // Represents the backoff after the first failure, doubled after each one (e.g. 200ms).
+	MaxDelay time.Duration `yaml:"max_delay"` // Represents the maximum backoff (e.g. 5s).
+}
+
+// FeedConfig represents how the websocket feeds of an exchange are supervised: a feed silent for the heartbeat
+// timeout is degraded, and reconnected when silent for twice as long or closed, after an exponential backoff.
+//
+//     Omitted (or zero) fields use the defaults: 30s heartbeat timeout, 1s minimum and 1m maximum backoff.
+type FeedConfig struct {
+	HeartbeatTimeout time.Duration `yaml:"heartbeat_timeout"` // Represents how long a feed can be silent before being degraded (e.g. 30s).
+	MinBackoff       time.Duration `yaml:"min_backoff"`       // Represents the wait before the first reconnection, doubled after each failure (e.g. 1s).
+	MaxBackoff       time.Duration `yaml:"max_backoff"`       // Represents the maximum wait between reconnections (e.g. 1m).
 }
 
 // StrategyConfig contains where a strategy will be applied in the specified exchange.
 type StrategyConfig struct {
-	Strategy string         `yaml:"strategy"` // Represents the applied strategy name: must be unique in the system.
-	Markets  []MarketConfig `yaml:"markets"`  // Represents the exchanges where the strategy is applied.
+	Strategy string                 `yaml:"strategy"`         // Represents the applied strategy name: must be unique in the system.
+	Markets  []MarketConfig         `yaml:"markets"`          // Represents the exchanges where the strategy is applied.
+	Params   map[string]interface{} `yaml:"params,omitempty"` // Represents the parameters of the strategy on these markets, validated against the ones it declares.
+	ID       string                 `yaml:"id,omitempty"`     // Represents the identifier of the binding, required to bind the strategy more times on the same markets.
 }
 
 // MarketConfig contains all market configuration data.
 type MarketConfig struct {
-	Name      string                   `yaml:"market"`   // Represents the market where the strategy is applied.
-	Exchanges []ExchangeBindingsConfig `yaml:"bindings"` // Represents the list of markets where the strategy is applied, along with extra-data regarding binded exchanges.
+	Name      string                   `yaml:"market"`     // Represents the market where the strategy is applied.
+	Exchanges []ExchangeBindingsConfig `yaml:"bindings"`   // Represents the list of markets where the strategy is applied, along with extra-data regarding binded exchanges.
+	TimeFrame TimeFrame                `yaml:"time_frame"` // Represents the timeframe of the candles of the market on every binded exchange, unless overridden by the binding.
 }
 
 // ExchangeBindingsConfig represents the binding of market names between bot notation and exchange ticker.
 type ExchangeBindingsConfig struct {
-	Name       string `yaml:"exchange"`    // Represents the name of the exchange.
-	MarketName string `yaml:"market_name"` // Represents the name of the market as seen from the exchange.
-	TimeFrame  string `yaml:"time_frame"`
+	Name       string    `yaml:"exchange"`    // Represents the name of the exchange.
+	MarketName string    `yaml:"market_name"` // Represents the name of the market as seen from the exchange.
+	TimeFrame  TimeFrame `yaml:"time_frame"`  // Represents the timeframe of the candles of the market on the exchange (e.g. 15m, 1h, 1d).
 }
 
 // BotConfig contains all config data of the bot, which can be also loaded from config file.
@@ -54,4 +127,19 @@ type BotConfig struct {
 	SimulationModeOn bool             `yaml:"simulation_mode"`  // if true, do not create real orders and do not get real balance
 	ExchangeConfigs  []ExchangeConfig `yaml:"exchange_configs"` // Represents the current exchange configuration.
 	Strategies       []StrategyConfig `yaml:"strategies"`       // Represents the current strategies adopted by the bot.
+	Shutdown         ShutdownConfig   `yaml:"shutdown"`         // Represents the behaviour of the bot when stopped.
+	StateFile        string           `yaml:"state_file"`       // Represents the JSON file keeping the state of the strategies across restarts, in memory if not set or simulating.
+	Portfolio        PortfolioConfig  `yaml:"portfolio"`        // Represents the tracking of balances, fills and positions across the exchanges.
+}
+
+// PortfolioConfig contains the configuration of the portfolio tracker.
+type PortfolioConfig struct {
+	QuoteCurrency  string        `yaml:"quote_currency"`  // Represents the currency the equity is expressed in (e.g. BTC), the portfolio is not tracked if not set.
+	ReportInterval time.Duration `yaml:"report_interval"` // Represents the interval between reports of the portfolio (e.g. 1h), reported only when the bot stops if not set.
+}
+
+// ShutdownConfig contains the configuration of the shutdown of the bot.
+type ShutdownConfig struct {
+	GraceTimeout     time.Duration `yaml:"grace_timeout"`      // Represents the time given to the strategies to tear down before forcing the exit (e.g. 30s).
+	CancelOpenOrders bool          `yaml:"cancel_open_orders"` // if true, cancel the open orders on the markets of the strategies before exiting.
 }
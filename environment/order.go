@@ -58,3 +58,54 @@ type Order struct {
 func (order Order) Total() decimal.Decimal {
 	return order.Quantity.Mul(order.Value)
 }
+
+//OrderStatus is an enum {NEW, PARTIALLY_FILLED, FILLED, CANCELLED}
+type OrderStatus string
+
+const (
+	//OrderNew Represents an order accepted by the exchange and not filled yet.
+	OrderNew OrderStatus = "new"
+	//OrderPartiallyFilled Represents an open order which has been partially filled.
+	OrderPartiallyFilled OrderStatus = "partially_filled"
+	//OrderFilled Represents an order which has been completely filled.
+	OrderFilled OrderStatus = "filled"
+	//OrderCancelled Represents an order cancelled (or expired) before being completely filled.
+	OrderCancelled OrderStatus = "cancelled"
+)
+
+//IsOpen returns true if the order can still be filled.
+func (status OrderStatus) IsOpen() bool {
+	return status == OrderNew || status == OrderPartiallyFilled
+}
+
+//OrderInfo represents the status of an order placed on an exchange.
+type OrderInfo struct {
+	ID             string          //Order ID as returned by the order methods of the exchange wrapper.
+	Market         *Market         //Market of the order.
+	Type           OrderType       //Side of the order (Bid for buys, Ask for sells).
+	Status         OrderStatus     //Status of the order.
+	Quantity       decimal.Decimal //Quantity of coins of the order, in market currency.
+	Limit          decimal.Decimal //[optional] Limit price of the order, zero for market orders.
+	FilledQuantity decimal.Decimal //Quantity of coins filled so far, in market currency.
+	AveragePrice   decimal.Decimal //Average price of the fills, zero if not filled yet.
+	Fee            decimal.Decimal //[optional] Fees paid by the fills, in fee currency.
+	FeeCurrency    string          //[optional] Currency the fees have been charged in.
+	Timestamp      time.Time       //[optional] The time the order was placed.
+}
+
+//RemainingQuantity returns the quantity of coins still to be filled.
+func (order OrderInfo) RemainingQuantity() decimal.Decimal {
+	if !order.Status.IsOpen() {
+		return decimal.Zero
+	}
+	return order.Quantity.Sub(order.FilledQuantity)
+}
+
+// String returns the string representation of the object.
+func (order OrderInfo) String() string {
+	side := "BUY"
+	if order.Type == Ask {
+		side = "SELL"
+	}
+	return fmt.Sprintf("%s %s %s %s@%s (%s filled, avg %s)", order.ID, side, order.Status, order.Quantity, order.Limit, order.FilledQuantity, order.AveragePrice)
+}
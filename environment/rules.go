@@ -0,0 +1,129 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package environment
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	// ErrOrderQuantity is the error representing an order whose quantity is below the minimum of the market.
+	ErrOrderQuantity = errors.New("Order quantity below the minimum of the market")
+	// ErrOrderNotional is the error representing an order whose value is below the minimum of the market.
+	ErrOrderNotional = errors.New("Order value below the minimum of the market")
+)
+
+// MarketRules represents the trading rules of a market on an exchange: orders are rounded to its steps
+// and refused when below its minimums.
+//
+// Zero steps and minimums do not restrict the orders.
+type MarketRules struct {
+	PricePrecision    int32           `json:"pricePrecision"`    //Represents the number of decimals of the prices.
+	QuantityPrecision int32           `json:"quantityPrecision"` //Represents the number of decimals of the quantities.
+	TickSize          decimal.Decimal `json:"tickSize"`          //Represents the step of the prices (e.g. 0.01).
+	StepSize          decimal.Decimal `json:"stepSize"`          //Represents the step of the quantities, in market currency.
+	MinQuantity       decimal.Decimal `json:"minQuantity"`       //Represents the minimum quantity of an order, in market currency.
+	MinNotional       decimal.Decimal `json:"minNotional"`       //Represents the minimum value of an order (quantity times price), in base currency.
+}
+
+// NewMarketRules creates the rules of a market from the number of decimals of its prices and quantities.
+func NewMarketRules(pricePrecision int32, quantityPrecision int32) *MarketRules {
+	return &MarketRules{
+		PricePrecision:    pricePrecision,
+		QuantityPrecision: quantityPrecision,
+		TickSize:          decimal.New(1, -pricePrecision),
+		StepSize:          decimal.New(1, -quantityPrecision),
+	}
+}
+
+// NewMarketRulesFromSteps creates the rules of a market from the steps of its prices and quantities,
+// zero if not restricted.
+func NewMarketRulesFromSteps(tickSize decimal.Decimal, stepSize decimal.Decimal) *MarketRules {
+	return &MarketRules{
+		PricePrecision:    stepPrecision(tickSize),
+		QuantityPrecision: stepPrecision(stepSize),
+		TickSize:          tickSize,
+		StepSize:          stepSize,
+	}
+}
+
+// stepPrecision returns the number of decimals of a step (e.g. 2 for 0.01, 0 for 10).
+func stepPrecision(step decimal.Decimal) int32 {
+	if !step.IsPositive() {
+		return 0
+	}
+	for precision := int32(0); ; precision++ {
+		if step.Truncate(precision).Equal(step) {
+			return precision
+		}
+	}
+}
+
+// RoundQuantity rounds a quantity down to the step of the quantities, so that an order never exceeds the requested quantity.
+func (rules *MarketRules) RoundQuantity(quantity decimal.Decimal) decimal.Decimal {
+	if rules == nil || !rules.StepSize.IsPositive() {
+		return quantity
+	}
+	return quantity.Div(rules.StepSize).Floor().Mul(rules.StepSize)
+}
+
+// RoundPrice rounds a price to the step of the prices, down for buys and up for sells,
+// so that the price is never worse than the requested one.
+func (rules *MarketRules) RoundPrice(price decimal.Decimal, orderType OrderType) decimal.Decimal {
+	if rules == nil || !rules.TickSize.IsPositive() {
+		return price
+	}
+	steps := price.Div(rules.TickSize)
+	if orderType == Ask {
+		return steps.Ceil().Mul(rules.TickSize)
+	}
+	return steps.Floor().Mul(rules.TickSize)
+}
+
+// Validate checks an order against the minimums of the market, price is zero for market orders
+// (whose value is not checked).
+func (rules *MarketRules) Validate(quantity decimal.Decimal, price decimal.Decimal) error {
+	if !quantity.IsPositive() {
+		return fmt.Errorf("%w: %s", ErrOrderQuantity, quantity)
+	}
+	if rules == nil {
+		return nil
+	}
+	if quantity.LessThan(rules.MinQuantity) {
+		return fmt.Errorf("%w: %s, minimum %s", ErrOrderQuantity, quantity, rules.MinQuantity)
+	}
+	if notional := quantity.Mul(price); price.IsPositive() && notional.LessThan(rules.MinNotional) {
+		return fmt.Errorf("%w: %s, minimum %s", ErrOrderNotional, notional, rules.MinNotional)
+	}
+	return nil
+}
+
+// Apply rounds the quantity and the price (zero for market orders) of an order to the rules, then validates it.
+//
+// Orders are only checked to have a positive quantity when the rules are nil (unknown).
+func (rules *MarketRules) Apply(orderType OrderType, quantity decimal.Decimal, price decimal.Decimal) (decimal.Decimal, decimal.Decimal, error) {
+	quantity = rules.RoundQuantity(quantity)
+	if price.IsPositive() {
+		price = rules.RoundPrice(price, orderType)
+	}
+	if err := rules.Validate(quantity, price); err != nil {
+		return decimal.Zero, decimal.Zero, err
+	}
+	return quantity, price, nil
+}
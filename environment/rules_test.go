@@ -0,0 +1,86 @@
+package environment
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func dec(value string) decimal.Decimal {
+	return decimal.RequireFromString(value)
+}
+
+func TestNewMarketRulesFromSteps(t *testing.T) {
+	tests := []struct {
+		tickSize          string
+		stepSize          string
+		pricePrecision    int32
+		quantityPrecision int32
+	}{
+		{"0.01", "0.001", 2, 3},
+		{"0.00000001", "1", 8, 0},
+		{"0.05", "10", 2, 0},
+		{"0", "0", 0, 0},
+	}
+
+	for _, test := range tests {
+		rules := NewMarketRulesFromSteps(dec(test.tickSize), dec(test.stepSize))
+		if rules.PricePrecision != test.pricePrecision || rules.QuantityPrecision != test.quantityPrecision {
+			t.Errorf("steps %s %s: precisions %d %d, want %d %d", test.tickSize, test.stepSize,
+				rules.PricePrecision, rules.QuantityPrecision, test.pricePrecision, test.quantityPrecision)
+		}
+	}
+}
+
+func TestMarketRulesApply(t *testing.T) {
+	rules := &MarketRules{
+		TickSize:    dec("0.05"),
+		StepSize:    dec("0.01"),
+		MinQuantity: dec("0.1"),
+		MinNotional: dec("1"),
+	}
+
+	tests := []struct {
+		name      string
+		rules     *MarketRules
+		orderType OrderType
+		quantity  string
+		price     string
+		wantQty   string
+		wantPrice string
+		err       error
+	}{
+		{"on the steps", rules, Bid, "1.25", "10.05", "1.25", "10.05", nil},
+		{"quantity rounded down", rules, Bid, "1.259", "10", "1.25", "10", nil},
+		{"buy price rounded down", rules, Bid, "1", "10.09", "1", "10.05", nil},
+		{"sell price rounded up", rules, Ask, "1", "10.01", "1", "10.05", nil},
+		{"market order not rounded nor valued", rules, Bid, "0.109", "0", "0.1", "0", nil},
+		{"quantity below the minimum", rules, Bid, "0.09", "10", "", "", ErrOrderQuantity},
+		{"quantity rounded down to the minimum", rules, Ask, "0.109", "100", "0.1", "100", nil},
+		{"quantity rounded to zero", rules, Bid, "0.009", "10", "", "", ErrOrderQuantity},
+		{"value below the minimum", rules, Bid, "0.1", "9.95", "", "", ErrOrderNotional},
+		{"value above the minimum after rounding the sell price", rules, Ask, "0.1", "9.96", "0.1", "10", nil},
+		{"unknown rules keep the order", nil, Bid, "0.0001234", "10.001", "0.0001234", "10.001", nil},
+		{"unknown rules refuse zero quantities", nil, Bid, "0", "10", "", "", ErrOrderQuantity},
+		{"precisions", NewMarketRules(1, 2), Ask, "1.239", "3.01", "1.23", "3.1", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			quantity, price, err := test.rules.Apply(test.orderType, dec(test.quantity), dec(test.price))
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("error %v, want %v", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !quantity.Equal(dec(test.wantQty)) || !price.Equal(dec(test.wantPrice)) {
+				t.Errorf("got %s at %s, want %s at %s", quantity, price, test.wantQty, test.wantPrice)
+			}
+		})
+	}
+}
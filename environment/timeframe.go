@@ -0,0 +1,130 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package environment
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//TimeFrame represents the period of the candles of a market, independently from the exchange.
+//
+//The zero value means no timeframe is specified, so the default one of the exchange is used.
+type TimeFrame time.Duration
+
+//Common timeframes.
+const (
+	OneMinute      = TimeFrame(time.Minute)
+	ThreeMinutes   = TimeFrame(3 * time.Minute)
+	FiveMinutes    = TimeFrame(5 * time.Minute)
+	FifteenMinutes = TimeFrame(15 * time.Minute)
+	ThirtyMinutes  = TimeFrame(30 * time.Minute)
+	OneHour        = TimeFrame(time.Hour)
+	TwoHours       = TimeFrame(2 * time.Hour)
+	FourHours      = TimeFrame(4 * time.Hour)
+	SixHours       = TimeFrame(6 * time.Hour)
+	EightHours     = TimeFrame(8 * time.Hour)
+	TwelveHours    = TimeFrame(12 * time.Hour)
+	OneDay         = TimeFrame(24 * time.Hour)
+	ThreeDays      = TimeFrame(3 * 24 * time.Hour)
+	OneWeek        = TimeFrame(7 * 24 * time.Hour)
+)
+
+//timeFrameUnits contains the units of a timeframe, from the largest.
+var timeFrameUnits = []struct {
+	suffix   string
+	duration time.Duration
+}{
+	{"w", 7 * 24 * time.Hour},
+	{"d", 24 * time.Hour},
+	{"h", time.Hour},
+	{"m", time.Minute},
+}
+
+//ParseTimeFrame parses a timeframe in the form <amount><unit> (e.g. 1m, 15m, 4h, 1d, 1w), where unit is one of m, h, d or w.
+func ParseTimeFrame(s string) (TimeFrame, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	for _, unit := range timeFrameUnits {
+		if !strings.HasSuffix(s, unit.suffix) {
+			continue
+		}
+		amount, err := strconv.Atoi(strings.TrimSuffix(s, unit.suffix))
+		if err != nil || amount <= 0 {
+			break
+		}
+		return TimeFrame(time.Duration(amount) * unit.duration), nil
+	}
+
+	return 0, fmt.Errorf("Invalid timeframe %q: expected <amount><unit> with unit one of m, h, d, w (e.g. 15m, 4h, 1d)", s)
+}
+
+//Duration returns the length of the timeframe.
+func (tf TimeFrame) Duration() time.Duration {
+	return time.Duration(tf)
+}
+
+//IsZero returns true if no timeframe is specified.
+func (tf TimeFrame) IsZero() bool {
+	return tf == 0
+}
+
+//Divides returns true if candles of this timeframe can be aggregated into candles of the other timeframe.
+func (tf TimeFrame) Divides(other TimeFrame) bool {
+	return tf > 0 && other%tf == 0
+}
+
+//String returns the timeframe in the form accepted by ParseTimeFrame, using the largest unit possible.
+func (tf TimeFrame) String() string {
+	if tf == 0 {
+		return ""
+	}
+	for _, unit := range timeFrameUnits {
+		if tf.Duration()%unit.duration == 0 {
+			return strconv.FormatInt(int64(tf.Duration()/unit.duration), 10) + unit.suffix
+		}
+	}
+	return tf.Duration().String()
+}
+
+//MarshalText encodes the timeframe as text (e.g. 4h).
+func (tf TimeFrame) MarshalText() ([]byte, error) {
+	return []byte(tf.String()), nil
+}
+
+//UnmarshalText decodes a timeframe from text (see ParseTimeFrame).
+func (tf *TimeFrame) UnmarshalText(text []byte) error {
+	parsed, err := ParseTimeFrame(string(text))
+	if err != nil {
+		return err
+	}
+	*tf = parsed
+	return nil
+}
+
+//UnmarshalYAML decodes a timeframe from a YAML scalar (see ParseTimeFrame).
+func (tf *TimeFrame) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var text string
+	if err := unmarshal(&text); err != nil {
+		return err
+	}
+	return tf.UnmarshalText([]byte(text))
+}
@@ -18,34 +18,78 @@ package exchanges
 import (
 	"context"
 	"errors"
-	"fmt"
+	"strings"
+	"time"
 
 	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/common"
 	"github.com/saniales/golang-crypto-trading-bot/environment"
 	"github.com/shopspring/decimal"
-	"github.com/sirupsen/logrus"
 )
 
+// binanceIntervals maps the supported timeframes to the kline intervals of Binance.
+var binanceIntervals = map[environment.TimeFrame]string{
+	environment.OneMinute:      "1m",
+	environment.ThreeMinutes:   "3m",
+	environment.FiveMinutes:    "5m",
+	environment.FifteenMinutes: "15m",
+	environment.ThirtyMinutes:  "30m",
+	environment.OneHour:        "1h",
+	environment.TwoHours:       "2h",
+	environment.FourHours:      "4h",
+	environment.SixHours:       "6h",
+	environment.EightHours:     "8h",
+	environment.TwelveHours:    "12h",
+	environment.OneDay:         "1d",
+	environment.ThreeDays:      "3d",
+	environment.OneWeek:        "1w",
+}
+
 // BinanceWrapper represents the wrapper for the Binance exchange.
 type BinanceWrapper struct {
 	api              *binance.Client
 	summaries        *SummaryCache
 	candles          *CandlesCache
 	orderbook        *OrderbookCache
+	rules            *RulesCache
+	limiter          *RateLimiter
+	feeds            *FeedSupervisor
 	depositAddresses map[string]string
-	websocketOn      bool
+}
+
+const (
+	binanceTickerFeed = "ticker" // feed of the market summaries.
+	binanceDepthFeed  = "depth"  // feed of the order books.
+)
+
+// binanceWeights represents the request weights of the binance endpoints, by wrapper method name.
+var binanceWeights = map[string]int{
+	"GetMarkets":       20,
+	"GetOrderBook":     5,
+	"GetTicker":        2,
+	"GetMarketSummary": 2,
+	"GetCandles":       2,
+	"GetOrder":         4,
+	"GetOpenOrders":    6,
+	"GetBalance":       20,
 }
 
 // NewBinanceWrapper creates a generic wrapper of the binance API.
 func NewBinanceWrapper(publicKey string, secretKey string, depositAddresses map[string]string) ExchangeWrapper {
+	limiter := NewRateLimiter("binance", 6000, time.Minute, binanceWeights)
+	limiter.usedHeader = "X-Mbx-Used-Weight-1m"
+
 	client := binance.NewClient(publicKey, secretKey)
+	client.HTTPClient = limiter.HTTPClient(client.HTTPClient)
 	return &BinanceWrapper{
 		api:              client,
 		summaries:        NewSummaryCache(),
 		candles:          NewCandlesCache(),
 		orderbook:        NewOrderbookCache(),
+		rules:            NewRulesCache(),
+		limiter:          limiter,
+		feeds:            NewFeedSupervisor("binance"),
 		depositAddresses: depositAddresses,
-		websocketOn:      false,
 	}
 }
 
@@ -58,31 +102,85 @@ func (wrapper *BinanceWrapper) String() string {
 	return wrapper.Name()
 }
 
+// RateLimiter returns the budget of the calls to binance.
+func (wrapper *BinanceWrapper) RateLimiter() *RateLimiter {
+	return wrapper.limiter
+}
+
+// FeedSupervisor returns the supervisor of the binance feeds.
+func (wrapper *BinanceWrapper) FeedSupervisor() *FeedSupervisor {
+	return wrapper.feeds
+}
+
 // GetMarkets Gets all the markets info.
 func (wrapper *BinanceWrapper) GetMarkets() ([]*environment.Market, error) {
-	binanceExchangeInfo, err := wrapper.api.NewExchangeInfoService().Do(context.Background())
+	return wrapper.GetMarketsContext(context.Background())
+}
+
+// GetMarketsContext Gets all the markets info.
+//
+// NOTE: only markets currently trading are returned.
+func (wrapper *BinanceWrapper) GetMarketsContext(ctx context.Context) ([]*environment.Market, error) {
+	if err := wrapper.limiter.Wait(ctx, "GetMarkets"); err != nil {
+		return nil, err
+	}
+	binanceExchangeInfo, err := wrapper.api.NewExchangeInfoService().Do(ctx)
 
 	if err != nil {
 		return nil, err
 	}
 
-	ret := make([]*environment.Market, len(binanceExchangeInfo.Symbols))
+	ret := make([]*environment.Market, 0, len(binanceExchangeInfo.Symbols))
 
-	for i, market := range binanceExchangeInfo.Symbols {
-		ret[i] = &environment.Market{
-			Name:           market.Symbol,
-			BaseCurrency:   market.BaseAsset,
-			MarketCurrency: market.QuoteAsset,
+	for _, market := range binanceExchangeInfo.Symbols {
+		if market.Status != "TRADING" {
+			continue
 		}
+		// binance quotes the base asset in the quote asset, which is the base currency of the bot.
+		ret = append(ret, newExchangeMarket(wrapper, market.Symbol, market.QuoteAsset, market.BaseAsset, binanceMarketRules(market)))
 	}
 
+	wrapper.rules.Set(wrapper, ret)
 	return ret, nil
 }
 
+// binanceMarketRules gets the trading rules of a market from the filters of its symbol.
+func binanceMarketRules(symbol binance.Symbol) *environment.MarketRules {
+	var tickSize, stepSize, minQuantity, minNotional decimal.Decimal
+	if filter := symbol.PriceFilter(); filter != nil {
+		tickSize, _ = decimal.NewFromString(filter.TickSize)
+	}
+	if filter := symbol.LotSizeFilter(); filter != nil {
+		stepSize, _ = decimal.NewFromString(filter.StepSize)
+		minQuantity, _ = decimal.NewFromString(filter.MinQuantity)
+	}
+	if filter := symbol.NotionalFilter(); filter != nil {
+		minNotional, _ = decimal.NewFromString(filter.MinNotional)
+	}
+
+	rules := environment.NewMarketRulesFromSteps(tickSize, stepSize)
+	rules.MinQuantity = minQuantity
+	rules.MinNotional = minNotional
+	return rules
+}
+
+// MarketRules gets the trading rules of a market, getting the ones of all markets from the exchange the first time.
+func (wrapper *BinanceWrapper) MarketRules(market *environment.Market) (*environment.MarketRules, error) {
+	return wrapper.rules.Get(wrapper, market, func() error {
+		_, err := wrapper.GetMarkets()
+		return err
+	})
+}
+
 // GetOrderBook gets the order(ASK + BID) book of a market.
 func (wrapper *BinanceWrapper) GetOrderBook(market *environment.Market) (*environment.OrderBook, error) {
-	if !wrapper.websocketOn {
-		orderbook, _, err := wrapper.orderbookFromREST(market)
+	return wrapper.GetOrderBookContext(context.Background(), market)
+}
+
+// GetOrderBookContext gets the order(ASK + BID) book of a market.
+func (wrapper *BinanceWrapper) GetOrderBookContext(ctx context.Context, market *environment.Market) (*environment.OrderBook, error) {
+	if !wrapper.feeds.Up(binanceDepthFeed, market) {
+		orderbook, _, err := wrapper.orderbookFromREST(ctx, market)
 		if err != nil {
 			return nil, err
 		}
@@ -99,8 +197,19 @@ func (wrapper *BinanceWrapper) GetOrderBook(market *environment.Market) (*enviro
 	return orderbook, nil
 }
 
-func (wrapper *BinanceWrapper) orderbookFromREST(market *environment.Market) (*environment.OrderBook, int64, error) {
-	binanceOrderBook, err := wrapper.api.NewDepthService().Symbol(MarketNameFor(market, wrapper)).Do(context.Background())
+// StreamedOrderBook returns the order book of a market kept from the depth feed, false if the feed of the market is down.
+func (wrapper *BinanceWrapper) StreamedOrderBook(market *environment.Market) (*environment.OrderBook, bool) {
+	if !wrapper.feeds.Up(binanceDepthFeed, market) {
+		return nil, false
+	}
+	return wrapper.orderbook.Get(market)
+}
+
+func (wrapper *BinanceWrapper) orderbookFromREST(ctx context.Context, market *environment.Market) (*environment.OrderBook, int64, error) {
+	if err := wrapper.limiter.Wait(ctx, "GetOrderBook"); err != nil {
+		return nil, -1, err
+	}
+	binanceOrderBook, err := wrapper.api.NewDepthService().Symbol(MarketNameFor(market, wrapper)).Do(ctx)
 	if err != nil {
 		return nil, -1, err
 	}
@@ -145,44 +254,177 @@ func (wrapper *BinanceWrapper) orderbookFromREST(market *environment.Market) (*e
 }
 
 // BuyLimit performs a limit buy action.
-func (wrapper *BinanceWrapper) BuyLimit(market *environment.Market, amount float64, limit float64) (string, error) {
-	orderNumber, err := wrapper.api.NewCreateOrderService().Type(binance.OrderTypeLimit).Side(binance.SideTypeBuy).Symbol(MarketNameFor(market, wrapper)).Price(fmt.Sprint(limit)).Quantity(fmt.Sprint(amount)).Do(context.Background())
+func (wrapper *BinanceWrapper) BuyLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return wrapper.BuyLimitContext(context.Background(), market, amount, limit)
+}
+
+// BuyLimitContext performs a limit buy action.
+func (wrapper *BinanceWrapper) BuyLimitContext(ctx context.Context, market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return wrapper.PlaceOrder(ctx, OrderRequest{Market: market, Type: environment.Bid, Quantity: amount, Limit: limit})
+}
+
+// SellLimit performs a limit sell action.
+func (wrapper *BinanceWrapper) SellLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return wrapper.SellLimitContext(context.Background(), market, amount, limit)
+}
+
+// SellLimitContext performs a limit sell action.
+func (wrapper *BinanceWrapper) SellLimitContext(ctx context.Context, market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return wrapper.PlaceOrder(ctx, OrderRequest{Market: market, Type: environment.Ask, Quantity: amount, Limit: limit})
+}
+
+// BuyMarket performs a market buy action.
+func (wrapper *BinanceWrapper) BuyMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
+	return wrapper.BuyMarketContext(context.Background(), market, amount)
+}
+
+// BuyMarketContext performs a market buy action.
+func (wrapper *BinanceWrapper) BuyMarketContext(ctx context.Context, market *environment.Market, amount decimal.Decimal) (string, error) {
+	return wrapper.PlaceOrder(ctx, OrderRequest{Market: market, Type: environment.Bid, Quantity: amount})
+}
+
+// SellMarket performs a market sell action.
+func (wrapper *BinanceWrapper) SellMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
+	return wrapper.SellMarketContext(context.Background(), market, amount)
+}
+
+// SellMarketContext performs a market sell action.
+func (wrapper *BinanceWrapper) SellMarketContext(ctx context.Context, market *environment.Market, amount decimal.Decimal) (string, error) {
+	return wrapper.PlaceOrder(ctx, OrderRequest{Market: market, Type: environment.Ask, Quantity: amount})
+}
+
+// PlaceOrder places an order, whose ID on binance is its client order ID (generated by binance if empty).
+func (wrapper *BinanceWrapper) PlaceOrder(ctx context.Context, order OrderRequest) (string, error) {
+	quantity, price, err := roundOrder(wrapper, order.Market, order.Type, order.Quantity, order.Limit)
 	if err != nil {
 		return "", err
 	}
-	return orderNumber.ClientOrderID, nil
-}
 
-// SellLimit performs a limit sell action.
-func (wrapper *BinanceWrapper) SellLimit(market *environment.Market, amount float64, limit float64) (string, error) {
-	orderNumber, err := wrapper.api.NewCreateOrderService().Type(binance.OrderTypeLimit).Side(binance.SideTypeSell).Symbol(MarketNameFor(market, wrapper)).Price(fmt.Sprint(limit)).Quantity(fmt.Sprint(amount)).Do(context.Background())
+	service := wrapper.api.NewCreateOrderService().Symbol(MarketNameFor(order.Market, wrapper)).Quantity(quantity.String())
+	if order.Type == environment.Ask {
+		service.Side(binance.SideTypeSell)
+	} else {
+		service.Side(binance.SideTypeBuy)
+	}
+	if price.IsZero() {
+		service.Type(binance.OrderTypeMarket)
+	} else {
+		service.Type(binance.OrderTypeLimit).Price(price.String())
+	}
+	if order.ClientOrderID != "" {
+		service.NewClientOrderID(order.ClientOrderID)
+	}
+
+	if err := wrapper.limiter.Wait(ctx, orderEndpoint(order)); err != nil {
+		return "", err
+	}
+	orderNumber, err := service.Do(ctx)
 	if err != nil {
 		return "", err
 	}
 	return orderNumber.ClientOrderID, nil
 }
 
-// BuyMarket performs a market buy action.
-func (wrapper *BinanceWrapper) BuyMarket(market *environment.Market, amount float64) (string, error) {
-	orderNumber, err := wrapper.api.NewCreateOrderService().Type(binance.OrderTypeMarket).Side(binance.SideTypeBuy).Symbol(MarketNameFor(market, wrapper)).Quantity(fmt.Sprint(amount)).Do(context.Background())
+// GetOrder gets the status of an order placed on the exchange.
+func (wrapper *BinanceWrapper) GetOrder(market *environment.Market, orderID string) (*environment.OrderInfo, error) {
+	return wrapper.GetOrderContext(context.Background(), market, orderID)
+}
+
+// GetOrderContext gets the status of an order placed on the exchange.
+func (wrapper *BinanceWrapper) GetOrderContext(ctx context.Context, market *environment.Market, orderID string) (*environment.OrderInfo, error) {
+	if err := wrapper.limiter.Wait(ctx, "GetOrder"); err != nil {
+		return nil, err
+	}
+	binanceOrder, err := wrapper.api.NewGetOrderService().Symbol(MarketNameFor(market, wrapper)).OrigClientOrderID(orderID).Do(ctx)
+	if apiErr, isAPIErr := err.(*common.APIError); isAPIErr && apiErr.Code == binanceOrderNotFoundCode {
+		return nil, ErrOrderNotFound
+	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return orderNumber.ClientOrderID, nil
+	return convertFromBinanceOrder(market, binanceOrder), nil
 }
 
-// SellMarket performs a market sell action.
-func (wrapper *BinanceWrapper) SellMarket(market *environment.Market, amount float64) (string, error) {
-	orderNumber, err := wrapper.api.NewCreateOrderService().Type(binance.OrderTypeMarket).Side(binance.SideTypeSell).Symbol(MarketNameFor(market, wrapper)).Quantity(fmt.Sprint(amount)).Do(context.Background())
+// GetOpenOrders gets the open orders of the user on a market.
+func (wrapper *BinanceWrapper) GetOpenOrders(market *environment.Market) ([]*environment.OrderInfo, error) {
+	return wrapper.GetOpenOrdersContext(context.Background(), market)
+}
+
+// GetOpenOrdersContext gets the open orders of the user on a market.
+func (wrapper *BinanceWrapper) GetOpenOrdersContext(ctx context.Context, market *environment.Market) ([]*environment.OrderInfo, error) {
+	if err := wrapper.limiter.Wait(ctx, "GetOpenOrders"); err != nil {
+		return nil, err
+	}
+	binanceOrders, err := wrapper.api.NewListOpenOrdersService().Symbol(MarketNameFor(market, wrapper)).Do(ctx)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return orderNumber.ClientOrderID, nil
+
+	ret := make([]*environment.OrderInfo, len(binanceOrders))
+	for i, binanceOrder := range binanceOrders {
+		ret[i] = convertFromBinanceOrder(market, binanceOrder)
+	}
+
+	return ret, nil
+}
+
+// CancelOrder cancels an open order.
+func (wrapper *BinanceWrapper) CancelOrder(market *environment.Market, orderID string) error {
+	return wrapper.CancelOrderContext(context.Background(), market, orderID)
+}
+
+// CancelOrderContext cancels an open order.
+func (wrapper *BinanceWrapper) CancelOrderContext(ctx context.Context, market *environment.Market, orderID string) error {
+	if err := wrapper.limiter.Wait(ctx, "CancelOrder"); err != nil {
+		return err
+	}
+	_, err := wrapper.api.NewCancelOrderService().Symbol(MarketNameFor(market, wrapper)).OrigClientOrderID(orderID).Do(ctx)
+	return err
+}
+
+// convertFromBinanceOrder converts a binance order to a environment.OrderInfo.
+func convertFromBinanceOrder(market *environment.Market, binanceOrder *binance.Order) *environment.OrderInfo {
+	quantity, _ := decimal.NewFromString(binanceOrder.OrigQuantity)
+	limit, _ := decimal.NewFromString(binanceOrder.Price)
+	filledQuantity, _ := decimal.NewFromString(binanceOrder.ExecutedQuantity)
+	filledValue, _ := decimal.NewFromString(binanceOrder.CummulativeQuoteQuantity)
+
+	order := &environment.OrderInfo{
+		ID:             binanceOrder.ClientOrderID,
+		Market:         market,
+		Type:           environment.Bid,
+		Quantity:       quantity,
+		Limit:          limit,
+		FilledQuantity: filledQuantity,
+		Timestamp:      time.Unix(0, binanceOrder.Time*int64(time.Millisecond)),
+	}
+	if binanceOrder.Side == binance.SideTypeSell {
+		order.Type = environment.Ask
+	}
+	if filledQuantity.IsPositive() {
+		order.AveragePrice = filledValue.Div(filledQuantity)
+	}
+
+	switch binanceOrder.Status {
+	case binance.OrderStatusTypeNew:
+		order.Status = environment.OrderNew
+	case binance.OrderStatusTypePartiallyFilled:
+		order.Status = environment.OrderPartiallyFilled
+	case binance.OrderStatusTypeFilled:
+		order.Status = environment.OrderFilled
+	default:
+		order.Status = environment.OrderCancelled
+	}
+
+	return order
 }
 
 // GetTicker gets the updated ticker for a market.
 func (wrapper *BinanceWrapper) GetTicker(market *environment.Market) (*environment.Ticker, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetTicker"); err != nil {
+		return nil, err
+	}
 	binanceTicker, err := wrapper.api.NewListBookTickersService().Symbol(MarketNameFor(market, wrapper)).Do(context.Background())
 	if err != nil {
 		return nil, err
@@ -200,8 +442,16 @@ func (wrapper *BinanceWrapper) GetTicker(market *environment.Market) (*environme
 
 // GetMarketSummary gets the current market summary.
 func (wrapper *BinanceWrapper) GetMarketSummary(market *environment.Market) (*environment.MarketSummary, error) {
-	if !wrapper.websocketOn {
-		binanceSummary, err := wrapper.api.NewListPriceChangeStatsService().Symbol(MarketNameFor(market, wrapper)).Do(context.Background())
+	return wrapper.GetMarketSummaryContext(context.Background(), market)
+}
+
+// GetMarketSummaryContext gets the current market summary.
+func (wrapper *BinanceWrapper) GetMarketSummaryContext(ctx context.Context, market *environment.Market) (*environment.MarketSummary, error) {
+	if !wrapper.feeds.Up(binanceTickerFeed, market) {
+		if err := wrapper.limiter.Wait(ctx, "GetMarketSummary"); err != nil {
+			return nil, err
+		}
+		binanceSummary, err := wrapper.api.NewListPriceChangeStatsService().Symbol(MarketNameFor(market, wrapper)).Do(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -232,47 +482,72 @@ func (wrapper *BinanceWrapper) GetMarketSummary(market *environment.Market) (*en
 
 // GetCandles gets the candle data from the exchange.
 func (wrapper *BinanceWrapper) GetCandles(market *environment.Market) ([]environment.CandleStick, error) {
-	if !wrapper.websocketOn {
-		binanceCandles, err := wrapper.api.NewKlinesService().
-			Symbol(MarketNameFor(market, wrapper)).
-			Interval(MarketTimeFrameFor(market, wrapper)).
-			Do(context.Background())
-		if err != nil {
-			return nil, err
-		}
+	return wrapper.GetCandlesContext(context.Background(), market)
+}
 
-		ret := make([]environment.CandleStick, len(binanceCandles))
-
-		for i, binanceCandle := range binanceCandles {
-			high, _ := decimal.NewFromString(binanceCandle.High)
-			open, _ := decimal.NewFromString(binanceCandle.Open)
-			close, _ := decimal.NewFromString(binanceCandle.Close)
-			low, _ := decimal.NewFromString(binanceCandle.Low)
-			volume, _ := decimal.NewFromString(binanceCandle.Volume)
-
-			ret[i] = environment.CandleStick{
-				High:   high,
-				Open:   open,
-				Close:  close,
-				Low:    low,
-				Volume: volume,
-			}
-		}
+// GetCandlesContext gets the candle data from the exchange.
+//
+// NOTE: candles are always fetched via REST, 30 minutes long unless a different time_frame is configured for the market.
+func (wrapper *BinanceWrapper) GetCandlesContext(ctx context.Context, market *environment.Market) ([]environment.CandleStick, error) {
+	timeFrame, native, err := candleTimeFrames(market, wrapper, binanceIntervals, environment.ThirtyMinutes)
+	if err != nil {
+		return nil, err
+	}
 
-		wrapper.candles.Set(market, ret)
+	if err := wrapper.limiter.Wait(ctx, "GetCandles"); err != nil {
+		return nil, err
+	}
+	binanceCandles, err := wrapper.api.NewKlinesService().
+		Symbol(MarketNameFor(market, wrapper)).
+		Interval(binanceIntervals[native]).
+		Do(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	ret, candleLoaded := wrapper.candles.Get(market)
-	if !candleLoaded {
-		return nil, errors.New("No candle data yet")
+	ret := make([]environment.CandleStick, len(binanceCandles))
+
+	for i, binanceCandle := range binanceCandles {
+		high, _ := decimal.NewFromString(binanceCandle.High)
+		open, _ := decimal.NewFromString(binanceCandle.Open)
+		close, _ := decimal.NewFromString(binanceCandle.Close)
+		low, _ := decimal.NewFromString(binanceCandle.Low)
+		volume, _ := decimal.NewFromString(binanceCandle.Volume)
+		openTime := time.Unix(0, binanceCandle.OpenTime*int64(time.Millisecond))
+		closeTime := time.Unix(0, (binanceCandle.CloseTime+1)*int64(time.Millisecond)) // binance close time is the last millisecond of the period.
+
+		ret[i] = environment.CandleStick{
+			High:      high,
+			Open:      open,
+			Close:     close,
+			Low:       low,
+			Volume:    volume,
+			OpenTime:  openTime,
+			CloseTime: closeTime,
+			Period:    closeTime.Sub(openTime),
+		}
+	}
+
+	ret, err = AggregateCandles(ret, timeFrame)
+	if err != nil {
+		return nil, err
 	}
 
+	wrapper.candles.Set(market, ret)
 	return ret, nil
 }
 
 // GetBalance gets the balance of the user of the specified currency.
 func (wrapper *BinanceWrapper) GetBalance(symbol string) (*decimal.Decimal, error) {
-	binanceAccount, err := wrapper.api.NewGetAccountService().Do(context.Background())
+	return wrapper.GetBalanceContext(context.Background(), symbol)
+}
+
+// GetBalanceContext gets the balance of the user of the specified currency.
+func (wrapper *BinanceWrapper) GetBalanceContext(ctx context.Context, symbol string) (*decimal.Decimal, error) {
+	if err := wrapper.limiter.Wait(ctx, "GetBalance"); err != nil {
+		return nil, err
+	}
+	binanceAccount, err := wrapper.api.NewGetAccountService().Do(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -299,41 +574,59 @@ func (wrapper *BinanceWrapper) GetDepositAddress(coinTicker string) (string, boo
 // CalculateTradingFees calculates the trading fees for an order on a specified market.
 //
 //     NOTE: In Binance fees are currently hardcoded.
-func (wrapper *BinanceWrapper) CalculateTradingFees(market *environment.Market, amount float64, limit float64, orderType TradeType) float64 {
-	var feePercentage float64
+func (wrapper *BinanceWrapper) CalculateTradingFees(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal, orderType TradeType) decimal.Decimal {
+	var feePercentage decimal.Decimal
 	if orderType == MakerTrade {
-		feePercentage = 0.0010
+		feePercentage = decimal.NewFromFloat(0.0010)
 	} else if orderType == TakerTrade {
-		feePercentage = 0.0010
+		feePercentage = decimal.NewFromFloat(0.0010)
 	} else {
 		panic("Unknown trade type")
 	}
 
-	return amount * limit * feePercentage
+	return amount.Mul(limit).Mul(feePercentage)
 }
 
 // CalculateWithdrawFees calculates the withdrawal fees on a specified market.
-func (wrapper *BinanceWrapper) CalculateWithdrawFees(market *environment.Market, amount float64) float64 {
+func (wrapper *BinanceWrapper) CalculateWithdrawFees(market *environment.Market, amount decimal.Decimal) decimal.Decimal {
 	panic("Not Implemented")
 }
 
 // FeedConnect connects to the feed of the exchange.
 func (wrapper *BinanceWrapper) FeedConnect(markets []*environment.Market) error {
-	for _, m := range markets {
-		err := wrapper.subscribeMarketSummaryFeed(m)
-		if err != nil {
-			return err
-		}
-		wrapper.subscribeOrderbookFeed(m)
+	return wrapper.FeedConnectContext(context.Background(), markets)
+}
+
+// FeedConnectContext connects to the feed of the exchange, closing the subscriptions when the context is done.
+//
+// The feeds are supervised (see FeedSupervisor): summaries and order books are fetched via REST while their feed is down.
+func (wrapper *BinanceWrapper) FeedConnectContext(ctx context.Context, markets []*environment.Market) error {
+	err := wrapper.feeds.Start(ctx, binanceTickerFeed, markets, wrapper.runMarketSummaryFeed)
+	if err != nil {
+		return err
 	}
-	wrapper.websocketOn = true
 
-	return nil
+	return wrapper.feeds.Start(ctx, binanceDepthFeed, markets, wrapper.runOrderbookFeed)
 }
 
-// SubscribeMarketSummaryFeed subscribes to the Market Summary Feed service.
-func (wrapper *BinanceWrapper) subscribeMarketSummaryFeed(market *environment.Market) error {
-	_, _, err := binance.WsMarketStatServe(MarketNameFor(market, wrapper), func(event *binance.WsMarketStatEvent) {
+// runMarketSummaryFeed runs a connection to the Market Summary Feed of the markets.
+func (wrapper *BinanceWrapper) runMarketSummaryFeed(ctx context.Context, markets []*environment.Market, link *FeedLink) error {
+	symbols := make([]string, len(markets))
+	bySymbol := make(map[string]*environment.Market, len(markets))
+	for i, m := range markets {
+		symbols[i] = MarketNameFor(m, wrapper)
+		bySymbol[strings.ToUpper(symbols[i])] = m // events have upper case symbols.
+	}
+
+	var feedErr error
+	done, stop, err := binance.WsCombinedMarketStatServe(symbols, func(event *binance.WsMarketStatEvent) {
+		link.Beat()
+
+		market, exists := bySymbol[event.Symbol]
+		if !exists {
+			return
+		}
+
 		high, _ := decimal.NewFromString(event.HighPrice)
 		low, _ := decimal.NewFromString(event.LowPrice)
 		ask, _ := decimal.NewFromString(event.AskPrice)
@@ -349,70 +642,128 @@ func (wrapper *BinanceWrapper) subscribeMarketSummaryFeed(market *environment.Ma
 			Last:   last,
 			Volume: volume,
 		})
-	}, func(error) {})
+	}, func(err error) {
+		feedErr = err
+	})
 	if err != nil {
 		return err
 	}
-	return nil
+	link.Connected()
+
+	closeOnDone(ctx, done, stop)
+	return feedErr
 }
 
-func (wrapper *BinanceWrapper) subscribeOrderbookFeed(market *environment.Market) {
-	go func() {
-		for {
-			_, lastUpdateID, err := wrapper.orderbookFromREST(market)
-			if err != nil {
-				logrus.Error(err)
-				return
+// runOrderbookFeed runs a connection to the Orderbook Feed of the markets, starting from their REST order books.
+func (wrapper *BinanceWrapper) runOrderbookFeed(ctx context.Context, markets []*environment.Market, link *FeedLink) error {
+	symbolLevels := make(map[string]string, len(markets))
+	bySymbol := make(map[string]*environment.Market, len(markets))
+	lastUpdateIDs := make(map[string]int64, len(markets))
+	for _, m := range markets {
+		symbol := strings.ToUpper(MarketNameFor(m, wrapper)) // events have upper case symbols.
+		symbolLevels[symbol] = "20"
+		bySymbol[symbol] = m
+
+		orderbook, lastUpdateID, err := wrapper.orderbookFromREST(ctx, m)
+		if err != nil {
+			return err
+		}
+		wrapper.orderbook.Set(m, orderbook)
+		lastUpdateIDs[symbol] = lastUpdateID
+	}
+
+	var feedErr error
+	done, stop, err := binance.WsCombinedPartialDepthServe(symbolLevels, func(event *binance.WsPartialDepthEvent) {
+		link.Beat()
+
+		market, exists := bySymbol[event.Symbol]
+		if !exists || event.LastUpdateID <= lastUpdateIDs[event.Symbol] { // the fetched order book is more recent than this update
+			return
+		}
+
+		var orderbook environment.OrderBook
+
+		orderbook.Asks = make([]environment.Order, len(event.Asks))
+		orderbook.Bids = make([]environment.Order, len(event.Bids))
+
+		for i, ask := range event.Asks {
+			price, _ := decimal.NewFromString(ask.Price)
+			quantity, _ := decimal.NewFromString(ask.Quantity)
+			newOrder := environment.Order{
+				Value:    price,
+				Quantity: quantity,
+			}
+			orderbook.Asks[i] = newOrder
+		}
+
+		for i, bid := range event.Bids {
+			price, _ := decimal.NewFromString(bid.Price)
+			quantity, _ := decimal.NewFromString(bid.Quantity)
+			newOrder := environment.Order{
+				Value:    price,
+				Quantity: quantity,
 			}
-			// 24 hours max
-			currentUpdateID := lastUpdateID
-
-			done, _, _ := binance.WsPartialDepthServe(MarketNameFor(market, wrapper), "20", func(event *binance.WsPartialDepthEvent) {
-				if event.LastUpdateID <= currentUpdateID { // this update is more recent than the latest fetched
-					return
-				}
-
-				var orderbook environment.OrderBook
-
-				orderbook.Asks = make([]environment.Order, len(event.Asks))
-				orderbook.Bids = make([]environment.Order, len(event.Bids))
-
-				for i, ask := range event.Asks {
-					price, _ := decimal.NewFromString(ask.Price)
-					quantity, _ := decimal.NewFromString(ask.Quantity)
-					newOrder := environment.Order{
-						Value:    price,
-						Quantity: quantity,
-					}
-					orderbook.Asks[i] = newOrder
-				}
-
-				for i, bid := range event.Bids {
-					price, _ := decimal.NewFromString(bid.Price)
-					quantity, _ := decimal.NewFromString(bid.Quantity)
-					newOrder := environment.Order{
-						Value:    price,
-						Quantity: quantity,
-					}
-					orderbook.Bids[i] = newOrder
-				}
-
-				wrapper.orderbook.Set(market, &orderbook)
-			}, func(err error) {
-				logrus.Error(err)
-			})
-
-			<-done
+			orderbook.Bids[i] = newOrder
 		}
-	}()
+
+		wrapper.orderbook.Set(market, &orderbook)
+	}, func(err error) {
+		feedErr = err
+	})
+	if err != nil {
+		return err
+	}
+	link.Connected()
+
+	closeOnDone(ctx, done, stop)
+	return feedErr
+}
+
+// closeOnDone waits for a websocket subscription to end, closing it if the context is done first.
+func closeOnDone(ctx context.Context, done <-chan struct{}, stop chan<- struct{}) {
+	select {
+	case <-done:
+	case <-ctx.Done():
+		close(stop)
+		<-done
+	}
 }
 
 // Withdraw performs a withdraw operation from the exchange to a destination address.
-func (wrapper *BinanceWrapper) Withdraw(destinationAddress string, coinTicker string, amount float64) error {
-	_, err := wrapper.api.NewCreateWithdrawService().Address(destinationAddress).Coin(coinTicker).Amount(fmt.Sprint(amount)).Do(context.Background())
+func (wrapper *BinanceWrapper) Withdraw(destinationAddress string, coinTicker string, amount decimal.Decimal) error {
+	return wrapper.WithdrawContext(context.Background(), destinationAddress, coinTicker, amount)
+}
+
+// WithdrawContext performs a withdraw operation from the exchange to a destination address.
+func (wrapper *BinanceWrapper) WithdrawContext(ctx context.Context, destinationAddress string, coinTicker string, amount decimal.Decimal) error {
+	if err := wrapper.limiter.Wait(ctx, "Withdraw"); err != nil {
+		return err
+	}
+	_, err := wrapper.api.NewCreateWithdrawService().Address(destinationAddress).Coin(coinTicker).Amount(amount.String()).Do(ctx)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// binanceOrderNotFoundCode is the code of the error returned by Binance when an order does not exist.
+const binanceOrderNotFoundCode = -2013
+
+// isBinanceTransient tells if an error is a Binance error after which a call can be retried:
+// an unknown or timed out request, too many requests or an unreadable response.
+func isBinanceTransient(err error) bool {
+	var apiErr *common.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if !apiErr.IsValid() {
+		return true
+	}
+	switch apiErr.Code {
+	case -1000, -1001, -1003, -1006, -1007, -1008, -1015:
+		return true
+	default:
+		return false
+	}
+}
@@ -1,14 +1,24 @@
 package exchanges
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"math"
+	"io"
+	"net/http"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/shopspring/decimal"
 
+	bitfinexutils "github.com/bitfinexcom/bitfinex-api-go/pkg/utils"
 	bitfinex "github.com/bitfinexcom/bitfinex-api-go/v1"
 	"github.com/saniales/golang-crypto-trading-bot/environment"
 )
@@ -16,21 +26,31 @@ import (
 // BitfinexWrapper provides a Generic wrapper of the Bitfinex API.
 type BitfinexWrapper struct {
 	api                 *bitfinex.Client
-	websocketOn         bool
 	unsubscribeChannels map[string]chan bool
 	summaries           *SummaryCache
 	orderbook           *OrderbookCache
+	rules               *RulesCache
+	limiter             *RateLimiter
+	httpClient          *http.Client // sends the requests of post, updating the budget from the responses.
+	feeds               *FeedSupervisor
 	depositAddresses    map[string]string
 }
 
+// bitfinexFeed is the name of the feed of the market summaries and order books.
+const bitfinexFeed = "ticker+book"
+
 // NewBitfinexWrapper creates a generic wrapper of the bittrex API.
 func NewBitfinexWrapper(publicKey string, secretKey string, depositAddresses map[string]string) ExchangeWrapper {
+	limiter := NewRateLimiter("bitfinex", 90, time.Minute, nil)
 	return &BitfinexWrapper{
 		api:                 bitfinex.NewClient().Auth(publicKey, secretKey),
 		unsubscribeChannels: make(map[string]chan bool),
 		summaries:           NewSummaryCache(),
 		orderbook:           NewOrderbookCache(),
-		websocketOn:         false,
+		rules:               NewRulesCache(),
+		limiter:             limiter,
+		httpClient:          limiter.HTTPClient(nil),
+		feeds:               NewFeedSupervisor("bitfinex"),
 		depositAddresses:    depositAddresses,
 	}
 }
@@ -44,29 +64,54 @@ func (wrapper *BitfinexWrapper) String() string {
 	return wrapper.Name()
 }
 
+// RateLimiter returns the budget of the calls to bitfinex.
+func (wrapper *BitfinexWrapper) RateLimiter() *RateLimiter {
+	return wrapper.limiter
+}
+
 // GetMarkets gets all the markets info.
 func (wrapper *BitfinexWrapper) GetMarkets() ([]*environment.Market, error) {
-	bitfinexMarkets, err := wrapper.api.Pairs.All()
+	if err := wrapper.limiter.Wait(context.Background(), "GetMarkets"); err != nil {
+		return nil, err
+	}
+	bitfinexMarkets, err := wrapper.api.Pairs.AllDetailed()
 	if err != nil {
 		return nil, err
 	}
 
-	wrappedMarkets := make([]*environment.Market, len(bitfinexMarkets))
-	for i, pair := range bitfinexMarkets {
-		quote, base := pair[0:2], pair[3:5]
-		wrappedMarkets[i] = &environment.Market{
-			Name:           pair,
-			BaseCurrency:   base,
-			MarketCurrency: quote,
+	wrappedMarkets := make([]*environment.Market, 0, len(bitfinexMarkets))
+	for _, market := range bitfinexMarkets {
+		pair := market.Pair
+		if len(pair) != 6 {
+			continue
+		}
+		// pairs are in the form ethbtc, the first currency being quoted in the second one.
+		quote, base := strings.ToUpper(pair[0:3]), strings.ToUpper(pair[3:6])
+		// the precision of the prices is in significant digits, which cannot be represented by a tick size.
+		rules := &environment.MarketRules{
+			MinQuantity: decimal.NewFromFloat(market.MinimumOrderSize),
 		}
+		wrappedMarkets = append(wrappedMarkets, newExchangeMarket(wrapper, pair, base, quote, rules))
 	}
 
+	wrapper.rules.Set(wrapper, wrappedMarkets)
 	return wrappedMarkets, nil
 }
 
+// MarketRules gets the trading rules of a market, getting the ones of all markets from the exchange the first time.
+func (wrapper *BitfinexWrapper) MarketRules(market *environment.Market) (*environment.MarketRules, error) {
+	return wrapper.rules.Get(wrapper, market, func() error {
+		_, err := wrapper.GetMarkets()
+		return err
+	})
+}
+
 // GetOrderBook gets the order(ASK + BID) book of a market.
 func (wrapper *BitfinexWrapper) GetOrderBook(market *environment.Market) (*environment.OrderBook, error) {
-	if !wrapper.websocketOn {
+	if !wrapper.feeds.Up(bitfinexFeed, market) {
+		if err := wrapper.limiter.Wait(context.Background(), "GetOrderBook"); err != nil {
+			return nil, err
+		}
 		bitfinexOrderBook, err := wrapper.api.OrderBook.Get(MarketNameFor(market, wrapper), 0, 0, false)
 		if err != nil {
 			return nil, err
@@ -116,51 +161,197 @@ func (wrapper *BitfinexWrapper) GetOrderBook(market *environment.Market) (*envir
 	return orderbook, nil
 }
 
+// StreamedOrderBook returns the order book of a market kept from the book feed, false if the feed of the market is down.
+func (wrapper *BitfinexWrapper) StreamedOrderBook(market *environment.Market) (*environment.OrderBook, bool) {
+	if !wrapper.feeds.Up(bitfinexFeed, market) {
+		return nil, false
+	}
+	return wrapper.orderbook.Get(market)
+}
+
 // BuyLimit performs a limit buy action.
-//
-// NOTE: In bitfinex buy and sell orders behave the same (the go bitfinex api automatically puts it on correct side)
-func (wrapper *BitfinexWrapper) BuyLimit(market *environment.Market, amount float64, limit float64) (string, error) {
-	amount = math.Abs(amount)
-	return wrapper.createOrder(market, bitfinex.OrderTypeLimit, amount, limit)
+func (wrapper *BitfinexWrapper) BuyLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return wrapper.createOrder(market, "BuyLimit", bitfinex.OrderTypeLimit, environment.Bid, amount, limit)
 }
 
 // SellLimit performs a limit sell action.
-//
-// NOTE: In bitfinex buy and sell orders behave the same (the go bitfinex api automatically puts it on correct side)
-func (wrapper *BitfinexWrapper) SellLimit(market *environment.Market, amount float64, limit float64) (string, error) {
-	amount = -math.Abs(amount) // a sell is a buy with negative amount.
-	return wrapper.createOrder(market, bitfinex.OrderTypeLimit, amount, limit)
+func (wrapper *BitfinexWrapper) SellLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return wrapper.createOrder(market, "SellLimit", bitfinex.OrderTypeLimit, environment.Ask, amount, limit)
 }
 
-// BuyMarket performs a limit buy action.
-//
-// NOTE: In bitfinex buy and sell orders behave the same (the go bitfinex api automatically puts it on correct side)
-func (wrapper *BitfinexWrapper) BuyMarket(market *environment.Market, amount float64) (string, error) {
-	amount = math.Abs(amount)
-	return wrapper.createOrder(market, bitfinex.OrderTypeMarket, amount, 0)
+// BuyMarket performs a market buy action.
+func (wrapper *BitfinexWrapper) BuyMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
+	return wrapper.createOrder(market, "BuyMarket", bitfinex.OrderTypeMarket, environment.Bid, amount, decimal.Zero)
 }
 
-// SellMarket performs a limit sell action.
-//
-// NOTE: In bitfinex buy and sell orders behave the same (the go bitfinex api automatically puts it on correct side)
-func (wrapper *BitfinexWrapper) SellMarket(market *environment.Market, amount float64) (string, error) {
-	amount = -math.Abs(amount) // a sell is a buy with negative amount.
-	return wrapper.createOrder(market, bitfinex.OrderTypeMarket, amount, 0)
+// SellMarket performs a market sell action.
+func (wrapper *BitfinexWrapper) SellMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
+	return wrapper.createOrder(market, "SellMarket", bitfinex.OrderTypeMarket, environment.Ask, amount, decimal.Zero)
 }
 
-// createOrder creates a generic order.
-//
-// NOTE: In bitfinex buy and sell orders behave the same (in sell the amount is negative)
-func (wrapper *BitfinexWrapper) createOrder(market *environment.Market, orderType string, amount float64, price float64) (string, error) {
-	orderNumber, err := wrapper.api.Orders.Create(MarketNameFor(market, wrapper), amount, price, orderType)
+// createOrder creates a generic order through the endpoint of its wrapper method, price is zero for market orders.
+func (wrapper *BitfinexWrapper) createOrder(market *environment.Market, endpoint string, orderType string, side environment.OrderType, amount decimal.Decimal, price decimal.Decimal) (string, error) {
+	quantity, limit, err := roundOrder(wrapper, market, side, amount.Abs(), price)
+	if err != nil {
+		return "", err
+	}
+
+	payload := map[string]interface{}{
+		"symbol":   MarketNameFor(market, wrapper),
+		"amount":   quantity.String(),
+		"price":    limit.String(),
+		"side":     "buy",
+		"type":     orderType,
+		"exchange": "bitfinex",
+	}
+	if side == environment.Ask {
+		payload["side"] = "sell"
+	}
+	if limit.IsZero() {
+		// market orders need a positive price, which is ignored.
+		payload["price"] = "1"
+	}
+
+	if err := wrapper.limiter.Wait(context.Background(), endpoint); err != nil {
+		return "", err
+	}
+	var orderNumber bitfinex.Order
+	err = wrapper.post("order/new", payload, &orderNumber)
 	if err != nil {
 		return "", err
 	}
 	return fmt.Sprint(orderNumber.ID), nil
 }
 
+// post sends an authenticated request to the v1 REST API of bitfinex, decoding the response into v.
+//
+// The requests placing orders and withdraws are sent here instead of through the client, which formats
+// their amounts as float32: the payload is sent as it is, so amounts are sent as exact decimal strings.
+func (wrapper *BitfinexWrapper) post(refURL string, payload map[string]interface{}, v interface{}) error {
+	payload["request"] = "/v1/" + refURL
+	payload["nonce"] = bitfinexutils.GetNonce()
+	content, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString(content)
+	signature := hmac.New(sha512.New384, []byte(wrapper.api.APISecret))
+	signature.Write([]byte(encoded))
+
+	req, err := http.NewRequest("POST", wrapper.api.BaseURL.String()+refURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("X-BFX-APIKEY", wrapper.api.APIKey)
+	req.Header.Add("X-BFX-PAYLOAD", encoded)
+	req.Header.Add("X-BFX-SIGNATURE", hex.EncodeToString(signature.Sum(nil)))
+
+	resp, err := wrapper.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var errorResponse struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &errorResponse) != nil || errorResponse.Message == "" {
+			errorResponse.Message = string(body)
+		}
+		return fmt.Errorf("POST %s: %d %s", refURL, resp.StatusCode, errorResponse.Message)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// GetOrder gets the status of an order placed on the exchange.
+func (wrapper *BitfinexWrapper) GetOrder(market *environment.Market, orderID string) (*environment.OrderInfo, error) {
+	id, err := strconv.ParseInt(orderID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := wrapper.limiter.Wait(context.Background(), "GetOrder"); err != nil {
+		return nil, err
+	}
+	bitfinexOrder, err := wrapper.api.Orders.Status(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertFromBitfinexOrder(market, bitfinexOrder), nil
+}
+
+// GetOpenOrders gets the open orders of the user on a market.
+func (wrapper *BitfinexWrapper) GetOpenOrders(market *environment.Market) ([]*environment.OrderInfo, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetOpenOrders"); err != nil {
+		return nil, err
+	}
+	bitfinexOrders, err := wrapper.api.Orders.All()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]*environment.OrderInfo, 0, len(bitfinexOrders))
+	for _, bitfinexOrder := range bitfinexOrders {
+		if strings.EqualFold(bitfinexOrder.Symbol, MarketNameFor(market, wrapper)) {
+			ret = append(ret, convertFromBitfinexOrder(market, bitfinexOrder))
+		}
+	}
+
+	return ret, nil
+}
+
+// CancelOrder cancels an open order.
+func (wrapper *BitfinexWrapper) CancelOrder(market *environment.Market, orderID string) error {
+	id, err := strconv.ParseInt(orderID, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	if err := wrapper.limiter.Wait(context.Background(), "CancelOrder"); err != nil {
+		return err
+	}
+	return wrapper.api.Orders.Cancel(id)
+}
+
+// convertFromBitfinexOrder converts a bitfinex order to a environment.OrderInfo.
+func convertFromBitfinexOrder(market *environment.Market, bitfinexOrder bitfinex.Order) *environment.OrderInfo {
+	quantity, _ := decimal.NewFromString(bitfinexOrder.OriginalAmount)
+	limit, _ := decimal.NewFromString(bitfinexOrder.Price)
+	filledQuantity, _ := decimal.NewFromString(bitfinexOrder.ExecutedAmount)
+	averagePrice, _ := decimal.NewFromString(bitfinexOrder.AvgExecutionPrice)
+	timestamp, _ := strconv.ParseFloat(bitfinexOrder.Timestamp, 64)
+
+	order := &environment.OrderInfo{
+		ID:             fmt.Sprint(bitfinexOrder.ID),
+		Market:         market,
+		Type:           environment.Bid,
+		Status:         orderStatusFor(quantity, filledQuantity, bitfinexOrder.IsLive),
+		Quantity:       quantity,
+		Limit:          limit,
+		FilledQuantity: filledQuantity,
+		AveragePrice:   averagePrice,
+		Timestamp:      time.Unix(int64(timestamp), 0),
+	}
+	if bitfinexOrder.Side == "sell" {
+		order.Type = environment.Ask
+	}
+
+	return order
+}
+
 // GetTicker gets the updated ticker for a market.
 func (wrapper *BitfinexWrapper) GetTicker(market *environment.Market) (*environment.Ticker, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetTicker"); err != nil {
+		return nil, err
+	}
 	bitfinexTicker, err := wrapper.api.Ticker.Get(MarketNameFor(market, wrapper))
 	if err != nil {
 		return nil, err
@@ -179,7 +370,10 @@ func (wrapper *BitfinexWrapper) GetTicker(market *environment.Market) (*environm
 
 // GetMarketSummary gets the current market summary.
 func (wrapper *BitfinexWrapper) GetMarketSummary(market *environment.Market) (*environment.MarketSummary, error) {
-	if !wrapper.websocketOn {
+	if !wrapper.feeds.Up(bitfinexFeed, market) {
+		if err := wrapper.limiter.Wait(context.Background(), "GetMarketSummary"); err != nil {
+			return nil, err
+		}
 		bitfinexSummary, err := wrapper.api.Ticker.Get(MarketNameFor(market, wrapper))
 		if err != nil {
 			return nil, err
@@ -216,6 +410,9 @@ func (wrapper *BitfinexWrapper) GetCandles(market *environment.Market) ([]enviro
 
 // GetBalance gets the balance of the user of the specified currency.
 func (wrapper *BitfinexWrapper) GetBalance(symbol string) (*decimal.Decimal, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetBalance"); err != nil {
+		return nil, err
+	}
 	bitfinexBalances, err := wrapper.api.Balances.All()
 	if err != nil {
 		return nil, err
@@ -244,80 +441,77 @@ func (wrapper *BitfinexWrapper) GetDepositAddress(coinTicker string) (string, bo
 // CalculateTradingFees calculates the trading fees for an order on a specified market.
 //
 //     NOTE: In Bitfinex fees are currently hardcoded.
-func (wrapper *BitfinexWrapper) CalculateTradingFees(market *environment.Market, amount float64, limit float64, orderType TradeType) float64 {
-	var feePercentage float64
+func (wrapper *BitfinexWrapper) CalculateTradingFees(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal, orderType TradeType) decimal.Decimal {
+	var feePercentage decimal.Decimal
 	if orderType == MakerTrade {
-		feePercentage = 0.0010 // 0.1%
+		feePercentage = decimal.NewFromFloat(0.0010) // 0.1%
 	} else if orderType == TakerTrade {
-		feePercentage = 0.0020 // 0.2%
+		feePercentage = decimal.NewFromFloat(0.0020) // 0.2%
 	} else {
 		panic("Unknown trade type")
 	}
 
-	return amount * limit * feePercentage
+	return amount.Mul(limit).Mul(feePercentage)
 }
 
 // CalculateWithdrawFees calculates the withdrawal fees on a specified market.
-func (wrapper *BitfinexWrapper) CalculateWithdrawFees(market *environment.Market, amount float64) float64 {
+func (wrapper *BitfinexWrapper) CalculateWithdrawFees(market *environment.Market, amount decimal.Decimal) decimal.Decimal {
 	panic("Not Implemented")
 }
 
 // FeedConnect connects to the feed of the exchange.
 func (wrapper *BitfinexWrapper) FeedConnect(markets []*environment.Market) error {
-	err := wrapper.api.WebSocket.Connect()
+	return wrapper.FeedConnectContext(context.Background(), markets)
+}
+
+// FeedConnectContext connects to the feed of the exchange, closing it when the context is done.
+//
+// The feed is supervised (see FeedSupervisor): summaries and order books are fetched via REST while it is down.
+func (wrapper *BitfinexWrapper) FeedConnectContext(ctx context.Context, markets []*environment.Market) error {
+	return wrapper.feeds.Start(ctx, bitfinexFeed, markets, wrapper.runFeed)
+}
+
+// runFeed runs a connection to the ticker and book channels of the markets.
+func (wrapper *BitfinexWrapper) runFeed(ctx context.Context, markets []*environment.Market, link *FeedLink) error {
+	ws := bitfinex.NewWebSocketService(wrapper.api)
+	err := ws.Connect()
 	if err != nil {
-		fmt.Println(err)
+		return err
 	}
 
-	bookMap := make(map[string]chan []float64)
-	tickers := make(chan []float64, 25)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ws.Close()
+		case <-done:
+		}
+	}()
 
+	channels := make([]chan []float64, 0, 2*len(markets))
 	for _, m := range markets {
 		tickerKey := MarketNameFor(m, wrapper)
-		bookMap[tickerKey] = make(chan []float64, 25)
-		wrapper.api.WebSocket.AddSubscribe(bitfinex.ChanBook, tickerKey, bookMap[tickerKey])
-		wrapper.subscribeFeeds(m, tickers, bookMap[tickerKey]) // tickers is not used
+		tickers := make(chan []float64, 25)
+		orderbooks := make(chan []float64, 25)
+		ws.AddSubscribe(bitfinex.ChanTicker, tickerKey, tickers)
+		ws.AddSubscribe(bitfinex.ChanBook, tickerKey, orderbooks)
+		wrapper.subscribeFeeds(m, tickers, orderbooks, link)
+		channels = append(channels, tickers, orderbooks)
 	}
+	link.Connected()
 
-	wrapper.websocketOn = true
-
-	go func(tickers chan []float64, orderbooks map[string]chan []float64) {
-		for {
-			err = wrapper.api.WebSocket.Subscribe()
-			if err != nil {
-				fmt.Println(err)
-			}
-
-			wrapper.api.WebSocket.Close()
-
-			for _, channel := range bookMap {
-				close(channel)
-			}
-
-			bookMap := make(map[string]chan []float64)
-			err = errors.New("")
-			for err != nil {
-				err = wrapper.api.WebSocket.Connect()
-				if err != nil {
-					fmt.Println(err)
-				}
-			}
-			wrapper.api.WebSocket.ClearSubscriptions()
-
-			for _, m := range markets {
-				tickerKey := MarketNameFor(m, wrapper)
-				bookMap[tickerKey] = make(chan []float64)
-				//wrapper.api.WebSocket.AddSubscribe(bitfinex.ChanTicker, tickerKey, tickers)
-				wrapper.api.WebSocket.AddSubscribe(bitfinex.ChanBook, tickerKey, bookMap[tickerKey])
-				//wrapper.api.WebSocket.AddSubscribe(bitfinex.ChanTrade, tickerKey, trades)
-			}
-		}
-	}(tickers, bookMap)
-	return nil
+	// sends the subscriptions, then reads the feed until the connection fails.
+	err = ws.Subscribe()
+	ws.Close()
+	for _, channel := range channels {
+		close(channel)
+	}
+	return err
 }
 
 // subscribeMarketSummaryFeed subscribes to the Market Summary Feed service.
-func (wrapper *BitfinexWrapper) subscribeFeeds(market *environment.Market, tickers <-chan []float64, orderbooks <-chan []float64) {
+func (wrapper *BitfinexWrapper) subscribeFeeds(market *environment.Market, tickers <-chan []float64, orderbooks <-chan []float64, link *FeedLink) {
 	//trades := make(chan []float64)
 
 	//     NOTE: Content of result array
@@ -337,10 +531,12 @@ func (wrapper *BitfinexWrapper) subscribeFeeds(market *environment.Market, ticke
 			if !stillOpen {
 				return
 			}
+			link.Beat()
 			if len(values) == 10 { // for client bug : https://github.com/bitfinexcom/bitfinex-api-go/issues/133
 				wrapper.summaries.Set(market, &environment.MarketSummary{
 					Bid:    decimal.NewFromFloat(values[0]),
 					Ask:    decimal.NewFromFloat(values[2]),
+					Last:   decimal.NewFromFloat(values[6]),
 					Volume: decimal.NewFromFloat(values[7]),
 					High:   decimal.NewFromFloat(values[8]),
 					Low:    decimal.NewFromFloat(values[9]),
@@ -357,6 +553,7 @@ func (wrapper *BitfinexWrapper) subscribeFeeds(market *environment.Market, ticke
 			if !stillOpen {
 				return
 			}
+			link.Beat()
 
 			if len(values) != 3 { // for client bug : https://github.com/bitfinexcom/bitfinex-api-go/issues/133
 				continue
@@ -420,12 +617,21 @@ func (wrapper *BitfinexWrapper) subscribeFeeds(market *environment.Market, ticke
 }
 
 // Withdraw performs a withdraw operation from the exchange to a destination address.
-func (wrapper *BitfinexWrapper) Withdraw(destinationAddress string, coinTicker string, amount float64) error {
-	status, err := wrapper.api.Wallet.WithdrawCrypto(amount, coinTicker, bitfinex.WALLET_TRADING, destinationAddress)
+func (wrapper *BitfinexWrapper) Withdraw(destinationAddress string, coinTicker string, amount decimal.Decimal) error {
+	if err := wrapper.limiter.Wait(context.Background(), "Withdraw"); err != nil {
+		return err
+	}
+	var status []bitfinex.WithdrawStatus
+	err := wrapper.post("withdraw", map[string]interface{}{
+		"amount":         amount.String(),
+		"walletselected": bitfinex.WALLET_TRADING,
+		"withdraw_type":  coinTicker,
+		"address":        destinationAddress,
+	}, &status)
 	if err != nil {
 		return err
 	}
-	if status[0].Status == "error" {
+	if len(status) > 0 && status[0].Status == "error" {
 		return errors.New(status[0].Message)
 	}
 
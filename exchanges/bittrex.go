@@ -16,7 +16,10 @@
 package exchanges
 
 import (
+	"context"
 	"errors"
+	"net/http"
+	"time"
 
 	"github.com/saniales/golang-crypto-trading-bot/environment"
 	"github.com/shopspring/decimal"
@@ -33,18 +36,32 @@ type BittrexWrapper struct {
 	api                 *api.Bittrex //Represents the helper of the Bittrex API.
 	summaries           *SummaryCache
 	candles             *CandlesCache
+	rules               *RulesCache
+	limiter             *RateLimiter
 	websocketOn         bool
 	unsubscribeChannels map[*environment.Market]chan bool
 	depositAddresses    map[string]string
 }
 
+// bittrexIntervals maps the supported timeframes to the tick intervals of bittrex.
+var bittrexIntervals = map[environment.TimeFrame]string{
+	environment.OneMinute:     "oneMin",
+	environment.FiveMinutes:   "fiveMin",
+	environment.ThirtyMinutes: "thirtyMin",
+	environment.OneHour:       "hour",
+	environment.OneDay:        "day",
+}
+
 // NewBittrexWrapper creates a generic wrapper of the bittrex API.
 func NewBittrexWrapper(publicKey string, secretKey string, depositAddresses map[string]string) ExchangeWrapper {
+	limiter := NewRateLimiter("bittrex", 60, time.Minute, nil)
 	return &BittrexWrapper{
-		api:              api.New(publicKey, secretKey),
+		api:              api.NewWithCustomHttpClient(publicKey, secretKey, limiter.HTTPClient(&http.Client{})),
 		websocketOn:      false,
 		summaries:        NewSummaryCache(),
 		candles:          NewCandlesCache(),
+		rules:            NewRulesCache(),
+		limiter:          limiter,
 		depositAddresses: depositAddresses,
 	}
 }
@@ -58,25 +75,48 @@ func (wrapper *BittrexWrapper) String() string {
 	return wrapper.Name()
 }
 
+// RateLimiter returns the budget of the calls to bittrex.
+func (wrapper *BittrexWrapper) RateLimiter() *RateLimiter {
+	return wrapper.limiter
+}
+
 // GetMarkets gets all the markets info.
 func (wrapper *BittrexWrapper) GetMarkets() ([]*environment.Market, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetMarkets"); err != nil {
+		return nil, err
+	}
 	bittrexMarkets, err := wrapper.api.GetMarkets()
 	if err != nil {
 		return nil, err
 	}
 	wrappedMarkets := make([]*environment.Market, 0, len(bittrexMarkets))
 	for _, market := range bittrexMarkets {
-		wrappedMarkets = append(wrappedMarkets, &environment.Market{
-			Name:           market.Symbol,
-			BaseCurrency:   market.BaseCurrencySymbol,
-			MarketCurrency: market.QuoteCurrencySymbol,
-		})
+		// the precision of bittrex is the one of the prices.
+		rules := &environment.MarketRules{
+			PricePrecision: market.Precision,
+			TickSize:       decimal.New(1, -market.Precision),
+			MinQuantity:    market.MinTradeSize,
+		}
+		// bittrex quotes the base currency symbol in the quote currency symbol, which is the base currency of the bot.
+		wrappedMarkets = append(wrappedMarkets, newExchangeMarket(wrapper, market.Symbol, market.QuoteCurrencySymbol, market.BaseCurrencySymbol, rules))
 	}
+	wrapper.rules.Set(wrapper, wrappedMarkets)
 	return wrappedMarkets, nil
 }
 
+// MarketRules gets the trading rules of a market, getting the ones of all markets from the exchange the first time.
+func (wrapper *BittrexWrapper) MarketRules(market *environment.Market) (*environment.MarketRules, error) {
+	return wrapper.rules.Get(wrapper, market, func() error {
+		_, err := wrapper.GetMarkets()
+		return err
+	})
+}
+
 // GetOrderBook gets the order(ASK + BID) book of a market.
 func (wrapper *BittrexWrapper) GetOrderBook(market *environment.Market) (*environment.OrderBook, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetOrderBook"); err != nil {
+		return nil, err
+	}
 	bittrexOrderBook, err := wrapper.api.GetOrderBook(MarketNameFor(market, wrapper), 5, "both")
 	if err != nil {
 		return nil, err
@@ -100,13 +140,22 @@ func (wrapper *BittrexWrapper) GetOrderBook(market *environment.Market) (*enviro
 }
 
 // BuyLimit performs a limit buy action.
-func (wrapper *BittrexWrapper) BuyLimit(market *environment.Market, amount float64, limit float64) (string, error) {
+func (wrapper *BittrexWrapper) BuyLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	quantity, price, err := roundOrder(wrapper, market, environment.Bid, amount, limit)
+	if err != nil {
+		return "", err
+	}
+	if err := wrapper.limiter.Wait(context.Background(), "BuyLimit"); err != nil {
+		return "", err
+	}
+	// the client takes the limit as a float64 and encodes its shortest form, so the price is rounded to 8 decimals
+	// first: once rounded it is sent unchanged, unless it has more than 15 significant digits.
 	orderNumber, err := wrapper.api.CreateOrder(bittrex.CreateOrderParams{
 		Type:         bittrex.LIMIT,
 		TimeInForce:  bittrex.GOOD_TIL_CANCELLED,
 		MarketSymbol: MarketNameFor(market, wrapper),
-		Quantity:     decimal.NewFromFloat(amount),
-		Limit:        limit,
+		Quantity:     quantity,
+		Limit:        price.Round(8).InexactFloat64(),
 		Direction:    bittrex.BUY,
 	})
 
@@ -114,13 +163,20 @@ func (wrapper *BittrexWrapper) BuyLimit(market *environment.Market, amount float
 }
 
 // SellLimit performs a limit sell action.
-func (wrapper *BittrexWrapper) SellLimit(market *environment.Market, amount float64, limit float64) (string, error) {
+func (wrapper *BittrexWrapper) SellLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	quantity, price, err := roundOrder(wrapper, market, environment.Ask, amount, limit)
+	if err != nil {
+		return "", err
+	}
+	if err := wrapper.limiter.Wait(context.Background(), "SellLimit"); err != nil {
+		return "", err
+	}
 	orderNumber, err := wrapper.api.CreateOrder(bittrex.CreateOrderParams{
 		Type:         bittrex.LIMIT,
 		TimeInForce:  bittrex.GOOD_TIL_CANCELLED,
 		MarketSymbol: MarketNameFor(market, wrapper),
-		Quantity:     decimal.NewFromFloat(amount),
-		Limit:        limit,
+		Quantity:     quantity,
+		Limit:        price.Round(8).InexactFloat64(),
 		Direction:    bittrex.SELL,
 	})
 
@@ -128,17 +184,100 @@ func (wrapper *BittrexWrapper) SellLimit(market *environment.Market, amount floa
 }
 
 // BuyMarket performs a market buy action.
-func (wrapper *BittrexWrapper) BuyMarket(market *environment.Market, amount float64) (string, error) {
+func (wrapper *BittrexWrapper) BuyMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
 	panic("Not supported on bittrex")
 }
 
 // SellMarket performs a market sell action.
-func (wrapper *BittrexWrapper) SellMarket(market *environment.Market, amount float64) (string, error) {
+func (wrapper *BittrexWrapper) SellMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
 	panic("Not supported on bittrex")
 }
 
+// GetOrder gets the status of an order placed on the exchange.
+func (wrapper *BittrexWrapper) GetOrder(market *environment.Market, orderID string) (*environment.OrderInfo, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetOrder"); err != nil {
+		return nil, err
+	}
+	openOrders, err := wrapper.api.GetOpenOrders(MarketNameFor(market, wrapper))
+	if err != nil {
+		return nil, err
+	}
+	for _, bittrexOrder := range openOrders {
+		if bittrexOrder.ID == orderID {
+			return convertFromBittrexOrder(market, bittrexOrder), nil
+		}
+	}
+
+	if err := wrapper.limiter.Wait(context.Background(), "GetOrder"); err != nil {
+		return nil, err
+	}
+	closedOrders, err := wrapper.api.GetClosedOrders(MarketNameFor(market, wrapper))
+	if err != nil {
+		return nil, err
+	}
+	for _, bittrexOrder := range closedOrders {
+		if bittrexOrder.ID == orderID {
+			return convertFromBittrexOrder(market, bittrexOrder), nil
+		}
+	}
+
+	return nil, ErrOrderNotFound
+}
+
+// GetOpenOrders gets the open orders of the user on a market.
+func (wrapper *BittrexWrapper) GetOpenOrders(market *environment.Market) ([]*environment.OrderInfo, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetOpenOrders"); err != nil {
+		return nil, err
+	}
+	bittrexOrders, err := wrapper.api.GetOpenOrders(MarketNameFor(market, wrapper))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]*environment.OrderInfo, len(bittrexOrders))
+	for i, bittrexOrder := range bittrexOrders {
+		ret[i] = convertFromBittrexOrder(market, bittrexOrder)
+	}
+
+	return ret, nil
+}
+
+// CancelOrder cancels an open order.
+func (wrapper *BittrexWrapper) CancelOrder(market *environment.Market, orderID string) error {
+	if err := wrapper.limiter.Wait(context.Background(), "CancelOrder"); err != nil {
+		return err
+	}
+	_, err := wrapper.api.CancelOrder(orderID)
+	return err
+}
+
+//convertFromBittrexOrder converts a bittrex order to a environment.OrderInfo.
+func convertFromBittrexOrder(market *environment.Market, bittrexOrder api.OrderV3) *environment.OrderInfo {
+	order := &environment.OrderInfo{
+		ID:             bittrexOrder.ID,
+		Market:         market,
+		Type:           environment.Bid,
+		Status:         orderStatusFor(bittrexOrder.Quantity, bittrexOrder.FillQuantity, bittrexOrder.Status == "OPEN"),
+		Quantity:       bittrexOrder.Quantity,
+		Limit:          bittrexOrder.Limit,
+		FilledQuantity: bittrexOrder.FillQuantity,
+		Timestamp:      bittrexOrder.CreatedAt,
+	}
+	if bittrexOrder.Direction == string(bittrex.SELL) {
+		order.Type = environment.Ask
+	}
+	if bittrexOrder.FillQuantity.IsPositive() {
+		order.AveragePrice = bittrexOrder.Proceeds.Div(bittrexOrder.FillQuantity)
+	}
+
+	return order
+}
+
 // GetTicker gets the updated ticker for a market.
 func (wrapper *BittrexWrapper) GetTicker(market *environment.Market) (*environment.Ticker, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetTicker"); err != nil {
+		return nil, err
+	}
 	bittrexTicker, err := wrapper.api.GetTicker(MarketNameFor(market, wrapper))
 	if err != nil {
 		return nil, err
@@ -154,6 +293,9 @@ func (wrapper *BittrexWrapper) GetTicker(market *environment.Market) (*environme
 // GetMarketSummary gets the current market summary.
 func (wrapper *BittrexWrapper) GetMarketSummary(market *environment.Market) (*environment.MarketSummary, error) {
 	if !wrapper.websocketOn {
+		if err := wrapper.limiter.Wait(context.Background(), "GetMarketSummary"); err != nil {
+			return nil, err
+		}
 		summary, err := wrapper.api.GetMarketSummary(MarketNameFor(market, wrapper))
 		if err != nil {
 			return nil, err
@@ -183,22 +325,55 @@ func (wrapper *BittrexWrapper) GetMarketSummary(market *environment.Market) (*en
 }
 
 //convertFromBittrexCandle converts a bittrex candle to a environment.CandleStick.
-func convertFromBittrexCandle(candle api.Candle) environment.CandleStick {
+func convertFromBittrexCandle(candle api.Candle, period time.Duration) environment.CandleStick {
 	return environment.CandleStick{
-		High:  candle.High,
-		Open:  candle.Open,
-		Close: candle.Close,
-		Low:   candle.Low,
+		High:      candle.High,
+		Open:      candle.Open,
+		Close:     candle.Close,
+		Low:       candle.Low,
+		Volume:    candle.BaseVolume,
+		OpenTime:  candle.TimeStamp.Time,
+		CloseTime: candle.TimeStamp.Time.Add(period),
+		Period:    period,
 	}
 }
 
 // GetCandles gets the candle data from the exchange.
+//
+// NOTE: candles are 30 minutes long, unless a different time_frame is configured for the market.
 func (wrapper *BittrexWrapper) GetCandles(market *environment.Market) ([]environment.CandleStick, error) {
-	panic("Not supported in Bittrex V1")
+	timeFrame, native, err := candleTimeFrames(market, wrapper, bittrexIntervals, environment.ThirtyMinutes)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := wrapper.limiter.Wait(context.Background(), "GetCandles"); err != nil {
+		return nil, err
+	}
+	bittrexCandles, err := wrapper.api.GetTicks(MarketNameFor(market, wrapper), bittrexIntervals[native])
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]environment.CandleStick, len(bittrexCandles))
+	for i, bittrexCandle := range bittrexCandles {
+		ret[i] = convertFromBittrexCandle(bittrexCandle, native.Duration())
+	}
+
+	ret, err = AggregateCandles(ret, timeFrame)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapper.candles.Set(market, ret)
+	return ret, nil
 }
 
 // GetBalance gets the balance of the user of the specified currency.
 func (wrapper *BittrexWrapper) GetBalance(symbol string) (*decimal.Decimal, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetBalance"); err != nil {
+		return nil, err
+	}
 	balance, err := wrapper.api.GetBalance(symbol)
 	if err != nil {
 		return nil, err
@@ -216,21 +391,21 @@ func (wrapper *BittrexWrapper) GetDepositAddress(coinTicker string) (string, boo
 // CalculateTradingFees calculates the trading fees for an order on a specified market.
 //
 //     NOTE: In Bittrex fees are hardcoded due to the inability to obtain them via API before placing an order.
-func (wrapper *BittrexWrapper) CalculateTradingFees(market *environment.Market, amount float64, limit float64, orderType TradeType) float64 {
-	var feePercentage float64
+func (wrapper *BittrexWrapper) CalculateTradingFees(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal, orderType TradeType) decimal.Decimal {
+	var feePercentage decimal.Decimal
 	if orderType == MakerTrade {
-		feePercentage = 0.0025
+		feePercentage = decimal.NewFromFloat(0.0025)
 	} else if orderType == TakerTrade {
-		feePercentage = 0.0025
+		feePercentage = decimal.NewFromFloat(0.0025)
 	} else {
 		panic("Unknown trade type")
 	}
 
-	return amount * limit * feePercentage
+	return amount.Mul(limit).Mul(feePercentage)
 }
 
 // CalculateWithdrawFees calculates the withdrawal fees on a specified market.
-func (wrapper *BittrexWrapper) CalculateWithdrawFees(market *environment.Market, amount float64) float64 {
+func (wrapper *BittrexWrapper) CalculateWithdrawFees(market *environment.Market, amount decimal.Decimal) decimal.Decimal {
 	panic("Not Implemented")
 }
 
@@ -247,8 +422,11 @@ func (wrapper *BittrexWrapper) subscribeMarketSummaryFeed(market *environment.Ma
 }
 
 // Withdraw performs a withdraw operation from the exchange to a destination address.
-func (wrapper *BittrexWrapper) Withdraw(destinationAddress string, coinTicker string, amount float64) error {
-	_, err := wrapper.api.Withdraw(destinationAddress, coinTicker, decimal.NewFromFloat(amount), "golang-crypto-trading-bot")
+func (wrapper *BittrexWrapper) Withdraw(destinationAddress string, coinTicker string, amount decimal.Decimal) error {
+	if err := wrapper.limiter.Wait(context.Background(), "Withdraw"); err != nil {
+		return err
+	}
+	_, err := wrapper.api.Withdraw(destinationAddress, coinTicker, amount, "golang-crypto-trading-bot")
 	if err != nil {
 		return err
 	}
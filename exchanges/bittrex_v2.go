@@ -16,7 +16,9 @@
 package exchanges
 
 import (
+	"context"
 	"errors"
+	"time"
 
 	"github.com/saniales/golang-crypto-trading-bot/environment"
 	"github.com/shopspring/decimal"
@@ -28,6 +30,7 @@ type BittrexWrapperV2 struct {
 	PublicKey        string
 	SecretKey        string
 	summaries        *SummaryCache
+	limiter          *RateLimiter
 	depositAddresses map[string]string
 }
 
@@ -37,6 +40,7 @@ func NewBittrexV2Wrapper(publicKey string, secretKey string, depositAddresses ma
 		PublicKey:        publicKey,
 		SecretKey:        secretKey,
 		summaries:        NewSummaryCache(),
+		limiter:          NewRateLimiter("bittrex", 60, time.Minute, nil),
 		depositAddresses: depositAddresses,
 	}
 }
@@ -50,8 +54,16 @@ func (wrapper *BittrexWrapperV2) String() string {
 	return wrapper.Name()
 }
 
+// RateLimiter returns the budget of the calls to bittrex.
+func (wrapper *BittrexWrapperV2) RateLimiter() *RateLimiter {
+	return wrapper.limiter
+}
+
 // GetMarkets gets all the markets info.
 func (wrapper *BittrexWrapperV2) GetMarkets() ([]*environment.Market, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetMarkets"); err != nil {
+		return nil, err
+	}
 	bittrexMarkets, err := bittrex.GetMarkets()
 	if err != nil {
 		return nil, err
@@ -59,11 +71,9 @@ func (wrapper *BittrexWrapperV2) GetMarkets() ([]*environment.Market, error) {
 	wrappedMarkets := make([]*environment.Market, 0, len(bittrexMarkets))
 	for _, market := range bittrexMarkets {
 		if market.IsActive {
-			wrappedMarkets = append(wrappedMarkets, &environment.Market{
-				Name:           market.MarketName,
-				BaseCurrency:   market.BaseCurrency,
-				MarketCurrency: market.MarketCurrency,
-			})
+			wrappedMarkets = append(wrappedMarkets, newExchangeMarket(wrapper, market.MarketName, market.BaseCurrency, market.MarketCurrency, &environment.MarketRules{
+				MinQuantity: market.MinTradeSize,
+			}))
 		}
 	}
 	return wrappedMarkets, nil
@@ -75,27 +85,45 @@ func (wrapper *BittrexWrapperV2) GetOrderBook(market *environment.Market) (*envi
 }
 
 // BuyLimit performs a limit buy action.
-func (wrapper *BittrexWrapperV2) BuyLimit(market *environment.Market, amount float64, limit float64) (string, error) {
+func (wrapper *BittrexWrapperV2) BuyLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
 	return "", errors.New("BuyLimit not implemented")
 }
 
 // BuyMarket performs a market buy action.
-func (wrapper *BittrexWrapperV2) BuyMarket(market *environment.Market, amount float64) (string, error) {
+func (wrapper *BittrexWrapperV2) BuyMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
 	return "", errors.New("BuyMarket not implemented")
 }
 
 // SellLimit performs a limit sell action.
-func (wrapper *BittrexWrapperV2) SellLimit(market *environment.Market, amount float64, limit float64) (string, error) {
+func (wrapper *BittrexWrapperV2) SellLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
 	return "", errors.New("SellLimit not implemented")
 }
 
 // SellMarket performs a market sell action.
-func (wrapper *BittrexWrapperV2) SellMarket(market *environment.Market, amount float64) (string, error) {
+func (wrapper *BittrexWrapperV2) SellMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
 	return "", errors.New("SellMarket not implemented")
 }
 
+// GetOrder gets the status of an order placed on the exchange.
+func (wrapper *BittrexWrapperV2) GetOrder(market *environment.Market, orderID string) (*environment.OrderInfo, error) {
+	return nil, errors.New("GetOrder not implemented")
+}
+
+// GetOpenOrders gets the open orders of the user on a market.
+func (wrapper *BittrexWrapperV2) GetOpenOrders(market *environment.Market) ([]*environment.OrderInfo, error) {
+	return nil, errors.New("GetOpenOrders not implemented")
+}
+
+// CancelOrder cancels an open order.
+func (wrapper *BittrexWrapperV2) CancelOrder(market *environment.Market, orderID string) error {
+	return errors.New("CancelOrder not implemented")
+}
+
 // GetMarketSummary gets the current market summary.
 func (wrapper *BittrexWrapperV2) GetMarketSummary(market *environment.Market) (*environment.MarketSummary, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetMarketSummary"); err != nil {
+		return nil, err
+	}
 	summary, err := bittrex.GetMarketSummary(market.Name)
 	if err != nil {
 		return nil, err
@@ -116,8 +144,18 @@ func (wrapper *BittrexWrapperV2) GetMarketSummary(market *environment.Market) (*
 }
 
 // GetCandles gets the candle data from the exchange.
+//
+// NOTE: candles are 30 minutes long, unless a different time_frame is configured for the market.
 func (wrapper *BittrexWrapperV2) GetCandles(market *environment.Market) ([]environment.CandleStick, error) {
-	bittrexCandles, err := bittrex.GetTicks(MarketNameFor(market, wrapper), "30m")
+	timeFrame, native, err := candleTimeFrames(market, wrapper, bittrexIntervals, environment.ThirtyMinutes)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := wrapper.limiter.Wait(context.Background(), "GetCandles"); err != nil {
+		return nil, err
+	}
+	bittrexCandles, err := bittrex.GetTicks(MarketNameFor(market, wrapper), bittrexIntervals[native])
 	if err != nil {
 		return nil, err
 	}
@@ -125,16 +163,20 @@ func (wrapper *BittrexWrapperV2) GetCandles(market *environment.Market) ([]envir
 	ret := make([]environment.CandleStick, len(bittrexCandles))
 
 	for i, bittrexCandle := range bittrexCandles {
+		openTime := time.Time(bittrexCandle.Timestamp)
 		ret[i] = environment.CandleStick{
-			High:   bittrexCandle.High,
-			Open:   bittrexCandle.Open,
-			Close:  bittrexCandle.Close,
-			Low:    bittrexCandle.Low,
-			Volume: bittrexCandle.BaseVolume,
+			High:      bittrexCandle.High,
+			Open:      bittrexCandle.Open,
+			Close:     bittrexCandle.Close,
+			Low:       bittrexCandle.Low,
+			Volume:    bittrexCandle.BaseVolume,
+			OpenTime:  openTime,
+			CloseTime: openTime.Add(native.Duration()),
+			Period:    native.Duration(),
 		}
 	}
 
-	return ret, nil
+	return AggregateCandles(ret, timeFrame)
 }
 
 // GetBalance gets the balance of the user of the specified currency.
@@ -151,21 +193,21 @@ func (wrapper *BittrexWrapperV2) GetDepositAddress(coinTicker string) (string, b
 // CalculateTradingFees calculates the trading fees for an order on a specified market.
 //
 //     NOTE: In Bittrex fees are hardcoded due to the inability to obtain them via API before placing an order.
-func (wrapper *BittrexWrapperV2) CalculateTradingFees(market *environment.Market, amount float64, limit float64, orderType TradeType) float64 {
-	var feePercentage float64
+func (wrapper *BittrexWrapperV2) CalculateTradingFees(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal, orderType TradeType) decimal.Decimal {
+	var feePercentage decimal.Decimal
 	if orderType == MakerTrade {
-		feePercentage = 0.0025
+		feePercentage = decimal.NewFromFloat(0.0025)
 	} else if orderType == TakerTrade {
-		feePercentage = 0.0025
+		feePercentage = decimal.NewFromFloat(0.0025)
 	} else {
 		panic("Unknown trade type")
 	}
 
-	return amount * limit * feePercentage
+	return amount.Mul(limit).Mul(feePercentage)
 }
 
 // CalculateWithdrawFees calculates the withdrawal fees on a specified market.
-func (wrapper *BittrexWrapperV2) CalculateWithdrawFees(market *environment.Market, amount float64) float64 {
+func (wrapper *BittrexWrapperV2) CalculateWithdrawFees(market *environment.Market, amount decimal.Decimal) decimal.Decimal {
 	panic("Not Implemented")
 }
 
@@ -177,6 +219,6 @@ func (wrapper *BittrexWrapperV2) FeedConnect(markets []*environment.Market) erro
 }
 
 // Withdraw performs a withdraw operation from the exchange to a destination address.
-func (wrapper *BittrexWrapperV2) Withdraw(destinationAddress string, coinTicker string, amount float64) error {
+func (wrapper *BittrexWrapperV2) Withdraw(destinationAddress string, coinTicker string, amount decimal.Decimal) error {
 	panic("Not Implemented")
 }
@@ -98,3 +98,54 @@ func (cc *OrderbookCache) Get(market *environment.Market) (*environment.OrderBoo
 	cc.mutex.RUnlock()
 	return ret, isSet
 }
+
+// RulesCache represents a local cache of the trading rules of the markets of an exchange, by market name on the exchange.
+type RulesCache struct {
+	mutex    *sync.RWMutex
+	internal map[string]*environment.MarketRules
+	loaded   bool
+}
+
+// NewRulesCache creates a new RulesCache Object
+func NewRulesCache() *RulesCache {
+	return &RulesCache{
+		mutex:    &sync.RWMutex{},
+		internal: make(map[string]*environment.MarketRules),
+	}
+}
+
+// Set sets the rules of the markets got from the exchange of the wrapper.
+func (rc *RulesCache) Set(wrapper ExchangeWrapper, markets []*environment.Market) {
+	rc.mutex.Lock()
+	for _, market := range markets {
+		if rules := MarketRulesFor(market, wrapper); rules != nil {
+			rc.internal[MarketNameFor(market, wrapper)] = rules
+		}
+	}
+	rc.loaded = true
+	rc.mutex.Unlock()
+}
+
+// Get gets the rules of a market on the exchange of the wrapper: the ones set in the market, if any,
+// otherwise the cached ones, calling load (which must Set them) the first time.
+//
+// Returns nil if the rules of the market are unknown.
+func (rc *RulesCache) Get(wrapper ExchangeWrapper, market *environment.Market, load func() error) (*environment.MarketRules, error) {
+	if rules := MarketRulesFor(market, wrapper); rules != nil {
+		return rules, nil
+	}
+
+	rc.mutex.RLock()
+	loaded := rc.loaded
+	rc.mutex.RUnlock()
+	if !loaded {
+		if err := load(); err != nil {
+			return nil, err
+		}
+	}
+
+	rc.mutex.RLock()
+	ret := rc.internal[MarketNameFor(market, wrapper)]
+	rc.mutex.RUnlock()
+	return ret, nil
+}
@@ -0,0 +1,97 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package exchanges
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/shopspring/decimal"
+)
+
+// ErrClientOrderIDNotSupported is the error representing when an exchange cannot place orders with a client order ID.
+var ErrClientOrderIDNotSupported = errors.New("Cannot use client order ID: exchange does not support it")
+
+// OrderRequest represents an order to place on an exchange, identified by an ID chosen by the caller.
+type OrderRequest struct {
+	Market        *environment.Market
+	Type          environment.OrderType // Represents the side of the order (Bid or Ask).
+	Quantity      decimal.Decimal       // Represents the quantity of the order, in market currency.
+	Limit         decimal.Decimal       // Represents the limit price of the order, zero for market orders.
+	ClientOrderID string                // Represents the ID chosen by the caller, a new one is generated if empty.
+}
+
+// ClientOrderExchangeWrapper is implemented by the wrappers placing orders with a client order ID.
+//
+// The order is placed at most once with the same client order ID, which can then be used in place of
+// the order ID in GetOrder: placing an order again after a failure whose outcome is unknown is safe,
+// if GetOrder does not find it first.
+type ClientOrderExchangeWrapper interface {
+	ExchangeWrapper
+
+	PlaceOrder(ctx context.Context, order OrderRequest) (string, error) // Places an order, returning its ID.
+}
+
+// NewClientOrderID generates a new client order ID, accepted by all the exchanges supporting them.
+func NewClientOrderID() (string, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+	return strings.Replace(id.String(), "-", "", -1), nil
+}
+
+// PlaceOrder places an order with a client order ID on the exchange of a wrapper, generating the ID if empty.
+//
+// Returns ErrClientOrderIDNotSupported if the exchange (or one of the decorators of its wrapper) does not support them.
+func PlaceOrder(ctx context.Context, wrapper ExchangeWrapper, order OrderRequest) (string, error) {
+	placer, isPlacer := wrapper.(ClientOrderExchangeWrapper)
+	if !isPlacer {
+		return "", ErrClientOrderIDNotSupported
+	}
+	if order.ClientOrderID == "" {
+		id, err := NewClientOrderID()
+		if err != nil {
+			return "", err
+		}
+		order.ClientOrderID = id
+	}
+	return placer.PlaceOrder(ctx, order)
+}
+
+// placeOrderWith places an order with a client order ID on the wrapped exchange, for the decorators.
+func placeOrderWith(ctx context.Context, wrapper ExchangeWrapper, order OrderRequest) (string, error) {
+	placer, isPlacer := wrapper.(ClientOrderExchangeWrapper)
+	if !isPlacer {
+		return "", ErrClientOrderIDNotSupported
+	}
+	return placer.PlaceOrder(ctx, order)
+}
+
+// orderEndpoint returns the name of the wrapper method placing an order like the specified one.
+func orderEndpoint(order OrderRequest) string {
+	side := "Buy"
+	if order.Type == environment.Ask {
+		side = "Sell"
+	}
+	if order.Limit.IsZero() {
+		return side + "Market"
+	}
+	return side + "Limit"
+}
@@ -0,0 +1,553 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package exchanges
+
+import (
+	"context"
+	"time"
+
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/shopspring/decimal"
+)
+
+// ContextExchangeWrapper provides the context-aware variants of the ExchangeWrapper calls reaching the exchange.
+//
+// Cancelling the context aborts the in-flight requests and closes the websocket subscriptions opened with it.
+type ContextExchangeWrapper interface {
+	ExchangeWrapper
+
+	GetMarketsContext(ctx context.Context) ([]*environment.Market, error) // Gets all the markets of the exchange, binded to their names on the exchange.
+
+	GetCandlesContext(ctx context.Context, market *environment.Market) ([]environment.CandleStick, error)        // Gets the candle data from the exchange.
+	GetMarketSummaryContext(ctx context.Context, market *environment.Market) (*environment.MarketSummary, error) // Gets the current market summary.
+	GetOrderBookContext(ctx context.Context, market *environment.Market) (*environment.OrderBook, error)         // Gets the order(ASK + BID) book of a market.
+
+	BuyLimitContext(ctx context.Context, market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error)  // Performs a limit buy action.
+	SellLimitContext(ctx context.Context, market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) // Performs a limit sell action.
+	BuyMarketContext(ctx context.Context, market *environment.Market, amount decimal.Decimal) (string, error)                        // Performs a market buy action.
+	SellMarketContext(ctx context.Context, market *environment.Market, amount decimal.Decimal) (string, error)                       // Performs a market sell action.
+
+	GetOrderContext(ctx context.Context, market *environment.Market, orderID string) (*environment.OrderInfo, error) // Gets the status of an order placed on the exchange.
+	GetOpenOrdersContext(ctx context.Context, market *environment.Market) ([]*environment.OrderInfo, error)          // Gets the open orders of the user on a market.
+	CancelOrderContext(ctx context.Context, market *environment.Market, orderID string) error                        // Cancels an open order.
+
+	GetBalanceContext(ctx context.Context, symbol string) (*decimal.Decimal, error) // Gets the balance of the user of the specified currency.
+
+	FeedConnectContext(ctx context.Context, markets []*environment.Market) error // Connects to the feed of the exchange, until the context is done.
+
+	WithdrawContext(ctx context.Context, destinationAddress string, coinTicker string, amount decimal.Decimal) error // Performs a withdraw operation from the exchange to a destination address.
+}
+
+// ContextWrapper binds an ExchangeWrapper to a context, applying a deadline to each call.
+//
+// Wrappers implementing ContextExchangeWrapper receive the context of the call, the reads
+// of the others are abandoned (but not aborted) when the context is done, while their orders,
+// cancels and withdraws are not started once the context is done, but always awaited.
+type ContextWrapper struct {
+	innerWrapper ExchangeWrapper
+	ctx          context.Context
+	feedCtx      context.Context // context of the feeds, if different from the one of the calls.
+	timeout      time.Duration   // deadline of each call, 0 means no deadline.
+}
+
+// feedContextConnector is implemented by wrappers able to close their feeds when a context is done.
+type feedContextConnector interface {
+	FeedConnectContext(ctx context.Context, markets []*environment.Market) error
+}
+
+// NewContextWrapper creates a wrapper applying the specified deadline to each call of another wrapper.
+func NewContextWrapper(wrapper ExchangeWrapper, timeout time.Duration) *ContextWrapper {
+	return &ContextWrapper{
+		innerWrapper: wrapper,
+		ctx:          context.Background(),
+		timeout:      timeout,
+	}
+}
+
+// WithContext returns a copy of the wrapper whose calls without context use the specified one.
+func (wrapper *ContextWrapper) WithContext(ctx context.Context) *ContextWrapper {
+	ret := *wrapper
+	ret.ctx = ctx
+	return &ret
+}
+
+// WithFeedContext returns a copy of the wrapper whose feeds are connected until the specified context is done,
+// regardless of the context of the calls.
+func (wrapper *ContextWrapper) WithFeedContext(ctx context.Context) *ContextWrapper {
+	ret := *wrapper
+	ret.feedCtx = ctx
+	return &ret
+}
+
+// Unwrap returns the wrapped ExchangeWrapper.
+func (wrapper *ContextWrapper) Unwrap() ExchangeWrapper {
+	return wrapper.innerWrapper
+}
+
+// AsContextExchangeWrapper returns a wrapper as a ContextExchangeWrapper: the wrapper itself if it supports contexts,
+// otherwise a ContextWrapper without deadline.
+//
+// Decorators call their wrapped wrapper through it, so that the context of their calls reaches the exchange.
+func AsContextExchangeWrapper(wrapper ExchangeWrapper) ContextExchangeWrapper {
+	if native, ok := wrapper.(ContextExchangeWrapper); ok {
+		return native
+	}
+	return NewContextWrapper(wrapper, 0)
+}
+
+// BindContext binds the wrappers to a context, so that cancelling it aborts their calls.
+//
+// The context reaches the exchange through the decorators supporting contexts (e.g. RiskManager), down to
+// the ContextWrapper applying the deadline of the exchange.
+func BindContext(ctx context.Context, wrappers []ExchangeWrapper) []ExchangeWrapper {
+	ret := make([]ExchangeWrapper, len(wrappers))
+	for i, wrapper := range wrappers {
+		if contextWrapper, ok := wrapper.(*ContextWrapper); ok {
+			ret[i] = contextWrapper.WithContext(ctx)
+		} else {
+			ret[i] = NewContextWrapper(wrapper, 0).WithContext(ctx)
+		}
+	}
+	return ret
+}
+
+// BindFeedContext binds the feeds of the wrappers to a context, so that cancelling it closes them.
+func BindFeedContext(ctx context.Context, wrappers []ExchangeWrapper) []ExchangeWrapper {
+	ret := make([]ExchangeWrapper, len(wrappers))
+	for i, wrapper := range wrappers {
+		if contextWrapper, ok := wrapper.(*ContextWrapper); ok {
+			ret[i] = contextWrapper.WithFeedContext(ctx)
+		} else {
+			ret[i] = NewContextWrapper(wrapper, 0).WithFeedContext(ctx)
+		}
+	}
+	return ret
+}
+
+// callContext derives the context of a call, applying the deadline.
+func (wrapper *ContextWrapper) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if wrapper.timeout > 0 {
+		return context.WithTimeout(ctx, wrapper.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// native returns the inner wrapper as a ContextExchangeWrapper, if it supports contexts.
+func (wrapper *ContextWrapper) native() (ContextExchangeWrapper, bool) {
+	native, ok := wrapper.innerWrapper.(ContextExchangeWrapper)
+	return native, ok
+}
+
+// runOrderContext runs a call changing the state of the account (e.g. placing an order), unless the context is done.
+//
+// Unlike runContext, the call is never abandoned once started, so that its outcome is reported to the caller.
+func runOrderContext(ctx context.Context, call func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return call()
+}
+
+// runContext runs a call, returning the context error if the context is done before the call returns.
+func runContext(ctx context.Context, call func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- call()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Name returns the name of the wrapped exchange.
+func (wrapper *ContextWrapper) Name() string {
+	return wrapper.innerWrapper.Name()
+}
+
+// String returns a string representation of the object.
+func (wrapper *ContextWrapper) String() string {
+	return wrapper.innerWrapper.String()
+}
+
+// GetMarkets gets all the markets of the exchange.
+func (wrapper *ContextWrapper) GetMarkets() ([]*environment.Market, error) {
+	return wrapper.GetMarketsContext(wrapper.ctx)
+}
+
+// GetMarketsContext gets all the markets of the exchange.
+func (wrapper *ContextWrapper) GetMarketsContext(ctx context.Context) ([]*environment.Market, error) {
+	ctx, cancel := wrapper.callContext(ctx)
+	defer cancel()
+
+	if native, ok := wrapper.native(); ok {
+		return native.GetMarketsContext(ctx)
+	}
+
+	var ret []*environment.Market
+	err := runContext(ctx, func() (err error) {
+		ret, err = wrapper.innerWrapper.GetMarkets()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// GetCandles gets the candle data from the exchange.
+func (wrapper *ContextWrapper) GetCandles(market *environment.Market) ([]environment.CandleStick, error) {
+	return wrapper.GetCandlesContext(wrapper.ctx, market)
+}
+
+// GetCandlesContext gets the candle data from the exchange.
+func (wrapper *ContextWrapper) GetCandlesContext(ctx context.Context, market *environment.Market) ([]environment.CandleStick, error) {
+	ctx, cancel := wrapper.callContext(ctx)
+	defer cancel()
+
+	if native, ok := wrapper.native(); ok {
+		return native.GetCandlesContext(ctx, market)
+	}
+
+	var ret []environment.CandleStick
+	err := runContext(ctx, func() (err error) {
+		ret, err = wrapper.innerWrapper.GetCandles(market)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// GetMarketSummary gets the current market summary.
+func (wrapper *ContextWrapper) GetMarketSummary(market *environment.Market) (*environment.MarketSummary, error) {
+	return wrapper.GetMarketSummaryContext(wrapper.ctx, market)
+}
+
+// GetMarketSummaryContext gets the current market summary.
+func (wrapper *ContextWrapper) GetMarketSummaryContext(ctx context.Context, market *environment.Market) (*environment.MarketSummary, error) {
+	ctx, cancel := wrapper.callContext(ctx)
+	defer cancel()
+
+	if native, ok := wrapper.native(); ok {
+		return native.GetMarketSummaryContext(ctx, market)
+	}
+
+	var ret *environment.MarketSummary
+	err := runContext(ctx, func() (err error) {
+		ret, err = wrapper.innerWrapper.GetMarketSummary(market)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// GetOrderBook gets the order(ASK + BID) book of a market.
+func (wrapper *ContextWrapper) GetOrderBook(market *environment.Market) (*environment.OrderBook, error) {
+	return wrapper.GetOrderBookContext(wrapper.ctx, market)
+}
+
+// GetOrderBookContext gets the order(ASK + BID) book of a market.
+func (wrapper *ContextWrapper) GetOrderBookContext(ctx context.Context, market *environment.Market) (*environment.OrderBook, error) {
+	ctx, cancel := wrapper.callContext(ctx)
+	defer cancel()
+
+	if native, ok := wrapper.native(); ok {
+		return native.GetOrderBookContext(ctx, market)
+	}
+
+	var ret *environment.OrderBook
+	err := runContext(ctx, func() (err error) {
+		ret, err = wrapper.innerWrapper.GetOrderBook(market)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// BuyLimit performs a limit buy action.
+func (wrapper *ContextWrapper) BuyLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return wrapper.BuyLimitContext(wrapper.ctx, market, amount, limit)
+}
+
+// BuyLimitContext performs a limit buy action.
+func (wrapper *ContextWrapper) BuyLimitContext(ctx context.Context, market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	ctx, cancel := wrapper.callContext(ctx)
+	defer cancel()
+
+	if native, ok := wrapper.native(); ok {
+		return native.BuyLimitContext(ctx, market, amount, limit)
+	}
+
+	var ret string
+	err := runOrderContext(ctx, func() (err error) {
+		ret, err = wrapper.innerWrapper.BuyLimit(market, amount, limit)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return ret, nil
+}
+
+// SellLimit performs a limit sell action.
+func (wrapper *ContextWrapper) SellLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return wrapper.SellLimitContext(wrapper.ctx, market, amount, limit)
+}
+
+// SellLimitContext performs a limit sell action.
+func (wrapper *ContextWrapper) SellLimitContext(ctx context.Context, market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	ctx, cancel := wrapper.callContext(ctx)
+	defer cancel()
+
+	if native, ok := wrapper.native(); ok {
+		return native.SellLimitContext(ctx, market, amount, limit)
+	}
+
+	var ret string
+	err := runOrderContext(ctx, func() (err error) {
+		ret, err = wrapper.innerWrapper.SellLimit(market, amount, limit)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return ret, nil
+}
+
+// BuyMarket performs a market buy action.
+func (wrapper *ContextWrapper) BuyMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
+	return wrapper.BuyMarketContext(wrapper.ctx, market, amount)
+}
+
+// BuyMarketContext performs a market buy action.
+func (wrapper *ContextWrapper) BuyMarketContext(ctx context.Context, market *environment.Market, amount decimal.Decimal) (string, error) {
+	ctx, cancel := wrapper.callContext(ctx)
+	defer cancel()
+
+	if native, ok := wrapper.native(); ok {
+		return native.BuyMarketContext(ctx, market, amount)
+	}
+
+	var ret string
+	err := runOrderContext(ctx, func() (err error) {
+		ret, err = wrapper.innerWrapper.BuyMarket(market, amount)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return ret, nil
+}
+
+// SellMarket performs a market sell action.
+func (wrapper *ContextWrapper) SellMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
+	return wrapper.SellMarketContext(wrapper.ctx, market, amount)
+}
+
+// SellMarketContext performs a market sell action.
+func (wrapper *ContextWrapper) SellMarketContext(ctx context.Context, market *environment.Market, amount decimal.Decimal) (string, error) {
+	ctx, cancel := wrapper.callContext(ctx)
+	defer cancel()
+
+	if native, ok := wrapper.native(); ok {
+		return native.SellMarketContext(ctx, market, amount)
+	}
+
+	var ret string
+	err := runOrderContext(ctx, func() (err error) {
+		ret, err = wrapper.innerWrapper.SellMarket(market, amount)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return ret, nil
+}
+
+// PlaceOrder places an order with a client order ID, if the wrapped exchange supports them.
+func (wrapper *ContextWrapper) PlaceOrder(ctx context.Context, order OrderRequest) (string, error) {
+	ctx, cancel := wrapper.callContext(ctx)
+	defer cancel()
+
+	if _, ok := wrapper.native(); ok {
+		return placeOrderWith(ctx, wrapper.innerWrapper, order)
+	}
+
+	var ret string
+	err := runOrderContext(ctx, func() (err error) {
+		ret, err = placeOrderWith(ctx, wrapper.innerWrapper, order)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return ret, nil
+}
+
+// GetOrder gets the status of an order placed on the exchange.
+func (wrapper *ContextWrapper) GetOrder(market *environment.Market, orderID string) (*environment.OrderInfo, error) {
+	return wrapper.GetOrderContext(wrapper.ctx, market, orderID)
+}
+
+// GetOrderContext gets the status of an order placed on the exchange.
+func (wrapper *ContextWrapper) GetOrderContext(ctx context.Context, market *environment.Market, orderID string) (*environment.OrderInfo, error) {
+	ctx, cancel := wrapper.callContext(ctx)
+	defer cancel()
+
+	if native, ok := wrapper.native(); ok {
+		return native.GetOrderContext(ctx, market, orderID)
+	}
+
+	var ret *environment.OrderInfo
+	err := runContext(ctx, func() (err error) {
+		ret, err = wrapper.innerWrapper.GetOrder(market, orderID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// GetOpenOrders gets the open orders of the user on a market.
+func (wrapper *ContextWrapper) GetOpenOrders(market *environment.Market) ([]*environment.OrderInfo, error) {
+	return wrapper.GetOpenOrdersContext(wrapper.ctx, market)
+}
+
+// GetOpenOrdersContext gets the open orders of the user on a market.
+func (wrapper *ContextWrapper) GetOpenOrdersContext(ctx context.Context, market *environment.Market) ([]*environment.OrderInfo, error) {
+	ctx, cancel := wrapper.callContext(ctx)
+	defer cancel()
+
+	if native, ok := wrapper.native(); ok {
+		return native.GetOpenOrdersContext(ctx, market)
+	}
+
+	var ret []*environment.OrderInfo
+	err := runContext(ctx, func() (err error) {
+		ret, err = wrapper.innerWrapper.GetOpenOrders(market)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// CancelOrder cancels an open order.
+func (wrapper *ContextWrapper) CancelOrder(market *environment.Market, orderID string) error {
+	return wrapper.CancelOrderContext(wrapper.ctx, market, orderID)
+}
+
+// CancelOrderContext cancels an open order.
+func (wrapper *ContextWrapper) CancelOrderContext(ctx context.Context, market *environment.Market, orderID string) error {
+	ctx, cancel := wrapper.callContext(ctx)
+	defer cancel()
+
+	if native, ok := wrapper.native(); ok {
+		return native.CancelOrderContext(ctx, market, orderID)
+	}
+
+	return runOrderContext(ctx, func() error {
+		return wrapper.innerWrapper.CancelOrder(market, orderID)
+	})
+}
+
+// CalculateTradingFees calculates the trading fees for an order on a specified market.
+func (wrapper *ContextWrapper) CalculateTradingFees(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal, orderType TradeType) decimal.Decimal {
+	return wrapper.innerWrapper.CalculateTradingFees(market, amount, limit, orderType)
+}
+
+// CalculateWithdrawFees calculates the withdrawal fees on a specified market.
+func (wrapper *ContextWrapper) CalculateWithdrawFees(market *environment.Market, amount decimal.Decimal) decimal.Decimal {
+	return wrapper.innerWrapper.CalculateWithdrawFees(market, amount)
+}
+
+// GetBalance gets the balance of the user of the specified currency.
+func (wrapper *ContextWrapper) GetBalance(symbol string) (*decimal.Decimal, error) {
+	return wrapper.GetBalanceContext(wrapper.ctx, symbol)
+}
+
+// GetBalanceContext gets the balance of the user of the specified currency.
+func (wrapper *ContextWrapper) GetBalanceContext(ctx context.Context, symbol string) (*decimal.Decimal, error) {
+	ctx, cancel := wrapper.callContext(ctx)
+	defer cancel()
+
+	if native, ok := wrapper.native(); ok {
+		return native.GetBalanceContext(ctx, symbol)
+	}
+
+	var ret *decimal.Decimal
+	err := runContext(ctx, func() (err error) {
+		ret, err = wrapper.innerWrapper.GetBalance(symbol)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// GetDepositAddress gets the deposit address for the specified coin on the exchange.
+func (wrapper *ContextWrapper) GetDepositAddress(coinTicker string) (string, bool) {
+	return wrapper.innerWrapper.GetDepositAddress(coinTicker)
+}
+
+// FeedConnect connects to the feed of the exchange, until the feed context (or the context) of the wrapper is done.
+func (wrapper *ContextWrapper) FeedConnect(markets []*environment.Market) error {
+	if wrapper.feedCtx != nil {
+		return wrapper.FeedConnectContext(wrapper.feedCtx, markets)
+	}
+	return wrapper.FeedConnectContext(wrapper.ctx, markets)
+}
+
+// FeedConnectContext connects to the feed of the exchange, until the context is done.
+//
+// The deadline is not applied, as subscriptions outlive the call.
+func (wrapper *ContextWrapper) FeedConnectContext(ctx context.Context, markets []*environment.Market) error {
+	if connector, ok := wrapper.innerWrapper.(feedContextConnector); ok {
+		return connector.FeedConnectContext(ctx, markets)
+	}
+	return wrapper.innerWrapper.FeedConnect(markets)
+}
+
+// Withdraw performs a withdraw operation from the exchange to a destination address.
+func (wrapper *ContextWrapper) Withdraw(destinationAddress string, coinTicker string, amount decimal.Decimal) error {
+	return wrapper.WithdrawContext(wrapper.ctx, destinationAddress, coinTicker, amount)
+}
+
+// WithdrawContext performs a withdraw operation from the exchange to a destination address.
+func (wrapper *ContextWrapper) WithdrawContext(ctx context.Context, destinationAddress string, coinTicker string, amount decimal.Decimal) error {
+	ctx, cancel := wrapper.callContext(ctx)
+	defer cancel()
+
+	if native, ok := wrapper.native(); ok {
+		return native.WithdrawContext(ctx, destinationAddress, coinTicker, amount)
+	}
+
+	return runOrderContext(ctx, func() error {
+		return wrapper.innerWrapper.Withdraw(destinationAddress, coinTicker, amount)
+	})
+}
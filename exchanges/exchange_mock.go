@@ -1,7 +1,11 @@
 package exchanges
 
 import (
+	"context"
 	"fmt"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/gofrs/uuid"
 	"github.com/juju/errors"
@@ -10,16 +14,93 @@ import (
 )
 
 // ExchangeWrapperSimulator wraps another wrapper and returns simulated balances and orders.
+//
+// Limit orders rest in a local book and are filled when the market data of the inner wrapper crosses their price,
+// while their amount is reserved from the balance. Each order book snapshot is consumed by the resting orders
+// in price/time priority, so that its depth fills them only once; market orders take the depth they leave,
+// and cancel the quantity it cannot fill.
 type ExchangeWrapperSimulator struct {
 	innerWrapper ExchangeWrapper
-	balances     map[string]decimal.Decimal
+	mutex        *sync.Mutex
+	balances     map[string]decimal.Decimal // free balances.
+	reserved     map[string]decimal.Decimal // balances reserved by open limit orders.
+	openOrders   []*simulatedOrder
+	orders       map[string]*simulatedOrder // all the orders placed, by ID.
+	fills        []SimulatedFill
+	fees         environment.FeeConfig // overrides the fees of the inner wrapper, when set.
+	depths       map[string]*bookDepth // depth left by the last matched order book of each market.
+}
+
+// bookDepth represents the depth of an order book snapshot not consumed by the resting orders yet.
+type bookDepth struct {
+	snapshot environment.OrderBook // the matched snapshot, as got from the inner wrapper.
+	asks     []environment.Order   // asks left, best price first.
+	bids     []environment.Order   // bids left, best price first.
+}
+
+// SimulatedFill represents a (partial) fill of an order placed on the simulator.
+type SimulatedFill struct {
+	Time        time.Time             // Represents the time of the fill.
+	OrderID     string                // Represents the ID of the filled order.
+	Market      string                // Represents the name of the market.
+	Type        environment.OrderType // Represents the side of the fill (Bid for buys, Ask for sells).
+	TradeType   TradeType             // Represents whether the fill paid maker or taker fees.
+	Price       decimal.Decimal       // Represents the fill price, in base currency.
+	Quantity    decimal.Decimal       // Represents the filled quantity, in market currency.
+	Fee         decimal.Decimal       // Represents the paid fee, in fee currency.
+	FeeCurrency string                // Represents the currency the fee has been charged in.
+}
+
+// simulatedOrder represents an order placed on the simulator.
+type simulatedOrder struct {
+	id          string
+	market      *environment.Market
+	orderType   environment.OrderType
+	quantity    decimal.Decimal // total quantity, in market currency.
+	filled      decimal.Decimal // filled quantity, in market currency.
+	filledValue decimal.Decimal // value of the fills, in base currency.
+	limit       decimal.Decimal // limit price, in base currency, zero for market orders.
+	reserved    decimal.Decimal // amount still reserved by the order.
+	fee         decimal.Decimal // fees paid by the fills, in fee currency.
+	feeCurrency string
+	cancelled   bool
+	placedAt    time.Time
+}
+
+// info returns the status of the order.
+func (order *simulatedOrder) info() *environment.OrderInfo {
+	ret := &environment.OrderInfo{
+		ID:             order.id,
+		Market:         order.market,
+		Type:           order.orderType,
+		Status:         orderStatusFor(order.quantity, order.filled, !order.cancelled && order.filled.LessThan(order.quantity)),
+		Quantity:       order.quantity,
+		Limit:          order.limit,
+		FilledQuantity: order.filled,
+		Fee:            order.fee,
+		FeeCurrency:    order.feeCurrency,
+		Timestamp:      order.placedAt,
+	}
+	if order.filled.IsPositive() {
+		ret.AveragePrice = order.filledValue.Div(order.filled)
+	}
+	return ret
 }
 
 // NewExchangeWrapperSimulator creates a new simulated wrapper from another wrapper and an initial balance.
 func NewExchangeWrapperSimulator(mockedWrapper ExchangeWrapper, initialBalances map[string]decimal.Decimal) *ExchangeWrapperSimulator {
+	balances := make(map[string]decimal.Decimal, len(initialBalances))
+	for coin, balance := range initialBalances {
+		balances[coin] = balance
+	}
+
 	return &ExchangeWrapperSimulator{
 		innerWrapper: mockedWrapper,
-		balances:     initialBalances,
+		mutex:        &sync.Mutex{},
+		balances:     balances,
+		reserved:     make(map[string]decimal.Decimal),
+		orders:       make(map[string]*simulatedOrder),
+		depths:       make(map[string]*bookDepth),
 	}
 }
 
@@ -33,121 +114,672 @@ func (wrapper *ExchangeWrapperSimulator) Name() string {
 	return fmt.Sprint(wrapper.innerWrapper.Name(), "mock")
 }
 
+// GetMarkets gets all the markets of the exchange.
+func (wrapper *ExchangeWrapperSimulator) GetMarkets() ([]*environment.Market, error) {
+	return wrapper.GetMarketsContext(context.Background())
+}
+
+// GetMarketsContext gets all the markets of the exchange.
+func (wrapper *ExchangeWrapperSimulator) GetMarketsContext(ctx context.Context) ([]*environment.Market, error) {
+	return AsContextExchangeWrapper(wrapper.innerWrapper).GetMarketsContext(ctx)
+}
+
+// Unwrap returns the wrapped ExchangeWrapper.
+func (wrapper *ExchangeWrapperSimulator) Unwrap() ExchangeWrapper {
+	return wrapper.innerWrapper
+}
+
 // GetCandles gets the candle data from the exchange.
 func (wrapper *ExchangeWrapperSimulator) GetCandles(market *environment.Market) ([]environment.CandleStick, error) {
-	return wrapper.innerWrapper.GetCandles(market)
+	return wrapper.GetCandlesContext(context.Background(), market)
 }
 
-// GetMarketSummary gets the current market summary.
+// GetCandlesContext gets the candle data from the exchange.
+func (wrapper *ExchangeWrapperSimulator) GetCandlesContext(ctx context.Context, market *environment.Market) ([]environment.CandleStick, error) {
+	return AsContextExchangeWrapper(wrapper.innerWrapper).GetCandlesContext(ctx, market)
+}
+
+// GetMarketSummary gets the current market summary, filling the resting orders crossed by it.
 func (wrapper *ExchangeWrapperSimulator) GetMarketSummary(market *environment.Market) (*environment.MarketSummary, error) {
-	return wrapper.innerWrapper.GetMarketSummary(market)
+	return wrapper.GetMarketSummaryContext(context.Background(), market)
 }
 
-// GetOrderBook gets the order(ASK + BID) book of a market.
+// GetMarketSummaryContext gets the current market summary, filling the resting orders crossed by it.
+func (wrapper *ExchangeWrapperSimulator) GetMarketSummaryContext(ctx context.Context, market *environment.Market) (*environment.MarketSummary, error) {
+	summary, err := AsContextExchangeWrapper(wrapper.innerWrapper).GetMarketSummaryContext(ctx, market)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapper.mutex.Lock()
+	wrapper.matchSummary(market, summary)
+	wrapper.mutex.Unlock()
+
+	return summary, nil
+}
+
+// GetOrderBook gets the order(ASK + BID) book of a market, filling the resting orders crossed by it.
 func (wrapper *ExchangeWrapperSimulator) GetOrderBook(market *environment.Market) (*environment.OrderBook, error) {
-	return wrapper.innerWrapper.GetOrderBook(market)
+	return wrapper.GetOrderBookContext(context.Background(), market)
 }
 
-// BuyLimit here is just to implement the ExchangeWrapper Interface, do not use, use BuyMarket instead.
-func (wrapper *ExchangeWrapperSimulator) BuyLimit(market *environment.Market, amount float64, limit float64) (string, error) {
-	return "", errors.New("BuyLimit operation is not mockable")
+// GetOrderBookContext gets the order(ASK + BID) book of a market, filling the resting orders crossed by it.
+func (wrapper *ExchangeWrapperSimulator) GetOrderBookContext(ctx context.Context, market *environment.Market) (*environment.OrderBook, error) {
+	orderbook, err := AsContextExchangeWrapper(wrapper.innerWrapper).GetOrderBookContext(ctx, market)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapper.mutex.Lock()
+	wrapper.matchOrderBook(market, orderbook)
+	wrapper.mutex.Unlock()
+
+	return orderbook, nil
+}
+
+// BuyLimit places a FAKE limit buy order, reserving its cost (fees included) from the base currency balance.
+//
+// Fills pay maker fees.
+func (wrapper *ExchangeWrapperSimulator) BuyLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return wrapper.placeLimitOrder(market, environment.Bid, amount, limit, "")
+}
+
+// BuyLimitContext places a FAKE limit buy order, like BuyLimit, unless the context is done.
+func (wrapper *ExchangeWrapperSimulator) BuyLimitContext(ctx context.Context, market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return wrapper.BuyLimit(market, amount, limit)
+}
+
+// SellLimit places a FAKE limit sell order, reserving its amount from the market currency balance.
+//
+// Fills pay maker fees.
+func (wrapper *ExchangeWrapperSimulator) SellLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return wrapper.placeLimitOrder(market, environment.Ask, amount, limit, "")
+}
+
+// SellLimitContext places a FAKE limit sell order, like SellLimit, unless the context is done.
+func (wrapper *ExchangeWrapperSimulator) SellLimitContext(ctx context.Context, market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return wrapper.SellLimit(market, amount, limit)
 }
 
-// SellLimit here is just to implement the ExchangeWrapper Interface, do not use, use SellMarket instead.
-func (wrapper *ExchangeWrapperSimulator) SellLimit(market *environment.Market, amount float64, limit float64) (string, error) {
-	return "", errors.New("SellLimit operation is not mockable")
+// PlaceOrder places a FAKE order, whose ID is its client order ID (generated if empty).
+func (wrapper *ExchangeWrapperSimulator) PlaceOrder(ctx context.Context, order OrderRequest) (string, error) {
+	switch {
+	case ctx.Err() != nil:
+		return "", ctx.Err()
+	case !order.Limit.IsZero():
+		return wrapper.placeLimitOrder(order.Market, order.Type, order.Quantity, order.Limit, order.ClientOrderID)
+	case order.Type == environment.Bid:
+		return wrapper.buyMarket(ctx, order.Market, order.Quantity, order.ClientOrderID)
+	default:
+		return wrapper.sellMarket(ctx, order.Market, order.Quantity, order.ClientOrderID)
+	}
 }
 
-// BuyMarket performs a FAKE market buy action.
-func (wrapper *ExchangeWrapperSimulator) BuyMarket(market *environment.Market, amount float64) (string, error) {
-	baseBalance, _ := wrapper.GetBalance(market.BaseCurrency)
-	quoteBalance, _ := wrapper.GetBalance(market.MarketCurrency)
+// simulatedOrderID returns the ID of a FAKE order: its client order ID if not empty, otherwise a new one with the specified prefix.
+func simulatedOrderID(clientOrderID string, prefix string) (string, error) {
+	if clientOrderID != "" {
+		return clientOrderID, nil
+	}
+	orderFakeID, err := uuid.NewV4()
+	if err != nil {
+		return "", errors.Annotate(err, "UUID Generation")
+	}
+	return fmt.Sprintf("%s-%s", prefix, orderFakeID), nil
+}
 
-	orderbook, err := wrapper.GetOrderBook(market)
+func (wrapper *ExchangeWrapperSimulator) placeLimitOrder(market *environment.Market, orderType environment.OrderType, amount decimal.Decimal, limit decimal.Decimal, clientOrderID string) (string, error) {
+	if !amount.IsPositive() || !limit.IsPositive() {
+		return "", errors.New("Order amount and limit must be > 0")
+	}
+
+	quantity, price, err := roundOrder(wrapper.innerWrapper, market, orderType, amount, limit)
 	if err != nil {
-		return "", errors.Annotate(err, "Cannot market buy without orderbook knowledge")
+		return "", err
 	}
 
-	totalQuote := decimal.Zero
-	remainingAmount := decimal.NewFromFloat(amount)
-	expense := decimal.Zero
+	order := &simulatedOrder{
+		market:    market,
+		orderType: orderType,
+		quantity:  quantity,
+		limit:     price,
+		placedAt:  time.Now(),
+	}
 
-	for _, ask := range orderbook.Asks {
-		if remainingAmount.LessThanOrEqual(ask.Quantity) {
-			totalQuote = totalQuote.Add(remainingAmount)
-			expense = expense.Add(remainingAmount.Mul(ask.Value))
-			if expense.GreaterThan(*baseBalance) {
-				return "", fmt.Errorf("cannot Buy not enough %s balance", market.BaseCurrency)
+	prefix := "FAKE_BUY_LIMIT"
+	if orderType == environment.Ask {
+		prefix = "FAKE_SELL_LIMIT"
+	}
+	order.id, err = simulatedOrderID(clientOrderID, prefix)
+	if err != nil {
+		return "", err
+	}
+
+	wrapper.mutex.Lock()
+	defer wrapper.mutex.Unlock()
+
+	if _, exists := wrapper.orders[order.id]; exists {
+		return "", fmt.Errorf("Cannot place order: duplicate order ID %s", order.id)
+	}
+
+	spentCoin, spent := order.spending(order.quantity, order.limit)
+	fee, feeCoin := wrapper.tradingFee(market, orderType, MakerTrade, order.quantity, order.limit)
+	if feeCoin == spentCoin {
+		spent = spent.Add(fee)
+	}
+
+	if wrapper.balances[spentCoin].LessThan(spent) {
+		return "", fmt.Errorf("Cannot place order: not enough %s balance", spentCoin)
+	}
+	order.reserved = spent
+	wrapper.balances[spentCoin] = wrapper.balances[spentCoin].Sub(spent)
+	wrapper.reserved[spentCoin] = wrapper.reserved[spentCoin].Add(spent)
+	wrapper.openOrders = append(wrapper.openOrders, order)
+	wrapper.orders[order.id] = order
+
+	return order.id, nil
+}
+
+// spending returns the coin and the amount spent by filling a quantity of the order at a price, fees excluded.
+func (order *simulatedOrder) spending(quantity decimal.Decimal, price decimal.Decimal) (string, decimal.Decimal) {
+	if order.orderType == environment.Bid {
+		return order.market.BaseCurrency, quantity.Mul(price)
+	}
+	return order.market.MarketCurrency, quantity
+}
+
+// SetFeeSchedule sets the fee schedule of the simulated fills, overriding the fees of the inner wrapper.
+//
+// Returns an error, leaving the schedule unchanged, if the fee currency is not one of the FeeCurrency values.
+func (wrapper *ExchangeWrapperSimulator) SetFeeSchedule(fees environment.FeeConfig) error {
+	switch fees.Currency {
+	case "", environment.FeeCurrencyBase, environment.FeeCurrencyMarket, environment.FeeCurrencyReceived:
+	default:
+		return fmt.Errorf("Unknown fee currency %q: must be %s, %s or %s", fees.Currency,
+			environment.FeeCurrencyBase, environment.FeeCurrencyMarket, environment.FeeCurrencyReceived)
+	}
+
+	wrapper.mutex.Lock()
+	defer wrapper.mutex.Unlock()
+	wrapper.fees = fees
+	return nil
+}
+
+// Fills returns the fills of the orders placed on the simulator.
+func (wrapper *ExchangeWrapperSimulator) Fills() []SimulatedFill {
+	wrapper.mutex.Lock()
+	defer wrapper.mutex.Unlock()
+	return append([]SimulatedFill(nil), wrapper.fills...)
+}
+
+// tradingFee calculates the fee of a fill and the coin it is charged in.
+func (wrapper *ExchangeWrapperSimulator) tradingFee(market *environment.Market, orderType environment.OrderType, tradeType TradeType, quantity decimal.Decimal, price decimal.Decimal) (decimal.Decimal, string) {
+	var fee decimal.Decimal
+	if rate := wrapper.feeRate(tradeType); rate != nil {
+		fee = quantity.Mul(price).Mul(*rate)
+	} else {
+		fee = wrapper.innerWrapper.CalculateTradingFees(market, quantity, price, tradeType)
+	}
+
+	switch wrapper.fees.Currency {
+	case environment.FeeCurrencyMarket:
+		return fee.Div(price), market.MarketCurrency
+	case environment.FeeCurrencyReceived:
+		if orderType == environment.Bid {
+			return fee.Div(price), market.MarketCurrency
+		}
+	}
+	return fee, market.BaseCurrency
+}
+
+// feeRate returns the fee rate of the schedule for a trade type, nil if not set.
+func (wrapper *ExchangeWrapperSimulator) feeRate(tradeType TradeType) *decimal.Decimal {
+	if tradeType == MakerTrade {
+		return wrapper.fees.Maker
+	}
+	return wrapper.fees.Taker
+}
+
+// matchSummary fills the resting orders of a market crossed by its summary, the caller must hold the lock.
+//
+// The summary carries no depth, so crossed orders are filled completely, at the quoted price.
+func (wrapper *ExchangeWrapperSimulator) matchSummary(market *environment.Market, summary *environment.MarketSummary) {
+	for _, order := range wrapper.openOrders {
+		if order.market.Name != market.Name {
+			continue
+		}
+		if order.orderType == environment.Bid && summary.Ask.IsPositive() && summary.Ask.LessThanOrEqual(order.limit) {
+			wrapper.fillLimitOrder(order, order.quantity.Sub(order.filled), summary.Ask)
+		} else if order.orderType == environment.Ask && summary.Bid.IsPositive() && summary.Bid.GreaterThanOrEqual(order.limit) {
+			wrapper.fillLimitOrder(order, order.quantity.Sub(order.filled), summary.Bid)
+		}
+	}
+	wrapper.removeClosedOrders()
+}
+
+// matchOrderBook fills the resting orders of a market crossed by its order book, the caller must hold the lock.
+//
+// The orders consume the depth of the book in price/time priority, each level filling them at its price.
+// Matching the same snapshot again fills the orders only with the depth left by the previous matches.
+func (wrapper *ExchangeWrapperSimulator) matchOrderBook(market *environment.Market, orderbook *environment.OrderBook) {
+	depth, matched := wrapper.depths[market.Name]
+	if !matched || !sameOrderBook(&depth.snapshot, orderbook) {
+		depth = newBookDepth(orderbook)
+		wrapper.depths[market.Name] = depth
+	}
+
+	var orders []*simulatedOrder
+	for _, order := range wrapper.openOrders {
+		if order.market.Name == market.Name {
+			orders = append(orders, order)
+		}
+	}
+	// the open orders are sorted by time, a stable sort keeps it among the same prices.
+	sort.SliceStable(orders, func(i, j int) bool {
+		if orders[i].orderType != orders[j].orderType {
+			return orders[i].orderType == environment.Bid
+		}
+		if orders[i].orderType == environment.Bid {
+			return orders[i].limit.GreaterThan(orders[j].limit)
+		}
+		return orders[i].limit.LessThan(orders[j].limit)
+	})
+
+	for _, order := range orders {
+		levels := &depth.asks
+		crosses := order.limit.GreaterThanOrEqual
+		if order.orderType == environment.Ask {
+			levels = &depth.bids
+			crosses = order.limit.LessThanOrEqual
+		}
+
+		for len(*levels) > 0 && order.filled.LessThan(order.quantity) {
+			level := &(*levels)[0]
+			if !crosses(level.Value) {
+				break
 			}
-			break
+			quantity := decimal.Min(level.Quantity, order.quantity.Sub(order.filled))
+			if quantity.IsPositive() {
+				wrapper.fillLimitOrder(order, quantity, level.Value)
+			}
+			level.Quantity = level.Quantity.Sub(quantity)
+			if !level.Quantity.IsPositive() {
+				*levels = (*levels)[1:]
+			}
+		}
+	}
+	wrapper.removeClosedOrders()
+}
+
+// newBookDepth returns the whole depth of an order book snapshot.
+func newBookDepth(orderbook *environment.OrderBook) *bookDepth {
+	depth := &bookDepth{
+		snapshot: environment.OrderBook{
+			Asks: append([]environment.Order(nil), orderbook.Asks...),
+			Bids: append([]environment.Order(nil), orderbook.Bids...),
+		},
+		asks: append([]environment.Order(nil), orderbook.Asks...),
+		bids: append([]environment.Order(nil), orderbook.Bids...),
+	}
+	sort.SliceStable(depth.asks, func(i, j int) bool {
+		return depth.asks[i].Value.LessThan(depth.asks[j].Value)
+	})
+	sort.SliceStable(depth.bids, func(i, j int) bool {
+		return depth.bids[i].Value.GreaterThan(depth.bids[j].Value)
+	})
+	return depth
+}
+
+// sameOrderBook tells if two order book snapshots have the same levels.
+func sameOrderBook(first *environment.OrderBook, second *environment.OrderBook) bool {
+	return sameLevels(first.Asks, second.Asks) && sameLevels(first.Bids, second.Bids)
+}
+
+// sameLevels tells if two sides of an order book have the same prices and quantities.
+func sameLevels(first []environment.Order, second []environment.Order) bool {
+	if len(first) != len(second) {
+		return false
+	}
+	for i := range first {
+		if !first[i].Value.Equal(second[i].Value) || !first[i].Quantity.Equal(second[i].Quantity) {
+			return false
 		}
-		totalQuote = totalQuote.Add(ask.Quantity)
-		expense = expense.Add(ask.Quantity.Mul(ask.Value))
-		if expense.GreaterThan(*baseBalance) {
-			return "", fmt.Errorf("cannot Buy not enough %s balance", market.BaseCurrency)
+	}
+	return true
+}
+
+// fillLimitOrder fills a quantity of a resting order at a price not worse than its limit, the caller must hold the lock.
+func (wrapper *ExchangeWrapperSimulator) fillLimitOrder(order *simulatedOrder, quantity decimal.Decimal, price decimal.Decimal) {
+	market := order.market
+	value := quantity.Mul(price)
+	fee, feeCoin := wrapper.tradingFee(market, order.orderType, MakerTrade, quantity, price)
+	order.filled = order.filled.Add(quantity)
+	order.filledValue = order.filledValue.Add(value)
+
+	// the spent amount is taken from the reservation, releasing what is left when the order is filled.
+	spentCoin, spent := order.spending(quantity, price)
+	if feeCoin == spentCoin {
+		spent = spent.Add(fee)
+	}
+	release := spent
+	if order.filled.GreaterThanOrEqual(order.quantity) || release.GreaterThan(order.reserved) {
+		release = order.reserved
+	}
+	order.reserved = order.reserved.Sub(release)
+	wrapper.reserved[spentCoin] = wrapper.reserved[spentCoin].Sub(release)
+	wrapper.balances[spentCoin] = wrapper.balances[spentCoin].Add(release).Sub(spent)
+
+	if order.orderType == environment.Bid {
+		wrapper.balances[market.MarketCurrency] = wrapper.balances[market.MarketCurrency].Add(quantity)
+	} else {
+		wrapper.balances[market.BaseCurrency] = wrapper.balances[market.BaseCurrency].Add(value)
+	}
+	if feeCoin != spentCoin {
+		wrapper.balances[feeCoin] = wrapper.balances[feeCoin].Sub(fee)
+	}
+
+	wrapper.addFill(order, MakerTrade, quantity, price, fee, feeCoin)
+}
+
+// addFill records a fill of an order, the caller must hold the lock.
+func (wrapper *ExchangeWrapperSimulator) addFill(order *simulatedOrder, tradeType TradeType, quantity decimal.Decimal, price decimal.Decimal, fee decimal.Decimal, feeCoin string) {
+	order.fee = order.fee.Add(fee)
+	order.feeCurrency = feeCoin
+	wrapper.fills = append(wrapper.fills, SimulatedFill{
+		Time:        time.Now(),
+		OrderID:     order.id,
+		Market:      order.market.Name,
+		Type:        order.orderType,
+		TradeType:   tradeType,
+		Price:       price,
+		Quantity:    quantity,
+		Fee:         fee,
+		FeeCurrency: feeCoin,
+	})
+}
+
+// removeClosedOrders removes the fully filled orders from the book, the caller must hold the lock.
+func (wrapper *ExchangeWrapperSimulator) removeClosedOrders() {
+	stillOpen := wrapper.openOrders[:0]
+	for _, order := range wrapper.openOrders {
+		if !order.cancelled && order.filled.LessThan(order.quantity) {
+			stillOpen = append(stillOpen, order)
 		}
 	}
+	wrapper.openOrders = stillOpen
+}
 
-	wrapper.balances[market.BaseCurrency] = baseBalance.Sub(expense)
-	wrapper.balances[market.MarketCurrency] = quoteBalance.Add(totalQuote)
+// matchOpenOrders fetches the order book of each market having resting orders and fills the crossed ones.
+func (wrapper *ExchangeWrapperSimulator) matchOpenOrders(ctx context.Context) {
+	wrapper.mutex.Lock()
+	markets := make(map[string]*environment.Market)
+	for _, order := range wrapper.openOrders {
+		markets[order.market.Name] = order.market
+	}
+	wrapper.mutex.Unlock()
 
-	orderFakeID, err := uuid.NewV4()
-	if err != nil {
-		return "", errors.Annotate(err, "UUID Generation")
+	for _, market := range markets {
+		wrapper.GetOrderBookContext(ctx, market)
 	}
-	return fmt.Sprintf("FAKE_BUY-%s", orderFakeID), nil
 }
 
-// SellMarket performs a FAKE market buy action.
-func (wrapper *ExchangeWrapperSimulator) SellMarket(market *environment.Market, amount float64) (string, error) {
-	baseBalance, _ := wrapper.GetBalance(market.BaseCurrency)
-	quoteBalance, _ := wrapper.GetBalance(market.MarketCurrency)
+// BuyMarket performs a FAKE market buy action, paying taker fees.
+func (wrapper *ExchangeWrapperSimulator) BuyMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
+	return wrapper.buyMarket(context.Background(), market, amount, "")
+}
+
+// BuyMarketContext performs a FAKE market buy action, reading the order book with the context.
+func (wrapper *ExchangeWrapperSimulator) BuyMarketContext(ctx context.Context, market *environment.Market, amount decimal.Decimal) (string, error) {
+	return wrapper.buyMarket(ctx, market, amount, "")
+}
 
-	orderbook, err := wrapper.GetOrderBook(market)
+func (wrapper *ExchangeWrapperSimulator) buyMarket(ctx context.Context, market *environment.Market, amount decimal.Decimal, clientOrderID string) (string, error) {
+	quantity, _, err := roundOrder(wrapper.innerWrapper, market, environment.Bid, amount, decimal.Zero)
 	if err != nil {
+		return "", err
+	}
+
+	if _, err := wrapper.GetOrderBookContext(ctx, market); err != nil {
 		return "", errors.Annotate(err, "Cannot market buy without orderbook knowledge")
 	}
 
-	totalQuote := decimal.Zero
-	remainingAmount := decimal.NewFromFloat(amount)
-	gain := decimal.Zero
+	wrapper.mutex.Lock()
+	defer wrapper.mutex.Unlock()
 
-	if quoteBalance.LessThan(remainingAmount) {
+	depth := wrapper.depths[market.Name]
+	filled, expense, asksLeft := takeDepth(depth.asks, quantity)
+	if !filled.IsPositive() {
+		return "", errors.New("Cannot market buy: empty orderbook")
+	}
+
+	baseBalance := wrapper.balances[market.BaseCurrency]
+	price := expense.Div(filled)
+	fee, feeCoin := wrapper.tradingFee(market, environment.Bid, TakerTrade, filled, price)
+	spent := expense
+	if feeCoin == market.BaseCurrency {
+		spent = spent.Add(fee)
+	}
+	if spent.GreaterThan(baseBalance) {
+		return "", fmt.Errorf("cannot Buy not enough %s balance", market.BaseCurrency)
+	}
+
+	orderID, err := simulatedOrderID(clientOrderID, "FAKE_BUY")
+	if err != nil {
+		return "", err
+	}
+	if _, exists := wrapper.orders[orderID]; exists {
+		return "", fmt.Errorf("Cannot place order: duplicate order ID %s", orderID)
+	}
+
+	depth.asks = asksLeft
+	wrapper.balances[market.BaseCurrency] = baseBalance.Sub(expense)
+	wrapper.balances[market.MarketCurrency] = wrapper.balances[market.MarketCurrency].Add(filled)
+	wrapper.balances[feeCoin] = wrapper.balances[feeCoin].Sub(fee)
+
+	return wrapper.addMarketOrder(orderID, market, environment.Bid, quantity, filled, price, fee, feeCoin), nil
+}
+
+// SellMarket performs a FAKE market sell action, paying taker fees.
+func (wrapper *ExchangeWrapperSimulator) SellMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
+	return wrapper.sellMarket(context.Background(), market, amount, "")
+}
+
+// SellMarketContext performs a FAKE market sell action, reading the order book with the context.
+func (wrapper *ExchangeWrapperSimulator) SellMarketContext(ctx context.Context, market *environment.Market, amount decimal.Decimal) (string, error) {
+	return wrapper.sellMarket(ctx, market, amount, "")
+}
+
+func (wrapper *ExchangeWrapperSimulator) sellMarket(ctx context.Context, market *environment.Market, amount decimal.Decimal, clientOrderID string) (string, error) {
+	quantity, _, err := roundOrder(wrapper.innerWrapper, market, environment.Ask, amount, decimal.Zero)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := wrapper.GetOrderBookContext(ctx, market); err != nil {
+		return "", errors.Annotate(err, "Cannot market sell without orderbook knowledge")
+	}
+
+	wrapper.mutex.Lock()
+	defer wrapper.mutex.Unlock()
+
+	marketBalance := wrapper.balances[market.MarketCurrency]
+	if marketBalance.LessThan(quantity) {
 		return "", fmt.Errorf("Cannot Sell: not enough %s balance", market.MarketCurrency)
 	}
 
-	for _, bid := range orderbook.Bids {
-		if remainingAmount.LessThanOrEqual(bid.Quantity) {
-			totalQuote = totalQuote.Add(remainingAmount)
-			gain = gain.Add(remainingAmount.Mul(bid.Value))
-			break
-		}
-		totalQuote = totalQuote.Add(bid.Quantity)
-		gain = gain.Add(bid.Quantity.Mul(bid.Value))
+	depth := wrapper.depths[market.Name]
+	filled, gain, bidsLeft := takeDepth(depth.bids, quantity)
+	if !filled.IsPositive() {
+		return "", errors.New("Cannot market sell: empty orderbook")
 	}
 
-	wrapper.balances[market.BaseCurrency] = baseBalance.Add(gain)
-	wrapper.balances[market.MarketCurrency] = quoteBalance.Sub(totalQuote)
+	price := gain.Div(filled)
+	fee, feeCoin := wrapper.tradingFee(market, environment.Ask, TakerTrade, filled, price)
+	if feeCoin == market.MarketCurrency && filled.Add(fee).GreaterThan(marketBalance) {
+		return "", fmt.Errorf("Cannot Sell: not enough %s balance", market.MarketCurrency)
+	}
 
-	orderFakeID, err := uuid.NewV4()
+	orderID, err := simulatedOrderID(clientOrderID, "FAKE_SELL")
 	if err != nil {
-		return "", errors.Annotate(err, "UUID Generation")
+		return "", err
+	}
+	if _, exists := wrapper.orders[orderID]; exists {
+		return "", fmt.Errorf("Cannot place order: duplicate order ID %s", orderID)
+	}
+
+	depth.bids = bidsLeft
+	wrapper.balances[market.BaseCurrency] = wrapper.balances[market.BaseCurrency].Add(gain)
+	wrapper.balances[market.MarketCurrency] = marketBalance.Sub(filled)
+	wrapper.balances[feeCoin] = wrapper.balances[feeCoin].Sub(fee)
+
+	return wrapper.addMarketOrder(orderID, market, environment.Ask, quantity, filled, price, fee, feeCoin), nil
+}
+
+// takeDepth walks the levels of a side of the book, best price first, up to a quantity.
+//
+// Returns the quantity filled by the levels, which is less than the requested one when the depth is not enough,
+// its value and the levels left, without changing the walked ones.
+func takeDepth(levels []environment.Order, quantity decimal.Decimal) (decimal.Decimal, decimal.Decimal, []environment.Order) {
+	filled := decimal.Zero
+	value := decimal.Zero
+	for i, level := range levels {
+		taken := decimal.Min(level.Quantity, quantity.Sub(filled))
+		filled = filled.Add(taken)
+		value = value.Add(taken.Mul(level.Value))
+		if taken.LessThan(level.Quantity) {
+			level.Quantity = level.Quantity.Sub(taken)
+			return filled, value, append([]environment.Order{level}, levels[i+1:]...)
+		}
+	}
+	return filled, value, nil
+}
+
+// addMarketOrder records a market order filled at an average price, the caller must hold the lock.
+//
+// Market orders do not rest in the book: the quantity the depth could not fill is cancelled.
+func (wrapper *ExchangeWrapperSimulator) addMarketOrder(id string, market *environment.Market, orderType environment.OrderType, quantity decimal.Decimal, filled decimal.Decimal, price decimal.Decimal, fee decimal.Decimal, feeCoin string) string {
+	order := &simulatedOrder{
+		id:          id,
+		market:      market,
+		orderType:   orderType,
+		quantity:    quantity,
+		filled:      filled,
+		filledValue: filled.Mul(price),
+		cancelled:   filled.LessThan(quantity),
+		placedAt:    time.Now(),
+	}
+	wrapper.orders[id] = order
+	wrapper.addFill(order, TakerTrade, filled, price, fee, feeCoin)
+	return id
+}
+
+// GetOrder gets the status of an order placed on the simulator, after filling the crossed resting orders.
+func (wrapper *ExchangeWrapperSimulator) GetOrder(market *environment.Market, orderID string) (*environment.OrderInfo, error) {
+	return wrapper.GetOrderContext(context.Background(), market, orderID)
+}
+
+// GetOrderContext gets the status of an order placed on the simulator, after filling the crossed resting orders.
+func (wrapper *ExchangeWrapperSimulator) GetOrderContext(ctx context.Context, market *environment.Market, orderID string) (*environment.OrderInfo, error) {
+	wrapper.matchOpenOrders(ctx)
+
+	wrapper.mutex.Lock()
+	defer wrapper.mutex.Unlock()
+
+	order, exists := wrapper.orders[orderID]
+	if !exists {
+		return nil, ErrOrderNotFound
+	}
+	return order.info(), nil
+}
+
+// GetOpenOrders gets the resting orders of a market, after filling the crossed ones.
+func (wrapper *ExchangeWrapperSimulator) GetOpenOrders(market *environment.Market) ([]*environment.OrderInfo, error) {
+	return wrapper.GetOpenOrdersContext(context.Background(), market)
+}
+
+// GetOpenOrdersContext gets the resting orders of a market, after filling the crossed ones.
+func (wrapper *ExchangeWrapperSimulator) GetOpenOrdersContext(ctx context.Context, market *environment.Market) ([]*environment.OrderInfo, error) {
+	wrapper.matchOpenOrders(ctx)
+
+	wrapper.mutex.Lock()
+	defer wrapper.mutex.Unlock()
+
+	ret := make([]*environment.OrderInfo, 0, len(wrapper.openOrders))
+	for _, order := range wrapper.openOrders {
+		if order.market.Name == market.Name {
+			ret = append(ret, order.info())
+		}
+	}
+	return ret, nil
+}
+
+// CancelOrder cancels a resting order, releasing its reserved balance.
+func (wrapper *ExchangeWrapperSimulator) CancelOrder(market *environment.Market, orderID string) error {
+	wrapper.mutex.Lock()
+	defer wrapper.mutex.Unlock()
+
+	order, exists := wrapper.orders[orderID]
+	if !exists {
+		return ErrOrderNotFound
+	}
+	if order.cancelled || order.filled.GreaterThanOrEqual(order.quantity) {
+		return fmt.Errorf("Cannot cancel order %s: order is closed", orderID)
 	}
-	return fmt.Sprintf("FAKE_SELL-%s", orderFakeID), nil
+
+	reservedCoin := order.market.MarketCurrency
+	if order.orderType == environment.Bid {
+		reservedCoin = order.market.BaseCurrency
+	}
+	wrapper.reserved[reservedCoin] = wrapper.reserved[reservedCoin].Sub(order.reserved)
+	wrapper.balances[reservedCoin] = wrapper.balances[reservedCoin].Add(order.reserved)
+	order.reserved = decimal.Zero
+	order.cancelled = true
+	wrapper.removeClosedOrders()
+
+	return nil
+}
+
+// CancelOrderContext cancels a resting order, like CancelOrder.
+func (wrapper *ExchangeWrapperSimulator) CancelOrderContext(ctx context.Context, market *environment.Market, orderID string) error {
+	return wrapper.CancelOrder(market, orderID)
 }
 
-// CalculateTradingFees calculates the trading fees for an order on a specified market.
-func (wrapper *ExchangeWrapperSimulator) CalculateTradingFees(market *environment.Market, amount float64, limit float64, orderType TradeType) float64 {
+// CalculateTradingFees calculates the trading fees for an order on a specified market, using the fee schedule if set.
+func (wrapper *ExchangeWrapperSimulator) CalculateTradingFees(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal, orderType TradeType) decimal.Decimal {
+	wrapper.mutex.Lock()
+	rate := wrapper.feeRate(orderType)
+	wrapper.mutex.Unlock()
+
+	if rate != nil {
+		return amount.Mul(limit).Mul(*rate)
+	}
 	return wrapper.innerWrapper.CalculateTradingFees(market, amount, limit, orderType)
 }
 
 // CalculateWithdrawFees calculates the withdrawal fees on a specified market.
-func (wrapper *ExchangeWrapperSimulator) CalculateWithdrawFees(market *environment.Market, amount float64) float64 {
+func (wrapper *ExchangeWrapperSimulator) CalculateWithdrawFees(market *environment.Market, amount decimal.Decimal) decimal.Decimal {
 	return wrapper.innerWrapper.CalculateWithdrawFees(market, amount)
 }
 
-// GetBalance gets the balance of the user of the specified currency.
+// GetBalance gets the free balance of the user of the specified currency, after filling the crossed resting orders.
+//
+// NOTE: The amounts reserved by resting orders are not included.
 func (wrapper *ExchangeWrapperSimulator) GetBalance(symbol string) (*decimal.Decimal, error) {
+	return wrapper.GetBalanceContext(context.Background(), symbol)
+}
+
+// GetBalanceContext gets the free balance of the user of the specified currency, after filling the crossed resting orders.
+func (wrapper *ExchangeWrapperSimulator) GetBalanceContext(ctx context.Context, symbol string) (*decimal.Decimal, error) {
+	wrapper.matchOpenOrders(ctx)
+
+	wrapper.mutex.Lock()
+	defer wrapper.mutex.Unlock()
+
 	bal, exists := wrapper.balances[symbol]
 	if !exists {
 		wrapper.balances[symbol] = decimal.Zero
@@ -167,19 +799,35 @@ func (wrapper *ExchangeWrapperSimulator) FeedConnect(markets []*environment.Mark
 	return wrapper.innerWrapper.FeedConnect(markets)
 }
 
+// FeedConnectContext connects to the feed of the exchange, closing it when the context is done
+// if the exchange supports it.
+func (wrapper *ExchangeWrapperSimulator) FeedConnectContext(ctx context.Context, markets []*environment.Market) error {
+	if connector, ok := wrapper.innerWrapper.(feedContextConnector); ok {
+		return connector.FeedConnectContext(ctx, markets)
+	}
+	return wrapper.innerWrapper.FeedConnect(markets)
+}
+
 // Withdraw performs a FAKE withdraw operation from the exchange to a destination address.
-func (wrapper *ExchangeWrapperSimulator) Withdraw(destinationAddress string, coinTicker string, amount float64) error {
-	if amount <= 0 {
+func (wrapper *ExchangeWrapperSimulator) Withdraw(destinationAddress string, coinTicker string, amount decimal.Decimal) error {
+	if !amount.IsPositive() {
 		return errors.New("Withdraw amount must be > 0")
 	}
 
+	wrapper.mutex.Lock()
+	defer wrapper.mutex.Unlock()
+
 	bal, exists := wrapper.balances[coinTicker]
-	amt := decimal.NewFromFloat(amount)
-	if !exists || amt.GreaterThan(bal) {
+	if !exists || amount.GreaterThan(bal) {
 		return errors.New("Not enough balance")
 	}
 
-	wrapper.balances[coinTicker] = bal.Sub(amt)
+	wrapper.balances[coinTicker] = bal.Sub(amount)
 
 	return nil
 }
+
+// WithdrawContext performs a FAKE withdraw operation, like Withdraw.
+func (wrapper *ExchangeWrapperSimulator) WithdrawContext(ctx context.Context, destinationAddress string, coinTicker string, amount decimal.Decimal) error {
+	return wrapper.Withdraw(destinationAddress, coinTicker, amount)
+}
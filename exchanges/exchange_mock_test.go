@@ -0,0 +1,407 @@
+package exchanges
+
+import (
+	"context"
+	"testing"
+
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/shopspring/decimal"
+)
+
+// fakeExchange is an exchange serving a fixed order book, whose trading fees are a rate of the traded value.
+//
+// The methods not overridden panic, as the embedded wrapper is nil.
+type fakeExchange struct {
+	ExchangeWrapper
+	book    environment.OrderBook
+	feeRate decimal.Decimal
+}
+
+func (exchange *fakeExchange) Name() string {
+	return "fake"
+}
+
+func (exchange *fakeExchange) GetOrderBook(market *environment.Market) (*environment.OrderBook, error) {
+	book := environment.OrderBook{
+		Asks: append([]environment.Order(nil), exchange.book.Asks...),
+		Bids: append([]environment.Order(nil), exchange.book.Bids...),
+	}
+	return &book, nil
+}
+
+func (exchange *fakeExchange) CalculateTradingFees(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal, orderType TradeType) decimal.Decimal {
+	return amount.Mul(limit).Mul(exchange.feeRate)
+}
+
+var testMarket = &environment.Market{Name: "BTC-ETH", BaseCurrency: "BTC", MarketCurrency: "ETH"}
+
+func dec(value string) decimal.Decimal {
+	return decimal.RequireFromString(value)
+}
+
+func levels(pairs ...string) []environment.Order {
+	ret := make([]environment.Order, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		ret = append(ret, environment.Order{Value: dec(pairs[i]), Quantity: dec(pairs[i+1])})
+	}
+	return ret
+}
+
+// testOrder is an order placed on the simulator by the tests, a market order if limit is empty.
+type testOrder struct {
+	side     environment.OrderType
+	quantity string
+	limit    string
+}
+
+func placeTestOrder(t *testing.T, simulator *ExchangeWrapperSimulator, order testOrder) string {
+	t.Helper()
+	var id string
+	var err error
+	switch {
+	case order.limit == "" && order.side == environment.Bid:
+		id, err = simulator.BuyMarket(testMarket, dec(order.quantity))
+	case order.limit == "":
+		id, err = simulator.SellMarket(testMarket, dec(order.quantity))
+	case order.side == environment.Bid:
+		id, err = simulator.BuyLimit(testMarket, dec(order.quantity), dec(order.limit))
+	default:
+		id, err = simulator.SellLimit(testMarket, dec(order.quantity), dec(order.limit))
+	}
+	if err != nil {
+		t.Fatalf("cannot place %v: %s", order, err)
+	}
+	return id
+}
+
+func TestSimulatorMatching(t *testing.T) {
+	book := environment.OrderBook{
+		Asks: levels("10", "1", "11", "2"),
+		Bids: levels("9", "1", "8", "2"),
+	}
+
+	tests := []struct {
+		name    string
+		orders  []testOrder
+		matches int // order book snapshots matched after placing the orders.
+		filled  []string
+		average []string
+		status  []environment.OrderStatus
+	}{
+		{
+			name:    "limit buy below the asks rests",
+			orders:  []testOrder{{environment.Bid, "1", "9.5"}},
+			matches: 1,
+			filled:  []string{"0"},
+			average: []string{"0"},
+			status:  []environment.OrderStatus{environment.OrderNew},
+		},
+		{
+			name:    "limit buy walks the asks up to its limit",
+			orders:  []testOrder{{environment.Bid, "2", "11"}},
+			matches: 1,
+			filled:  []string{"2"},
+			average: []string{"10.5"},
+			status:  []environment.OrderStatus{environment.OrderFilled},
+		},
+		{
+			name:    "limit sell partially filled by the depth",
+			orders:  []testOrder{{environment.Ask, "2", "9"}},
+			matches: 1,
+			filled:  []string{"1"},
+			average: []string{"9"},
+			status:  []environment.OrderStatus{environment.OrderPartiallyFilled},
+		},
+		{
+			name:    "the same snapshot fills only once",
+			orders:  []testOrder{{environment.Ask, "2", "9"}},
+			matches: 3,
+			filled:  []string{"1"},
+			average: []string{"9"},
+			status:  []environment.OrderStatus{environment.OrderPartiallyFilled},
+		},
+		{
+			name:    "better priced orders take the depth first",
+			orders:  []testOrder{{environment.Bid, "1", "10"}, {environment.Bid, "1", "11"}},
+			matches: 1,
+			filled:  []string{"0", "1"},
+			average: []string{"0", "10"},
+			status:  []environment.OrderStatus{environment.OrderNew, environment.OrderFilled},
+		},
+		{
+			name:    "older orders take the depth first at the same price",
+			orders:  []testOrder{{environment.Bid, "1", "10"}, {environment.Bid, "1", "10"}},
+			matches: 1,
+			filled:  []string{"1", "0"},
+			average: []string{"10", "0"},
+			status:  []environment.OrderStatus{environment.OrderFilled, environment.OrderNew},
+		},
+		{
+			name:    "market buy walks the asks",
+			orders:  []testOrder{{environment.Bid, "2", ""}},
+			filled:  []string{"2"},
+			average: []string{"10.5"},
+			status:  []environment.OrderStatus{environment.OrderFilled},
+		},
+		{
+			name:    "market buy cancels what the depth cannot fill",
+			orders:  []testOrder{{environment.Bid, "5", ""}},
+			filled:  []string{"3"},
+			average: []string{"10.6666666666666667"},
+			status:  []environment.OrderStatus{environment.OrderCancelled},
+		},
+		{
+			name:    "market sell takes the depth left by the resting orders",
+			orders:  []testOrder{{environment.Ask, "1", "9"}, {environment.Ask, "1", ""}},
+			filled:  []string{"1", "1"},
+			average: []string{"9", "8"},
+			status:  []environment.OrderStatus{environment.OrderFilled, environment.OrderFilled},
+		},
+		{
+			name:    "market orders consume the depth",
+			orders:  []testOrder{{environment.Bid, "1", ""}, {environment.Bid, "1", ""}},
+			filled:  []string{"1", "1"},
+			average: []string{"10", "11"},
+			status:  []environment.OrderStatus{environment.OrderFilled, environment.OrderFilled},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			simulator := NewExchangeWrapperSimulator(&fakeExchange{book: book}, map[string]decimal.Decimal{
+				"BTC": dec("1000"),
+				"ETH": dec("100"),
+			})
+
+			ids := make([]string, len(test.orders))
+			for i, order := range test.orders {
+				ids[i] = placeTestOrder(t, simulator, order)
+			}
+			for i := 0; i < test.matches; i++ {
+				if _, err := simulator.GetOrderBook(testMarket); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for i, id := range ids {
+				info, err := simulator.GetOrder(testMarket, id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !info.FilledQuantity.Equal(dec(test.filled[i])) {
+					t.Errorf("order %d: filled %s, want %s", i, info.FilledQuantity, test.filled[i])
+				}
+				if !info.AveragePrice.Equal(dec(test.average[i])) {
+					t.Errorf("order %d: average price %s, want %s", i, info.AveragePrice, test.average[i])
+				}
+				if info.Status != test.status[i] {
+					t.Errorf("order %d: status %s, want %s", i, info.Status, test.status[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSimulatorBalances(t *testing.T) {
+	book := environment.OrderBook{
+		Asks: levels("10", "1", "11", "2"),
+		Bids: levels("9", "1", "8", "2"),
+	}
+
+	tests := []struct {
+		name   string
+		orders []testOrder
+		btc    string // free base currency balance after the orders.
+		eth    string // free market currency balance after the orders.
+	}{
+		{"resting buy reserves its cost", []testOrder{{environment.Bid, "1", "5"}}, "95", "10"},
+		{"resting sell reserves its quantity", []testOrder{{environment.Ask, "1", "20"}}, "100", "9"},
+		{"filled buy pays its fills", []testOrder{{environment.Bid, "2", "11"}}, "79", "12"},
+		{"partially filled market buy pays its fills", []testOrder{{environment.Bid, "5", ""}}, "68", "13"},
+		{"market sell gets its fills", []testOrder{{environment.Ask, "2", ""}}, "117", "8"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			simulator := NewExchangeWrapperSimulator(&fakeExchange{book: book}, map[string]decimal.Decimal{
+				"BTC": dec("100"),
+				"ETH": dec("10"),
+			})
+			for _, order := range test.orders {
+				placeTestOrder(t, simulator, order)
+			}
+			if _, err := simulator.GetOrderBook(testMarket); err != nil {
+				t.Fatal(err)
+			}
+
+			for coin, want := range map[string]string{"BTC": test.btc, "ETH": test.eth} {
+				balance, err := simulator.GetBalance(coin)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !balance.Equal(dec(want)) {
+					t.Errorf("%s balance %s, want %s", coin, balance, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSimulatorRefusedOrders(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name  string
+		book  environment.OrderBook
+		place func(simulator *ExchangeWrapperSimulator) (string, error)
+	}{
+		{
+			name: "limit buy above the balance",
+			place: func(simulator *ExchangeWrapperSimulator) (string, error) {
+				return simulator.BuyLimit(testMarket, dec("20"), dec("10"))
+			},
+		},
+		{
+			name: "limit sell above the balance",
+			place: func(simulator *ExchangeWrapperSimulator) (string, error) {
+				return simulator.SellLimit(testMarket, dec("20"), dec("10"))
+			},
+		},
+		{
+			name: "market buy on an empty book",
+			place: func(simulator *ExchangeWrapperSimulator) (string, error) {
+				return simulator.BuyMarket(testMarket, dec("1"))
+			},
+		},
+		{
+			name: "market sell on an empty book",
+			place: func(simulator *ExchangeWrapperSimulator) (string, error) {
+				return simulator.SellMarket(testMarket, dec("1"))
+			},
+		},
+		{
+			name: "market buy above the balance",
+			book: environment.OrderBook{Asks: levels("200", "1")},
+			place: func(simulator *ExchangeWrapperSimulator) (string, error) {
+				return simulator.BuyMarket(testMarket, dec("1"))
+			},
+		},
+		{
+			name: "limit buy with a done context",
+			place: func(simulator *ExchangeWrapperSimulator) (string, error) {
+				return simulator.BuyLimitContext(cancelled, testMarket, dec("1"), dec("1"))
+			},
+		},
+		{
+			name: "limit sell with a done context",
+			place: func(simulator *ExchangeWrapperSimulator) (string, error) {
+				return simulator.SellLimitContext(cancelled, testMarket, dec("1"), dec("1"))
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			simulator := NewExchangeWrapperSimulator(&fakeExchange{book: test.book}, map[string]decimal.Decimal{
+				"BTC": dec("100"),
+				"ETH": dec("10"),
+			})
+			if _, err := test.place(simulator); err == nil {
+				t.Fatal("order placed, want an error")
+			}
+
+			for coin, want := range map[string]string{"BTC": "100", "ETH": "10"} {
+				if balance, _ := simulator.GetBalance(coin); !balance.Equal(dec(want)) {
+					t.Errorf("%s balance %s, want %s", coin, balance, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSimulatorFees(t *testing.T) {
+	book := environment.OrderBook{
+		Asks: levels("10", "10"),
+		Bids: levels("9", "10"),
+	}
+	rate := func(value string) *decimal.Decimal {
+		ret := dec(value)
+		return &ret
+	}
+
+	tests := []struct {
+		name      string
+		schedule  *environment.FeeConfig // nil to use the fees of the exchange.
+		order     testOrder
+		tradeType TradeType
+		fee       string
+		feeCoin   string
+		btc       string
+		eth       string
+	}{
+		{"exchange fees", nil, testOrder{environment.Bid, "1", ""}, TakerTrade, "0.1", "BTC", "89.9", "11"},
+		{"taker rate", &environment.FeeConfig{Taker: rate("0.002")}, testOrder{environment.Bid, "1", ""}, TakerTrade, "0.02", "BTC", "89.98", "11"},
+		{"maker rate", &environment.FeeConfig{Maker: rate("0.001")}, testOrder{environment.Bid, "1", "10"}, MakerTrade, "0.01", "BTC", "89.99", "11"},
+		{"exchange fees without the maker rate", &environment.FeeConfig{Taker: rate("0.002")}, testOrder{environment.Bid, "1", "10"}, MakerTrade, "0.1", "BTC", "89.9", "11"},
+		{"market currency on buys", &environment.FeeConfig{Taker: rate("0.002"), Currency: environment.FeeCurrencyMarket}, testOrder{environment.Bid, "1", ""}, TakerTrade, "0.002", "ETH", "90", "10.998"},
+		{"market currency on sells", &environment.FeeConfig{Taker: rate("0.002"), Currency: environment.FeeCurrencyMarket}, testOrder{environment.Ask, "1", ""}, TakerTrade, "0.002", "ETH", "109", "8.998"},
+		{"received currency on buys", &environment.FeeConfig{Taker: rate("0.002"), Currency: environment.FeeCurrencyReceived}, testOrder{environment.Bid, "1", ""}, TakerTrade, "0.002", "ETH", "90", "10.998"},
+		{"received currency on sells", &environment.FeeConfig{Taker: rate("0.002"), Currency: environment.FeeCurrencyReceived}, testOrder{environment.Ask, "1", ""}, TakerTrade, "0.018", "BTC", "108.982", "9"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			simulator := NewExchangeWrapperSimulator(&fakeExchange{book: book, feeRate: dec("0.01")}, map[string]decimal.Decimal{
+				"BTC": dec("100"),
+				"ETH": dec("10"),
+			})
+			if test.schedule != nil {
+				if err := simulator.SetFeeSchedule(*test.schedule); err != nil {
+					t.Fatal(err)
+				}
+			}
+			placeTestOrder(t, simulator, test.order)
+			if _, err := simulator.GetOrderBook(testMarket); err != nil {
+				t.Fatal(err)
+			}
+
+			fills := simulator.Fills()
+			if len(fills) != 1 {
+				t.Fatalf("%d fills, want 1", len(fills))
+			}
+			if fills[0].TradeType != test.tradeType {
+				t.Errorf("trade type %v, want %v", fills[0].TradeType, test.tradeType)
+			}
+			if !fills[0].Fee.Equal(dec(test.fee)) || fills[0].FeeCurrency != test.feeCoin {
+				t.Errorf("fee %s %s, want %s %s", fills[0].Fee, fills[0].FeeCurrency, test.fee, test.feeCoin)
+			}
+			for coin, want := range map[string]string{"BTC": test.btc, "ETH": test.eth} {
+				if balance, _ := simulator.GetBalance(coin); !balance.Equal(dec(want)) {
+					t.Errorf("%s balance %s, want %s", coin, balance, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSimulatorFeeSchedule(t *testing.T) {
+	tests := []struct {
+		currency string
+		valid    bool
+	}{
+		{"", true},
+		{environment.FeeCurrencyBase, true},
+		{environment.FeeCurrencyMarket, true},
+		{environment.FeeCurrencyReceived, true},
+		{"BTC", false},
+	}
+
+	for _, test := range tests {
+		simulator := NewExchangeWrapperSimulator(&fakeExchange{}, nil)
+		err := simulator.SetFeeSchedule(environment.FeeConfig{Currency: test.currency})
+		if (err == nil) != test.valid {
+			t.Errorf("currency %q: error %v, want valid %t", test.currency, err, test.valid)
+		}
+	}
+}
@@ -0,0 +1,467 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package exchanges
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultFeedHeartbeatTimeout = 30 * time.Second
+	defaultFeedMinBackoff       = time.Second
+	defaultFeedMaxBackoff       = time.Minute
+)
+
+var (
+	// ErrFeedStale is the error representing a feed reconnected because silent for too long.
+	ErrFeedStale = errors.New("Feed silent for too long")
+	// ErrFeedClosed is the error representing a feed closed by the exchange.
+	ErrFeedClosed = errors.New("Feed closed by the exchange")
+)
+
+// FeedState represents the health of a websocket feed.
+type FeedState string
+
+const (
+	// FeedConnected is the state of a feed receiving messages.
+	FeedConnected FeedState = "connected"
+	// FeedDegraded is the state of a connected feed silent for longer than the heartbeat timeout.
+	FeedDegraded FeedState = "degraded"
+	// FeedDown is the state of a feed not connected, either connecting or waiting to reconnect.
+	FeedDown FeedState = "down"
+)
+
+// FeedStatus represents the status of a websocket feed of an exchange.
+type FeedStatus struct {
+	Exchange    string    // Represents the name of the exchange.
+	Feed        string    // Represents the name of the feed (e.g. ticker).
+	Markets     []string  // Represents the names of the markets subscribed by the feed.
+	State       FeedState // Represents the health of the feed.
+	Since       time.Time // Represents when the feed entered its state.
+	LastMessage time.Time // Represents when the last message was received, zero if none.
+	Reconnects  int       // Represents how many times the feed has been reconnected.
+	Err         error     // Represents the error which brought the feed down, if down.
+}
+
+// FeedSession runs a connection to a feed: it subscribes all the markets, reporting it with link.Connected,
+// then reads the feed reporting each message with link.Beat, until the connection fails or the context is done.
+//
+// A session is run again, with all the markets, every time the feed is reconnected.
+type FeedSession func(ctx context.Context, markets []*environment.Market, link *FeedLink) error
+
+// FeedLink reports the health of the connection of a FeedSession to its supervisor.
+type FeedLink struct {
+	supervisor *FeedSupervisor
+	feed       *supervisedFeed
+	opened     time.Time // when the session started.
+	lastBeat   time.Time
+	connected  bool
+	closed     bool // whether the session ended, so that late reports are ignored.
+}
+
+// Connected reports that the session subscribed all its markets.
+func (link *FeedLink) Connected() {
+	supervisor := link.supervisor
+	supervisor.mutex.Lock()
+	if link.closed || link.connected {
+		supervisor.mutex.Unlock()
+		return
+	}
+	link.connected = true
+	if link.feed.first != nil {
+		link.feed.first <- nil
+		link.feed.first = nil
+	}
+	status := supervisor.setState(link.feed, FeedConnected, nil)
+	supervisor.mutex.Unlock()
+
+	supervisor.notify(status)
+}
+
+// Beat reports that the session received a message.
+func (link *FeedLink) Beat() {
+	supervisor := link.supervisor
+	supervisor.mutex.Lock()
+	if link.closed {
+		supervisor.mutex.Unlock()
+		return
+	}
+	link.lastBeat = time.Now()
+	link.feed.status.LastMessage = link.lastBeat
+	if link.feed.status.State != FeedDegraded {
+		supervisor.mutex.Unlock()
+		return
+	}
+	status := supervisor.setState(link.feed, FeedConnected, nil)
+	supervisor.mutex.Unlock()
+
+	supervisor.notify(status)
+}
+
+// supervisedFeed represents a feed started by a FeedSupervisor.
+type supervisedFeed struct {
+	markets []*environment.Market
+	status  FeedStatus
+	first   chan error // receives the outcome of the first connection, nil after.
+}
+
+// FeedSupervisor owns the websocket feeds of an exchange: it tracks the health of their connections
+// and reconnects them, with all their markets, when they fail or stay silent for too long.
+//
+// Feeds are reconnected after a random delay between half and all of an exponential backoff, reset once connected.
+type FeedSupervisor struct {
+	name             string
+	mutex            *sync.Mutex
+	heartbeatTimeout time.Duration
+	minBackoff       time.Duration
+	maxBackoff       time.Duration
+	feeds            []*supervisedFeed
+	watchers         map[int]func(FeedStatus)
+	nextWatcher      int
+}
+
+// NewFeedSupervisor creates a new supervisor of the feeds of an exchange, with the default timeouts.
+func NewFeedSupervisor(name string) *FeedSupervisor {
+	return &FeedSupervisor{
+		name:             name,
+		mutex:            &sync.Mutex{},
+		heartbeatTimeout: defaultFeedHeartbeatTimeout,
+		minBackoff:       defaultFeedMinBackoff,
+		maxBackoff:       defaultFeedMaxBackoff,
+		watchers:         make(map[int]func(FeedStatus)),
+	}
+}
+
+// Configure applies a configuration to the supervisor, keeping the current value of the omitted fields.
+//
+// Feeds already started keep the previous heartbeat timeout until reconnected.
+func (supervisor *FeedSupervisor) Configure(config environment.FeedConfig) {
+	supervisor.mutex.Lock()
+	defer supervisor.mutex.Unlock()
+
+	if config.HeartbeatTimeout > 0 {
+		supervisor.heartbeatTimeout = config.HeartbeatTimeout
+	}
+	if config.MinBackoff > 0 {
+		supervisor.minBackoff = config.MinBackoff
+	}
+	if config.MaxBackoff > 0 {
+		supervisor.maxBackoff = config.MaxBackoff
+	}
+	if supervisor.maxBackoff < supervisor.minBackoff {
+		supervisor.maxBackoff = supervisor.minBackoff
+	}
+}
+
+// Start starts a feed of the markets, running the session until the context is done and reconnecting it when it fails.
+//
+// Returns when the feed is first connected, or with the error of the first session if it fails before:
+// in that case the feed is not reconnected.
+func (supervisor *FeedSupervisor) Start(ctx context.Context, name string, markets []*environment.Market, session FeedSession) error {
+	names := make([]string, len(markets))
+	for i, market := range markets {
+		names[i] = market.Name
+	}
+	first := make(chan error, 1)
+	feed := &supervisedFeed{
+		markets: markets,
+		status: FeedStatus{
+			Exchange: supervisor.name,
+			Feed:     name,
+			Markets:  names,
+			State:    FeedDown,
+			Since:    time.Now(),
+		},
+		first: first,
+	}
+
+	supervisor.mutex.Lock()
+	supervisor.feeds = append(supervisor.feeds, feed)
+	supervisor.mutex.Unlock()
+
+	go supervisor.supervise(ctx, feed, session)
+	return <-first
+}
+
+// supervise runs the sessions of a feed until the context is done or the first one fails before connecting.
+func (supervisor *FeedSupervisor) supervise(ctx context.Context, feed *supervisedFeed, session FeedSession) {
+	defer supervisor.remove(feed)
+
+	failures := 0
+	for sessions := 0; ; sessions++ {
+		link, heartbeatTimeout := supervisor.open(feed, sessions > 0)
+
+		sessionCtx, cancel := context.WithCancelCause(ctx)
+		go supervisor.watchHeartbeat(sessionCtx, link, heartbeatTimeout, cancel)
+		err := session(sessionCtx, feed.markets, link)
+		if cause := context.Cause(sessionCtx); ctx.Err() == nil && errors.Is(cause, ErrFeedStale) {
+			err = ErrFeedStale
+		}
+		cancel(nil)
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err == nil {
+			err = ErrFeedClosed
+		}
+
+		wasConnected, giveUp := supervisor.close(link, err)
+		if giveUp || ctx.Err() != nil {
+			return
+		}
+		logrus.Warnf("Feed %s of %s down, reconnecting: %s", feed.status.Feed, supervisor.name, err)
+
+		if wasConnected {
+			failures = 0
+		}
+		supervisor.mutex.Lock()
+		delay := jitteredBackoff(supervisor.minBackoff, supervisor.maxBackoff, failures)
+		supervisor.mutex.Unlock()
+		failures++
+
+		if sleepContext(ctx, delay) != nil {
+			return
+		}
+	}
+}
+
+// open creates the link of a new session of a feed, returning it with the heartbeat timeout of the session.
+func (supervisor *FeedSupervisor) open(feed *supervisedFeed, reconnect bool) (*FeedLink, time.Duration) {
+	supervisor.mutex.Lock()
+	defer supervisor.mutex.Unlock()
+
+	if reconnect {
+		feed.status.Reconnects++
+	}
+	return &FeedLink{
+		supervisor: supervisor,
+		feed:       feed,
+		opened:     time.Now(),
+	}, supervisor.heartbeatTimeout
+}
+
+// close ends the session of a link with the specified error, bringing its feed down.
+//
+// Returns whether the session had connected, and whether the feed must not be reconnected because its first session failed.
+func (supervisor *FeedSupervisor) close(link *FeedLink, err error) (bool, bool) {
+	supervisor.mutex.Lock()
+	link.closed = true
+	first := link.feed.first
+	link.feed.first = nil
+	status := supervisor.setState(link.feed, FeedDown, err)
+	supervisor.mutex.Unlock()
+
+	if first != nil {
+		first <- err
+	}
+	supervisor.notify(status)
+	return link.connected, first != nil
+}
+
+// remove removes a feed from the supervised ones.
+func (supervisor *FeedSupervisor) remove(feed *supervisedFeed) {
+	supervisor.mutex.Lock()
+	defer supervisor.mutex.Unlock()
+
+	for i, supervised := range supervisor.feeds {
+		if supervised == feed {
+			supervisor.feeds = append(supervisor.feeds[:i], supervisor.feeds[i+1:]...)
+			return
+		}
+	}
+}
+
+// watchHeartbeat degrades the feed of a link when silent for the heartbeat timeout, and cancels its session
+// when silent for twice as long, until the session ends.
+func (supervisor *FeedSupervisor) watchHeartbeat(ctx context.Context, link *FeedLink, timeout time.Duration, cancel context.CancelCauseFunc) {
+	ticker := time.NewTicker(timeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			supervisor.mutex.Lock()
+			if link.closed {
+				supervisor.mutex.Unlock()
+				return
+			}
+			lastHeard := link.opened
+			if link.lastBeat.After(lastHeard) {
+				lastHeard = link.lastBeat
+			}
+			silent := now.Sub(lastHeard)
+
+			if silent > 2*timeout {
+				supervisor.mutex.Unlock()
+				cancel(ErrFeedStale)
+				return
+			}
+			if silent <= timeout || link.feed.status.State != FeedConnected {
+				supervisor.mutex.Unlock()
+				continue
+			}
+			status := supervisor.setState(link.feed, FeedDegraded, nil)
+			supervisor.mutex.Unlock()
+
+			logrus.Warnf("Feed %s of %s degraded: no messages for %s", status.Feed, supervisor.name, silent.Truncate(time.Second))
+			supervisor.notify(status)
+		}
+	}
+}
+
+// setState changes the state of a feed, returning its new status.
+// Must be called with the mutex locked.
+func (supervisor *FeedSupervisor) setState(feed *supervisedFeed, state FeedState, err error) FeedStatus {
+	if state == FeedConnected && feed.status.State == FeedDown {
+		logrus.Infof("Feed %s of %s connected", feed.status.Feed, supervisor.name)
+	}
+	feed.status.State = state
+	feed.status.Since = time.Now()
+	feed.status.Err = err
+	return feed.statusCopy()
+}
+
+// statusCopy returns a copy of the status of the feed, not sharing its markets.
+func (feed *supervisedFeed) statusCopy() FeedStatus {
+	status := feed.status
+	status.Markets = append([]string(nil), feed.status.Markets...)
+	return status
+}
+
+// notify calls the watchers with the new status of a feed.
+func (supervisor *FeedSupervisor) notify(status FeedStatus) {
+	supervisor.mutex.Lock()
+	watchers := make([]func(FeedStatus), 0, len(supervisor.watchers))
+	for _, watcher := range supervisor.watchers {
+		watchers = append(watchers, watcher)
+	}
+	supervisor.mutex.Unlock()
+
+	for _, watcher := range watchers {
+		watcher(status)
+	}
+}
+
+// Watch calls a function each time a feed changes its state, until the returned function is called.
+//
+// The function is called by the goroutines supervising the feeds, so it must not block.
+func (supervisor *FeedSupervisor) Watch(watcher func(FeedStatus)) func() {
+	supervisor.mutex.Lock()
+	defer supervisor.mutex.Unlock()
+
+	id := supervisor.nextWatcher
+	supervisor.nextWatcher++
+	supervisor.watchers[id] = watcher
+
+	return func() {
+		supervisor.mutex.Lock()
+		defer supervisor.mutex.Unlock()
+
+		delete(supervisor.watchers, id)
+	}
+}
+
+// Status returns the status of the started feeds, none for a nil supervisor.
+func (supervisor *FeedSupervisor) Status() []FeedStatus {
+	if supervisor == nil {
+		return nil
+	}
+
+	supervisor.mutex.Lock()
+	defer supervisor.mutex.Unlock()
+
+	ret := make([]FeedStatus, len(supervisor.feeds))
+	for i, feed := range supervisor.feeds {
+		ret[i] = feed.statusCopy()
+	}
+	return ret
+}
+
+// Up tells if a feed with the specified name subscribing the market is connected (degraded included),
+// so that its data can be used instead of calling the exchange.
+func (supervisor *FeedSupervisor) Up(name string, market *environment.Market) bool {
+	if supervisor == nil {
+		return false
+	}
+
+	supervisor.mutex.Lock()
+	defer supervisor.mutex.Unlock()
+
+	for _, feed := range supervisor.feeds {
+		if feed.status.Feed != name || feed.status.State == FeedDown {
+			continue
+		}
+		for _, m := range feed.markets {
+			if m == market || m.Name == market.Name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// feedSupervised is implemented by wrappers supervising their websocket feeds.
+type feedSupervised interface {
+	FeedSupervisor() *FeedSupervisor
+}
+
+// GetFeedSupervisor gets the supervisor of the websocket feeds of the exchange of a wrapper, also when the wrapper
+// decorates the one of the exchange (see IsExchange); nil if the feeds of the exchange are not supervised.
+//
+// Strategies can check the health of the feeds (see FeedSupervisor.Status) or be notified of its changes (see FeedSupervisor.Watch).
+func GetFeedSupervisor(wrapper ExchangeWrapper) *FeedSupervisor {
+	for {
+		if supervised, isSupervised := wrapper.(feedSupervised); isSupervised {
+			return supervised.FeedSupervisor()
+		}
+
+		decorator, isDecorator := wrapper.(interface{ Unwrap() ExchangeWrapper })
+		if !isDecorator {
+			return nil
+		}
+		wrapper = decorator.Unwrap()
+	}
+}
+
+// orderbookStreamed is implemented by wrappers keeping the order books received from their feeds.
+type orderbookStreamed interface {
+	StreamedOrderBook(market *environment.Market) (*environment.OrderBook, bool)
+}
+
+// GetStreamedOrderBook gets the order book of a market kept from the feeds of the exchange of a wrapper, also when
+// the wrapper decorates the one of the exchange; false if the feed of the market is down or the exchange does not stream
+// order books, in which case the order book must be requested with GetOrderBook.
+func GetStreamedOrderBook(wrapper ExchangeWrapper, market *environment.Market) (*environment.OrderBook, bool) {
+	for {
+		if streamed, isStreamed := wrapper.(orderbookStreamed); isStreamed {
+			return streamed.StreamedOrderBook(market)
+		}
+
+		decorator, isDecorator := wrapper.(interface{ Unwrap() ExchangeWrapper })
+		if !isDecorator {
+			return nil, false
+		}
+		wrapper = decorator.Unwrap()
+	}
+}
@@ -17,6 +17,8 @@ package exchanges
 
 import (
 	"errors"
+	"fmt"
+
 	"github.com/saniales/golang-crypto-trading-bot/environment"
 	"github.com/shopspring/decimal"
 )
@@ -34,24 +36,29 @@ const (
 // ExchangeWrapper provides a generic wrapper for exchange services.
 type ExchangeWrapper interface {
 	Name() string                                                                    // Gets the name of the exchange.
+	GetMarkets() ([]*environment.Market, error)                                      // Gets all the markets of the exchange, binded to their names on the exchange.
 	GetCandles(market *environment.Market) ([]environment.CandleStick, error)        // Gets the candle data from the exchange.
 	GetMarketSummary(market *environment.Market) (*environment.MarketSummary, error) // Gets the current market summary.
 	GetOrderBook(market *environment.Market) (*environment.OrderBook, error)         // Gets the order(ASK + BID) book of a market.
 
-	BuyLimit(market *environment.Market, amount float64, limit float64) (string, error)  // Performs a limit buy action.
-	SellLimit(market *environment.Market, amount float64, limit float64) (string, error) // Performs a limit sell action.
-	BuyMarket(market *environment.Market, amount float64) (string, error)                // Performs a market buy action.
-	SellMarket(market *environment.Market, amount float64) (string, error)               // Performs a market sell action.
+	BuyLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error)  // Performs a limit buy action.
+	SellLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) // Performs a limit sell action.
+	BuyMarket(market *environment.Market, amount decimal.Decimal) (string, error)                        // Performs a market buy action.
+	SellMarket(market *environment.Market, amount decimal.Decimal) (string, error)                       // Performs a market sell action.
+
+	GetOrder(market *environment.Market, orderID string) (*environment.OrderInfo, error) // Gets the status of an order placed on the exchange.
+	GetOpenOrders(market *environment.Market) ([]*environment.OrderInfo, error)          // Gets the open orders of the user on a market.
+	CancelOrder(market *environment.Market, orderID string) error                        // Cancels an open order.
 
-	CalculateTradingFees(market *environment.Market, amount float64, limit float64, orderType TradeType) float64 // Calculates the trading fees for an order on a specified market.
-	CalculateWithdrawFees(market *environment.Market, amount float64) float64                                    // Calculates the withdrawal fees on a specified market.
+	CalculateTradingFees(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal, orderType TradeType) decimal.Decimal // Calculates the trading fees for an order on a specified market.
+	CalculateWithdrawFees(market *environment.Market, amount decimal.Decimal) decimal.Decimal                                            // Calculates the withdrawal fees on a specified market.
 
 	GetBalance(symbol string) (*decimal.Decimal, error) // Gets the balance of the user of the specified currency.
 	GetDepositAddress(coinTicker string) (string, bool) // Gets the deposit address for the specified coin on the exchange, if exists.
 
 	FeedConnect(markets []*environment.Market) error // Connects to the feed of the exchange.
 
-	Withdraw(destinationAddress string, coinTicker string, amount float64) error // Performs a withdraw operation from the exchange to a destination address.
+	Withdraw(destinationAddress string, coinTicker string, amount decimal.Decimal) error // Performs a withdraw operation from the exchange to a destination address.
 
 	String() string // Returns a string representation of the object.
 }
@@ -59,12 +66,151 @@ type ExchangeWrapper interface {
 // ErrWebsocketNotSupported is the error representing when an exchange does not support websocket.
 var ErrWebsocketNotSupported = errors.New("Cannot use websocket: exchange does not support it")
 
+// ErrOrderNotFound is the error representing when an order cannot be found on the exchange.
+var ErrOrderNotFound = errors.New("Order not found")
+
+// orderStatusFor gets the status of an order from its quantities and whether it is still open.
+func orderStatusFor(quantity decimal.Decimal, filledQuantity decimal.Decimal, open bool) environment.OrderStatus {
+	if open {
+		if filledQuantity.IsPositive() {
+			return environment.OrderPartiallyFilled
+		}
+		return environment.OrderNew
+	}
+	if filledQuantity.GreaterThanOrEqual(quantity) {
+		return environment.OrderFilled
+	}
+	return environment.OrderCancelled
+}
+
+// newExchangeMarket creates a market in the notation of the bot (e.g. BTC-ETH, where BTC is the base currency)
+// binded to its name and its trading rules (nil if unknown) on the exchange of the wrapper.
+func newExchangeMarket(wrapper ExchangeWrapper, exchangeMarketName string, baseCurrency string, marketCurrency string, rules *environment.MarketRules) *environment.Market {
+	market := &environment.Market{
+		Name:           baseCurrency + "-" + marketCurrency,
+		BaseCurrency:   baseCurrency,
+		MarketCurrency: marketCurrency,
+		ExchangeNames:  map[string]string{wrapper.Name(): exchangeMarketName},
+	}
+	if rules != nil {
+		market.ExchangeRules = map[string]*environment.MarketRules{wrapper.Name(): rules}
+	}
+	return market
+}
+
 // MarketNameFor gets the market name as seen by the exchange.
 func MarketNameFor(m *environment.Market, wrapper ExchangeWrapper) string {
 	return m.ExchangeNames[wrapper.Name()]
 }
 
-// MarketTimeFrameFor gets the market timeframe
-func MarketTimeFrameFor(m *environment.Market, wrapper ExchangeWrapper) string {
+// IsExchange tells if a wrapper is the one of the specified exchange, also when the wrapper decorates
+// the one of the exchange (e.g. the simulator, whose name has the "mock" suffix).
+func IsExchange(wrapper ExchangeWrapper, name string) bool {
+	for {
+		if wrapper.Name() == name {
+			return true
+		}
+
+		decorator, isDecorator := wrapper.(interface{ Unwrap() ExchangeWrapper })
+		if !isDecorator {
+			return false
+		}
+		wrapper = decorator.Unwrap()
+	}
+}
+
+// IsBinded tells if a market is binded to the exchange of a wrapper (see IsExchange).
+func IsBinded(m *environment.Market, wrapper ExchangeWrapper) bool {
+	for name := range m.ExchangeNames {
+		if IsExchange(wrapper, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// MarketTimeFrameFor gets the timeframe of the candles of the market on the exchange (zero if not configured).
+func MarketTimeFrameFor(m *environment.Market, wrapper ExchangeWrapper) environment.TimeFrame {
 	return m.ExchangeTimeFrames[wrapper.Name()]
 }
+
+// MarketRulesFor gets the trading rules of the market on the exchange, as set in the market (nil if not set).
+func MarketRulesFor(m *environment.Market, wrapper ExchangeWrapper) *environment.MarketRules {
+	return m.ExchangeRules[wrapper.Name()]
+}
+
+// marketRulesProvider is implemented by wrappers getting the trading rules of the markets from their exchange.
+type marketRulesProvider interface {
+	MarketRules(market *environment.Market) (*environment.MarketRules, error)
+}
+
+// GetMarketRules gets the trading rules of a market on the exchange of a wrapper, also when the wrapper decorates
+// the one of the exchange (see IsExchange); nil if unknown.
+func GetMarketRules(wrapper ExchangeWrapper, market *environment.Market) (*environment.MarketRules, error) {
+	for {
+		if provider, isProvider := wrapper.(marketRulesProvider); isProvider {
+			return provider.MarketRules(market)
+		}
+
+		decorator, isDecorator := wrapper.(interface{ Unwrap() ExchangeWrapper })
+		if !isDecorator {
+			return MarketRulesFor(market, wrapper), nil
+		}
+		wrapper = decorator.Unwrap()
+	}
+}
+
+// roundOrder rounds the quantity and the price (zero for market orders) of an order to the trading rules
+// of its market on the exchange of the wrapper, refusing the orders below the minimums of the market.
+func roundOrder(wrapper ExchangeWrapper, market *environment.Market, orderType environment.OrderType, amount decimal.Decimal, limit decimal.Decimal) (decimal.Decimal, decimal.Decimal, error) {
+	rules, err := GetMarketRules(wrapper, market)
+	if err != nil {
+		return decimal.Zero, decimal.Zero, fmt.Errorf("Cannot get the trading rules of %s: %s", market.Name, err)
+	}
+	return rules.Apply(orderType, amount, limit)
+}
+
+// candleTimeFrames resolves the timeframe of the candles of a market (defaultTimeFrame if not configured)
+// and the timeframe to request to an exchange which natively serves the specified ones.
+//
+// When the timeframe is not supported natively, the largest supported timeframe which divides it is chosen
+// and the candles must be aggregated with AggregateCandles.
+func candleTimeFrames(m *environment.Market, wrapper ExchangeWrapper, supported map[environment.TimeFrame]string, defaultTimeFrame environment.TimeFrame) (timeFrame environment.TimeFrame, native environment.TimeFrame, err error) {
+	timeFrame = MarketTimeFrameFor(m, wrapper)
+	if timeFrame.IsZero() {
+		timeFrame = defaultTimeFrame
+	}
+
+	if _, supported := supported[timeFrame]; supported {
+		return timeFrame, timeFrame, nil
+	}
+	for candidate := range supported {
+		if candidate.Divides(timeFrame) && candidate > native {
+			native = candidate
+		}
+	}
+	if native.IsZero() {
+		return 0, 0, fmt.Errorf("Timeframe %s not supported on %s", timeFrame, wrapper.Name())
+	}
+	return timeFrame, native, nil
+}
+
+// AggregateCandles aggregates candles, sorted by open time, into candles of the specified timeframe
+// (e.g. for exchanges which do not serve the timeframe natively).
+//
+// The candles are returned as they are when the timeframe is zero or is the one of the candles;
+// otherwise the first aggregated candle is dropped when the candles do not cover its whole period.
+func AggregateCandles(candles []environment.CandleStick, timeFrame environment.TimeFrame) ([]environment.CandleStick, error) {
+	if len(candles) == 0 || timeFrame.IsZero() || candles[0].Period == timeFrame.Duration() {
+		return candles, nil
+	}
+
+	ret, err := environment.ResampleCandles(candles, timeFrame.Duration())
+	if err != nil {
+		return nil, err
+	}
+	if !candles[0].OpenTime.Equal(ret[0].OpenTime) {
+		ret = ret[1:]
+	}
+	return ret, nil
+}
@@ -17,36 +17,53 @@
 package exchanges
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"sort"
+	"time"
 
-	"github.com/gofrs/uuid"
-
+	"github.com/gorilla/websocket"
 	"github.com/juju/errors"
 	"github.com/saniales/go-hitbtc"
 	"github.com/saniales/golang-crypto-trading-bot/environment"
 	"github.com/shopspring/decimal"
 )
 
+const (
+	hitbtcWebsocketURL = "wss://api.hitbtc.com/api/2/ws"
+	hitbtcFeed         = "ticker+book"
+)
+
 // HitBtcWrapperV2 wraps HitBtc API v2.0
 type HitBtcWrapperV2 struct {
 	api              *hitbtc.HitBtc
-	ws               *hitbtc.WSClient
-	websocketOn      bool
+	publicKey        string
+	secretKey        string
 	summaries        *SummaryCache
 	orderbook        *OrderbookCache
+	rules            *RulesCache
+	limiter          *RateLimiter
+	feeds            *FeedSupervisor
+	httpClient       *http.Client // sends the requests not supported by go-hitbtc, updating the budget from the responses.
 	depositAddresses map[string]string
 }
 
 // NewHitBtcV2Wrapper creates a generic wrapper of the HitBtc API v2.0.
 func NewHitBtcV2Wrapper(publicKey string, secretKey string, depositAddresses map[string]string) ExchangeWrapper {
-	ws, _ := hitbtc.NewWSClient()
+	limiter := NewRateLimiter("hitbtc", 100, time.Second, nil)
 	return &HitBtcWrapperV2{
-		api:              hitbtc.New(publicKey, secretKey),
-		ws:               ws,
-		websocketOn:      false,
+		api:              hitbtc.NewWithCustomHttpClient(publicKey, secretKey, limiter.HTTPClient(&http.Client{})),
+		publicKey:        publicKey,
+		secretKey:        secretKey,
 		summaries:        NewSummaryCache(),
 		orderbook:        NewOrderbookCache(),
+		rules:            NewRulesCache(),
+		limiter:          limiter,
+		feeds:            NewFeedSupervisor("hitbtc"),
+		httpClient:       limiter.HTTPClient(nil),
 		depositAddresses: depositAddresses,
 	}
 }
@@ -60,8 +77,21 @@ func (wrapper *HitBtcWrapperV2) String() string {
 	return wrapper.Name()
 }
 
+// RateLimiter returns the budget of the calls to hitbtc.
+func (wrapper *HitBtcWrapperV2) RateLimiter() *RateLimiter {
+	return wrapper.limiter
+}
+
+// FeedSupervisor returns the supervisor of the hitbtc feed.
+func (wrapper *HitBtcWrapperV2) FeedSupervisor() *FeedSupervisor {
+	return wrapper.feeds
+}
+
 // GetMarkets gets all the markets info.
 func (wrapper *HitBtcWrapperV2) GetMarkets() ([]*environment.Market, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetMarkets"); err != nil {
+		return nil, err
+	}
 	HitBtcMarkets, err := wrapper.api.GetSymbols()
 
 	if err != nil {
@@ -70,20 +100,32 @@ func (wrapper *HitBtcWrapperV2) GetMarkets() ([]*environment.Market, error) {
 
 	wrappedMarkets := make([]*environment.Market, 0, len(HitBtcMarkets))
 	for _, market := range HitBtcMarkets {
-		wrappedMarkets = append(wrappedMarkets, &environment.Market{
-			Name:           market.Id,
-			BaseCurrency:   market.BaseCurrency,
-			MarketCurrency: market.QuoteCurrency,
-		})
+		// the minimum quantity of an order is a quantity increment.
+		rules := environment.NewMarketRulesFromSteps(decimal.NewFromFloat(market.TickSize), decimal.NewFromFloat(market.QuantityIncrement))
+		rules.MinQuantity = rules.StepSize
+		// hitbtc quotes the base currency in the quote currency, which is the base currency of the bot.
+		wrappedMarkets = append(wrappedMarkets, newExchangeMarket(wrapper, market.Id, market.QuoteCurrency, market.BaseCurrency, rules))
 	}
 
+	wrapper.rules.Set(wrapper, wrappedMarkets)
 	return wrappedMarkets, nil
 }
 
+// MarketRules gets the trading rules of a market, getting the ones of all markets from the exchange the first time.
+func (wrapper *HitBtcWrapperV2) MarketRules(market *environment.Market) (*environment.MarketRules, error) {
+	return wrapper.rules.Get(wrapper, market, func() error {
+		_, err := wrapper.GetMarkets()
+		return err
+	})
+}
+
 // GetOrderBook gets the order(ASK + BID) book of a market.
 func (wrapper *HitBtcWrapperV2) GetOrderBook(market *environment.Market) (*environment.OrderBook, error) {
 	ret, exists := wrapper.orderbook.Get(market)
-	if !wrapper.websocketOn {
+	if !wrapper.feeds.Up(hitbtcFeed, market) {
+		if err := wrapper.limiter.Wait(context.Background(), "GetOrderBook"); err != nil {
+			return nil, err
+		}
 		hitbtcOrderBook, err := wrapper.api.GetOrderbook(MarketNameFor(market, wrapper))
 
 		if err != nil {
@@ -119,35 +161,61 @@ func (wrapper *HitBtcWrapperV2) GetOrderBook(market *environment.Market) (*envir
 	return ret, nil
 }
 
+// StreamedOrderBook returns the order book of a market kept from the order book feed, false if the feed of the market is down.
+func (wrapper *HitBtcWrapperV2) StreamedOrderBook(market *environment.Market) (*environment.OrderBook, bool) {
+	if !wrapper.feeds.Up(hitbtcFeed, market) {
+		return nil, false
+	}
+	return wrapper.orderbook.Get(market)
+}
+
 // BuyLimit performs a limit buy action.
-func (wrapper *HitBtcWrapperV2) BuyLimit(market *environment.Market, amount float64, limit float64) (string, error) {
+func (wrapper *HitBtcWrapperV2) BuyLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return wrapper.PlaceOrder(context.Background(), OrderRequest{Market: market, Type: environment.Bid, Quantity: amount, Limit: limit})
+}
 
-	requestOrder := hitbtc.Order{
-		Symbol:   MarketNameFor(market, wrapper),
-		Side:     "buy",
-		Status:   "new",
-		Type:     "limit",
-		Quantity: amount,
-		Price:    limit,
-	}
+// BuyMarket performs a market buy action.
+func (wrapper *HitBtcWrapperV2) BuyMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
+	return wrapper.PlaceOrder(context.Background(), OrderRequest{Market: market, Type: environment.Bid, Quantity: amount})
+}
 
-	orderNumber, err := wrapper.api.PlaceOrder(requestOrder)
+// SellLimit performs a limit sell action.
+func (wrapper *HitBtcWrapperV2) SellLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return wrapper.PlaceOrder(context.Background(), OrderRequest{Market: market, Type: environment.Ask, Quantity: amount, Limit: limit})
+}
+
+// SellMarket performs a market sell action.
+func (wrapper *HitBtcWrapperV2) SellMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
+	return wrapper.PlaceOrder(context.Background(), OrderRequest{Market: market, Type: environment.Ask, Quantity: amount})
+}
+
+// PlaceOrder places an order, whose ID on hitbtc is its client order ID (generated by hitbtc if empty, at most 32 characters).
+func (wrapper *HitBtcWrapperV2) PlaceOrder(ctx context.Context, order OrderRequest) (string, error) {
+	quantity, price, err := roundOrder(wrapper, order.Market, order.Type, order.Quantity, order.Limit)
 	if err != nil {
 		return "", err
 	}
-	return fmt.Sprint(orderNumber.ClientOrderId), nil
-}
 
-// BuyMarket performs a market buy action.
-func (wrapper *HitBtcWrapperV2) BuyMarket(market *environment.Market, amount float64) (string, error) {
+	// the client takes float64 values and sends them with 8 decimals (withdrawals in their shortest form), so
+	// quantities are truncated and prices rounded to 8 decimals first, to send them unchanged.
 	requestOrder := hitbtc.Order{
-		Symbol:   MarketNameFor(market, wrapper),
-		Side:     "buy",
-		Status:   "new",
-		Type:     "market",
-		Quantity: amount,
+		Symbol:        MarketNameFor(order.Market, wrapper),
+		Side:          "buy",
+		Type:          "limit",
+		Quantity:      quantity.Truncate(8).InexactFloat64(),
+		Price:         price.Round(8).InexactFloat64(),
+		ClientOrderId: order.ClientOrderID,
+	}
+	if order.Type == environment.Ask {
+		requestOrder.Side = "sell"
+	}
+	if price.IsZero() {
+		requestOrder.Type = "market"
 	}
 
+	if err := wrapper.limiter.Wait(ctx, orderEndpoint(order)); err != nil {
+		return "", err
+	}
 	orderNumber, err := wrapper.api.PlaceOrder(requestOrder)
 	if err != nil {
 		return "", err
@@ -155,46 +223,117 @@ func (wrapper *HitBtcWrapperV2) BuyMarket(market *environment.Market, amount flo
 	return fmt.Sprint(orderNumber.ClientOrderId), nil
 }
 
-// SellLimit performs a limit sell action.
-func (wrapper *HitBtcWrapperV2) SellLimit(market *environment.Market, amount float64, limit float64) (string, error) {
-	requestOrder := hitbtc.Order{
-		Symbol:   MarketNameFor(market, wrapper),
-		Side:     "sell",
-		Type:     "limit",
-		Quantity: amount,
-		Price:    limit,
+// GetOrder gets the status of an order placed on the exchange.
+func (wrapper *HitBtcWrapperV2) GetOrder(market *environment.Market, orderID string) (*environment.OrderInfo, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetOrder"); err != nil {
+		return nil, err
+	}
+	openOrders, err := wrapper.api.GetOpenOrders()
+	if err != nil {
+		return nil, err
+	}
+	for _, hitbtcOrder := range openOrders {
+		if hitbtcOrder.ClientOrderId == orderID {
+			return convertFromHitBtcOrder(market, hitbtcOrder), nil
+		}
 	}
 
-	orderNumber, err := wrapper.api.PlaceOrder(requestOrder)
+	if err := wrapper.limiter.Wait(context.Background(), "GetOrder"); err != nil {
+		return nil, err
+	}
+	hitbtcOrders, err := wrapper.api.GetOrder(orderID)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return fmt.Sprint(orderNumber.ClientOrderId), nil
+	if len(hitbtcOrders) == 0 {
+		return nil, ErrOrderNotFound
+	}
+
+	return convertFromHitBtcOrder(market, hitbtcOrders[0]), nil
 }
 
-// SellMarket performs a market sell action.
-func (wrapper *HitBtcWrapperV2) SellMarket(market *environment.Market, amount float64) (string, error) {
-	clientOrderID, err := uuid.NewV4()
+// GetOpenOrders gets the open orders of the user on a market.
+func (wrapper *HitBtcWrapperV2) GetOpenOrders(market *environment.Market) ([]*environment.OrderInfo, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetOpenOrders"); err != nil {
+		return nil, err
+	}
+	hitbtcOrders, err := wrapper.api.GetOpenOrders()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	requestOrder := hitbtc.Order{
-		Symbol:        MarketNameFor(market, wrapper),
-		Side:          "sell",
-		Type:          "market",
-		Quantity:      amount,
-		ClientOrderId: clientOrderID.String()[:32], // max length is 32 characters
+
+	ret := make([]*environment.OrderInfo, 0, len(hitbtcOrders))
+	for _, hitbtcOrder := range hitbtcOrders {
+		if hitbtcOrder.Symbol == MarketNameFor(market, wrapper) {
+			ret = append(ret, convertFromHitBtcOrder(market, hitbtcOrder))
+		}
 	}
 
-	orderNumber, err := wrapper.api.PlaceOrder(requestOrder)
+	return ret, nil
+}
+
+// CancelOrder cancels an open order.
+//
+// NOTE: go-hitbtc can only cancel all the orders of a symbol, so the request is performed here.
+func (wrapper *HitBtcWrapperV2) CancelOrder(market *environment.Market, orderID string) error {
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/order/%s", hitbtc.API_BASE, orderID), nil)
 	if err != nil {
-		return "", err
+		return err
 	}
-	return fmt.Sprint(orderNumber.ClientOrderId), nil
+	req.SetBasicAuth(wrapper.publicKey, wrapper.secretKey)
+
+	if err := wrapper.limiter.Wait(context.Background(), "CancelOrder"); err != nil {
+		return err
+	}
+	resp, err := wrapper.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrOrderNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("Cannot cancel order %s: %s", orderID, body)
+	}
+
+	return nil
+}
+
+// convertFromHitBtcOrder converts a hitbtc order to a environment.OrderInfo.
+func convertFromHitBtcOrder(market *environment.Market, hitbtcOrder hitbtc.Order) *environment.OrderInfo {
+	quantity := decimal.NewFromFloat(hitbtcOrder.Quantity)
+	filledQuantity := decimal.NewFromFloat(hitbtcOrder.CumQuantity)
+	isOpen := hitbtcOrder.Status == "new" || hitbtcOrder.Status == "suspended" || hitbtcOrder.Status == "partiallyFilled"
+
+	order := &environment.OrderInfo{
+		ID:             hitbtcOrder.ClientOrderId,
+		Market:         market,
+		Type:           environment.Bid,
+		Status:         orderStatusFor(quantity, filledQuantity, isOpen),
+		Quantity:       quantity,
+		Limit:          decimal.NewFromFloat(hitbtcOrder.Price),
+		FilledQuantity: filledQuantity,
+		Timestamp:      hitbtcOrder.Created,
+	}
+	if hitbtcOrder.Side == "sell" {
+		order.Type = environment.Ask
+	}
+	if filledQuantity.IsPositive() {
+		// NOTE: average price is not reported by the API.
+		order.AveragePrice = order.Limit
+	}
+
+	return order
 }
 
 // GetTicker gets the updated ticker for a market.
 func (wrapper *HitBtcWrapperV2) GetTicker(market *environment.Market) (*environment.Ticker, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetTicker"); err != nil {
+		return nil, err
+	}
 	hitbtcTicker, err := wrapper.api.GetTicker(MarketNameFor(market, wrapper))
 	if err != nil {
 		return nil, err
@@ -213,7 +352,10 @@ func (wrapper *HitBtcWrapperV2) GetTicker(market *environment.Market) (*environm
 // GetMarketSummary gets the current market summary.
 func (wrapper *HitBtcWrapperV2) GetMarketSummary(market *environment.Market) (*environment.MarketSummary, error) {
 	ret, exists := wrapper.summaries.Get(market)
-	if !wrapper.websocketOn {
+	if !wrapper.feeds.Up(hitbtcFeed, market) {
+		if err := wrapper.limiter.Wait(context.Background(), "GetMarketSummary"); err != nil {
+			return nil, err
+		}
 		hitbtcSummary, err := wrapper.api.GetTicker(MarketNameFor(market, wrapper))
 		if err != nil {
 			return nil, err
@@ -248,6 +390,9 @@ func (wrapper *HitBtcWrapperV2) GetMarketSummary(market *environment.Market) (*e
 
 // GetBalance gets the balance of the user of the specified currency.
 func (wrapper *HitBtcWrapperV2) GetBalance(symbol string) (*decimal.Decimal, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetBalance"); err != nil {
+		return nil, err
+	}
 	Hitbtcbalance, err := wrapper.api.GetBalances()
 
 	if err != nil {
@@ -275,21 +420,21 @@ func (wrapper *HitBtcWrapperV2) GetDepositAddress(coinTicker string) (string, bo
 }
 
 // CalculateTradingFees calculates the trading fees for an order on a specified market.
-func (wrapper *HitBtcWrapperV2) CalculateTradingFees(market *environment.Market, amount float64, limit float64, orderType TradeType) float64 {
-	var feePercentage float64
+func (wrapper *HitBtcWrapperV2) CalculateTradingFees(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal, orderType TradeType) decimal.Decimal {
+	var feePercentage decimal.Decimal
 	if orderType == MakerTrade {
-		feePercentage = 0.0025
+		feePercentage = decimal.NewFromFloat(0.0025)
 	} else if orderType == TakerTrade {
-		feePercentage = 0.0025
+		feePercentage = decimal.NewFromFloat(0.0025)
 	} else {
 		panic("Unknown trade type")
 	}
 
-	return amount * limit * feePercentage
+	return amount.Mul(limit).Mul(feePercentage)
 }
 
 // CalculateWithdrawFees calculates the withdrawal fees on a specified market.
-func (wrapper *HitBtcWrapperV2) CalculateWithdrawFees(market *environment.Market, amount float64) float64 {
+func (wrapper *HitBtcWrapperV2) CalculateWithdrawFees(market *environment.Market, amount decimal.Decimal) decimal.Decimal {
 	panic("Not Implemented")
 }
 
@@ -300,24 +445,89 @@ func (wrapper *HitBtcWrapperV2) GetCandles(market *environment.Market) ([]enviro
 
 // FeedConnect connects to the feed of the exchange.
 func (wrapper *HitBtcWrapperV2) FeedConnect(markets []*environment.Market) error {
-	wrapper.websocketOn = true
+	return wrapper.FeedConnectContext(context.Background(), markets)
+}
+
+// FeedConnectContext connects to the feed of the exchange, closing it when the context is done.
+//
+// NOTE: summaries and order books are received from the websocket.
+// The feed is supervised (see FeedSupervisor): summaries and order books are fetched via REST while it is down.
+func (wrapper *HitBtcWrapperV2) FeedConnectContext(ctx context.Context, markets []*environment.Market) error {
+	feedMarkets := make(map[string]*environment.Market, len(markets))
 	for _, m := range markets {
-		err := wrapper.subscribeFeeds(m)
+		feedMarkets[MarketNameFor(m, wrapper)] = m
+	}
+
+	return wrapper.feeds.Start(ctx, hitbtcFeed, markets, func(ctx context.Context, _ []*environment.Market, link *FeedLink) error {
+		return wrapper.runFeed(ctx, feedMarkets, link)
+	})
+}
+
+// runFeed runs a connection to the feed of the markets, by symbol.
+//
+// NOTE: go-hitbtc WSClient cannot be closed safely while receiving, so the feed is read from its own connection.
+func (wrapper *HitBtcWrapperV2) runFeed(ctx context.Context, markets map[string]*environment.Market, link *FeedLink) error {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, hitbtcWebsocketURL, nil)
+	if err != nil {
+		return err
+	}
+
+	requestID := 0
+	for symbol := range markets {
+		for _, method := range []string{"subscribeTicker", "subscribeOrderbook"} {
+			requestID++
+			err = conn.WriteJSON(hitbtcRequest{
+				Method: method,
+				Params: hitbtc.WSSubscriptionRequest{Symbol: symbol},
+				ID:     requestID,
+			})
+			if err != nil {
+				conn.Close()
+				return err
+			}
+		}
+	}
+	link.Connected()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	return wrapper.readFeed(conn, markets, link)
+}
+
+// readFeed updates summaries and order books with the notifications of the feed, until the connection is closed.
+func (wrapper *HitBtcWrapperV2) readFeed(conn *websocket.Conn, markets map[string]*environment.Market, link *FeedLink) error {
+	defer conn.Close()
+
+	books := make(map[string]*environment.OrderBook, len(markets))
+	sequences := make(map[string]int64, len(markets))
+	for {
+		var message hitbtcMessage
+		err := conn.ReadJSON(&message)
 		if err != nil {
 			return err
 		}
-	}
+		link.Beat()
 
-	return nil
-}
+		if message.Error != nil {
+			return fmt.Errorf("hitbtc feed: %s", message.Error.Message)
+		}
 
-// subscribeFeeds subscribes to the Market Summary Feed service.
-func (wrapper *HitBtcWrapperV2) subscribeFeeds(market *environment.Market) error {
-	handleTicker := func(wrapper *HitBtcWrapperV2, summaryChannel <-chan hitbtc.WSNotificationTickerResponse, m *environment.Market) {
-		for {
-			summary, stillOpen := <-summaryChannel
-			if !stillOpen {
-				return
+		switch message.Method {
+		case "ticker":
+			var summary hitbtc.WSNotificationTickerResponse
+			if json.Unmarshal(message.Params, &summary) != nil {
+				continue
+			}
+			market, exists := markets[summary.Symbol]
+			if !exists {
+				continue
 			}
 
 			high, _ := decimal.NewFromString(summary.High)
@@ -327,88 +537,87 @@ func (wrapper *HitBtcWrapperV2) subscribeFeeds(market *environment.Market) error
 			last, _ := decimal.NewFromString(summary.Last)
 			volume, _ := decimal.NewFromString(summary.Volume)
 
-			sum := &environment.MarketSummary{
+			wrapper.summaries.Set(market, &environment.MarketSummary{
 				High:   high,
 				Low:    low,
 				Last:   last,
 				Volume: volume,
 				Ask:    ask,
 				Bid:    bid,
+			})
+		case "snapshotOrderbook":
+			var snap hitbtc.WSNotificationOrderbookSnapshot
+			if json.Unmarshal(message.Params, &snap) != nil {
+				continue
+			}
+			market, exists := markets[snap.Symbol]
+			if !exists {
+				continue
 			}
 
-			wrapper.summaries.Set(m, sum)
-		}
-	}
+			orderbook := new(environment.OrderBook)
+			for _, item := range snap.Ask {
+				price, _ := decimal.NewFromString(item.Price)
+				size, _ := decimal.NewFromString(item.Size)
 
-	handleOrderbook := func(wrapper *HitBtcWrapperV2, bookSnapshotChannel <-chan hitbtc.WSNotificationOrderbookSnapshot, bookUpdateChannel <-chan hitbtc.WSNotificationOrderbookUpdate, m *environment.Market) {
-		var currentSequence int64
-
-		for {
-			select {
-			case snap, stillOpen := <-bookSnapshotChannel:
-				if !stillOpen {
-					return
-				}
-				if currentSequence > snap.Sequence { // my snapshot is more recent than the one provided
-					continue
-				}
-
-				orderbook := new(environment.OrderBook)
-
-				for _, item := range snap.Ask {
-					price, _ := decimal.NewFromString(item.Price)
-					size, _ := decimal.NewFromString(item.Size)
-
-					orderbook.Asks = append(orderbook.Asks, environment.Order{
-						Value:    price,
-						Quantity: size,
-					})
-				}
-				for _, item := range snap.Bid {
-					price, _ := decimal.NewFromString(item.Price)
-					size, _ := decimal.NewFromString(item.Size)
-
-					orderbook.Bids = append(orderbook.Bids, environment.Order{
-						Value:    price,
-						Quantity: size,
-					})
-				}
-				wrapper.orderbook.Set(market, orderbook)
-			case update, stillOpen := <-bookUpdateChannel:
-				if !stillOpen {
-					return
-				}
-
-				if currentSequence > update.Sequence {
-					continue // my snapshot is more recent than the one provided
-				}
-
-				orderbook, exists := wrapper.orderbook.Get(m)
-				if !exists {
-					continue // wait for snapshot
-				}
-
-				orderbook.Asks = updateBook(orderbook.Asks, update.Ask, false)
-				orderbook.Bids = updateBook(orderbook.Bids, update.Bid, true)
-
-				wrapper.orderbook.Set(market, orderbook)
+				orderbook.Asks = append(orderbook.Asks, environment.Order{
+					Value:    price,
+					Quantity: size,
+				})
 			}
+			for _, item := range snap.Bid {
+				price, _ := decimal.NewFromString(item.Price)
+				size, _ := decimal.NewFromString(item.Size)
+
+				orderbook.Bids = append(orderbook.Bids, environment.Order{
+					Value:    price,
+					Quantity: size,
+				})
+			}
+			books[snap.Symbol] = orderbook
+			sequences[snap.Symbol] = snap.Sequence
+			wrapper.orderbook.Set(market, copyOrderBook(orderbook))
+		case "updateOrderbook":
+			var update hitbtc.WSNotificationOrderbookUpdate
+			if json.Unmarshal(message.Params, &update) != nil {
+				continue
+			}
+			market, exists := markets[update.Symbol]
+			orderbook, loaded := books[update.Symbol]
+			if !exists || !loaded || update.Sequence <= sequences[update.Symbol] {
+				continue // wait for the snapshot, or the update is older than it.
+			}
+
+			orderbook.Asks = updateBook(orderbook.Asks, update.Ask, false)
+			orderbook.Bids = updateBook(orderbook.Bids, update.Bid, true)
+			sequences[update.Symbol] = update.Sequence
+			wrapper.orderbook.Set(market, copyOrderBook(orderbook))
 		}
 	}
+}
 
-	summaryChannel, err := wrapper.ws.SubscribeTicker(MarketNameFor(market, wrapper))
-	if err != nil {
-		return err
-	}
+// hitbtcRequest represents a JSON-RPC request to the hitbtc feed.
+type hitbtcRequest struct {
+	Method string      `json:"method"`
+	Params interface{} `json:"params"`
+	ID     int         `json:"id"`
+}
 
-	bookUpdateChannel, bookSnapshotChannel, err := wrapper.ws.SubscribeOrderbook(MarketNameFor(market, wrapper))
-	if err != nil {
-		return err
-	}
+// hitbtcMessage represents a JSON-RPC message of the hitbtc feed: a notification or the response to a request.
+type hitbtcMessage struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Error  *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
 
-	go handleTicker(wrapper, summaryChannel, market)
-	go handleOrderbook(wrapper, bookSnapshotChannel, bookUpdateChannel, market)
-	return nil
+// copyOrderBook returns a copy of an order book, which can be read while the original one is updated.
+func copyOrderBook(orderbook *environment.OrderBook) *environment.OrderBook {
+	return &environment.OrderBook{
+		Asks: append([]environment.Order(nil), orderbook.Asks...),
+		Bids: append([]environment.Order(nil), orderbook.Bids...),
+	}
 }
 
 func updateBook(ordersToUpdate []environment.Order, newOrders []hitbtc.WSSubtypeTrade, reverseOrdering bool) []environment.Order {
@@ -460,8 +669,11 @@ func updateBook(ordersToUpdate []environment.Order, newOrders []hitbtc.WSSubtype
 }
 
 // Withdraw performs a withdraw operation from the exchange to a destination address.
-func (wrapper *HitBtcWrapperV2) Withdraw(destinationAddress string, coinTicker string, amount float64) error {
-	_, err := wrapper.api.Withdraw(destinationAddress, coinTicker, amount)
+func (wrapper *HitBtcWrapperV2) Withdraw(destinationAddress string, coinTicker string, amount decimal.Decimal) error {
+	if err := wrapper.limiter.Wait(context.Background(), "Withdraw"); err != nil {
+		return err
+	}
+	_, err := wrapper.api.Withdraw(destinationAddress, coinTicker, amount.Truncate(8).InexactFloat64())
 	if err != nil {
 		return err
 	}
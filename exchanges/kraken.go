@@ -16,35 +16,83 @@
 package exchanges
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/beldur/kraken-go-api-client"
-	"github.com/fatih/structs"
+	"github.com/gorilla/websocket"
 	"github.com/saniales/golang-crypto-trading-bot/environment"
 	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
 )
 
 // NOTE: https://www.kraken.com/help/api
 
+const (
+	krakenWebsocketURL = "wss://ws.kraken.com"
+	krakenBookDepth    = 10 // depth of the order books received from the websocket feed.
+	krakenFeed         = "ticker+book"
+)
+
+// krakenIntervals maps the supported timeframes to the OHLC intervals of Kraken (in minutes).
+var krakenIntervals = map[environment.TimeFrame]string{
+	environment.OneMinute:                      "1",
+	environment.FiveMinutes:                    "5",
+	environment.FifteenMinutes:                 "15",
+	environment.ThirtyMinutes:                  "30",
+	environment.OneHour:                        "60",
+	environment.FourHours:                      "240",
+	environment.OneDay:                         "1440",
+	environment.OneWeek:                        "10080",
+	environment.TimeFrame(15 * 24 * time.Hour): "21600",
+}
+
+// krakenAssetAliases maps the common name of a currency to its kraken name, when different.
+var krakenAssetAliases = map[string]string{
+	"BTC":  "XBT",
+	"DOGE": "XDG",
+}
+
 // KrakenWrapper provides a Generic wrapper of the Kraken API.
 type KrakenWrapper struct {
 	api              *krakenapi.KrakenApi
 	summaries        *SummaryCache
 	candles          *CandlesCache
+	orderbook        *OrderbookCache
+	rules            *RulesCache
+	limiter          *RateLimiter
+	feeds            *FeedSupervisor
 	depositAddresses map[string]string
-	websocketOn      bool
+}
+
+// krakenWeights represents the weights of the kraken endpoints, by wrapper method name: orders are not counted
+// by the API counter, which is approximated as an interval of 15 calls every 15 seconds.
+var krakenWeights = map[string]int{
+	"BuyLimit":    0,
+	"SellLimit":   0,
+	"BuyMarket":   0,
+	"SellMarket":  0,
+	"CancelOrder": 0,
 }
 
 // NewKrakenWrapper creates a generic wrapper of the poloniex API.
 func NewKrakenWrapper(publicKey string, secretKey string, depositAddresses map[string]string) ExchangeWrapper {
+	limiter := NewRateLimiter("kraken", 15, 15*time.Second, krakenWeights)
 	return &KrakenWrapper{
-		api:              krakenapi.New(publicKey, secretKey),
+		api:              krakenapi.NewWithClient(publicKey, secretKey, limiter.HTTPClient(nil)),
 		summaries:        NewSummaryCache(),
 		candles:          NewCandlesCache(),
+		orderbook:        NewOrderbookCache(),
+		rules:            NewRulesCache(),
+		limiter:          limiter,
+		feeds:            NewFeedSupervisor("kraken"),
 		depositAddresses: depositAddresses,
-		websocketOn:      false,
 	}
 }
 
@@ -57,100 +105,273 @@ func (wrapper *KrakenWrapper) String() string {
 	return wrapper.Name()
 }
 
+// RateLimiter returns the budget of the calls to kraken.
+func (wrapper *KrakenWrapper) RateLimiter() *RateLimiter {
+	return wrapper.limiter
+}
+
+// FeedSupervisor returns the supervisor of the kraken feed.
+func (wrapper *KrakenWrapper) FeedSupervisor() *FeedSupervisor {
+	return wrapper.feeds
+}
+
 // GetMarkets gets all the markets info.
 func (wrapper *KrakenWrapper) GetMarkets() ([]*environment.Market, error) {
-	krakenMarkets, err := wrapper.api.AssetPairs()
+	if err := wrapper.limiter.Wait(context.Background(), "GetMarkets"); err != nil {
+		return nil, err
+	}
+	krakenResponse, err := wrapper.api.Query("AssetPairs", map[string]string{})
 	if err != nil {
 		return nil, err
 	}
 
-	markets := structs.Map(krakenMarkets)
+	pairs, ok := krakenResponse.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Unexpected AssetPairs response")
+	}
 
-	wrappedMarkets := make([]*environment.Market, len(markets))
-	i := 0
-	for name, pair := range markets {
-		p := pair.(krakenapi.AssetPairInfo)
-		wrappedMarkets[i] = &environment.Market{
-			Name:           name,
-			BaseCurrency:   p.Base,
-			MarketCurrency: p.Quote,
+	wrappedMarkets := make([]*environment.Market, 0, len(pairs))
+	for name, pair := range pairs {
+		info, _ := pair.(map[string]interface{})
+		// the websocket name (e.g. ETH/XBT) contains the currencies without the kraken prefixes.
+		wsName, _ := info["wsname"].(string)
+		currencies := strings.SplitN(wsName, "/", 2)
+		if len(currencies) != 2 {
+			continue
 		}
-		i++
+		wrappedMarkets = append(wrappedMarkets, newExchangeMarket(wrapper, name, krakenCommonName(currencies[1]), krakenCommonName(currencies[0]), krakenMarketRules(info)))
 	}
 
+	wrapper.rules.Set(wrapper, wrappedMarkets)
 	return wrappedMarkets, nil
 }
 
+// krakenMarketRules gets the trading rules of a market from the info of its asset pair.
+func krakenMarketRules(info map[string]interface{}) *environment.MarketRules {
+	pairDecimals, _ := info["pair_decimals"].(float64)
+	lotDecimals, _ := info["lot_decimals"].(float64)
+	rules := environment.NewMarketRules(int32(pairDecimals), int32(lotDecimals))
+
+	// the tick size is a multiple of the smallest price on some pairs.
+	if tickSize, err := decimal.NewFromString(fmt.Sprint(info["tick_size"])); err == nil && tickSize.IsPositive() {
+		rules.TickSize = tickSize
+	}
+	rules.MinQuantity, _ = decimal.NewFromString(fmt.Sprint(info["ordermin"]))
+	rules.MinNotional, _ = decimal.NewFromString(fmt.Sprint(info["costmin"]))
+	return rules
+}
+
+// MarketRules gets the trading rules of a market, getting the ones of all markets from the exchange the first time.
+func (wrapper *KrakenWrapper) MarketRules(market *environment.Market) (*environment.MarketRules, error) {
+	return wrapper.rules.Get(wrapper, market, func() error {
+		_, err := wrapper.GetMarkets()
+		return err
+	})
+}
+
+// krakenCommonName converts the kraken name of a currency to its common name (e.g. XBT to BTC).
+func krakenCommonName(asset string) string {
+	for symbol, alias := range krakenAssetAliases {
+		if alias == asset {
+			return symbol
+		}
+	}
+	return asset
+}
+
 // GetOrderBook gets the order(ASK + BID) book of a market.
 func (wrapper *KrakenWrapper) GetOrderBook(market *environment.Market) (*environment.OrderBook, error) {
-	krakenOrderBook, err := wrapper.api.Depth(MarketNameFor(market, wrapper), 0)
-	if err != nil {
-		return nil, err
-	}
+	if !wrapper.feeds.Up(krakenFeed, market) {
+		if err := wrapper.limiter.Wait(context.Background(), "GetOrderBook"); err != nil {
+			return nil, err
+		}
+		krakenOrderBook, err := wrapper.api.Depth(MarketNameFor(market, wrapper), 0)
+		if err != nil {
+			return nil, err
+		}
 
-	var orderBook environment.OrderBook
-	for _, order := range krakenOrderBook.Bids {
-		amount := decimal.NewFromFloat(order.Amount)
-		rate := decimal.NewFromFloat(order.Price)
-		orderBook.Bids = append(orderBook.Bids, environment.Order{
-			Quantity:  amount,
-			Value:     rate,
-			Timestamp: time.Unix(order.Ts, 0),
-		})
+		var orderBook environment.OrderBook
+		for _, order := range krakenOrderBook.Bids {
+			amount := decimal.NewFromFloat(order.Amount)
+			rate := decimal.NewFromFloat(order.Price)
+			orderBook.Bids = append(orderBook.Bids, environment.Order{
+				Quantity:  amount,
+				Value:     rate,
+				Timestamp: time.Unix(order.Ts, 0),
+			})
+		}
+		for _, order := range krakenOrderBook.Asks {
+			amount := decimal.NewFromFloat(order.Amount)
+			rate := decimal.NewFromFloat(order.Price)
+			orderBook.Asks = append(orderBook.Asks, environment.Order{
+				Quantity:  amount,
+				Value:     rate,
+				Timestamp: time.Unix(order.Ts, 0),
+			})
+		}
+
+		wrapper.orderbook.Set(market, &orderBook)
 	}
-	for _, order := range krakenOrderBook.Asks {
-		amount := decimal.NewFromFloat(order.Amount)
-		rate := decimal.NewFromFloat(order.Price)
-		orderBook.Asks = append(orderBook.Asks, environment.Order{
-			Quantity:  amount,
-			Value:     rate,
-			Timestamp: time.Unix(order.Ts, 0),
-		})
+
+	orderbook, exists := wrapper.orderbook.Get(market)
+	if !exists {
+		return nil, errors.New("Orderbook not loaded")
 	}
 
-	return &orderBook, nil
+	return orderbook, nil
+}
+
+// StreamedOrderBook returns the order book of a market kept from the book feed, false if the feed of the market is down.
+func (wrapper *KrakenWrapper) StreamedOrderBook(market *environment.Market) (*environment.OrderBook, bool) {
+	if !wrapper.feeds.Up(krakenFeed, market) {
+		return nil, false
+	}
+	return wrapper.orderbook.Get(market)
 }
 
 // BuyLimit performs a limit buy action.
-func (wrapper *KrakenWrapper) BuyLimit(market *environment.Market, amount float64, limit float64) (string, error) {
-	orderNumber, err := wrapper.api.AddOrder(MarketNameFor(market, wrapper), "buy", "limit", fmt.Sprint(amount), map[string]string{"price": fmt.Sprint(limit)})
+func (wrapper *KrakenWrapper) BuyLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	quantity, price, err := roundOrder(wrapper, market, environment.Bid, amount, limit)
 	if err != nil {
 		return "", err
 	}
-	return fmt.Sprint(orderNumber.TransactionIds), nil
+	if err := wrapper.limiter.Wait(context.Background(), "BuyLimit"); err != nil {
+		return "", err
+	}
+	orderNumber, err := wrapper.api.AddOrder(MarketNameFor(market, wrapper), "buy", "limit", quantity.String(), map[string]string{"price": price.String()})
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(orderNumber.TransactionIds, ","), nil
 }
 
 // SellLimit performs a limit sell action.
 //
 // NOTE: In kraken buy and sell orders behave the same (the go kraken api automatically puts it on correct side)
-func (wrapper *KrakenWrapper) SellLimit(market *environment.Market, amount float64, limit float64) (string, error) {
-	orderNumber, err := wrapper.api.AddOrder(MarketNameFor(market, wrapper), "sell", "limit", fmt.Sprint(amount), map[string]string{"price": fmt.Sprint(limit)})
+func (wrapper *KrakenWrapper) SellLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	quantity, price, err := roundOrder(wrapper, market, environment.Ask, amount, limit)
+	if err != nil {
+		return "", err
+	}
+	if err := wrapper.limiter.Wait(context.Background(), "SellLimit"); err != nil {
+		return "", err
+	}
+	orderNumber, err := wrapper.api.AddOrder(MarketNameFor(market, wrapper), "sell", "limit", quantity.String(), map[string]string{"price": price.String()})
 	if err != nil {
 		return "", err
 	}
-	return fmt.Sprint(orderNumber.TransactionIds), nil
+	return strings.Join(orderNumber.TransactionIds, ","), nil
 }
 
 // BuyMarket performs a market buy action.
-func (wrapper *KrakenWrapper) BuyMarket(market *environment.Market, amount float64) (string, error) {
-	orderNumber, err := wrapper.api.AddOrder(MarketNameFor(market, wrapper), "buy", "market", fmt.Sprint(amount), map[string]string{})
+func (wrapper *KrakenWrapper) BuyMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
+	quantity, _, err := roundOrder(wrapper, market, environment.Bid, amount, decimal.Zero)
 	if err != nil {
 		return "", err
 	}
-	return fmt.Sprint(orderNumber.TransactionIds), nil
+	if err := wrapper.limiter.Wait(context.Background(), "BuyMarket"); err != nil {
+		return "", err
+	}
+	orderNumber, err := wrapper.api.AddOrder(MarketNameFor(market, wrapper), "buy", "market", quantity.String(), map[string]string{})
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(orderNumber.TransactionIds, ","), nil
 }
 
 // SellMarket performs a market sell action.
-func (wrapper *KrakenWrapper) SellMarket(market *environment.Market, amount float64) (string, error) {
-	orderNumber, err := wrapper.api.AddOrder(MarketNameFor(market, wrapper), "sell", "market", fmt.Sprint(amount), map[string]string{})
+func (wrapper *KrakenWrapper) SellMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
+	quantity, _, err := roundOrder(wrapper, market, environment.Ask, amount, decimal.Zero)
+	if err != nil {
+		return "", err
+	}
+	if err := wrapper.limiter.Wait(context.Background(), "SellMarket"); err != nil {
+		return "", err
+	}
+	orderNumber, err := wrapper.api.AddOrder(MarketNameFor(market, wrapper), "sell", "market", quantity.String(), map[string]string{})
 	if err != nil {
 		return "", err
 	}
-	return fmt.Sprint(orderNumber.TransactionIds), nil
+	return strings.Join(orderNumber.TransactionIds, ","), nil
+}
+
+// GetOrder gets the status of an order placed on the exchange.
+func (wrapper *KrakenWrapper) GetOrder(market *environment.Market, orderID string) (*environment.OrderInfo, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetOrder"); err != nil {
+		return nil, err
+	}
+	krakenOrders, err := wrapper.api.QueryOrders(orderID, map[string]string{})
+	if err != nil {
+		return nil, err
+	}
+
+	krakenOrder, exists := (*krakenOrders)[orderID]
+	if !exists {
+		return nil, ErrOrderNotFound
+	}
+
+	return convertFromKrakenOrder(market, orderID, krakenOrder), nil
+}
+
+// GetOpenOrders gets the open orders of the user on a market.
+func (wrapper *KrakenWrapper) GetOpenOrders(market *environment.Market) ([]*environment.OrderInfo, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetOpenOrders"); err != nil {
+		return nil, err
+	}
+	krakenOrders, err := wrapper.api.OpenOrders(map[string]string{})
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]*environment.OrderInfo, 0, len(krakenOrders.Open))
+	for id, krakenOrder := range krakenOrders.Open {
+		if krakenOrder.Description.AssetPair == MarketNameFor(market, wrapper) {
+			ret = append(ret, convertFromKrakenOrder(market, id, krakenOrder))
+		}
+	}
+
+	return ret, nil
+}
+
+// CancelOrder cancels an open order.
+func (wrapper *KrakenWrapper) CancelOrder(market *environment.Market, orderID string) error {
+	if err := wrapper.limiter.Wait(context.Background(), "CancelOrder"); err != nil {
+		return err
+	}
+	_, err := wrapper.api.CancelOrder(orderID)
+	return err
+}
+
+// convertFromKrakenOrder converts a kraken order to a environment.OrderInfo.
+func convertFromKrakenOrder(market *environment.Market, orderID string, krakenOrder krakenapi.Order) *environment.OrderInfo {
+	quantity, _ := decimal.NewFromString(krakenOrder.Volume)
+	limit, _ := decimal.NewFromString(krakenOrder.Description.PrimaryPrice)
+	filledQuantity := decimal.NewFromFloat(krakenOrder.VolumeExecuted)
+	isOpen := krakenOrder.Status == "pending" || krakenOrder.Status == "open"
+
+	order := &environment.OrderInfo{
+		ID:             orderID,
+		Market:         market,
+		Type:           environment.Bid,
+		Status:         orderStatusFor(quantity, filledQuantity, isOpen),
+		Quantity:       quantity,
+		Limit:          limit,
+		FilledQuantity: filledQuantity,
+		AveragePrice:   decimal.NewFromFloat(krakenOrder.Price),
+		Timestamp:      time.Unix(int64(krakenOrder.OpenTime), 0),
+	}
+	if krakenOrder.Description.Type == "sell" {
+		order.Type = environment.Ask
+	}
+
+	return order
 }
 
 // GetTicker gets the updated ticker for a market.
 func (wrapper *KrakenWrapper) GetTicker(market *environment.Market) (*environment.Ticker, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetTicker"); err != nil {
+		return nil, err
+	}
 	krakenTicker, err := wrapper.api.Ticker(MarketNameFor(market, wrapper))
 	if err != nil {
 		return nil, err
@@ -171,106 +392,168 @@ func (wrapper *KrakenWrapper) GetTicker(market *environment.Market) (*environmen
 
 // GetMarketSummary gets the current market summary.
 func (wrapper *KrakenWrapper) GetMarketSummary(market *environment.Market) (*environment.MarketSummary, error) {
-	krakenSummary, err := wrapper.api.Ticker(MarketNameFor(market, wrapper))
-	if err != nil {
-		return nil, err
-	}
+	if !wrapper.feeds.Up(krakenFeed, market) {
+		if err := wrapper.limiter.Wait(context.Background(), "GetMarketSummary"); err != nil {
+			return nil, err
+		}
+		krakenSummary, err := wrapper.api.Ticker(MarketNameFor(market, wrapper))
+		if err != nil {
+			return nil, err
+		}
 
-	sum := krakenSummary.GetPairTickerInfo(MarketNameFor(market, wrapper))
+		sum := krakenSummary.GetPairTickerInfo(MarketNameFor(market, wrapper))
+
+		high, _ := decimal.NewFromString(sum.High[1])
+		low, _ := decimal.NewFromString(sum.Low[1])
+		volume, _ := decimal.NewFromString(sum.Volume[1])
+		bid, _ := decimal.NewFromString(sum.Bid[0])
+		ask, _ := decimal.NewFromString(sum.Ask[0])
+		last, _ := decimal.NewFromString(sum.Close[0])
+
+		wrapper.summaries.Set(market, &environment.MarketSummary{
+			High:   high,
+			Low:    low,
+			Volume: volume,
+			Bid:    bid,
+			Ask:    ask,
+			Last:   last,
+		})
+	}
 
-	high, _ := decimal.NewFromString(sum.High[0])
-	low, _ := decimal.NewFromString(sum.Low[0])
-	volume, _ := decimal.NewFromString(sum.Volume[0])
-	bid, _ := decimal.NewFromString(sum.Bid[0])
-	ask, _ := decimal.NewFromString(sum.Ask[0])
+	ret, summaryLoaded := wrapper.summaries.Get(market)
+	if !summaryLoaded {
+		return nil, errors.New("Summary not loaded")
+	}
 
-	return &environment.MarketSummary{
-		High:   high,
-		Low:    low,
-		Volume: volume,
-		Bid:    bid,
-		Ask:    ask,
-		Last:   ask, // TODO: find a better way for last value, if any
-	}, nil
+	return ret, nil
 }
 
 // GetCandles gets the candle data from the exchange.
+//
+// NOTE: candles are 30 minutes long, unless a different time_frame is configured for the market.
 func (wrapper *KrakenWrapper) GetCandles(market *environment.Market) ([]environment.CandleStick, error) {
-	if !wrapper.websocketOn {
-		now := time.Now()
+	timeFrame, native, err := candleTimeFrames(market, wrapper, krakenIntervals, environment.ThirtyMinutes)
+	if err != nil {
+		return nil, err
+	}
 
-		krakenTrades, err := wrapper.api.Trades(MarketNameFor(market, wrapper), now.Add(-time.Hour*24).Unix())
-		if err != nil {
-			return nil, err
+	if err := wrapper.limiter.Wait(context.Background(), "GetCandles"); err != nil {
+		return nil, err
+	}
+	krakenResponse, err := wrapper.api.Query("OHLC", map[string]string{
+		"pair":     MarketNameFor(market, wrapper),
+		"interval": krakenIntervals[native],
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result, ok := krakenResponse.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Unexpected OHLC response")
+	}
+
+	// the candles are keyed by the kraken name of the pair, along with the "last" cursor.
+	for key, value := range result {
+		if key == "last" {
+			continue
 		}
 
-		trades := krakenTrades.Trades
+		krakenCandles, ok := value.([]interface{})
+		if !ok {
+			return nil, errors.New("Unexpected OHLC response")
+		}
 
-		for lastTradeTime := time.Unix(krakenTrades.Last, 0); lastTradeTime.Before(now); {
-			krakenTrades, err = wrapper.api.Trades(MarketNameFor(market, wrapper), now.Add(-time.Hour*24).Unix())
+		ret := make([]environment.CandleStick, len(krakenCandles))
+		for i, krakenCandle := range krakenCandles {
+			ret[i], err = convertFromKrakenCandle(krakenCandle, native.Duration())
 			if err != nil {
 				return nil, err
 			}
-
-			trades = append(trades, krakenTrades.Trades...)
 		}
 
-		ret := make([]environment.CandleStick, 0, 50)
-
-		step := time.Minute * 30
-		start := time.Unix(krakenTrades.Trades[0].Time, 0)
-
-		var open = decimal.NewFromFloat(krakenTrades.Trades[0].PriceFloat)
-		var high = open
-		var low = open
-		var close decimal.Decimal
-
-		N := len(trades)
-		for i := 1; i < N; i++ {
-			currentTrade := trades[i]
-			candleTime := time.Unix(currentTrade.Time, 0)
-			isLastTrade := i == N-1
-
-			if candleTime.Before(start.Add(step)) || isLastTrade {
-				// aggregate candles from trades.
-				currentPrice := decimal.NewFromFloat(currentTrade.PriceFloat)
-				high = decimal.Max(high, currentPrice)
-				low = decimal.Min(low, currentPrice)
-			} else {
-				// add candle with aggregate data and reset.
-				previousTrade := trades[i-1]
-				close = decimal.NewFromFloat(previousTrade.PriceFloat)
-				ret = append(ret, environment.CandleStick{
-					High:  high,
-					Open:  open,
-					Close: close,
-					Low:   low,
-				})
-				open = decimal.NewFromFloat(currentTrade.PriceFloat)
-				high = decimal.NewFromFloat(0)
-				low = decimal.NewFromFloat(999999999)
-			}
+		ret, err = AggregateCandles(ret, timeFrame)
+		if err != nil {
+			return nil, err
 		}
 
 		wrapper.candles.Set(market, ret)
+		return ret, nil
 	}
 
-	ret, candleLoaded := wrapper.candles.Get(market)
-	if !candleLoaded {
-		return nil, errors.New("No candle data yet")
+	return nil, errors.New("No candle data yet")
+}
+
+// convertFromKrakenCandle converts a kraken OHLC entry [time, open, high, low, close, vwap, volume, count] to a environment.CandleStick.
+func convertFromKrakenCandle(krakenCandle interface{}, period time.Duration) (environment.CandleStick, error) {
+	fields, ok := krakenCandle.([]interface{})
+	if !ok || len(fields) < 7 {
+		return environment.CandleStick{}, errors.New("Unexpected OHLC entry")
 	}
+	openUnix, ok := fields[0].(float64)
+	if !ok {
+		return environment.CandleStick{}, errors.New("Unexpected OHLC entry")
+	}
+	openTime := time.Unix(int64(openUnix), 0)
 
-	return ret, nil
+	values := make([]decimal.Decimal, 7)
+	for i := 1; i < 7; i++ {
+		value, err := decimal.NewFromString(fmt.Sprint(fields[i]))
+		if err != nil {
+			return environment.CandleStick{}, err
+		}
+		values[i] = value
+	}
+
+	return environment.CandleStick{
+		Open:      values[1],
+		High:      values[2],
+		Low:       values[3],
+		Close:     values[4],
+		Volume:    values[6],
+		OpenTime:  openTime,
+		CloseTime: openTime.Add(period),
+		Period:    period,
+	}, nil
 }
 
 // GetBalance gets the balance of the user of the specified currency.
 func (wrapper *KrakenWrapper) GetBalance(symbol string) (*decimal.Decimal, error) {
-	_, err := wrapper.api.Balance()
+	if err := wrapper.limiter.Wait(context.Background(), "GetBalance"); err != nil {
+		return nil, err
+	}
+	krakenResponse, err := wrapper.api.Query("Balance", map[string]string{})
 	if err != nil {
 		return nil, err
 	}
 
-	panic("Help me integrate this feature!")
+	balances, ok := krakenResponse.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Unexpected balance response")
+	}
+
+	for _, asset := range krakenAssetNamesFor(symbol) {
+		if balance, exists := balances[asset]; exists {
+			ret, err := decimal.NewFromString(fmt.Sprint(balance))
+			if err != nil {
+				return nil, err
+			}
+			return &ret, nil
+		}
+	}
+
+	// kraken omits the currencies never held.
+	ret := decimal.Zero
+	return &ret, nil
+}
+
+// krakenAssetNamesFor returns the names a currency can have in kraken (e.g. BTC, XBT, XXBT for bitcoin).
+func krakenAssetNamesFor(symbol string) []string {
+	asset := symbol
+	if alias, exists := krakenAssetAliases[symbol]; exists {
+		asset = alias
+	}
+	return []string{symbol, asset, "X" + asset, "Z" + asset}
 }
 
 // GetDepositAddress gets the deposit address for the specified coin on the exchange.
@@ -282,35 +565,303 @@ func (wrapper *KrakenWrapper) GetDepositAddress(coinTicker string) (string, bool
 // CalculateTradingFees calculates the trading fees for an order on a specified market.
 //
 //     NOTE: In Kraken fees are currently hardcoded.
-func (wrapper *KrakenWrapper) CalculateTradingFees(market *environment.Market, amount float64, limit float64, orderType TradeType) float64 {
-	var feePercentage float64
+func (wrapper *KrakenWrapper) CalculateTradingFees(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal, orderType TradeType) decimal.Decimal {
+	var feePercentage decimal.Decimal
 	if orderType == MakerTrade {
-		feePercentage = 0.0016
+		feePercentage = decimal.NewFromFloat(0.0016)
 	} else if orderType == TakerTrade {
-		feePercentage = 0.0026
+		feePercentage = decimal.NewFromFloat(0.0026)
 	} else {
 		panic("Unknown trade type")
 	}
 
-	return amount * limit * feePercentage
+	return amount.Mul(limit).Mul(feePercentage)
 }
 
 // CalculateWithdrawFees calculates the withdrawal fees on a specified market.
-func (wrapper *KrakenWrapper) CalculateWithdrawFees(market *environment.Market, amount float64) float64 {
+func (wrapper *KrakenWrapper) CalculateWithdrawFees(market *environment.Market, amount decimal.Decimal) decimal.Decimal {
 	panic("Not Implemented")
 }
 
 // FeedConnect connects to the feed of the exchange.
 func (wrapper *KrakenWrapper) FeedConnect(markets []*environment.Market) error {
-	return ErrWebsocketNotSupported
+	return wrapper.FeedConnectContext(context.Background(), markets)
 }
 
-// SubscribeMarketSummaryFeed subscribes to the Market Summary Feed service.
-func (wrapper *KrakenWrapper) subscribeMarketSummaryFeed(market *environment.Market) {
-	panic("Websocket Not Supported")
+// FeedConnectContext connects to the feed of the exchange, closing it when the context is done.
+//
+// NOTE: summaries and order books are received from the websocket, candles are always fetched via REST.
+// The feed is supervised (see FeedSupervisor): summaries and order books are fetched via REST while it is down.
+func (wrapper *KrakenWrapper) FeedConnectContext(ctx context.Context, markets []*environment.Market) error {
+	feedMarkets := make(map[string]*environment.Market, len(markets))
+	for _, m := range markets {
+		pair, err := wrapper.websocketNameFor(m)
+		if err != nil {
+			return err
+		}
+		feedMarkets[pair] = m
+	}
+
+	return wrapper.feeds.Start(ctx, krakenFeed, markets, func(ctx context.Context, _ []*environment.Market, link *FeedLink) error {
+		return wrapper.runFeed(ctx, feedMarkets, link)
+	})
+}
+
+// runFeed runs a connection to the feed of the markets, by websocket name.
+func (wrapper *KrakenWrapper) runFeed(ctx context.Context, markets map[string]*environment.Market, link *FeedLink) error {
+	pairs := make([]string, 0, len(markets))
+	for pair := range markets {
+		pairs = append(pairs, pair)
+	}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, krakenWebsocketURL, nil)
+	if err != nil {
+		return err
+	}
+
+	subscriptions := []krakenSubscription{
+		{Name: "ticker"},
+		{Name: "book", Depth: krakenBookDepth},
+	}
+	for _, subscription := range subscriptions {
+		err = conn.WriteJSON(krakenSubscribeMessage{
+			Event:        "subscribe",
+			Pair:         pairs,
+			Subscription: subscription,
+		})
+		if err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	link.Connected()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	return wrapper.readFeed(conn, markets, link)
+}
+
+// websocketNameFor gets the name of a market in the websocket feed (e.g. XBT/EUR).
+func (wrapper *KrakenWrapper) websocketNameFor(market *environment.Market) (string, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetMarkets"); err != nil {
+		return "", err
+	}
+	krakenResponse, err := wrapper.api.Query("AssetPairs", map[string]string{
+		"pair": MarketNameFor(market, wrapper),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	pairs, _ := krakenResponse.(map[string]interface{})
+	for _, pair := range pairs {
+		info, _ := pair.(map[string]interface{})
+		if wsName, ok := info["wsname"].(string); ok {
+			return wsName, nil
+		}
+	}
+
+	return "", fmt.Errorf("Market %s not available in the kraken feed", MarketNameFor(market, wrapper))
+}
+
+// readFeed updates summaries and order books with the messages of the feed, until the connection is closed.
+func (wrapper *KrakenWrapper) readFeed(conn *websocket.Conn, markets map[string]*environment.Market, link *FeedLink) error {
+	defer conn.Close()
+
+	books := make(map[string]*krakenBook, len(markets))
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		link.Beat()
+
+		var fields []json.RawMessage
+		if json.Unmarshal(message, &fields) != nil {
+			// events (heartbeats, subscription status) are objects.
+			var event krakenEvent
+			if json.Unmarshal(message, &event) == nil && event.ErrorMessage != "" {
+				logrus.Error(event.ErrorMessage)
+			}
+			continue
+		}
+
+		// [channelID, payload..., channelName, pair]
+		if len(fields) < 4 {
+			continue
+		}
+		var channel, pair string
+		json.Unmarshal(fields[len(fields)-2], &channel)
+		json.Unmarshal(fields[len(fields)-1], &pair)
+		market, exists := markets[pair]
+		if !exists {
+			continue
+		}
+
+		payloads := fields[1 : len(fields)-2]
+		switch {
+		case channel == "ticker":
+			var ticker krakenTickerPayload
+			if json.Unmarshal(payloads[0], &ticker) != nil {
+				continue
+			}
+			wrapper.summaries.Set(market, &environment.MarketSummary{
+				High:   krakenDecimalAt(ticker.High, 1),
+				Low:    krakenDecimalAt(ticker.Low, 1),
+				Volume: krakenDecimalAt(ticker.Volume, 1),
+				Ask:    krakenDecimalAt(ticker.Ask, 0),
+				Bid:    krakenDecimalAt(ticker.Bid, 0),
+				Last:   krakenDecimalAt(ticker.Close, 0),
+			})
+		case strings.HasPrefix(channel, "book"):
+			book, exists := books[pair]
+			if !exists {
+				book = newKrakenBook()
+				books[pair] = book
+			}
+			for _, payload := range payloads {
+				var update krakenBookPayload
+				if json.Unmarshal(payload, &update) != nil {
+					continue
+				}
+				book.update(update)
+			}
+			wrapper.orderbook.Set(market, book.orderBook(krakenBookDepth))
+		}
+	}
+}
+
+// krakenSubscription represents a channel of the kraken feed.
+type krakenSubscription struct {
+	Name  string `json:"name"`
+	Depth int    `json:"depth,omitempty"`
+}
+
+// krakenSubscribeMessage represents a subscription request to the kraken feed.
+type krakenSubscribeMessage struct {
+	Event        string             `json:"event"`
+	Pair         []string           `json:"pair"`
+	Subscription krakenSubscription `json:"subscription"`
+}
+
+// krakenEvent represents a status message of the kraken feed.
+type krakenEvent struct {
+	Event        string `json:"event"`
+	Status       string `json:"status"`
+	ErrorMessage string `json:"errorMessage"`
+}
+
+// krakenTickerPayload represents a ticker update of the kraken feed, each field is [today, last 24 hours] or [price, ...].
+type krakenTickerPayload struct {
+	Ask    []interface{} `json:"a"`
+	Bid    []interface{} `json:"b"`
+	Close  []interface{} `json:"c"`
+	Volume []interface{} `json:"v"`
+	High   []interface{} `json:"h"`
+	Low    []interface{} `json:"l"`
+}
+
+// krakenBookPayload represents a snapshot (as, bs) or an update (a, b) of a book of the kraken feed,
+// each level is [price, volume, timestamp].
+type krakenBookPayload struct {
+	AsksSnapshot [][]string `json:"as"`
+	BidsSnapshot [][]string `json:"bs"`
+	Asks         [][]string `json:"a"`
+	Bids         [][]string `json:"b"`
+}
+
+// krakenBook keeps a book of the kraken feed, by price level.
+type krakenBook struct {
+	asks map[string]environment.Order
+	bids map[string]environment.Order
+}
+
+func newKrakenBook() *krakenBook {
+	return &krakenBook{
+		asks: make(map[string]environment.Order),
+		bids: make(map[string]environment.Order),
+	}
+}
+
+// update applies a snapshot or an update to the book, levels with 0 volume are removed.
+func (book *krakenBook) update(payload krakenBookPayload) {
+	if payload.AsksSnapshot != nil || payload.BidsSnapshot != nil {
+		book.asks = make(map[string]environment.Order)
+		book.bids = make(map[string]environment.Order)
+	}
+
+	updateLevels := func(side map[string]environment.Order, levels [][]string) {
+		for _, level := range levels {
+			if len(level) < 3 {
+				continue
+			}
+			price, _ := decimal.NewFromString(level[0])
+			volume, _ := decimal.NewFromString(level[1])
+			timestamp, _ := strconv.ParseFloat(level[2], 64)
+
+			key := price.String()
+			if volume.IsZero() {
+				delete(side, key)
+				continue
+			}
+			side[key] = environment.Order{
+				Value:     price,
+				Quantity:  volume,
+				Timestamp: time.Unix(0, int64(timestamp*float64(time.Second))),
+			}
+		}
+	}
+
+	updateLevels(book.asks, payload.AsksSnapshot)
+	updateLevels(book.bids, payload.BidsSnapshot)
+	updateLevels(book.asks, payload.Asks)
+	updateLevels(book.bids, payload.Bids)
+}
+
+// orderBook returns the best levels of the book, asks ascending and bids descending.
+func (book *krakenBook) orderBook(depth int) *environment.OrderBook {
+	asks := make([]environment.Order, 0, len(book.asks))
+	for _, ask := range book.asks {
+		asks = append(asks, ask)
+	}
+	sort.Slice(asks, func(i, j int) bool { return asks[i].Value.LessThan(asks[j].Value) })
+
+	bids := make([]environment.Order, 0, len(book.bids))
+	for _, bid := range book.bids {
+		bids = append(bids, bid)
+	}
+	sort.Slice(bids, func(i, j int) bool { return bids[i].Value.GreaterThan(bids[j].Value) })
+
+	if len(asks) > depth {
+		asks = asks[:depth]
+	}
+	if len(bids) > depth {
+		bids = bids[:depth]
+	}
+
+	return &environment.OrderBook{
+		Asks: asks,
+		Bids: bids,
+	}
+}
+
+// krakenDecimalAt returns the i-th value of a field of the kraken feed, 0 if missing.
+func krakenDecimalAt(values []interface{}, i int) decimal.Decimal {
+	if i >= len(values) {
+		return decimal.Zero
+	}
+	ret, _ := decimal.NewFromString(fmt.Sprint(values[i]))
+	return ret
 }
 
 // Withdraw performs a withdraw operation from the exchange to a destination address.
-func (wrapper *KrakenWrapper) Withdraw(destinationAddress string, coinTicker string, amount float64) error {
+func (wrapper *KrakenWrapper) Withdraw(destinationAddress string, coinTicker string, amount decimal.Decimal) error {
 	panic("Not Supported")
 }
@@ -17,8 +17,11 @@
 package exchanges
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"time"
 
 	"github.com/fiore/kucoin-go"
 	"github.com/fiore/kucoin-go/websocket"
@@ -33,18 +36,21 @@ type KucoinWrapper struct {
 	websocketOn      bool
 	summaries        *SummaryCache
 	orderbook        *OrderbookCache
+	limiter          *RateLimiter
 	depositAddresses map[string]string
 }
 
 // NewKucoinWrapper creates a generic wrapper of theKucoin
 func NewKucoinWrapper(publicKey string, secretKey string, depositAddresses map[string]string) ExchangeWrapper {
 	ws, _ := websocket.NewWS()
+	limiter := NewRateLimiter("kucoin", 30, time.Second, nil)
 	return &KucoinWrapper{
-		api:              kucoin.New(publicKey, secretKey),
+		api:              kucoin.NewCustomClient(publicKey, secretKey, *limiter.HTTPClient(&http.Client{})),
 		ws:               ws,
 		websocketOn:      false,
 		summaries:        NewSummaryCache(),
 		orderbook:        NewOrderbookCache(),
+		limiter:          limiter,
 		depositAddresses: depositAddresses,
 	}
 }
@@ -58,8 +64,16 @@ func (wrapper *KucoinWrapper) String() string {
 	return wrapper.Name()
 }
 
+// RateLimiter returns the budget of the calls to kucoin.
+func (wrapper *KucoinWrapper) RateLimiter() *RateLimiter {
+	return wrapper.limiter
+}
+
 // GetMarkets gets all the markets info.
 func (wrapper *KucoinWrapper) GetMarkets() ([]*environment.Market, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetMarkets"); err != nil {
+		return nil, err
+	}
 	KucoinMarkets, err := wrapper.api.GetSymbols()
 
 	if err != nil {
@@ -68,11 +82,8 @@ func (wrapper *KucoinWrapper) GetMarkets() ([]*environment.Market, error) {
 
 	wrappedMarkets := make([]*environment.Market, 0, len(KucoinMarkets))
 	for _, market := range KucoinMarkets {
-		wrappedMarkets = append(wrappedMarkets, &environment.Market{
-			Name:           market.Symbol,
-			BaseCurrency:   market.CoinType,
-			MarketCurrency: market.CoinTypePair,
-		})
+		// kucoin quotes the coin type in the coin type pair, which is the base currency of the bot.
+		wrappedMarkets = append(wrappedMarkets, newExchangeMarket(wrapper, market.Symbol, market.CoinTypePair, market.CoinType, nil))
 	}
 
 	return wrappedMarkets, nil
@@ -82,6 +93,9 @@ func (wrapper *KucoinWrapper) GetMarkets() ([]*environment.Market, error) {
 func (wrapper *KucoinWrapper) GetOrderBook(market *environment.Market) (*environment.OrderBook, error) {
 	ret, exists := wrapper.orderbook.Get(market)
 	if !wrapper.websocketOn {
+		if err := wrapper.limiter.Wait(context.Background(), "GetOrderBook"); err != nil {
+			return nil, err
+		}
 		kucoinOrderBook, err := wrapper.api.OrdersBook(MarketNameFor(market, wrapper), 0, 0, "")
 
 		if err != nil {
@@ -118,8 +132,15 @@ func (wrapper *KucoinWrapper) GetOrderBook(market *environment.Market) (*environ
 }
 
 // BuyLimit performs a limit buy action.
-func (wrapper *KucoinWrapper) BuyLimit(market *environment.Market, amount, limit float64) (string, error) {
-	orderOid, err := wrapper.api.CreateOrder(MarketNameFor(market, wrapper), "BUY", limit, amount)
+func (wrapper *KucoinWrapper) BuyLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	quantity, price, err := roundOrder(wrapper, market, environment.Bid, amount, limit)
+	if err != nil {
+		return "", err
+	}
+	if err := wrapper.limiter.Wait(context.Background(), "BuyLimit"); err != nil {
+		return "", err
+	}
+	orderOid, err := wrapper.api.CreateOrderByString(MarketNameFor(market, wrapper), "BUY", price.String(), quantity.String())
 
 	if err != nil {
 		return "", err
@@ -129,13 +150,20 @@ func (wrapper *KucoinWrapper) BuyLimit(market *environment.Market, amount, limit
 }
 
 // BuyMarket performs a market buy action.
-func (wrapper *KucoinWrapper) BuyMarket(market *environment.Market, amount float64) (string, error) {
+func (wrapper *KucoinWrapper) BuyMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
 	panic("Not Implemented")
 }
 
 // SellLimit performs a limit sell action.
-func (wrapper *KucoinWrapper) SellLimit(market *environment.Market, amount, limit float64) (string, error) {
-	orderOid, err := wrapper.api.CreateOrder(MarketNameFor(market, wrapper), "SELL", limit, amount)
+func (wrapper *KucoinWrapper) SellLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	quantity, price, err := roundOrder(wrapper, market, environment.Ask, amount, limit)
+	if err != nil {
+		return "", err
+	}
+	if err := wrapper.limiter.Wait(context.Background(), "SellLimit"); err != nil {
+		return "", err
+	}
+	orderOid, err := wrapper.api.CreateOrderByString(MarketNameFor(market, wrapper), "SELL", price.String(), quantity.String())
 
 	if err != nil {
 		return "", err
@@ -145,13 +173,125 @@ func (wrapper *KucoinWrapper) SellLimit(market *environment.Market, amount, limi
 }
 
 // SellMarket performs a market sell action.
-func (wrapper *KucoinWrapper) SellMarket(market *environment.Market, amount float64) (string, error) {
+func (wrapper *KucoinWrapper) SellMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
 	panic("Not Implemented")
 }
 
+// GetOrder gets the status of an order placed on the exchange.
+func (wrapper *KucoinWrapper) GetOrder(market *environment.Market, orderID string) (*environment.OrderInfo, error) {
+	openOrders, err := wrapper.GetOpenOrders(market)
+	if err != nil {
+		return nil, err
+	}
+	for _, order := range openOrders {
+		if order.ID == orderID {
+			return order, nil
+		}
+	}
+
+	// closed orders can only be queried knowing their side.
+	for _, side := range []string{"BUY", "SELL"} {
+		if err := wrapper.limiter.Wait(context.Background(), "GetOrder"); err != nil {
+			return nil, err
+		}
+		details, err := wrapper.api.OrderDetails(MarketNameFor(market, wrapper), side, orderID, 0, 0)
+		if err != nil || details.OrderOid == "" {
+			continue
+		}
+
+		filledQuantity := decimal.NewFromFloat(details.DealAmount)
+		quantity := filledQuantity.Add(decimal.NewFromFloat(details.PendingAmount))
+		order := &environment.OrderInfo{
+			ID:             details.OrderOid,
+			Market:         market,
+			Type:           environment.Bid,
+			Status:         orderStatusFor(quantity, filledQuantity, false),
+			Quantity:       quantity,
+			Limit:          decimal.NewFromFloat(details.OrderPrice),
+			FilledQuantity: filledQuantity,
+			AveragePrice:   decimal.NewFromFloat(details.DealPriceAverage),
+		}
+		if side == "SELL" {
+			order.Type = environment.Ask
+		}
+		return order, nil
+	}
+
+	return nil, ErrOrderNotFound
+}
+
+// GetOpenOrders gets the open orders of the user on a market.
+func (wrapper *KucoinWrapper) GetOpenOrders(market *environment.Market) ([]*environment.OrderInfo, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetOpenOrders"); err != nil {
+		return nil, err
+	}
+	kucoinOrders, err := wrapper.api.ListActiveMapOrders(MarketNameFor(market, wrapper), "")
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]*environment.OrderInfo, 0, len(kucoinOrders.BUY)+len(kucoinOrders.SELL))
+	for _, kucoinOrder := range kucoinOrders.BUY {
+		ret = append(ret, convertFromKucoinActiveOrder(market, environment.Bid, kucoinOrder.Oid, kucoinOrder.Price, kucoinOrder.DealAmount, kucoinOrder.PendingAmount, kucoinOrder.CreatedAt))
+	}
+	for _, kucoinOrder := range kucoinOrders.SELL {
+		ret = append(ret, convertFromKucoinActiveOrder(market, environment.Ask, kucoinOrder.Oid, kucoinOrder.Price, kucoinOrder.DealAmount, kucoinOrder.PendingAmount, kucoinOrder.CreatedAt))
+	}
+
+	return ret, nil
+}
+
+// CancelOrder cancels an open order.
+func (wrapper *KucoinWrapper) CancelOrder(market *environment.Market, orderID string) error {
+	openOrders, err := wrapper.GetOpenOrders(market)
+	if err != nil {
+		return err
+	}
+
+	for _, order := range openOrders {
+		if order.ID == orderID {
+			side := "BUY"
+			if order.Type == environment.Ask {
+				side = "SELL"
+			}
+			if err := wrapper.limiter.Wait(context.Background(), "CancelOrder"); err != nil {
+				return err
+			}
+			return wrapper.api.CancelOrder(MarketNameFor(market, wrapper), orderID, side)
+		}
+	}
+
+	return ErrOrderNotFound
+}
+
+// convertFromKucoinActiveOrder converts a kucoin active order to a environment.OrderInfo.
+func convertFromKucoinActiveOrder(market *environment.Market, orderType environment.OrderType, oid string, price float64, dealAmount float64, pendingAmount float64, createdAt int64) *environment.OrderInfo {
+	filledQuantity := decimal.NewFromFloat(dealAmount)
+	quantity := filledQuantity.Add(decimal.NewFromFloat(pendingAmount))
+
+	order := &environment.OrderInfo{
+		ID:             oid,
+		Market:         market,
+		Type:           orderType,
+		Status:         orderStatusFor(quantity, filledQuantity, true),
+		Quantity:       quantity,
+		Limit:          decimal.NewFromFloat(price),
+		FilledQuantity: filledQuantity,
+		Timestamp:      time.Unix(0, createdAt*int64(time.Millisecond)),
+	}
+	if filledQuantity.IsPositive() {
+		order.AveragePrice = order.Limit
+	}
+
+	return order
+}
+
 // GetTicker gets the updated ticker for a market.
 func (wrapper *KucoinWrapper) GetTicker(market *environment.Market) (*environment.Ticker, error) {
 
+	if err := wrapper.limiter.Wait(context.Background(), "GetTicker"); err != nil {
+		return nil, err
+	}
 	kucoinTicker, err := wrapper.api.GetSymbol(MarketNameFor(market, wrapper))
 	if err != nil {
 		return nil, err
@@ -171,6 +311,9 @@ func (wrapper *KucoinWrapper) GetTicker(market *environment.Market) (*environmen
 func (wrapper *KucoinWrapper) GetMarketSummary(market *environment.Market) (*environment.MarketSummary, error) {
 	ret, exists := wrapper.summaries.Get(market)
 	if !wrapper.websocketOn {
+		if err := wrapper.limiter.Wait(context.Background(), "GetMarketSummary"); err != nil {
+			return nil, err
+		}
 		kucoinSummary, err := wrapper.api.GetSymbol(MarketNameFor(market, wrapper))
 		if err != nil {
 			return nil, err
@@ -205,6 +348,9 @@ func (wrapper *KucoinWrapper) GetMarketSummary(market *environment.Market) (*env
 
 // GetBalance gets the balance of the user of the specified currency.
 func (wrapper *KucoinWrapper) GetBalance(symbol string) (*decimal.Decimal, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetBalance"); err != nil {
+		return nil, err
+	}
 	kucoinBalance, err := wrapper.api.GetCoinBalance(symbol)
 
 	if err != nil {
@@ -223,21 +369,21 @@ func (wrapper *KucoinWrapper) GetDepositAddress(coinTicker string) (string, bool
 }
 
 // CalculateTradingFees calculates the trading fees for an order on a specified market.
-func (wrapper *KucoinWrapper) CalculateTradingFees(market *environment.Market, amount float64, limit float64, orderType TradeType) float64 {
-	var feePercentage float64
+func (wrapper *KucoinWrapper) CalculateTradingFees(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal, orderType TradeType) decimal.Decimal {
+	var feePercentage decimal.Decimal
 	if orderType == MakerTrade {
-		feePercentage = 0.0025
+		feePercentage = decimal.NewFromFloat(0.0025)
 	} else if orderType == TakerTrade {
-		feePercentage = 0.0025
+		feePercentage = decimal.NewFromFloat(0.0025)
 	} else {
 		panic("Unknown trade type")
 	}
 
-	return amount * limit * feePercentage
+	return amount.Mul(limit).Mul(feePercentage)
 }
 
 // CalculateWithdrawFees calculates the withdrawal fees on a specified market.
-func (wrapper *KucoinWrapper) CalculateWithdrawFees(market *environment.Market, amount float64) float64 {
+func (wrapper *KucoinWrapper) CalculateWithdrawFees(market *environment.Market, amount decimal.Decimal) decimal.Decimal {
 	panic("Not Implemented")
 }
 
@@ -257,8 +403,12 @@ func (wrapper *KucoinWrapper) subscribeFeeds(market *environment.Market) error {
 }
 
 // Withdraw performs a withdraw operation from the exchange to a destination address.
-func (wrapper *KucoinWrapper) Withdraw(destinationAddress string, coinTicker string, amount float64) error {
-	_, err := wrapper.api.CreateWithdrawalApply(coinTicker, destinationAddress, amount)
+func (wrapper *KucoinWrapper) Withdraw(destinationAddress string, coinTicker string, amount decimal.Decimal) error {
+	if err := wrapper.limiter.Wait(context.Background(), "Withdraw"); err != nil {
+		return err
+	}
+	// unlike orders, withdrawals only take a float64 amount, sent in its shortest form: truncated to 8 decimals it is sent unchanged.
+	_, err := wrapper.api.CreateWithdrawalApply(coinTicker, destinationAddress, amount.Truncate(8).InexactFloat64())
 	if err != nil {
 		return err
 	}
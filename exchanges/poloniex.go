@@ -16,35 +16,52 @@
 package exchanges
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/shopspring/decimal"
 
 	"github.com/pharrisee/poloniex-api"
 	"github.com/saniales/golang-crypto-trading-bot/environment"
 )
 
+const (
+	poloniexCandlePeriod  = 5 * time.Minute // period of the candles returned by the chart data of poloniex.
+	poloniexWebsocketURL  = "wss://api2.poloniex.com"
+	poloniexTickerChannel = 1002 // channel of the tickers of all the markets in the poloniex feed.
+	poloniexTickerFeed    = "ticker"
+)
+
+// poloniexTimeFrames contains the timeframes served by the chart data of poloniex.
+var poloniexTimeFrames = map[environment.TimeFrame]string{
+	environment.TimeFrame(poloniexCandlePeriod): "300",
+}
+
 // PoloniexWrapper provides a Generic wrapper of the Poloniex API.
 type PoloniexWrapper struct {
 	api              *poloniex.Poloniex // access to Poloniex API
-	bindedTickers    map[string]bool    // if true, i am subscribing to market ticker.
 	summaries        *SummaryCache
 	candles          *CandlesCache
+	limiter          *RateLimiter
+	feeds            *FeedSupervisor
 	depositAddresses map[string]string
-	websocketOn      bool
 }
 
 // NewPoloniexWrapper creates a generic wrapper of the poloniex API.
 func NewPoloniexWrapper(publicKey string, secretKey string, depositAddresses map[string]string) ExchangeWrapper {
 	return &PoloniexWrapper{
 		api:              poloniex.NewWithCredentials(publicKey, secretKey),
-		bindedTickers:    make(map[string]bool),
 		summaries:        NewSummaryCache(),
 		candles:          NewCandlesCache(),
+		limiter:          NewRateLimiter("poloniex", 6, time.Second, nil),
+		feeds:            NewFeedSupervisor("poloniex"),
 		depositAddresses: depositAddresses,
-		websocketOn:      false,
 	}
 }
 
@@ -57,59 +74,85 @@ func (wrapper *PoloniexWrapper) String() string {
 	return wrapper.Name()
 }
 
+// RateLimiter returns the budget of the calls to poloniex.
+func (wrapper *PoloniexWrapper) RateLimiter() *RateLimiter {
+	return wrapper.limiter
+}
+
+// FeedSupervisor returns the supervisor of the poloniex feed.
+func (wrapper *PoloniexWrapper) FeedSupervisor() *FeedSupervisor {
+	return wrapper.feeds
+}
+
 // GetMarkets gets all the markets info.
 func (wrapper *PoloniexWrapper) GetMarkets() ([]*environment.Market, error) {
-	poloniexMarkets, err := wrapper.api.Currencies()
+	if err := wrapper.limiter.Wait(context.Background(), "GetMarkets"); err != nil {
+		return nil, err
+	}
+	poloniexMarkets, err := wrapper.api.Ticker()
 	if err != nil {
 		return nil, err
 	}
 	wrappedMarkets := make([]*environment.Market, 0, len(poloniexMarkets))
-	for _, market := range poloniexMarkets {
-		if market.Disabled == 1 {
-			name := strings.SplitN(market.Name, "/", 2)
-			wrappedMarkets = append(wrappedMarkets, &environment.Market{
-				Name:           market.Name,
-				BaseCurrency:   name[1],
-				MarketCurrency: name[0],
-			})
+	for pair, market := range poloniexMarkets {
+		// pairs are in the form BTC_ETH, where BTC is the base currency.
+		currencies := strings.SplitN(pair, "_", 2)
+		if market.IsFrozen == 0 && len(currencies) == 2 {
+			wrappedMarkets = append(wrappedMarkets, newExchangeMarket(wrapper, pair, currencies[0], currencies[1], nil))
 		}
 	}
 	return wrappedMarkets, nil
 }
 
 // GetCandles gets the candle data from the exchange.
+//
+// NOTE: candles are 5 minutes long, unless a different time_frame is configured for the market,
+// in which case they are aggregated from the 5 minutes ones.
 func (wrapper *PoloniexWrapper) GetCandles(market *environment.Market) ([]environment.CandleStick, error) {
-	if !wrapper.websocketOn {
-		poloniesCandles, err := wrapper.api.ChartData(MarketNameFor(market, wrapper))
-		if err != nil {
-			return nil, err
-		}
+	timeFrame, _, err := candleTimeFrames(market, wrapper, poloniexTimeFrames, environment.TimeFrame(poloniexCandlePeriod))
+	if err != nil {
+		return nil, err
+	}
 
-		ret := make([]environment.CandleStick, len(poloniesCandles))
+	if err := wrapper.limiter.Wait(context.Background(), "GetCandles"); err != nil {
+		return nil, err
+	}
+	poloniesCandles, err := wrapper.api.ChartData(MarketNameFor(market, wrapper))
+	if err != nil {
+		return nil, err
+	}
 
-		for i, poloniexCandle := range poloniesCandles {
-			ret[i] = environment.CandleStick{
-				High:   decimal.NewFromFloat(poloniexCandle.High),
-				Open:   decimal.NewFromFloat(poloniexCandle.Open),
-				Close:  decimal.NewFromFloat(poloniexCandle.Close),
-				Low:    decimal.NewFromFloat(poloniexCandle.Low),
-				Volume: decimal.NewFromFloat(poloniexCandle.Volume),
-			}
+	ret := make([]environment.CandleStick, len(poloniesCandles))
+
+	for i, poloniexCandle := range poloniesCandles {
+		openTime := time.Unix(poloniexCandle.Date, 0)
+		ret[i] = environment.CandleStick{
+			High:      decimal.NewFromFloat(poloniexCandle.High),
+			Open:      decimal.NewFromFloat(poloniexCandle.Open),
+			Close:     decimal.NewFromFloat(poloniexCandle.Close),
+			Low:       decimal.NewFromFloat(poloniexCandle.Low),
+			Volume:    decimal.NewFromFloat(poloniexCandle.Volume),
+			OpenTime:  openTime,
+			CloseTime: openTime.Add(poloniexCandlePeriod),
+			Period:    poloniexCandlePeriod,
 		}
-
-		wrapper.candles.Set(market, ret)
 	}
 
-	ret, candleLoaded := wrapper.candles.Get(market)
-	if !candleLoaded {
-		return nil, errors.New("No candle data yet")
+	ret, err = AggregateCandles(ret, timeFrame)
+	if err != nil {
+		return nil, err
 	}
 
+	wrapper.candles.Set(market, ret)
+
 	return ret, nil
 }
 
 // GetOrderBook gets the order(ASK + BID) book of a market.
 func (wrapper *PoloniexWrapper) GetOrderBook(market *environment.Market) (*environment.OrderBook, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetOrderBook"); err != nil {
+		return nil, err
+	}
 	poloniexOrderBook, err := wrapper.api.OrderBook(MarketNameFor(market, wrapper))
 	if err != nil {
 		return nil, err
@@ -133,29 +176,157 @@ func (wrapper *PoloniexWrapper) GetOrderBook(market *environment.Market) (*envir
 }
 
 // BuyLimit performs a limit buy action.
-func (wrapper *PoloniexWrapper) BuyLimit(market *environment.Market, amount float64, limit float64) (string, error) {
-	orderNumber, err := wrapper.api.Buy(MarketNameFor(market, wrapper), amount, limit)
+func (wrapper *PoloniexWrapper) BuyLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	quantity, price, err := roundOrder(wrapper, market, environment.Bid, amount, limit)
+	if err != nil {
+		return "", err
+	}
+	if err := wrapper.limiter.Wait(context.Background(), "BuyLimit"); err != nil {
+		return "", err
+	}
+	// the client takes float64 values and sends them with 8 decimals (withdrawals with 6), so amounts are truncated
+	// and rates rounded to those decimals first: otherwise the formatting could round an amount up.
+	orderNumber, err := wrapper.api.Buy(MarketNameFor(market, wrapper), price.Round(8).InexactFloat64(), quantity.Truncate(8).InexactFloat64())
 	return fmt.Sprint(orderNumber.OrderNumber), err
 }
 
 // SellLimit performs a limit sell action.
-func (wrapper *PoloniexWrapper) SellLimit(market *environment.Market, amount float64, limit float64) (string, error) {
-	orderNumber, err := wrapper.api.Sell(MarketNameFor(market, wrapper), amount, limit)
+func (wrapper *PoloniexWrapper) SellLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	quantity, price, err := roundOrder(wrapper, market, environment.Ask, amount, limit)
+	if err != nil {
+		return "", err
+	}
+	if err := wrapper.limiter.Wait(context.Background(), "SellLimit"); err != nil {
+		return "", err
+	}
+	orderNumber, err := wrapper.api.Sell(MarketNameFor(market, wrapper), price.Round(8).InexactFloat64(), quantity.Truncate(8).InexactFloat64())
 	return fmt.Sprint(orderNumber.OrderNumber), err
 }
 
 // BuyMarket performs a market buy action.
-func (wrapper *PoloniexWrapper) BuyMarket(market *environment.Market, amount float64) (string, error) {
+func (wrapper *PoloniexWrapper) BuyMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
 	panic("Not supported on poloniex")
 }
 
 // SellMarket performs a market sell action.
-func (wrapper *PoloniexWrapper) SellMarket(market *environment.Market, amount float64) (string, error) {
+func (wrapper *PoloniexWrapper) SellMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
 	panic("Not supported on poloniex")
 }
 
+// GetOrder gets the status of an order placed on the exchange.
+//
+//     NOTE: Poloniex reports the status of open orders only, closed orders are rebuilt from their trades.
+func (wrapper *PoloniexWrapper) GetOrder(market *environment.Market, orderID string) (*environment.OrderInfo, error) {
+	orderNumber, err := strconv.ParseInt(orderID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	openOrders, err := wrapper.GetOpenOrders(market)
+	if err != nil {
+		return nil, err
+	}
+	for _, order := range openOrders {
+		if order.ID == orderID {
+			return order, nil
+		}
+	}
+
+	if err := wrapper.limiter.Wait(context.Background(), "GetOrder"); err != nil {
+		return nil, err
+	}
+	poloniexTrades, err := wrapper.api.OrderTrades(orderNumber)
+	if err != nil {
+		return nil, err
+	}
+	if len(poloniexTrades) == 0 {
+		return nil, ErrOrderNotFound
+	}
+
+	order := &environment.OrderInfo{
+		ID:     orderID,
+		Market: market,
+		Type:   environment.Bid,
+		Status: environment.OrderFilled,
+	}
+	if poloniexTrades[0].Type == "sell" {
+		order.Type = environment.Ask
+	}
+
+	filledValue := decimal.Zero
+	for _, trade := range poloniexTrades {
+		order.FilledQuantity = order.FilledQuantity.Add(decimal.NewFromFloat(trade.Amount))
+		filledValue = filledValue.Add(decimal.NewFromFloat(trade.Total))
+	}
+	order.Quantity = order.FilledQuantity
+	order.AveragePrice = filledValue.Div(order.FilledQuantity)
+
+	return order, nil
+}
+
+// GetOpenOrders gets the open orders of the user on a market.
+func (wrapper *PoloniexWrapper) GetOpenOrders(market *environment.Market) ([]*environment.OrderInfo, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetOpenOrders"); err != nil {
+		return nil, err
+	}
+	poloniexOrders, err := wrapper.api.OpenOrders(MarketNameFor(market, wrapper))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]*environment.OrderInfo, len(poloniexOrders))
+	for i, poloniexOrder := range poloniexOrders {
+		quantity := decimal.NewFromFloat(poloniexOrder.StartingAmount)
+		filledQuantity := quantity.Sub(decimal.NewFromFloat(poloniexOrder.Amount))
+		timestamp, _ := time.Parse("2006-01-02 15:04:05", poloniexOrder.Date)
+
+		ret[i] = &environment.OrderInfo{
+			ID:             fmt.Sprint(poloniexOrder.OrderNumber),
+			Market:         market,
+			Type:           environment.Bid,
+			Status:         orderStatusFor(quantity, filledQuantity, true),
+			Quantity:       quantity,
+			Limit:          decimal.NewFromFloat(poloniexOrder.Rate),
+			FilledQuantity: filledQuantity,
+			Timestamp:      timestamp,
+		}
+		if poloniexOrder.Type == "sell" {
+			ret[i].Type = environment.Ask
+		}
+		if filledQuantity.IsPositive() {
+			ret[i].AveragePrice = ret[i].Limit
+		}
+	}
+
+	return ret, nil
+}
+
+// CancelOrder cancels an open order.
+func (wrapper *PoloniexWrapper) CancelOrder(market *environment.Market, orderID string) error {
+	orderNumber, err := strconv.ParseInt(orderID, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	if err := wrapper.limiter.Wait(context.Background(), "CancelOrder"); err != nil {
+		return err
+	}
+	success, err := wrapper.api.CancelOrder(orderNumber)
+	if err != nil {
+		return err
+	}
+	if !success {
+		return fmt.Errorf("Cannot cancel order %s", orderID)
+	}
+
+	return nil
+}
+
 // GetTicker gets the updated ticker for a market.
 func (wrapper *PoloniexWrapper) GetTicker(market *environment.Market) (*environment.Ticker, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetTicker"); err != nil {
+		return nil, err
+	}
 	poloniexTicker, err := wrapper.api.Ticker()
 	if err != nil {
 		return nil, err
@@ -174,7 +345,10 @@ func (wrapper *PoloniexWrapper) GetTicker(market *environment.Market) (*environm
 
 // GetMarketSummary gets the current market summary.
 func (wrapper *PoloniexWrapper) GetMarketSummary(market *environment.Market) (*environment.MarketSummary, error) {
-	if !wrapper.websocketOn {
+	if !wrapper.feeds.Up(poloniexTickerFeed, market) {
+		if err := wrapper.limiter.Wait(context.Background(), "GetMarketSummary"); err != nil {
+			return nil, err
+		}
 		poloniexSummaries, err := wrapper.api.Ticker()
 		if err != nil {
 			return nil, err
@@ -203,6 +377,9 @@ func (wrapper *PoloniexWrapper) GetMarketSummary(market *environment.Market) (*e
 
 // GetBalance gets the balance of the user of the specified currency.
 func (wrapper *PoloniexWrapper) GetBalance(symbol string) (*decimal.Decimal, error) {
+	if err := wrapper.limiter.Wait(context.Background(), "GetBalance"); err != nil {
+		return nil, err
+	}
 	poloniexBalances, err := wrapper.api.Balances()
 	if err != nil {
 		return nil, err
@@ -227,73 +404,141 @@ func (wrapper *PoloniexWrapper) GetDepositAddress(coinTicker string) (string, bo
 // CalculateTradingFees calculates the trading fees for an order on a specified market.
 //
 //     NOTE: In Binance fees are currently hardcoded.
-func (wrapper *PoloniexWrapper) CalculateTradingFees(market *environment.Market, amount float64, limit float64, orderType TradeType) float64 {
+func (wrapper *PoloniexWrapper) CalculateTradingFees(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal, orderType TradeType) decimal.Decimal {
 	// NOTE: possibility to use wrapper FeesInfo function.
-	var feePercentage float64
+	var feePercentage decimal.Decimal
 	if orderType == MakerTrade {
-		feePercentage = 0.0010
+		feePercentage = decimal.NewFromFloat(0.0010)
 	} else if orderType == TakerTrade {
-		feePercentage = 0.0020
+		feePercentage = decimal.NewFromFloat(0.0020)
 	} else {
 		panic("Unknown trade type")
 	}
 
-	return amount * limit * feePercentage
+	return amount.Mul(limit).Mul(feePercentage)
 }
 
 // CalculateWithdrawFees calculates the withdrawal fees on a specified market.
-func (wrapper *PoloniexWrapper) CalculateWithdrawFees(market *environment.Market, amount float64) float64 {
+func (wrapper *PoloniexWrapper) CalculateWithdrawFees(market *environment.Market, amount decimal.Decimal) decimal.Decimal {
 	panic("Not Implemented")
 }
 
 // FeedConnect connects to the feed of the poloniex websocket.
 func (wrapper *PoloniexWrapper) FeedConnect(markets []*environment.Market) error {
-	wrapper.api.StartWS()
-	wrapper.websocketOn = true
+	return wrapper.FeedConnectContext(context.Background(), markets)
+}
 
+// FeedConnectContext connects to the feed of the poloniex websocket, closing it when the context is done.
+//
+// NOTE: summaries are received from the websocket, candles and order books are always fetched via REST.
+// The feed is supervised (see FeedSupervisor): summaries are fetched via REST while it is down.
+func (wrapper *PoloniexWrapper) FeedConnectContext(ctx context.Context, markets []*environment.Market) error {
+	feedMarkets := make(map[string]*environment.Market, len(markets))
 	for _, m := range markets {
-		wrapper.subscribeMarketSummaryFeed(m)
+		pairID, exists := wrapper.api.ByName[MarketNameFor(m, wrapper)]
+		if !exists {
+			return fmt.Errorf("Market %s not available in the poloniex feed", MarketNameFor(m, wrapper))
+		}
+		feedMarkets[pairID] = m
 	}
 
-	return nil
+	return wrapper.feeds.Start(ctx, poloniexTickerFeed, markets, func(ctx context.Context, _ []*environment.Market, link *FeedLink) error {
+		return wrapper.runFeed(ctx, feedMarkets, link)
+	})
 }
 
-// SubscribeMarketSummaryFeed subscribes to the Market Summary Feed service.
-func (wrapper *PoloniexWrapper) subscribeMarketSummaryFeed(market *environment.Market) {
-	if wrapper.websocketOn {
-		subTicker := fmt.Sprintf("ticker:%s", MarketNameFor(market, wrapper))
-		if len(wrapper.bindedTickers) == 0 {
-			wrapper.api.Subscribe("ticker")
-
-			wrapper.api.On("ticker", func(t poloniex.WSTicker) {
-				for bindedTicker := range wrapper.bindedTickers {
-					if bindedTicker == t.Pair {
-						wrapper.api.Emit(subTicker, t)
-					}
-				}
-			})
+// runFeed runs a connection to the ticker channel of the feed, updating the summaries of the markets by pair ID.
+func (wrapper *PoloniexWrapper) runFeed(ctx context.Context, markets map[string]*environment.Market, link *FeedLink) error {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, poloniexWebsocketURL, nil)
+	if err != nil {
+		return err
+	}
+
+	err = conn.WriteJSON(poloniexSubscribeMessage{
+		Command: "subscribe",
+		Channel: poloniexTickerChannel,
+	})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	link.Connected()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
 		}
+	}()
+	return wrapper.readFeed(conn, markets, link)
+}
 
-		if _, exists := wrapper.bindedTickers[MarketNameFor(market, wrapper)]; !exists {
-			wrapper.bindedTickers[MarketNameFor(market, wrapper)] = true
+// readFeed updates the summaries with the messages of the feed, until the connection is closed.
+func (wrapper *PoloniexWrapper) readFeed(conn *websocket.Conn, markets map[string]*environment.Market, link *FeedLink) error {
+	defer conn.Close()
 
-			wrapper.api.On(subTicker, func(t poloniex.WSTicker) {
-				wrapper.summaries.Set(market, &environment.MarketSummary{
-					High:   decimal.NewFromFloat(t.DailyHigh),
-					Low:    decimal.NewFromFloat(t.DailyLow),
-					Last:   decimal.NewFromFloat(t.Last),
-					Ask:    decimal.NewFromFloat(t.Ask),
-					Bid:    decimal.NewFromFloat(t.Bid),
-					Volume: decimal.NewFromFloat(t.BaseVolume),
-				})
-			})
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return err
 		}
+		link.Beat()
+
+		// [channel, sequence, payload], heartbeats are [1010].
+		var fields []json.RawMessage
+		if json.Unmarshal(message, &fields) != nil || len(fields) < 3 {
+			continue
+		}
+		var channel int
+		if json.Unmarshal(fields[0], &channel) != nil || channel != poloniexTickerChannel {
+			continue
+		}
+
+		// [pair ID, last, lowest ask, highest bid, percent change, base volume, quote volume, is frozen, 24h high, 24h low]
+		var ticker []interface{}
+		if json.Unmarshal(fields[2], &ticker) != nil || len(ticker) < 10 {
+			continue
+		}
+		market, exists := markets[fmt.Sprint(ticker[0])]
+		if !exists {
+			continue
+		}
+		wrapper.summaries.Set(market, &environment.MarketSummary{
+			High:   poloniexDecimal(ticker[8]),
+			Low:    poloniexDecimal(ticker[9]),
+			Last:   poloniexDecimal(ticker[1]),
+			Ask:    poloniexDecimal(ticker[2]),
+			Bid:    poloniexDecimal(ticker[3]),
+			Volume: poloniexDecimal(ticker[5]),
+		})
 	}
 }
 
+// poloniexSubscribeMessage represents a subscription request to a channel of the poloniex feed.
+type poloniexSubscribeMessage struct {
+	Command string `json:"command"`
+	Channel int    `json:"channel"`
+}
+
+// poloniexDecimal parses a decimal sent by the poloniex feed as a string, zero if it is not valid.
+func poloniexDecimal(value interface{}) decimal.Decimal {
+	text, _ := value.(string)
+	ret, err := decimal.NewFromString(text)
+	if err != nil {
+		return decimal.Zero
+	}
+	return ret
+}
+
 // Withdraw performs a withdraw operation from the exchange to a destination address.
-func (wrapper *PoloniexWrapper) Withdraw(destinationAddress string, coinTicker string, amount float64) error {
-	_, err := wrapper.api.Withdraw(coinTicker, amount, destinationAddress)
+func (wrapper *PoloniexWrapper) Withdraw(destinationAddress string, coinTicker string, amount decimal.Decimal) error {
+	if err := wrapper.limiter.Wait(context.Background(), "Withdraw"); err != nil {
+		return err
+	}
+	_, err := wrapper.api.Withdraw(coinTicker, amount.Truncate(6).InexactFloat64(), destinationAddress)
 	if err != nil {
 		return err
 	}
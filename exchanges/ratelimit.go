@@ -0,0 +1,333 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package exchanges
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+)
+
+// ErrRateLimited is the error representing a call refused because it exceeds the rate limit of the exchange.
+var ErrRateLimited = errors.New("Rate limit exceeded")
+
+// RateLimitError represents a call refused by a RateLimiter, wrapping ErrRateLimited.
+type RateLimitError struct {
+	Exchange  string    // Represents the name of the exchange.
+	Endpoint  string    // Represents the called endpoint.
+	Weight    int       // Represents the weight of the call.
+	Remaining int       // Represents the weight still available in the interval.
+	Reset     time.Time // Represents when the budget is available again.
+}
+
+// Error returns the description of the refused call.
+func (err *RateLimitError) Error() string {
+	return fmt.Sprintf("%s on %s calling %s: weight %d, remaining %d until %s", ErrRateLimited, err.Exchange, err.Endpoint, err.Weight, err.Remaining, err.Reset.Format(time.RFC3339))
+}
+
+// Unwrap returns ErrRateLimited.
+func (err *RateLimitError) Unwrap() error {
+	return ErrRateLimited
+}
+
+// RateBudget represents the state of the budget of a RateLimiter.
+type RateBudget struct {
+	Weight    int       // Represents the total weight of the calls allowed in an interval, zero if unlimited.
+	Used      int       // Represents the weight used in the current interval.
+	Remaining int       // Represents the weight still available in the current interval.
+	Reset     time.Time // Represents when the next interval starts (or the exchange accepts calls again, if blocked).
+	Blocked   bool      // Represents whether the exchange refused a call asking to retry later (e.g. HTTP 429).
+}
+
+// RateLimiter represents the budget of the calls to the API of an exchange, shared by all the users of its wrapper.
+//
+// Each call consumes the weight of its endpoint (1 if not specified) from the budget of a fixed interval;
+// the calls exceeding it wait for the next interval, or are refused with a RateLimitError when rejecting.
+// The budget is corrected by the rate limit headers of the responses, when the exchange sends them.
+type RateLimiter struct {
+	name       string
+	mutex      *sync.Mutex
+	weight     int
+	interval   time.Duration
+	weights    map[string]int // by endpoint (the name of the wrapper method).
+	reject     bool
+	usedHeader string    // header reporting the weight used in the interval, if any.
+	used       int       // weight used in the current interval.
+	window     time.Time // start of the current interval.
+	blocked    time.Time // until when the exchange refuses the calls.
+	now        func() time.Time
+}
+
+// NewRateLimiter creates a new budget of the specified weight per interval for the calls to an exchange,
+// weights are the weights of the endpoints, by wrapper method name.
+func NewRateLimiter(name string, weight int, interval time.Duration, weights map[string]int) *RateLimiter {
+	limiter := &RateLimiter{
+		name:     name,
+		mutex:    &sync.Mutex{},
+		weight:   weight,
+		interval: interval,
+		weights:  make(map[string]int, len(weights)),
+		now:      time.Now,
+	}
+	for endpoint, endpointWeight := range weights {
+		limiter.weights[endpoint] = endpointWeight
+	}
+	return limiter
+}
+
+// Configure applies a configuration to the budget, keeping the current value of the omitted fields.
+func (limiter *RateLimiter) Configure(config environment.RateLimitConfig) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	if config.Weight > 0 {
+		limiter.weight = config.Weight
+	}
+	if config.Interval > 0 {
+		limiter.interval = config.Interval
+		limiter.window = time.Time{}
+	}
+	for endpoint, weight := range config.Weights {
+		limiter.weights[endpoint] = weight
+	}
+	limiter.reject = config.Reject
+}
+
+// Weight returns the weight of an endpoint.
+func (limiter *RateLimiter) Weight(endpoint string) int {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	return limiter.endpointWeight(endpoint)
+}
+
+// endpointWeight returns the weight of an endpoint.
+// Must be called with the mutex locked.
+func (limiter *RateLimiter) endpointWeight(endpoint string) int {
+	if weight, exists := limiter.weights[endpoint]; exists {
+		return weight
+	}
+	return 1
+}
+
+// Budget returns the current state of the budget, unlimited for a nil limiter.
+func (limiter *RateLimiter) Budget() RateBudget {
+	if limiter == nil {
+		return RateBudget{}
+	}
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := limiter.now()
+	limiter.roll(now)
+	ret := RateBudget{
+		Weight:    limiter.weight,
+		Used:      limiter.used,
+		Remaining: limiter.weight - limiter.used,
+		Reset:     limiter.window.Add(limiter.interval),
+	}
+	if ret.Remaining < 0 {
+		ret.Remaining = 0
+	}
+	if now.Before(limiter.blocked) {
+		ret.Remaining = 0
+		ret.Reset = limiter.blocked
+		ret.Blocked = true
+	}
+	return ret
+}
+
+// Wait consumes the weight of an endpoint from the budget, waiting until it is available
+// (or refusing the call when rejecting); a nil limiter never waits.
+func (limiter *RateLimiter) Wait(ctx context.Context, endpoint string) error {
+	if limiter == nil {
+		return nil
+	}
+
+	for {
+		delay, err := limiter.reserve(endpoint)
+		if err != nil || delay <= 0 {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// reserve consumes the weight of an endpoint if available, otherwise returns how long to wait for it.
+func (limiter *RateLimiter) reserve(endpoint string) (time.Duration, error) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := limiter.now()
+	limiter.roll(now)
+	weight := limiter.endpointWeight(endpoint)
+
+	var reset time.Time
+	switch {
+	case now.Before(limiter.blocked):
+		reset = limiter.blocked
+	case limiter.weight <= 0 || limiter.used+weight <= limiter.weight || limiter.used == 0:
+		// calls heavier than the whole budget are allowed alone in an interval.
+		limiter.used += weight
+		return 0, nil
+	default:
+		reset = limiter.window.Add(limiter.interval)
+	}
+
+	if limiter.reject {
+		remaining := limiter.weight - limiter.used
+		if remaining < 0 || now.Before(limiter.blocked) {
+			remaining = 0
+		}
+		return 0, &RateLimitError{
+			Exchange:  limiter.name,
+			Endpoint:  endpoint,
+			Weight:    weight,
+			Remaining: remaining,
+			Reset:     reset,
+		}
+	}
+	return reset.Sub(now), nil
+}
+
+// roll starts a new interval if the current one is over.
+// Must be called with the mutex locked.
+func (limiter *RateLimiter) roll(now time.Time) {
+	if limiter.interval <= 0 {
+		return
+	}
+	if window := now.Truncate(limiter.interval); !window.Equal(limiter.window) {
+		limiter.window = window
+		limiter.used = 0
+	}
+}
+
+// HTTPClient returns a copy of an HTTP client (the default one if nil) whose responses update the budget.
+func (limiter *RateLimiter) HTTPClient(client *http.Client) *http.Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	ret := *client
+	ret.Transport = &rateLimitTransport{
+		limiter: limiter,
+		base:    client.Transport,
+	}
+	return &ret
+}
+
+// observe updates the budget from the rate limit headers of a response.
+func (limiter *RateLimiter) observe(resp *http.Response) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := limiter.now()
+	limiter.roll(now)
+
+	used := -1
+	if limiter.usedHeader != "" {
+		used = headerInt(resp.Header, limiter.usedHeader)
+	}
+	if used < 0 && limiter.weight > 0 {
+		for _, header := range []string{"X-RateLimit-Remaining", "Gw-Ratelimit-Remaining"} {
+			if remaining := headerInt(resp.Header, header); remaining >= 0 {
+				used = limiter.weight - remaining
+				break
+			}
+		}
+	}
+	// the exchange also counts the calls of other clients, never lower the local count.
+	if used > limiter.used {
+		limiter.used = used
+	}
+
+	// 418 is returned by binance when banning an IP which kept calling after a 429.
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusTeapot {
+		until := limiter.window.Add(limiter.interval)
+		if seconds := headerInt(resp.Header, "Retry-After"); seconds >= 0 {
+			until = now.Add(time.Duration(seconds) * time.Second)
+		} else if date, err := http.ParseTime(resp.Header.Get("Retry-After")); err == nil {
+			until = date
+		}
+		if until.After(limiter.blocked) {
+			limiter.blocked = until
+		}
+	}
+}
+
+// headerInt returns the value of a numeric header, -1 if missing or invalid.
+func headerInt(header http.Header, name string) int {
+	value, err := strconv.Atoi(header.Get(name))
+	if err != nil {
+		return -1
+	}
+	return value
+}
+
+// rateLimitTransport updates a budget from the responses of the requests it sends.
+type rateLimitTransport struct {
+	limiter *RateLimiter
+	base    http.RoundTripper // nil for http.DefaultTransport.
+}
+
+// RoundTrip sends a request, updating the budget from its response.
+func (transport *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := transport.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err == nil {
+		transport.limiter.observe(resp)
+	}
+	return resp, err
+}
+
+// rateLimited is implemented by wrappers limiting the rate of their calls to the exchange.
+type rateLimited interface {
+	RateLimiter() *RateLimiter
+}
+
+// GetRateLimiter gets the budget of the calls to the exchange of a wrapper, also when the wrapper decorates
+// the one of the exchange (see IsExchange); nil if the calls are not limited.
+//
+// Strategies can check the remaining budget (see RateLimiter.Budget) before polling the exchange.
+func GetRateLimiter(wrapper ExchangeWrapper) *RateLimiter {
+	for {
+		if limited, isLimited := wrapper.(rateLimited); isLimited {
+			return limited.RateLimiter()
+		}
+
+		decorator, isDecorator := wrapper.(interface{ Unwrap() ExchangeWrapper })
+		if !isDecorator {
+			return nil
+		}
+		wrapper = decorator.Unwrap()
+	}
+}
@@ -0,0 +1,255 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package exchanges
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/sirupsen/logrus"
+)
+
+// RecorderConfig contains the parameters of a market data recording.
+type RecorderConfig struct {
+	OutputDir     string        // Represents the directory where recordings are written, in a subdirectory per exchange.
+	Interval      time.Duration // Represents the time between two snapshots.
+	Rotation      time.Duration // Represents the time after which a new recording file is started.
+	Compress      bool          // If true, recording files are gzip compressed.
+	RecordCandles bool          // If true, candles are recorded along with summaries and order books.
+}
+
+// recordingFile represents an open recording file.
+type recordingFile struct {
+	file    *os.File
+	gzip    *gzip.Writer
+	buffer  *bufio.Writer
+	encoder *json.Encoder
+	opened  time.Time
+}
+
+func (rf *recordingFile) flush() error {
+	err := rf.buffer.Flush()
+	if err != nil {
+		return err
+	}
+	if rf.gzip != nil {
+		return rf.gzip.Flush()
+	}
+	return nil
+}
+
+func (rf *recordingFile) close() error {
+	err := rf.buffer.Flush()
+	if err != nil {
+		return err
+	}
+	if rf.gzip != nil {
+		err = rf.gzip.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return rf.file.Close()
+}
+
+// Recorder periodically snapshots the market data of a set of wrappers to disk, in the format read by ReplayWrapper.
+//
+// When a wrapper feed is connected, the snapshots are taken from its local caches,
+// otherwise the data is polled via REST.
+type Recorder struct {
+	config   RecorderConfig
+	wrappers []ExchangeWrapper
+	markets  []*environment.Market
+	files    map[string]*recordingFile // exchange name -> current recording file
+}
+
+// NewRecorder creates a new recorder of the specified markets on the specified wrappers.
+func NewRecorder(wrappers []ExchangeWrapper, markets []*environment.Market, config RecorderConfig) *Recorder {
+	return &Recorder{
+		config:   config,
+		wrappers: wrappers,
+		markets:  markets,
+		files:    make(map[string]*recordingFile),
+	}
+}
+
+// Start connects to the feed of each wrapper, falling back to REST polling if websocket is not supported.
+func (recorder *Recorder) Start() error {
+	for _, wrapper := range recorder.wrappers {
+		err := wrapper.FeedConnect(recorder.marketsOf(wrapper))
+		if err == ErrWebsocketNotSupported {
+			logrus.Infof("%s does not support websocket, polling via REST", wrapper)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run takes a snapshot every interval until stop is closed, then closes the recording files.
+func (recorder *Recorder) Run(stop <-chan struct{}) error {
+	ticker := time.NewTicker(recorder.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return recorder.Close()
+		case now := <-ticker.C:
+			err := recorder.Snapshot(now.UTC())
+			if err != nil {
+				recorder.Close()
+				return err
+			}
+		}
+	}
+}
+
+// marketsOf returns the markets binded to a wrapper.
+func (recorder *Recorder) marketsOf(wrapper ExchangeWrapper) []*environment.Market {
+	ret := make([]*environment.Market, 0, len(recorder.markets))
+	for _, market := range recorder.markets {
+		if MarketNameFor(market, wrapper) != "" {
+			ret = append(ret, market)
+		}
+	}
+	return ret
+}
+
+// Snapshot writes a snapshot of the data of each market, rotating the recording files if needed.
+//
+// Data which cannot be retrieved is logged and omitted from the snapshot.
+func (recorder *Recorder) Snapshot(now time.Time) error {
+	for _, wrapper := range recorder.wrappers {
+		rf, err := recorder.fileFor(wrapper, now)
+		if err != nil {
+			return err
+		}
+
+		for _, market := range recorder.marketsOf(wrapper) {
+			record := ReplayRecord{
+				Time:   now,
+				Market: MarketNameFor(market, wrapper),
+			}
+
+			record.Summary, err = wrapper.GetMarketSummary(market)
+			if err != nil {
+				logrus.Warnf("%s %s summary: %s", wrapper, market, err)
+			}
+			record.OrderBook, err = wrapper.GetOrderBook(market)
+			if err != nil {
+				logrus.Warnf("%s %s orderbook: %s", wrapper, market, err)
+			}
+			if recorder.config.RecordCandles {
+				record.Candles, err = getCandlesSafely(wrapper, market)
+				if err != nil {
+					logrus.Warnf("%s %s candles: %s", wrapper, market, err)
+				}
+			}
+
+			if record.Summary == nil && record.OrderBook == nil && record.Candles == nil {
+				continue
+			}
+			err = rf.encoder.Encode(record)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = rf.flush()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getCandlesSafely gets the candles of a market, turning the panics of wrappers not supporting them into errors.
+func getCandlesSafely(wrapper ExchangeWrapper, market *environment.Market) (candles []environment.CandleStick, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return wrapper.GetCandles(market)
+}
+
+// fileFor returns the current recording file of a wrapper, opening a new one if rotation is due.
+func (recorder *Recorder) fileFor(wrapper ExchangeWrapper, now time.Time) (*recordingFile, error) {
+	rf, exists := recorder.files[wrapper.Name()]
+	if exists && (recorder.config.Rotation <= 0 || now.Sub(rf.opened) < recorder.config.Rotation) {
+		return rf, nil
+	}
+	if exists {
+		err := rf.close()
+		if err != nil {
+			return nil, err
+		}
+		delete(recorder.files, wrapper.Name())
+	}
+
+	dir := filepath.Join(recorder.config.OutputDir, wrapper.Name())
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	fileName := fmt.Sprintf("%s-%s.jsonl", wrapper.Name(), now.Format("20060102T150405Z"))
+	if recorder.config.Compress {
+		fileName += ".gz"
+	}
+	file, err := os.Create(filepath.Join(dir, fileName))
+	if err != nil {
+		return nil, err
+	}
+
+	rf = &recordingFile{
+		file:   file,
+		opened: now,
+	}
+	var w io.Writer = file
+	if recorder.config.Compress {
+		rf.gzip = gzip.NewWriter(file)
+		w = rf.gzip
+	}
+	rf.buffer = bufio.NewWriter(w)
+	rf.encoder = json.NewEncoder(rf.buffer)
+
+	recorder.files[wrapper.Name()] = rf
+	return rf, nil
+}
+
+// Close closes all the recording files.
+func (recorder *Recorder) Close() error {
+	var firstErr error
+	for name, rf := range recorder.files {
+		err := rf.close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(recorder.files, name)
+	}
+	return firstErr
+}
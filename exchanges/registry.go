@@ -0,0 +1,79 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package exchanges
+
+import (
+	"sort"
+
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+)
+
+// ExchangeFactory creates the wrapper of an exchange from its configuration.
+type ExchangeFactory func(config environment.ExchangeConfig) (ExchangeWrapper, error)
+
+var factories map[string]ExchangeFactory //mapped name -> factory
+
+func init() {
+	factories = make(map[string]ExchangeFactory)
+
+	Register("bittrex", func(config environment.ExchangeConfig) (ExchangeWrapper, error) {
+		return NewBittrexWrapper(config.PublicKey, config.SecretKey, config.DepositAddresses), nil
+	})
+	Register("bittrexV2", func(config environment.ExchangeConfig) (ExchangeWrapper, error) {
+		return NewBittrexV2Wrapper(config.PublicKey, config.SecretKey, config.DepositAddresses), nil
+	})
+	Register("poloniex", func(config environment.ExchangeConfig) (ExchangeWrapper, error) {
+		return NewPoloniexWrapper(config.PublicKey, config.SecretKey, config.DepositAddresses), nil
+	})
+	Register("binance", func(config environment.ExchangeConfig) (ExchangeWrapper, error) {
+		return NewBinanceWrapper(config.PublicKey, config.SecretKey, config.DepositAddresses), nil
+	})
+	Register("bitfinex", func(config environment.ExchangeConfig) (ExchangeWrapper, error) {
+		return NewBitfinexWrapper(config.PublicKey, config.SecretKey, config.DepositAddresses), nil
+	})
+	Register("hitbtc", func(config environment.ExchangeConfig) (ExchangeWrapper, error) {
+		return NewHitBtcV2Wrapper(config.PublicKey, config.SecretKey, config.DepositAddresses), nil
+	})
+	Register("kucoin", func(config environment.ExchangeConfig) (ExchangeWrapper, error) {
+		return NewKucoinWrapper(config.PublicKey, config.SecretKey, config.DepositAddresses), nil
+	})
+	Register("kraken", func(config environment.ExchangeConfig) (ExchangeWrapper, error) {
+		return NewKrakenWrapper(config.PublicKey, config.SecretKey, config.DepositAddresses), nil
+	})
+}
+
+// Register adds an exchange to the available set, so that it can be selected by name in the configuration.
+//
+// Registering a name again replaces the previous factory (e.g. to customize a built-in exchange).
+func Register(name string, factory ExchangeFactory) {
+	factories[name] = factory
+}
+
+// GetFactory gets the factory of an exchange from the available set, by name.
+func GetFactory(name string) (ExchangeFactory, bool) {
+	factory, exists := factories[name]
+	return factory, exists
+}
+
+// RegisteredNames returns the names of the available exchanges, sorted.
+func RegisteredNames() []string {
+	ret := make([]string, 0, len(factories))
+	for name := range factories {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
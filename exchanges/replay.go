@@ -0,0 +1,600 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package exchanges
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/shopspring/decimal"
+)
+
+// VirtualClock represents a clock which can be driven manually or played back at a custom speed.
+type VirtualClock struct {
+	mutex   *sync.RWMutex
+	now     time.Time
+	playing bool
+}
+
+// NewVirtualClock creates a new virtual clock set at the specified time.
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{
+		mutex: &sync.RWMutex{},
+		now:   start,
+	}
+}
+
+// Now returns the current virtual time.
+func (clock *VirtualClock) Now() time.Time {
+	clock.mutex.RLock()
+	defer clock.mutex.RUnlock()
+	return clock.now
+}
+
+// Set sets the current virtual time.
+func (clock *VirtualClock) Set(t time.Time) {
+	clock.mutex.Lock()
+	clock.now = t
+	clock.mutex.Unlock()
+}
+
+// Advance moves the virtual time forward by the specified duration.
+func (clock *VirtualClock) Advance(d time.Duration) {
+	clock.mutex.Lock()
+	clock.now = clock.now.Add(d)
+	clock.mutex.Unlock()
+}
+
+// Play advances the virtual time in background, speed times faster than real time.
+//
+// Calling Play on an already playing clock does nothing.
+func (clock *VirtualClock) Play(speed float64) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	if clock.playing {
+		return
+	}
+	clock.playing = true
+
+	const tick = 100 * time.Millisecond
+	go func() {
+		for range time.Tick(tick) {
+			clock.Advance(time.Duration(float64(tick) * speed))
+		}
+	}()
+}
+
+// CandleSeries represents a recorded series of candles of a market.
+type CandleSeries struct {
+	Period  time.Duration             // Represents the period of each candle.
+	Times   []time.Time               // Represents the open time of each candle.
+	Candles []environment.CandleStick // Represents the candles, in chronological order.
+}
+
+// Len returns the number of candles in the series.
+func (series *CandleSeries) Len() int {
+	return len(series.Candles)
+}
+
+// CloseTime returns the close time of the i-th candle of the series.
+func (series *CandleSeries) CloseTime(i int) time.Time {
+	return series.Times[i].Add(series.Period)
+}
+
+// ClosedBefore returns the number of candles which are closed at the specified time.
+func (series *CandleSeries) ClosedBefore(t time.Time) int {
+	return sort.Search(series.Len(), func(i int) bool {
+		return series.CloseTime(i).After(t)
+	})
+}
+
+// LoadCandles loads a candle series from a CSV file.
+//
+// Each row must be in the form time,open,high,low,close,volume where time is the
+// open time of the candle expressed as a unix timestamp (seconds) or in RFC3339 format.
+// An optional header row is skipped.
+func LoadCandles(path string) (*CandleSeries, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadCandles(file)
+}
+
+// ReadCandles reads a candle series in CSV format (see LoadCandles) from a reader.
+func ReadCandles(r io.Reader) (*CandleSeries, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 6
+	reader.TrimLeadingSpace = true
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	series := &CandleSeries{}
+	for i, row := range rows {
+		openTime, err := parseRecordedTime(row[0])
+		if err != nil {
+			if i == 0 { // header row
+				continue
+			}
+			return nil, fmt.Errorf("Invalid time at row %d: %s", i+1, err)
+		}
+
+		values := make([]decimal.Decimal, 5)
+		for j := range values {
+			values[j], err = decimal.NewFromString(row[j+1])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid value at row %d: %s", i+1, err)
+			}
+		}
+
+		series.Times = append(series.Times, openTime)
+		series.Candles = append(series.Candles, environment.CandleStick{
+			Open:   values[0],
+			High:   values[1],
+			Low:    values[2],
+			Close:  values[3],
+			Volume: values[4],
+		})
+	}
+
+	sort.Sort(byOpenTime{series})
+
+	for i := 1; i < series.Len(); i++ {
+		diff := series.Times[i].Sub(series.Times[i-1])
+		if diff > 0 && (series.Period == 0 || diff < series.Period) {
+			series.Period = diff
+		}
+	}
+
+	for i := range series.Candles {
+		series.Candles[i].OpenTime = series.Times[i]
+		series.Candles[i].CloseTime = series.CloseTime(i)
+		series.Candles[i].Period = series.Period
+	}
+
+	return series, nil
+}
+
+func parseRecordedTime(value string) (time.Time, error) {
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(unix, 0).UTC(), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// byOpenTime sorts a series chronologically.
+type byOpenTime struct {
+	series *CandleSeries
+}
+
+func (s byOpenTime) Len() int {
+	return s.series.Len()
+}
+
+func (s byOpenTime) Less(i, j int) bool {
+	return s.series.Times[i].Before(s.series.Times[j])
+}
+
+func (s byOpenTime) Swap(i, j int) {
+	s.series.Times[i], s.series.Times[j] = s.series.Times[j], s.series.Times[i]
+	s.series.Candles[i], s.series.Candles[j] = s.series.Candles[j], s.series.Candles[i]
+}
+
+// ReplayRecord represents a snapshot of the data of a market, as stored in a recording.
+type ReplayRecord struct {
+	Time      time.Time                  `json:"time"`                // Represents the time of the snapshot.
+	Market    string                     `json:"market"`              // Represents the name of the market as seen from the exchange.
+	Summary   *environment.MarketSummary `json:"summary,omitempty"`   // Represents the market summary, if recorded.
+	OrderBook *environment.OrderBook     `json:"orderbook,omitempty"` // Represents the order book, if recorded.
+	Candles   []environment.CandleStick  `json:"candles,omitempty"`   // Represents the candles, if recorded.
+}
+
+// ReadRecords reads a recording in JSONL format (one ReplayRecord per line) from a reader.
+//
+// A truncated last record, as left by an interrupted recording, is ignored.
+func ReadRecords(r io.Reader) ([]ReplayRecord, error) {
+	var records []ReplayRecord
+	decoder := json.NewDecoder(r)
+	for {
+		var record ReplayRecord
+		err := decoder.Decode(&record)
+		if err == io.EOF || err == io.ErrUnexpectedEOF && len(records) > 0 {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+}
+
+// LoadRecords loads a recording in JSONL format, gzip compressed if the file name ends with .gz.
+func LoadRecords(path string) ([]ReplayRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	return ReadRecords(reader)
+}
+
+// replayMarket contains the recorded data of a single market, in chronological order.
+type replayMarket struct {
+	candleSeries *CandleSeries
+	summaries    []*ReplayRecord
+	orderbooks   []*ReplayRecord
+	candles      []*ReplayRecord
+}
+
+// latest returns the last record at or before the specified time.
+func latest(records []*ReplayRecord, t time.Time) *ReplayRecord {
+	i := sort.Search(len(records), func(i int) bool {
+		return records[i].Time.After(t)
+	})
+	if i == 0 {
+		return nil
+	}
+	return records[i-1]
+}
+
+// ReplayWrapper serves market data from local recordings, following a virtual clock.
+//
+// It cannot place orders nor hold balances: wrap it with NewExchangeWrapperSimulator
+// to obtain a fully offline simulation.
+type ReplayWrapper struct {
+	name    string
+	clock   *VirtualClock
+	mutex   *sync.RWMutex
+	markets map[string]*replayMarket // exchange market name -> data
+}
+
+// NewReplayWrapper creates a new replay wrapper impersonating the specified exchange,
+// driven by the specified clock.
+func NewReplayWrapper(name string, clock *VirtualClock) *ReplayWrapper {
+	return &ReplayWrapper{
+		name:    name,
+		clock:   clock,
+		mutex:   &sync.RWMutex{},
+		markets: make(map[string]*replayMarket),
+	}
+}
+
+// Name returns the name of the replayed exchange.
+func (wrapper *ReplayWrapper) Name() string {
+	return wrapper.name
+}
+
+// GetMarkets is not supported while replaying, since the currencies of the markets are not recorded.
+func (wrapper *ReplayWrapper) GetMarkets() ([]*environment.Market, error) {
+	return nil, errors.New("Markets info not available in replay mode")
+}
+
+// String returns a string representation of the replay wrapper.
+func (wrapper *ReplayWrapper) String() string {
+	return fmt.Sprint(wrapper.name, "replay")
+}
+
+// Clock returns the virtual clock driving the replay.
+func (wrapper *ReplayWrapper) Clock() *VirtualClock {
+	return wrapper.clock
+}
+
+func (wrapper *ReplayWrapper) market(marketName string) *replayMarket {
+	m, exists := wrapper.markets[marketName]
+	if !exists {
+		m = &replayMarket{}
+		wrapper.markets[marketName] = m
+	}
+	return m
+}
+
+// AddCandles binds a candle series to a market, identified by its name as seen from the exchange.
+func (wrapper *ReplayWrapper) AddCandles(marketName string, series *CandleSeries) {
+	wrapper.mutex.Lock()
+	wrapper.market(marketName).candleSeries = series
+	wrapper.mutex.Unlock()
+}
+
+// AddRecords adds recorded snapshots to the replay.
+func (wrapper *ReplayWrapper) AddRecords(records []ReplayRecord) {
+	wrapper.mutex.Lock()
+	defer wrapper.mutex.Unlock()
+
+	touched := make(map[*replayMarket]bool)
+	for i := range records {
+		record := &records[i]
+		m := wrapper.market(record.Market)
+		if record.Summary != nil {
+			m.summaries = append(m.summaries, record)
+		}
+		if record.OrderBook != nil {
+			m.orderbooks = append(m.orderbooks, record)
+		}
+		if record.Candles != nil {
+			m.candles = append(m.candles, record)
+		}
+		touched[m] = true
+	}
+
+	for m := range touched {
+		for _, records := range [][]*ReplayRecord{m.summaries, m.orderbooks, m.candles} {
+			sort.SliceStable(records, func(i, j int) bool {
+				return records[i].Time.Before(records[j].Time)
+			})
+		}
+	}
+}
+
+// LoadDir loads all the recordings contained in a directory.
+//
+// Files named <market_name>.csv are loaded as candle series (see LoadCandles),
+// files ending with .jsonl or .jsonl.gz are loaded as recorded snapshots (see LoadRecords).
+func (wrapper *ReplayWrapper) LoadDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+
+		switch {
+		case strings.HasSuffix(entry.Name(), ".csv"):
+			series, err := LoadCandles(path)
+			if err != nil {
+				return fmt.Errorf("%s: %s", path, err)
+			}
+			wrapper.AddCandles(strings.TrimSuffix(entry.Name(), ".csv"), series)
+		case strings.HasSuffix(entry.Name(), ".jsonl"), strings.HasSuffix(entry.Name(), ".jsonl.gz"):
+			records, err := LoadRecords(path)
+			if err != nil {
+				return fmt.Errorf("%s: %s", path, err)
+			}
+			wrapper.AddRecords(records)
+		}
+	}
+
+	return nil
+}
+
+// Bounds returns the time of the first and of the last recorded data.
+func (wrapper *ReplayWrapper) Bounds() (time.Time, time.Time, error) {
+	wrapper.mutex.RLock()
+	defer wrapper.mutex.RUnlock()
+
+	var start, end time.Time
+	found := false
+	extend := func(first, last time.Time) {
+		if !found || first.Before(start) {
+			start = first
+		}
+		if !found || last.After(end) {
+			end = last
+		}
+		found = true
+	}
+
+	for _, m := range wrapper.markets {
+		if m.candleSeries != nil && m.candleSeries.Len() > 0 {
+			extend(m.candleSeries.CloseTime(0), m.candleSeries.CloseTime(m.candleSeries.Len()-1))
+		}
+		for _, records := range [][]*ReplayRecord{m.summaries, m.orderbooks, m.candles} {
+			if len(records) > 0 {
+				extend(records[0].Time, records[len(records)-1].Time)
+			}
+		}
+	}
+
+	if !found {
+		return start, end, errors.New("No recorded data")
+	}
+	return start, end, nil
+}
+
+// closedCandles returns the candles of the series closed at the current virtual time.
+func (wrapper *ReplayWrapper) closedCandles(m *replayMarket) []environment.CandleStick {
+	if m.candleSeries == nil {
+		return nil
+	}
+	return m.candleSeries.Candles[:m.candleSeries.ClosedBefore(wrapper.clock.Now())]
+}
+
+func (wrapper *ReplayWrapper) replayedMarket(market *environment.Market) (*replayMarket, error) {
+	m, exists := wrapper.markets[MarketNameFor(market, wrapper)]
+	if !exists {
+		return nil, fmt.Errorf("No recorded data for market %s", market)
+	}
+	return m, nil
+}
+
+// GetCandles gets the candles recorded at the current virtual time.
+func (wrapper *ReplayWrapper) GetCandles(market *environment.Market) ([]environment.CandleStick, error) {
+	wrapper.mutex.RLock()
+	defer wrapper.mutex.RUnlock()
+
+	m, err := wrapper.replayedMarket(market)
+	if err != nil {
+		return nil, err
+	}
+
+	if record := latest(m.candles, wrapper.clock.Now()); record != nil {
+		return record.Candles, nil
+	}
+	if candles := wrapper.closedCandles(m); len(candles) > 0 {
+		candles, err = AggregateCandles(candles, MarketTimeFrameFor(market, wrapper))
+		if err != nil {
+			return nil, err
+		}
+		return append([]environment.CandleStick(nil), candles...), nil
+	}
+	return nil, errors.New("No candle data yet")
+}
+
+// GetMarketSummary gets the market summary recorded at the current virtual time.
+//
+// If no summary has been recorded, it is built from the last closed candle.
+func (wrapper *ReplayWrapper) GetMarketSummary(market *environment.Market) (*environment.MarketSummary, error) {
+	wrapper.mutex.RLock()
+	defer wrapper.mutex.RUnlock()
+
+	m, err := wrapper.replayedMarket(market)
+	if err != nil {
+		return nil, err
+	}
+
+	if record := latest(m.summaries, wrapper.clock.Now()); record != nil {
+		return record.Summary, nil
+	}
+	if candles := wrapper.closedCandles(m); len(candles) > 0 {
+		last := candles[len(candles)-1]
+		return &environment.MarketSummary{
+			High:   last.High,
+			Low:    last.Low,
+			Volume: last.Volume,
+			Ask:    last.Close,
+			Bid:    last.Close,
+			Last:   last.Close,
+		}, nil
+	}
+	return nil, errors.New("Summary not loaded")
+}
+
+// GetOrderBook gets the order book recorded at the current virtual time.
+//
+// If no order book has been recorded, a single level per side is built from the last closed candle,
+// with its volume as depth.
+func (wrapper *ReplayWrapper) GetOrderBook(market *environment.Market) (*environment.OrderBook, error) {
+	wrapper.mutex.RLock()
+	defer wrapper.mutex.RUnlock()
+
+	m, err := wrapper.replayedMarket(market)
+	if err != nil {
+		return nil, err
+	}
+
+	if record := latest(m.orderbooks, wrapper.clock.Now()); record != nil {
+		return record.OrderBook, nil
+	}
+	if candles := wrapper.closedCandles(m); len(candles) > 0 {
+		last := candles[len(candles)-1]
+		level := environment.Order{
+			Value:     last.Close,
+			Quantity:  last.Volume,
+			Timestamp: wrapper.clock.Now(),
+		}
+		return &environment.OrderBook{
+			Asks: []environment.Order{level},
+			Bids: []environment.Order{level},
+		}, nil
+	}
+	return nil, errors.New("Orderbook not loaded")
+}
+
+// BuyLimit is not supported by the replay wrapper, wrap it with NewExchangeWrapperSimulator.
+func (wrapper *ReplayWrapper) BuyLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return "", errors.New("Cannot place orders on a replay, use a simulator")
+}
+
+// SellLimit is not supported by the replay wrapper, wrap it with NewExchangeWrapperSimulator.
+func (wrapper *ReplayWrapper) SellLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return "", errors.New("Cannot place orders on a replay, use a simulator")
+}
+
+// BuyMarket is not supported by the replay wrapper, wrap it with NewExchangeWrapperSimulator.
+func (wrapper *ReplayWrapper) BuyMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
+	return "", errors.New("Cannot place orders on a replay, use a simulator")
+}
+
+// SellMarket is not supported by the replay wrapper, wrap it with NewExchangeWrapperSimulator.
+func (wrapper *ReplayWrapper) SellMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
+	return "", errors.New("Cannot place orders on a replay, use a simulator")
+}
+
+// GetOrder is not supported by the replay wrapper, wrap it with NewExchangeWrapperSimulator.
+func (wrapper *ReplayWrapper) GetOrder(market *environment.Market, orderID string) (*environment.OrderInfo, error) {
+	return nil, errors.New("Cannot query orders on a replay, use a simulator")
+}
+
+// GetOpenOrders is not supported by the replay wrapper, wrap it with NewExchangeWrapperSimulator.
+func (wrapper *ReplayWrapper) GetOpenOrders(market *environment.Market) ([]*environment.OrderInfo, error) {
+	return nil, errors.New("Cannot query orders on a replay, use a simulator")
+}
+
+// CancelOrder is not supported by the replay wrapper, wrap it with NewExchangeWrapperSimulator.
+func (wrapper *ReplayWrapper) CancelOrder(market *environment.Market, orderID string) error {
+	return errors.New("Cannot cancel orders on a replay, use a simulator")
+}
+
+// CalculateTradingFees returns no fees, as the replay does not know the fees of the exchange.
+func (wrapper *ReplayWrapper) CalculateTradingFees(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal, orderType TradeType) decimal.Decimal {
+	return decimal.Zero
+}
+
+// CalculateWithdrawFees returns no fees, as the replay does not know the fees of the exchange.
+func (wrapper *ReplayWrapper) CalculateWithdrawFees(market *environment.Market, amount decimal.Decimal) decimal.Decimal {
+	return decimal.Zero
+}
+
+// GetBalance is not supported by the replay wrapper, wrap it with NewExchangeWrapperSimulator.
+func (wrapper *ReplayWrapper) GetBalance(symbol string) (*decimal.Decimal, error) {
+	return nil, errors.New("Cannot get balances of a replay, use a simulator")
+}
+
+// GetDepositAddress gets the deposit address for the specified coin on the exchange.
+func (wrapper *ReplayWrapper) GetDepositAddress(coinTicker string) (string, bool) {
+	return "", false
+}
+
+// FeedConnect connects to the recorded feed, which follows the virtual clock.
+func (wrapper *ReplayWrapper) FeedConnect(markets []*environment.Market) error {
+	return nil
+}
+
+// Withdraw is not supported by the replay wrapper.
+func (wrapper *ReplayWrapper) Withdraw(destinationAddress string, coinTicker string, amount decimal.Decimal) error {
+	return errors.New("Cannot withdraw from a replay")
+}
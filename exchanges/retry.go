@@ -0,0 +1,417 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package exchanges
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	defaultRetryAttempts = 3
+	defaultRetryMinDelay = 200 * time.Millisecond
+	defaultRetryMaxDelay = 5 * time.Second
+)
+
+// IsTransient tells if an error is a transient failure of a call (e.g. a timeout or a dropped connection),
+// after which the call can be retried.
+//
+// The outcome of a call failed for a transient error is unknown: the exchange may have executed it.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, transient := range []error{ErrRateLimited, context.DeadlineExceeded, io.EOF, io.ErrUnexpectedEOF, syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.EPIPE} {
+		if errors.Is(err, transient) {
+			return true
+		}
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return isBinanceTransient(err)
+}
+
+// RetryWrapper wraps another wrapper, retrying the calls failed for transient errors (see IsTransient)
+// with a jittered exponential backoff.
+//
+// Orders are placed with a new client order ID when the exchange supports them (see ClientOrderExchangeWrapper):
+// before placing an order again, the wrapper looks for it by its client order ID, so that it is placed at most once.
+// Orders on the other exchanges, cancels and withdraws are never retried.
+type RetryWrapper struct {
+	innerWrapper ExchangeWrapper
+	policy       environment.RetryConfig
+	sleep        func(ctx context.Context, delay time.Duration) error
+}
+
+// NewRetryWrapper creates a new wrapper retrying the calls of another wrapper with the specified policy.
+func NewRetryWrapper(wrapper ExchangeWrapper, policy environment.RetryConfig) *RetryWrapper {
+	if policy.Attempts <= 0 {
+		policy.Attempts = defaultRetryAttempts
+	}
+	if policy.MinDelay <= 0 {
+		policy.MinDelay = defaultRetryMinDelay
+	}
+	if policy.MaxDelay < policy.MinDelay {
+		policy.MaxDelay = defaultRetryMaxDelay
+		if policy.MaxDelay < policy.MinDelay {
+			policy.MaxDelay = policy.MinDelay
+		}
+	}
+	return &RetryWrapper{
+		innerWrapper: wrapper,
+		policy:       policy,
+		sleep:        sleepContext,
+	}
+}
+
+// sleepContext waits for the specified delay, returning the context error if the context is done before.
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// String returns a string representation of the retry wrapper.
+func (wrapper *RetryWrapper) String() string {
+	return wrapper.innerWrapper.String()
+}
+
+// Name gets the name of the exchange.
+func (wrapper *RetryWrapper) Name() string {
+	return wrapper.innerWrapper.Name()
+}
+
+// Unwrap returns the wrapped ExchangeWrapper.
+func (wrapper *RetryWrapper) Unwrap() ExchangeWrapper {
+	return wrapper.innerWrapper
+}
+
+// Policy returns the retry policy of the wrapper, defaults included.
+func (wrapper *RetryWrapper) Policy() environment.RetryConfig {
+	return wrapper.policy
+}
+
+// backoff returns the delay before the retry following the specified failed attempt (0 for the first one).
+func (wrapper *RetryWrapper) backoff(attempt int) time.Duration {
+	return jitteredBackoff(wrapper.policy.MinDelay, wrapper.policy.MaxDelay, attempt)
+}
+
+// jitteredBackoff returns a random duration between half and all of the exponential backoff after the specified
+// failed attempt (0 for the first one), starting from minDelay and capped at maxDelay.
+func jitteredBackoff(minDelay time.Duration, maxDelay time.Duration, attempt int) time.Duration {
+	delay := minDelay
+	for i := 0; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// retry calls a function until it succeeds, fails for a non transient error or runs out of attempts.
+func (wrapper *RetryWrapper) retry(ctx context.Context, call func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := call()
+		if err == nil || !IsTransient(err) || attempt+1 >= wrapper.policy.Attempts {
+			return err
+		}
+		if sleepErr := wrapper.sleep(ctx, wrapper.backoff(attempt)); sleepErr != nil {
+			return err
+		}
+	}
+}
+
+// inner returns the wrapped wrapper, receiving the context of the calls.
+func (wrapper *RetryWrapper) inner() ContextExchangeWrapper {
+	return AsContextExchangeWrapper(wrapper.innerWrapper)
+}
+
+// GetMarkets gets all the markets of the exchange.
+func (wrapper *RetryWrapper) GetMarkets() ([]*environment.Market, error) {
+	return wrapper.GetMarketsContext(context.Background())
+}
+
+// GetMarketsContext gets all the markets of the exchange.
+func (wrapper *RetryWrapper) GetMarketsContext(ctx context.Context) ([]*environment.Market, error) {
+	var ret []*environment.Market
+	err := wrapper.retry(ctx, func() (err error) {
+		ret, err = wrapper.inner().GetMarketsContext(ctx)
+		return err
+	})
+	return ret, err
+}
+
+// GetCandles gets the candle data from the exchange.
+func (wrapper *RetryWrapper) GetCandles(market *environment.Market) ([]environment.CandleStick, error) {
+	return wrapper.GetCandlesContext(context.Background(), market)
+}
+
+// GetCandlesContext gets the candle data from the exchange.
+func (wrapper *RetryWrapper) GetCandlesContext(ctx context.Context, market *environment.Market) ([]environment.CandleStick, error) {
+	var ret []environment.CandleStick
+	err := wrapper.retry(ctx, func() (err error) {
+		ret, err = wrapper.inner().GetCandlesContext(ctx, market)
+		return err
+	})
+	return ret, err
+}
+
+// GetMarketSummary gets the current market summary.
+func (wrapper *RetryWrapper) GetMarketSummary(market *environment.Market) (*environment.MarketSummary, error) {
+	return wrapper.GetMarketSummaryContext(context.Background(), market)
+}
+
+// GetMarketSummaryContext gets the current market summary.
+func (wrapper *RetryWrapper) GetMarketSummaryContext(ctx context.Context, market *environment.Market) (*environment.MarketSummary, error) {
+	var ret *environment.MarketSummary
+	err := wrapper.retry(ctx, func() (err error) {
+		ret, err = wrapper.inner().GetMarketSummaryContext(ctx, market)
+		return err
+	})
+	return ret, err
+}
+
+// GetOrderBook gets the order(ASK + BID) book of a market.
+func (wrapper *RetryWrapper) GetOrderBook(market *environment.Market) (*environment.OrderBook, error) {
+	return wrapper.GetOrderBookContext(context.Background(), market)
+}
+
+// GetOrderBookContext gets the order(ASK + BID) book of a market.
+func (wrapper *RetryWrapper) GetOrderBookContext(ctx context.Context, market *environment.Market) (*environment.OrderBook, error) {
+	var ret *environment.OrderBook
+	err := wrapper.retry(ctx, func() (err error) {
+		ret, err = wrapper.inner().GetOrderBookContext(ctx, market)
+		return err
+	})
+	return ret, err
+}
+
+// BuyLimit performs a limit buy action.
+func (wrapper *RetryWrapper) BuyLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return wrapper.BuyLimitContext(context.Background(), market, amount, limit)
+}
+
+// BuyLimitContext performs a limit buy action.
+func (wrapper *RetryWrapper) BuyLimitContext(ctx context.Context, market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return wrapper.placeOrder(ctx, OrderRequest{Market: market, Type: environment.Bid, Quantity: amount, Limit: limit}, func() (string, error) {
+		return wrapper.inner().BuyLimitContext(ctx, market, amount, limit)
+	})
+}
+
+// SellLimit performs a limit sell action.
+func (wrapper *RetryWrapper) SellLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return wrapper.SellLimitContext(context.Background(), market, amount, limit)
+}
+
+// SellLimitContext performs a limit sell action.
+func (wrapper *RetryWrapper) SellLimitContext(ctx context.Context, market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return wrapper.placeOrder(ctx, OrderRequest{Market: market, Type: environment.Ask, Quantity: amount, Limit: limit}, func() (string, error) {
+		return wrapper.inner().SellLimitContext(ctx, market, amount, limit)
+	})
+}
+
+// BuyMarket performs a market buy action.
+func (wrapper *RetryWrapper) BuyMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
+	return wrapper.BuyMarketContext(context.Background(), market, amount)
+}
+
+// BuyMarketContext performs a market buy action.
+func (wrapper *RetryWrapper) BuyMarketContext(ctx context.Context, market *environment.Market, amount decimal.Decimal) (string, error) {
+	return wrapper.placeOrder(ctx, OrderRequest{Market: market, Type: environment.Bid, Quantity: amount}, func() (string, error) {
+		return wrapper.inner().BuyMarketContext(ctx, market, amount)
+	})
+}
+
+// SellMarket performs a market sell action.
+func (wrapper *RetryWrapper) SellMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
+	return wrapper.SellMarketContext(context.Background(), market, amount)
+}
+
+// SellMarketContext performs a market sell action.
+func (wrapper *RetryWrapper) SellMarketContext(ctx context.Context, market *environment.Market, amount decimal.Decimal) (string, error) {
+	return wrapper.placeOrder(ctx, OrderRequest{Market: market, Type: environment.Ask, Quantity: amount}, func() (string, error) {
+		return wrapper.inner().SellMarketContext(ctx, market, amount)
+	})
+}
+
+// placeOrder places an order with a new client order ID, retrying it, if the exchange supports them;
+// otherwise places it once.
+func (wrapper *RetryWrapper) placeOrder(ctx context.Context, order OrderRequest, place func() (string, error)) (string, error) {
+	orderID, err := PlaceOrder(ctx, wrapper, order)
+	if errors.Is(err, ErrClientOrderIDNotSupported) {
+		return place()
+	}
+	return orderID, err
+}
+
+// PlaceOrder places an order with a client order ID (generated if empty), retrying it after transient failures
+// only if the exchange does not have it yet.
+//
+// When the attempts run out, the order may have been placed anyway: it can be looked for with GetOrder
+// by its client order ID.
+func (wrapper *RetryWrapper) PlaceOrder(ctx context.Context, order OrderRequest) (string, error) {
+	if order.ClientOrderID == "" {
+		id, err := NewClientOrderID()
+		if err != nil {
+			return "", err
+		}
+		order.ClientOrderID = id
+	}
+
+	var err error
+	for attempt := 0; attempt < wrapper.policy.Attempts; attempt++ {
+		if attempt > 0 {
+			if sleepErr := wrapper.sleep(ctx, wrapper.backoff(attempt-1)); sleepErr != nil {
+				break
+			}
+
+			// the failed attempt may have placed the order anyway.
+			info, getErr := wrapper.inner().GetOrderContext(ctx, order.Market, order.ClientOrderID)
+			if getErr == nil {
+				return info.ID, nil
+			}
+			if !errors.Is(getErr, ErrOrderNotFound) {
+				err = getErr
+				continue
+			}
+		}
+
+		var orderID string
+		orderID, err = placeOrderWith(ctx, wrapper.innerWrapper, order)
+		if err == nil || !IsTransient(err) {
+			return orderID, err
+		}
+	}
+	return "", fmt.Errorf("Cannot place order %s, it may have been placed: %w", order.ClientOrderID, err)
+}
+
+// GetOrder gets the status of an order placed on the exchange.
+func (wrapper *RetryWrapper) GetOrder(market *environment.Market, orderID string) (*environment.OrderInfo, error) {
+	return wrapper.GetOrderContext(context.Background(), market, orderID)
+}
+
+// GetOrderContext gets the status of an order placed on the exchange.
+func (wrapper *RetryWrapper) GetOrderContext(ctx context.Context, market *environment.Market, orderID string) (*environment.OrderInfo, error) {
+	var ret *environment.OrderInfo
+	err := wrapper.retry(ctx, func() (err error) {
+		ret, err = wrapper.inner().GetOrderContext(ctx, market, orderID)
+		return err
+	})
+	return ret, err
+}
+
+// GetOpenOrders gets the open orders of the user on a market.
+func (wrapper *RetryWrapper) GetOpenOrders(market *environment.Market) ([]*environment.OrderInfo, error) {
+	return wrapper.GetOpenOrdersContext(context.Background(), market)
+}
+
+// GetOpenOrdersContext gets the open orders of the user on a market.
+func (wrapper *RetryWrapper) GetOpenOrdersContext(ctx context.Context, market *environment.Market) ([]*environment.OrderInfo, error) {
+	var ret []*environment.OrderInfo
+	err := wrapper.retry(ctx, func() (err error) {
+		ret, err = wrapper.inner().GetOpenOrdersContext(ctx, market)
+		return err
+	})
+	return ret, err
+}
+
+// CancelOrder cancels an open order.
+func (wrapper *RetryWrapper) CancelOrder(market *environment.Market, orderID string) error {
+	return wrapper.CancelOrderContext(context.Background(), market, orderID)
+}
+
+// CancelOrderContext cancels an open order.
+func (wrapper *RetryWrapper) CancelOrderContext(ctx context.Context, market *environment.Market, orderID string) error {
+	return wrapper.inner().CancelOrderContext(ctx, market, orderID)
+}
+
+// CalculateTradingFees calculates the trading fees for an order on a specified market.
+func (wrapper *RetryWrapper) CalculateTradingFees(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal, orderType TradeType) decimal.Decimal {
+	return wrapper.innerWrapper.CalculateTradingFees(market, amount, limit, orderType)
+}
+
+// CalculateWithdrawFees calculates the withdrawal fees on a specified market.
+func (wrapper *RetryWrapper) CalculateWithdrawFees(market *environment.Market, amount decimal.Decimal) decimal.Decimal {
+	return wrapper.innerWrapper.CalculateWithdrawFees(market, amount)
+}
+
+// GetBalance gets the balance of the user of the specified currency.
+func (wrapper *RetryWrapper) GetBalance(symbol string) (*decimal.Decimal, error) {
+	return wrapper.GetBalanceContext(context.Background(), symbol)
+}
+
+// GetBalanceContext gets the balance of the user of the specified currency.
+func (wrapper *RetryWrapper) GetBalanceContext(ctx context.Context, symbol string) (*decimal.Decimal, error) {
+	var ret *decimal.Decimal
+	err := wrapper.retry(ctx, func() (err error) {
+		ret, err = wrapper.inner().GetBalanceContext(ctx, symbol)
+		return err
+	})
+	return ret, err
+}
+
+// GetDepositAddress gets the deposit address for the specified coin on the exchange.
+func (wrapper *RetryWrapper) GetDepositAddress(coinTicker string) (string, bool) {
+	return wrapper.innerWrapper.GetDepositAddress(coinTicker)
+}
+
+// FeedConnect connects to the feed of the exchange.
+func (wrapper *RetryWrapper) FeedConnect(markets []*environment.Market) error {
+	return wrapper.innerWrapper.FeedConnect(markets)
+}
+
+// FeedConnectContext connects to the feed of the exchange, closing it when the context is done
+// if the exchange supports it.
+func (wrapper *RetryWrapper) FeedConnectContext(ctx context.Context, markets []*environment.Market) error {
+	if connector, ok := wrapper.innerWrapper.(feedContextConnector); ok {
+		return connector.FeedConnectContext(ctx, markets)
+	}
+	return wrapper.innerWrapper.FeedConnect(markets)
+}
+
+// Withdraw performs a withdraw operation from the exchange to a destination address, never retried.
+func (wrapper *RetryWrapper) Withdraw(destinationAddress string, coinTicker string, amount decimal.Decimal) error {
+	return wrapper.WithdrawContext(context.Background(), destinationAddress, coinTicker, amount)
+}
+
+// WithdrawContext performs a withdraw operation from the exchange to a destination address, never retried.
+func (wrapper *RetryWrapper) WithdrawContext(ctx context.Context, destinationAddress string, coinTicker string, amount decimal.Decimal) error {
+	return wrapper.inner().WithdrawContext(ctx, destinationAddress, coinTicker, amount)
+}
@@ -0,0 +1,203 @@
+package exchanges
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/shopspring/decimal"
+)
+
+var errRefused = errors.New("refused")
+
+// placeOutcome represents the outcome of an attempt to place an order on a flakyExchange.
+type placeOutcome struct {
+	placed bool  // true if the order reaches the exchange.
+	err    error // error returned to the caller.
+}
+
+// flakyExchange is a simulated exchange whose calls fail as scripted.
+type flakyExchange struct {
+	*ExchangeWrapperSimulator
+	places  []placeOutcome // outcomes of the attempts to place an order, then successful.
+	lookups []error        // errors of the lookups of the orders, then delegated to the simulator.
+	calls   int
+}
+
+func (exchange *flakyExchange) PlaceOrder(ctx context.Context, order OrderRequest) (string, error) {
+	exchange.calls++
+	outcome := placeOutcome{placed: true}
+	if len(exchange.places) > 0 {
+		outcome, exchange.places = exchange.places[0], exchange.places[1:]
+	}
+	var orderID string
+	if outcome.placed {
+		var err error
+		if orderID, err = exchange.ExchangeWrapperSimulator.PlaceOrder(ctx, order); err != nil {
+			return "", err
+		}
+	}
+	if outcome.err != nil {
+		return "", outcome.err
+	}
+	return orderID, nil
+}
+
+func (exchange *flakyExchange) GetOrderContext(ctx context.Context, market *environment.Market, orderID string) (*environment.OrderInfo, error) {
+	if len(exchange.lookups) > 0 {
+		err := exchange.lookups[0]
+		exchange.lookups = exchange.lookups[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+	return exchange.ExchangeWrapperSimulator.GetOrderContext(ctx, market, orderID)
+}
+
+func TestRetryPlaceOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		places  []placeOutcome
+		lookups []error
+		calls   int   // attempts to place the order.
+		orders  int   // orders placed on the exchange.
+		err     error // nil if the order is placed.
+	}{
+		{
+			name:   "placed at the first attempt",
+			calls:  1,
+			orders: 1,
+		},
+		{
+			name:   "placed again if not found",
+			places: []placeOutcome{{false, ErrRateLimited}},
+			calls:  2,
+			orders: 1,
+		},
+		{
+			name:   "found after a failure placing it",
+			places: []placeOutcome{{true, context.DeadlineExceeded}},
+			calls:  1,
+			orders: 1,
+		},
+		{
+			name:    "looked for again when the lookup fails",
+			places:  []placeOutcome{{false, ErrRateLimited}},
+			lookups: []error{ErrRateLimited},
+			calls:   2,
+			orders:  1,
+		},
+		{
+			name:   "not retried after a non transient error",
+			places: []placeOutcome{{false, errRefused}},
+			calls:  1,
+			err:    errRefused,
+		},
+		{
+			name:   "attempts run out",
+			places: []placeOutcome{{false, ErrRateLimited}, {false, ErrRateLimited}, {false, ErrRateLimited}},
+			calls:  3,
+			err:    ErrRateLimited,
+		},
+		{
+			name:    "attempts run out looking for it",
+			places:  []placeOutcome{{false, ErrRateLimited}},
+			lookups: []error{ErrRateLimited, ErrRateLimited},
+			calls:   1,
+			err:     ErrRateLimited,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			simulator := NewExchangeWrapperSimulator(&fakeExchange{}, map[string]decimal.Decimal{"BTC": dec("100")})
+			exchange := &flakyExchange{
+				ExchangeWrapperSimulator: simulator,
+				places:                   test.places,
+				lookups:                  test.lookups,
+			}
+			wrapper := NewRetryWrapper(exchange, environment.RetryConfig{Attempts: 3})
+			wrapper.sleep = func(ctx context.Context, delay time.Duration) error { return nil }
+
+			orderID, err := wrapper.BuyLimit(testMarket, dec("1"), dec("5"))
+			if test.err == nil && err != nil {
+				t.Fatalf("order not placed: %s", err)
+			}
+			if test.err != nil && !errors.Is(err, test.err) {
+				t.Fatalf("error %v, want %v", err, test.err)
+			}
+			if exchange.calls != test.calls {
+				t.Errorf("%d attempts to place the order, want %d", exchange.calls, test.calls)
+			}
+
+			openOrders, _ := simulator.GetOpenOrders(testMarket)
+			if len(openOrders) != test.orders {
+				t.Fatalf("%d orders placed, want %d", len(openOrders), test.orders)
+			}
+			if test.orders > 0 && openOrders[0].ID != orderID {
+				t.Errorf("order ID %s, want %s", orderID, openOrders[0].ID)
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		errors   []error // errors of the calls, then successful.
+		sleepErr error
+		calls    int
+		err      error
+	}{
+		{"successful call", nil, nil, 1, nil},
+		{"transient failures", []error{ErrRateLimited, context.DeadlineExceeded}, nil, 3, nil},
+		{"non transient failure", []error{errRefused}, nil, 1, errRefused},
+		{"attempts run out", []error{ErrRateLimited, ErrRateLimited, ErrRateLimited, ErrRateLimited}, nil, 3, ErrRateLimited},
+		{"context done while waiting", []error{ErrRateLimited}, context.Canceled, 1, ErrRateLimited},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wrapper := NewRetryWrapper(&fakeExchange{}, environment.RetryConfig{Attempts: 3})
+			wrapper.sleep = func(ctx context.Context, delay time.Duration) error { return test.sleepErr }
+
+			calls := 0
+			err := wrapper.retry(context.Background(), func() error {
+				calls++
+				if calls <= len(test.errors) {
+					return test.errors[calls-1]
+				}
+				return nil
+			})
+			if !errors.Is(err, test.err) {
+				t.Errorf("error %v, want %v", err, test.err)
+			}
+			if calls != test.calls {
+				t.Errorf("%d calls, want %d", calls, test.calls)
+			}
+		})
+	}
+}
+
+func TestJitteredBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{0, 50 * time.Millisecond, 100 * time.Millisecond},
+		{1, 100 * time.Millisecond, 200 * time.Millisecond},
+		{2, 200 * time.Millisecond, 400 * time.Millisecond},
+		{10, 500 * time.Millisecond, time.Second},
+	}
+
+	for _, test := range tests {
+		for i := 0; i < 20; i++ {
+			if delay := jitteredBackoff(100*time.Millisecond, time.Second, test.attempt); delay < test.min || delay > test.max {
+				t.Fatalf("attempt %d: delay %s, want between %s and %s", test.attempt, delay, test.min, test.max)
+			}
+		}
+	}
+}
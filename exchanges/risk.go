@@ -0,0 +1,501 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package exchanges
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	// ErrMaxOrderNotional is the error representing an order whose value exceeds the maximum order notional.
+	ErrMaxOrderNotional = errors.New("Order notional above the risk limit")
+	// ErrMaxPosition is the error representing a buy which would exceed the maximum position of a market.
+	ErrMaxPosition = errors.New("Position above the risk limit")
+	// ErrDailyLossLimit is the error representing a buy placed after reaching the daily loss limit.
+	ErrDailyLossLimit = errors.New("Daily loss limit reached")
+	// ErrMaxOpenOrders is the error representing an order which would exceed the maximum open orders of a market.
+	ErrMaxOpenOrders = errors.New("Open orders above the risk limit")
+	// ErrPriceDeviation is the error representing a limit price too far from the last price of the market, against the order.
+	ErrPriceDeviation = errors.New("Price deviation above the risk limit")
+	// ErrMaxWithdraw is the error representing a withdraw above the maximum withdraw amount.
+	ErrMaxWithdraw = errors.New("Withdraw above the risk limit")
+)
+
+// RiskError represents an operation refused by a RiskManager, wrapping one of the ErrMax*, ErrDailyLossLimit and ErrPriceDeviation errors:
+// use errors.Is to check which limit has been violated.
+type RiskError struct {
+	Err    error           // Represents the violated limit.
+	Target string          // Represents the market (or the currency) the limit applies to.
+	Value  decimal.Decimal // Represents the value the operation would have reached.
+	Limit  decimal.Decimal // Represents the configured limit.
+}
+
+// Error returns the description of the violation.
+func (err *RiskError) Error() string {
+	return fmt.Sprintf("%s on %s: %s, limit %s", err.Err, err.Target, err.Value, err.Limit)
+}
+
+// Unwrap returns the violated limit.
+func (err *RiskError) Unwrap() error {
+	return err.Err
+}
+
+// RiskManager wraps another wrapper, checking the risk limits before forwarding its orders and withdraws.
+//
+// Sells are only refused by the open orders and price deviation limits, so positions can always be reduced.
+// The daily loss is the loss realised since midnight (UTC) by the orders placed through the manager,
+// valued at their average cost.
+type RiskManager struct {
+	innerWrapper ExchangeWrapper
+	mutex        *sync.Mutex
+	limits       environment.RiskConfig
+	orders       map[string]*riskOrder    // orders placed through the manager and still open, by ID.
+	positions    map[string]*riskPosition // by market name.
+	day          time.Time                // start of the day of the daily loss.
+	now          func() time.Time
+}
+
+// riskOrder represents the quantities of an order placed through the risk manager, already applied to its position.
+type riskOrder struct {
+	market    *environment.Market
+	orderType environment.OrderType
+	filled    decimal.Decimal
+	value     decimal.Decimal // filled quantity times average price.
+	fee       decimal.Decimal
+}
+
+// riskPosition represents the coins bought through the risk manager on a market, valued at their average cost.
+type riskPosition struct {
+	market    *environment.Market
+	quantity  decimal.Decimal
+	cost      decimal.Decimal
+	dailyLoss decimal.Decimal // loss realised since the start of the day, negative for profits.
+}
+
+// NewRiskManager creates a new wrapper enforcing the specified limits on the orders of another wrapper.
+func NewRiskManager(wrapper ExchangeWrapper, limits environment.RiskConfig) *RiskManager {
+	return &RiskManager{
+		innerWrapper: wrapper,
+		mutex:        &sync.Mutex{},
+		limits:       limits,
+		orders:       make(map[string]*riskOrder),
+		positions:    make(map[string]*riskPosition),
+		now:          time.Now,
+	}
+}
+
+// String returns a string representation of the risk manager.
+func (wrapper *RiskManager) String() string {
+	return wrapper.innerWrapper.String()
+}
+
+// Name gets the name of the exchange.
+func (wrapper *RiskManager) Name() string {
+	return wrapper.innerWrapper.Name()
+}
+
+// Unwrap returns the wrapped ExchangeWrapper.
+func (wrapper *RiskManager) Unwrap() ExchangeWrapper {
+	return wrapper.innerWrapper
+}
+
+// Limits returns the limits enforced by the manager.
+func (wrapper *RiskManager) Limits() environment.RiskConfig {
+	return wrapper.limits
+}
+
+// inner returns the wrapped wrapper, receiving the context of the calls.
+func (wrapper *RiskManager) inner() ContextExchangeWrapper {
+	return AsContextExchangeWrapper(wrapper.innerWrapper)
+}
+
+// GetMarkets gets all the markets of the exchange.
+func (wrapper *RiskManager) GetMarkets() ([]*environment.Market, error) {
+	return wrapper.GetMarketsContext(context.Background())
+}
+
+// GetMarketsContext gets all the markets of the exchange.
+func (wrapper *RiskManager) GetMarketsContext(ctx context.Context) ([]*environment.Market, error) {
+	return wrapper.inner().GetMarketsContext(ctx)
+}
+
+// GetCandles gets the candle data from the exchange.
+func (wrapper *RiskManager) GetCandles(market *environment.Market) ([]environment.CandleStick, error) {
+	return wrapper.GetCandlesContext(context.Background(), market)
+}
+
+// GetCandlesContext gets the candle data from the exchange.
+func (wrapper *RiskManager) GetCandlesContext(ctx context.Context, market *environment.Market) ([]environment.CandleStick, error) {
+	return wrapper.inner().GetCandlesContext(ctx, market)
+}
+
+// GetMarketSummary gets the current market summary.
+func (wrapper *RiskManager) GetMarketSummary(market *environment.Market) (*environment.MarketSummary, error) {
+	return wrapper.GetMarketSummaryContext(context.Background(), market)
+}
+
+// GetMarketSummaryContext gets the current market summary.
+func (wrapper *RiskManager) GetMarketSummaryContext(ctx context.Context, market *environment.Market) (*environment.MarketSummary, error) {
+	return wrapper.inner().GetMarketSummaryContext(ctx, market)
+}
+
+// GetOrderBook gets the order(ASK + BID) book of a market.
+func (wrapper *RiskManager) GetOrderBook(market *environment.Market) (*environment.OrderBook, error) {
+	return wrapper.GetOrderBookContext(context.Background(), market)
+}
+
+// GetOrderBookContext gets the order(ASK + BID) book of a market.
+func (wrapper *RiskManager) GetOrderBookContext(ctx context.Context, market *environment.Market) (*environment.OrderBook, error) {
+	return wrapper.inner().GetOrderBookContext(ctx, market)
+}
+
+// BuyLimit performs a limit buy action, if within the risk limits.
+func (wrapper *RiskManager) BuyLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return wrapper.BuyLimitContext(context.Background(), market, amount, limit)
+}
+
+// BuyLimitContext performs a limit buy action, if within the risk limits.
+func (wrapper *RiskManager) BuyLimitContext(ctx context.Context, market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return wrapper.placeOrder(ctx, market, environment.Bid, amount, limit, func() (string, error) {
+		return wrapper.inner().BuyLimitContext(ctx, market, amount, limit)
+	})
+}
+
+// SellLimit performs a limit sell action, if within the risk limits.
+func (wrapper *RiskManager) SellLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return wrapper.SellLimitContext(context.Background(), market, amount, limit)
+}
+
+// SellLimitContext performs a limit sell action, if within the risk limits.
+func (wrapper *RiskManager) SellLimitContext(ctx context.Context, market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return wrapper.placeOrder(ctx, market, environment.Ask, amount, limit, func() (string, error) {
+		return wrapper.inner().SellLimitContext(ctx, market, amount, limit)
+	})
+}
+
+// BuyMarket performs a market buy action, if within the risk limits.
+func (wrapper *RiskManager) BuyMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
+	return wrapper.BuyMarketContext(context.Background(), market, amount)
+}
+
+// BuyMarketContext performs a market buy action, if within the risk limits.
+func (wrapper *RiskManager) BuyMarketContext(ctx context.Context, market *environment.Market, amount decimal.Decimal) (string, error) {
+	return wrapper.placeOrder(ctx, market, environment.Bid, amount, decimal.Zero, func() (string, error) {
+		return wrapper.inner().BuyMarketContext(ctx, market, amount)
+	})
+}
+
+// SellMarket performs a market sell action, if within the risk limits.
+func (wrapper *RiskManager) SellMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
+	return wrapper.SellMarketContext(context.Background(), market, amount)
+}
+
+// SellMarketContext performs a market sell action, if within the risk limits.
+func (wrapper *RiskManager) SellMarketContext(ctx context.Context, market *environment.Market, amount decimal.Decimal) (string, error) {
+	return wrapper.placeOrder(ctx, market, environment.Ask, amount, decimal.Zero, func() (string, error) {
+		return wrapper.inner().SellMarketContext(ctx, market, amount)
+	})
+}
+
+// PlaceOrder places an order with a client order ID, if within the risk limits.
+func (wrapper *RiskManager) PlaceOrder(ctx context.Context, order OrderRequest) (string, error) {
+	return wrapper.placeOrder(ctx, order.Market, order.Type, order.Quantity, order.Limit, func() (string, error) {
+		return placeOrderWith(ctx, wrapper.innerWrapper, order)
+	})
+}
+
+// placeOrder checks an order against the limits and places it, tracking its fills for the daily loss.
+//
+// Orders are placed one at a time, so that concurrent orders cannot exceed the limits together.
+func (wrapper *RiskManager) placeOrder(ctx context.Context, market *environment.Market, orderType environment.OrderType, amount decimal.Decimal, limit decimal.Decimal, place func() (string, error)) (string, error) {
+	wrapper.mutex.Lock()
+	defer wrapper.mutex.Unlock()
+
+	err := wrapper.checkOrder(ctx, market, orderType, amount, limit)
+	if err != nil {
+		return "", err
+	}
+
+	orderID, err := place()
+	if err != nil {
+		return "", err
+	}
+	if len(wrapper.limits.DailyLossLimit) > 0 {
+		wrapper.orders[orderID] = &riskOrder{
+			market:    market,
+			orderType: orderType,
+		}
+	}
+	return orderID, nil
+}
+
+// checkOrder checks an order against the limits, limit is zero for market orders.
+// Must be called with the mutex locked.
+func (wrapper *RiskManager) checkOrder(ctx context.Context, market *environment.Market, orderType environment.OrderType, amount decimal.Decimal, limit decimal.Decimal) error {
+	limits := wrapper.limits
+
+	if maxLoss, hasLimit := limits.DailyLossLimit[market.BaseCurrency]; hasLimit && orderType == environment.Bid {
+		wrapper.updatePositions(ctx)
+		if loss := wrapper.dailyLoss(market.BaseCurrency); loss.GreaterThanOrEqual(maxLoss) {
+			return &RiskError{Err: ErrDailyLossLimit, Target: market.BaseCurrency, Value: loss, Limit: maxLoss}
+		}
+	}
+
+	maxNotional, hasMaxNotional := limits.MaxOrderNotional[market.BaseCurrency]
+	hasMaxDeviation := limits.MaxPriceDeviation.IsPositive() && limit.IsPositive()
+	if hasMaxNotional || hasMaxDeviation {
+		summary, err := wrapper.inner().GetMarketSummaryContext(ctx, market)
+		if err != nil {
+			return fmt.Errorf("Cannot check risk limits of %s: %s", market.Name, err)
+		}
+
+		if hasMaxNotional {
+			price := limit
+			if price.IsZero() {
+				price = marketOrderPrice(summary, orderType)
+			}
+			if notional := amount.Mul(price); notional.GreaterThan(maxNotional) {
+				return &RiskError{Err: ErrMaxOrderNotional, Target: market.Name, Value: notional, Limit: maxNotional}
+			}
+		}
+
+		if hasMaxDeviation && summary.Last.IsPositive() {
+			deviation := limit.Sub(summary.Last).Div(summary.Last)
+			if orderType == environment.Ask {
+				deviation = deviation.Neg()
+			}
+			if deviation.GreaterThan(limits.MaxPriceDeviation) {
+				return &RiskError{Err: ErrPriceDeviation, Target: market.Name, Value: deviation, Limit: limits.MaxPriceDeviation}
+			}
+		}
+	}
+
+	maxPosition, hasMaxPosition := limits.MaxPosition[market.Name]
+	hasMaxPosition = hasMaxPosition && orderType == environment.Bid
+	if limits.MaxOpenOrders > 0 || hasMaxPosition {
+		openOrders, err := wrapper.inner().GetOpenOrdersContext(ctx, market)
+		if err != nil {
+			return fmt.Errorf("Cannot check risk limits of %s: %s", market.Name, err)
+		}
+
+		if limits.MaxOpenOrders > 0 && len(openOrders) >= limits.MaxOpenOrders {
+			return &RiskError{Err: ErrMaxOpenOrders, Target: market.Name, Value: decimal.NewFromInt(int64(len(openOrders) + 1)), Limit: decimal.NewFromInt(int64(limits.MaxOpenOrders))}
+		}
+
+		if hasMaxPosition {
+			balance, err := wrapper.inner().GetBalanceContext(ctx, market.MarketCurrency)
+			if err != nil {
+				return fmt.Errorf("Cannot check risk limits of %s: %s", market.Name, err)
+			}
+			position := balance.Add(amount)
+			for _, order := range openOrders {
+				if order.Type == environment.Bid {
+					position = position.Add(order.RemainingQuantity())
+				}
+			}
+			if position.GreaterThan(maxPosition) {
+				return &RiskError{Err: ErrMaxPosition, Target: market.Name, Value: position, Limit: maxPosition}
+			}
+		}
+	}
+	return nil
+}
+
+// marketOrderPrice returns the expected price of a market order from a market summary.
+func marketOrderPrice(summary *environment.MarketSummary, orderType environment.OrderType) decimal.Decimal {
+	if orderType == environment.Bid && summary.Ask.IsPositive() {
+		return summary.Ask
+	}
+	if orderType == environment.Ask && summary.Bid.IsPositive() {
+		return summary.Bid
+	}
+	return summary.Last
+}
+
+// updatePositions gets the orders placed through the manager which are still open, applying their new fills to the positions.
+// Must be called with the mutex locked.
+//
+// When the day changes, the fills are applied before resetting the daily loss: those not seen yet are counted
+// in the previous day, so that its losses do not block the new one.
+func (wrapper *RiskManager) updatePositions(ctx context.Context) {
+	for orderID, order := range wrapper.orders {
+		info, err := wrapper.inner().GetOrderContext(ctx, order.market, orderID)
+		if err != nil {
+			continue
+		}
+		wrapper.applyFills(order, info)
+		if !info.Status.IsOpen() {
+			delete(wrapper.orders, orderID)
+		}
+	}
+
+	today := wrapper.now().UTC().Truncate(24 * time.Hour)
+	if !today.Equal(wrapper.day) {
+		wrapper.day = today
+		for _, position := range wrapper.positions {
+			position.dailyLoss = decimal.Zero
+		}
+	}
+}
+
+// applyFills applies the quantity of an order filled since the last check to the position of its market.
+func (wrapper *RiskManager) applyFills(order *riskOrder, info *environment.OrderInfo) {
+	quantity := info.FilledQuantity.Sub(order.filled)
+	if !quantity.IsPositive() {
+		return
+	}
+
+	price := info.AveragePrice
+	if price.IsZero() {
+		price = info.Limit
+	}
+	value := info.FilledQuantity.Mul(price)
+	fillValue := value.Sub(order.value)
+	fee := info.Fee.Sub(order.fee)
+	switch info.FeeCurrency {
+	case "", order.market.BaseCurrency:
+	case order.market.MarketCurrency:
+		fee = fee.Mul(fillValue.Div(quantity))
+	default:
+		// fees paid in other currencies (e.g. exchange tokens) are not counted.
+		fee = decimal.Zero
+	}
+	order.filled, order.value, order.fee = info.FilledQuantity, value, info.Fee
+
+	position, exists := wrapper.positions[order.market.Name]
+	if !exists {
+		position = &riskPosition{market: order.market}
+		wrapper.positions[order.market.Name] = position
+	}
+
+	if order.orderType == environment.Bid {
+		position.quantity = position.quantity.Add(quantity)
+		position.cost = position.cost.Add(fillValue).Add(fee)
+		return
+	}
+
+	closed := decimal.Min(quantity, position.quantity)
+	if !closed.IsPositive() {
+		return
+	}
+	cost := position.cost.Mul(closed).Div(position.quantity)
+	proceeds := fillValue.Sub(fee).Mul(closed).Div(quantity)
+	position.dailyLoss = position.dailyLoss.Add(cost).Sub(proceeds)
+	position.quantity = position.quantity.Sub(closed)
+	position.cost = position.cost.Sub(cost)
+}
+
+// dailyLoss returns the loss realised since the start of the day on the markets with the specified base currency.
+func (wrapper *RiskManager) dailyLoss(baseCurrency string) decimal.Decimal {
+	loss := decimal.Zero
+	for _, position := range wrapper.positions {
+		if position.market.BaseCurrency == baseCurrency {
+			loss = loss.Add(position.dailyLoss)
+		}
+	}
+	return loss
+}
+
+// GetOrder gets the status of an order placed on the exchange.
+func (wrapper *RiskManager) GetOrder(market *environment.Market, orderID string) (*environment.OrderInfo, error) {
+	return wrapper.GetOrderContext(context.Background(), market, orderID)
+}
+
+// GetOrderContext gets the status of an order placed on the exchange.
+func (wrapper *RiskManager) GetOrderContext(ctx context.Context, market *environment.Market, orderID string) (*environment.OrderInfo, error) {
+	return wrapper.inner().GetOrderContext(ctx, market, orderID)
+}
+
+// GetOpenOrders gets the open orders of the user on a market.
+func (wrapper *RiskManager) GetOpenOrders(market *environment.Market) ([]*environment.OrderInfo, error) {
+	return wrapper.GetOpenOrdersContext(context.Background(), market)
+}
+
+// GetOpenOrdersContext gets the open orders of the user on a market.
+func (wrapper *RiskManager) GetOpenOrdersContext(ctx context.Context, market *environment.Market) ([]*environment.OrderInfo, error) {
+	return wrapper.inner().GetOpenOrdersContext(ctx, market)
+}
+
+// CancelOrder cancels an open order.
+func (wrapper *RiskManager) CancelOrder(market *environment.Market, orderID string) error {
+	return wrapper.CancelOrderContext(context.Background(), market, orderID)
+}
+
+// CancelOrderContext cancels an open order.
+func (wrapper *RiskManager) CancelOrderContext(ctx context.Context, market *environment.Market, orderID string) error {
+	return wrapper.inner().CancelOrderContext(ctx, market, orderID)
+}
+
+// CalculateTradingFees calculates the trading fees for an order on a specified market.
+func (wrapper *RiskManager) CalculateTradingFees(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal, orderType TradeType) decimal.Decimal {
+	return wrapper.innerWrapper.CalculateTradingFees(market, amount, limit, orderType)
+}
+
+// CalculateWithdrawFees calculates the withdrawal fees on a specified market.
+func (wrapper *RiskManager) CalculateWithdrawFees(market *environment.Market, amount decimal.Decimal) decimal.Decimal {
+	return wrapper.innerWrapper.CalculateWithdrawFees(market, amount)
+}
+
+// GetBalance gets the balance of the user of the specified currency.
+func (wrapper *RiskManager) GetBalance(symbol string) (*decimal.Decimal, error) {
+	return wrapper.GetBalanceContext(context.Background(), symbol)
+}
+
+// GetBalanceContext gets the balance of the user of the specified currency.
+func (wrapper *RiskManager) GetBalanceContext(ctx context.Context, symbol string) (*decimal.Decimal, error) {
+	return wrapper.inner().GetBalanceContext(ctx, symbol)
+}
+
+// GetDepositAddress gets the deposit address for the specified coin on the exchange.
+func (wrapper *RiskManager) GetDepositAddress(coinTicker string) (string, bool) {
+	return wrapper.innerWrapper.GetDepositAddress(coinTicker)
+}
+
+// FeedConnect connects to the feed of the exchange.
+func (wrapper *RiskManager) FeedConnect(markets []*environment.Market) error {
+	return wrapper.innerWrapper.FeedConnect(markets)
+}
+
+// FeedConnectContext connects to the feed of the exchange, closing it when the context is done
+// if the exchange supports it.
+func (wrapper *RiskManager) FeedConnectContext(ctx context.Context, markets []*environment.Market) error {
+	if connector, ok := wrapper.innerWrapper.(feedContextConnector); ok {
+		return connector.FeedConnectContext(ctx, markets)
+	}
+	return wrapper.innerWrapper.FeedConnect(markets)
+}
+
+// Withdraw performs a withdraw operation from the exchange to a destination address, if within the risk limits.
+func (wrapper *RiskManager) Withdraw(destinationAddress string, coinTicker string, amount decimal.Decimal) error {
+	return wrapper.WithdrawContext(context.Background(), destinationAddress, coinTicker, amount)
+}
+
+// WithdrawContext performs a withdraw operation from the exchange to a destination address, if within the risk limits.
+func (wrapper *RiskManager) WithdrawContext(ctx context.Context, destinationAddress string, coinTicker string, amount decimal.Decimal) error {
+	if maxAmount, hasLimit := wrapper.limits.MaxWithdraw[coinTicker]; hasLimit {
+		if amount.GreaterThan(maxAmount) {
+			return &RiskError{Err: ErrMaxWithdraw, Target: coinTicker, Value: amount, Limit: maxAmount}
+		}
+	}
+	return wrapper.inner().WithdrawContext(ctx, destinationAddress, coinTicker, amount)
+}
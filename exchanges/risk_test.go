@@ -0,0 +1,164 @@
+package exchanges
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/shopspring/decimal"
+)
+
+// GetMarketSummary returns the best prices of the order book of the exchange, last traded at their middle.
+func (exchange *fakeExchange) GetMarketSummary(market *environment.Market) (*environment.MarketSummary, error) {
+	summary := &environment.MarketSummary{}
+	if len(exchange.book.Asks) > 0 {
+		summary.Ask = exchange.book.Asks[0].Value
+	}
+	if len(exchange.book.Bids) > 0 {
+		summary.Bid = exchange.book.Bids[0].Value
+	}
+	summary.Last = summary.Ask.Add(summary.Bid).Div(decimal.NewFromInt(2))
+	return summary, nil
+}
+
+func placeWith(wrapper ExchangeWrapper, order testOrder) (string, error) {
+	switch {
+	case order.limit == "" && order.side == environment.Bid:
+		return wrapper.BuyMarket(testMarket, dec(order.quantity))
+	case order.limit == "":
+		return wrapper.SellMarket(testMarket, dec(order.quantity))
+	case order.side == environment.Bid:
+		return wrapper.BuyLimit(testMarket, dec(order.quantity), dec(order.limit))
+	default:
+		return wrapper.SellLimit(testMarket, dec(order.quantity), dec(order.limit))
+	}
+}
+
+func TestRiskManagerOrders(t *testing.T) {
+	book := environment.OrderBook{
+		Asks: levels("10", "10"),
+		Bids: levels("9", "10"),
+	}
+	limits := func(base string, value string) map[string]decimal.Decimal {
+		return map[string]decimal.Decimal{base: dec(value)}
+	}
+
+	tests := []struct {
+		name    string
+		limits  environment.RiskConfig
+		before  []testOrder // orders placed before the checked one.
+		nextDay bool        // checks the order on the day after the previous orders.
+		order   testOrder
+		err     error // limit refusing the order, nil if placed.
+	}{
+		{"no limits", environment.RiskConfig{}, nil, false, testOrder{environment.Bid, "5", ""}, nil},
+		{"notional within the limit", environment.RiskConfig{MaxOrderNotional: limits("BTC", "10")}, nil, false, testOrder{environment.Bid, "1", "9.5"}, nil},
+		{"notional above the limit", environment.RiskConfig{MaxOrderNotional: limits("BTC", "10")}, nil, false, testOrder{environment.Bid, "2", "9.5"}, ErrMaxOrderNotional},
+		{"market buy valued at the ask", environment.RiskConfig{MaxOrderNotional: limits("BTC", "9.5")}, nil, false, testOrder{environment.Bid, "1", ""}, ErrMaxOrderNotional},
+		{"market sell valued at the bid", environment.RiskConfig{MaxOrderNotional: limits("BTC", "9.5")}, nil, false, testOrder{environment.Ask, "1", ""}, nil},
+		{"notional of another base currency", environment.RiskConfig{MaxOrderNotional: limits("USDT", "1")}, nil, false, testOrder{environment.Bid, "1", ""}, nil},
+		{"buy within the deviation", environment.RiskConfig{MaxPriceDeviation: dec("0.05")}, nil, false, testOrder{environment.Bid, "1", "9.9"}, nil},
+		{"buy above the deviation", environment.RiskConfig{MaxPriceDeviation: dec("0.05")}, nil, false, testOrder{environment.Bid, "1", "10"}, ErrPriceDeviation},
+		{"buy below the last price", environment.RiskConfig{MaxPriceDeviation: dec("0.05")}, nil, false, testOrder{environment.Bid, "1", "5"}, nil},
+		{"sell within the deviation", environment.RiskConfig{MaxPriceDeviation: dec("0.05")}, nil, false, testOrder{environment.Ask, "1", "9.1"}, nil},
+		{"sell below the deviation", environment.RiskConfig{MaxPriceDeviation: dec("0.05")}, nil, false, testOrder{environment.Ask, "1", "9"}, ErrPriceDeviation},
+		{"market orders have no deviation", environment.RiskConfig{MaxPriceDeviation: dec("0.05")}, nil, false, testOrder{environment.Bid, "1", ""}, nil},
+		{"open orders within the limit", environment.RiskConfig{MaxOpenOrders: 2}, []testOrder{{environment.Bid, "1", "5"}}, false, testOrder{environment.Ask, "1", "20"}, nil},
+		{"open orders above the limit", environment.RiskConfig{MaxOpenOrders: 1}, []testOrder{{environment.Bid, "1", "5"}}, false, testOrder{environment.Ask, "1", "20"}, ErrMaxOpenOrders},
+		{"position within the limit", environment.RiskConfig{MaxPosition: limits("BTC-ETH", "12")}, []testOrder{{environment.Bid, "1", "5"}}, false, testOrder{environment.Bid, "1", ""}, nil},
+		{"position above the limit with the open buys", environment.RiskConfig{MaxPosition: limits("BTC-ETH", "12")}, []testOrder{{environment.Bid, "1", "5"}}, false, testOrder{environment.Bid, "1.5", ""}, ErrMaxPosition},
+		{"sells reduce the position", environment.RiskConfig{MaxPosition: limits("BTC-ETH", "1")}, nil, false, testOrder{environment.Ask, "1", ""}, nil},
+		{
+			name:   "buys within the daily loss",
+			limits: environment.RiskConfig{DailyLossLimit: limits("BTC", "1.5")},
+			before: []testOrder{{environment.Bid, "1", ""}, {environment.Ask, "1", ""}},
+			order:  testOrder{environment.Bid, "1", ""},
+		},
+		{
+			name:   "buys after the daily loss",
+			limits: environment.RiskConfig{DailyLossLimit: limits("BTC", "1")},
+			before: []testOrder{{environment.Bid, "1", ""}, {environment.Ask, "1", ""}},
+			order:  testOrder{environment.Bid, "1", ""},
+			err:    ErrDailyLossLimit,
+		},
+		{
+			name:   "sells after the daily loss",
+			limits: environment.RiskConfig{DailyLossLimit: limits("BTC", "1")},
+			before: []testOrder{{environment.Bid, "1", ""}, {environment.Ask, "1", ""}},
+			order:  testOrder{environment.Ask, "1", ""},
+		},
+		{
+			name:   "sells above the position do not count as losses",
+			limits: environment.RiskConfig{DailyLossLimit: limits("BTC", "1")},
+			before: []testOrder{{environment.Bid, "1", ""}, {environment.Ask, "1", ""}, {environment.Ask, "1", ""}},
+			order:  testOrder{environment.Bid, "1", ""},
+			err:    ErrDailyLossLimit,
+		},
+		{
+			name:    "buys on the next day",
+			limits:  environment.RiskConfig{DailyLossLimit: limits("BTC", "1")},
+			before:  []testOrder{{environment.Bid, "1", ""}, {environment.Ask, "1", ""}},
+			nextDay: true,
+			order:   testOrder{environment.Bid, "1", ""},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			simulator := NewExchangeWrapperSimulator(&fakeExchange{book: book}, map[string]decimal.Decimal{
+				"BTC": dec("100"),
+				"ETH": dec("10"),
+			})
+			manager := NewRiskManager(simulator, test.limits)
+			day := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+			manager.now = func() time.Time { return day }
+
+			for _, order := range test.before {
+				if _, err := placeWith(manager, order); err != nil {
+					t.Fatalf("cannot place %v: %s", order, err)
+				}
+			}
+			if test.nextDay {
+				day = day.Add(24 * time.Hour)
+			}
+
+			_, err := placeWith(manager, test.order)
+			if test.err == nil {
+				if err != nil {
+					t.Fatalf("order refused: %s", err)
+				}
+				return
+			}
+			var riskErr *RiskError
+			if !errors.As(err, &riskErr) || !errors.Is(err, test.err) {
+				t.Fatalf("error %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestRiskManagerWithdraw(t *testing.T) {
+	tests := []struct {
+		coin   string
+		amount string
+		err    error
+	}{
+		{"BTC", "1", nil},
+		{"BTC", "2", ErrMaxWithdraw},
+		{"ETH", "5", nil},
+	}
+
+	for _, test := range tests {
+		simulator := NewExchangeWrapperSimulator(&fakeExchange{}, map[string]decimal.Decimal{
+			"BTC": dec("100"),
+			"ETH": dec("10"),
+		})
+		manager := NewRiskManager(simulator, environment.RiskConfig{MaxWithdraw: map[string]decimal.Decimal{"BTC": dec("1")}})
+
+		err := manager.Withdraw("address", test.coin, dec(test.amount))
+		if !errors.Is(err, test.err) {
+			t.Errorf("withdraw %s %s: error %v, want %v", test.amount, test.coin, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package indicators
+
+import (
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/shopspring/decimal"
+)
+
+// ATR represents a streaming Average True Range, using the Wilder smoothing.
+type ATR struct {
+	period   int
+	previous *environment.CandleStick
+	count    int
+	value    decimal.Decimal
+}
+
+// NewATR creates an Average True Range of the specified period (usually 14).
+func NewATR(period int) *ATR {
+	validatePeriod(period)
+	return &ATR{
+		period: period,
+	}
+}
+
+// Add updates the average with a new candle, returning the average and whether it is ready.
+func (atr *ATR) Add(candle environment.CandleStick) (decimal.Decimal, bool) {
+	trueRange := candle.High.Sub(candle.Low)
+	if atr.previous != nil {
+		trueRange = decimal.Max(trueRange,
+			candle.High.Sub(atr.previous.Close).Abs(),
+			candle.Low.Sub(atr.previous.Close).Abs())
+	}
+	atr.previous = &candle
+
+	period := decimal.NewFromInt(int64(atr.period))
+	atr.count++
+	if atr.count <= atr.period {
+		// seed with the simple average of the first period true ranges.
+		atr.value = atr.value.Add(trueRange.Div(period))
+		return atr.value, atr.Ready()
+	}
+
+	atr.value = round(atr.value.Mul(period.Sub(decimal.NewFromInt(1))).Add(trueRange).Div(period))
+	return atr.value, true
+}
+
+// Update updates the average with a new candle.
+func (atr *ATR) Update(candle environment.CandleStick) {
+	atr.Add(candle)
+}
+
+// Ready returns true when period candles have been added.
+func (atr *ATR) Ready() bool {
+	return atr.count >= atr.period
+}
+
+// Value returns the current average.
+func (atr *ATR) Value() decimal.Decimal {
+	return atr.value
+}
+
+// ATRSeries computes the Average True Range of the candles, starting from the first complete period
+// (len(candles) - period + 1 values).
+func ATRSeries(candles []environment.CandleStick, period int) []decimal.Decimal {
+	atr := NewATR(period)
+	ret := make([]decimal.Decimal, 0, len(candles))
+	for _, candle := range candles {
+		if result, ready := atr.Add(candle); ready {
+			ret = append(ret, result)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,94 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package indicators
+
+import (
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/shopspring/decimal"
+)
+
+// Bands represents a value of the Bollinger Bands.
+type Bands struct {
+	Upper  decimal.Decimal // Represents the average plus k standard deviations.
+	Middle decimal.Decimal // Represents the simple average.
+	Lower  decimal.Decimal // Represents the average minus k standard deviations.
+}
+
+// BollingerBands represents streaming Bollinger Bands.
+type BollingerBands struct {
+	sma   *SMA
+	k     decimal.Decimal
+	value Bands
+}
+
+// NewBollingerBands creates Bollinger Bands of the specified period, k standard deviations wide (usually 20 and 2).
+func NewBollingerBands(period int, k decimal.Decimal) *BollingerBands {
+	return &BollingerBands{
+		sma: NewSMA(period),
+		k:   k,
+	}
+}
+
+// Add updates the bands with a new value, returning them and whether they are ready.
+func (bb *BollingerBands) Add(value decimal.Decimal) (Bands, bool) {
+	middle, ready := bb.sma.Add(value)
+	if !ready {
+		return Bands{}, false
+	}
+
+	var variance decimal.Decimal
+	for _, windowValue := range bb.sma.window {
+		deviation := windowValue.Sub(middle)
+		variance = variance.Add(deviation.Mul(deviation))
+	}
+	variance = variance.Div(decimal.NewFromInt(int64(bb.sma.period)))
+	width := sqrt(variance).Mul(bb.k)
+
+	bb.value = Bands{
+		Upper:  middle.Add(width),
+		Middle: middle,
+		Lower:  middle.Sub(width),
+	}
+	return bb.value, true
+}
+
+// Update updates the bands with the close value of a candle.
+func (bb *BollingerBands) Update(candle environment.CandleStick) {
+	bb.Add(candle.Close)
+}
+
+// Ready returns true when period values have been added.
+func (bb *BollingerBands) Ready() bool {
+	return bb.sma.Ready()
+}
+
+// Value returns the current bands.
+func (bb *BollingerBands) Value() Bands {
+	return bb.value
+}
+
+// BollingerSeries computes the Bollinger Bands of the values, starting from the first complete period
+// (len(values) - period + 1 values).
+func BollingerSeries(values []decimal.Decimal, period int, k decimal.Decimal) []Bands {
+	bb := NewBollingerBands(period, k)
+	ret := make([]Bands, 0, len(values))
+	for _, value := range values {
+		if result, ready := bb.Add(value); ready {
+			ret = append(ret, result)
+		}
+	}
+	return ret
+}
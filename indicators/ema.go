@@ -0,0 +1,71 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package indicators
+
+import (
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/shopspring/decimal"
+)
+
+// EMA represents a streaming Exponential Moving Average, seeded with the simple average of the first period values.
+type EMA struct {
+	period int
+	k      decimal.Decimal // smoothing factor, 2 / (period + 1).
+	seed   *SMA
+	value  decimal.Decimal
+}
+
+// NewEMA creates an Exponential Moving Average of the specified period.
+func NewEMA(period int) *EMA {
+	validatePeriod(period)
+	return &EMA{
+		period: period,
+		k:      decimal.NewFromInt(2).Div(decimal.NewFromInt(int64(period + 1))),
+		seed:   NewSMA(period),
+	}
+}
+
+// Add updates the average with a new value, returning the average and whether it is ready.
+func (ema *EMA) Add(value decimal.Decimal) (decimal.Decimal, bool) {
+	if !ema.seed.Ready() {
+		ema.value, _ = ema.seed.Add(value)
+		return ema.value, ema.seed.Ready()
+	}
+
+	ema.value = round(value.Sub(ema.value).Mul(ema.k).Add(ema.value))
+	return ema.value, true
+}
+
+// Update updates the average with the close value of a candle.
+func (ema *EMA) Update(candle environment.CandleStick) {
+	ema.Add(candle.Close)
+}
+
+// Ready returns true when period values have been added.
+func (ema *EMA) Ready() bool {
+	return ema.seed.Ready()
+}
+
+// Value returns the current average.
+func (ema *EMA) Value() decimal.Decimal {
+	return ema.value
+}
+
+// EMASeries computes the Exponential Moving Average of the values, starting from the first complete period
+// (len(values) - period + 1 values).
+func EMASeries(values []decimal.Decimal, period int) []decimal.Decimal {
+	return series(values, NewEMA(period).Add)
+}
@@ -0,0 +1,124 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package indicators
+
+import (
+	"math"
+
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/shopspring/decimal"
+)
+
+// CandleIndicator represents a streaming indicator, updated a candle at a time.
+type CandleIndicator interface {
+	Update(candle environment.CandleStick) // Update updates the indicator with a new closed candle.
+	Ready() bool                           // Ready returns true when enough candles have been received to compute the indicator.
+}
+
+// CandleFeed feeds streaming indicators with the windows of candles returned by GetCandles (or stored in a CandlesCache),
+// so that each closed candle is received only once.
+//
+// The last candle of a window is considered in progress, it is fed once a newer candle follows it.
+type CandleFeed struct {
+	indicators []CandleIndicator
+	last       *environment.CandleStick // last candle fed to the indicators.
+}
+
+// NewCandleFeed creates a feed updating the specified indicators.
+func NewCandleFeed(indicators ...CandleIndicator) *CandleFeed {
+	return &CandleFeed{
+		indicators: indicators,
+	}
+}
+
+// Update feeds the indicators with the closed candles of the window following the last candle fed,
+// returning the number of candles fed.
+func (feed *CandleFeed) Update(candles []environment.CandleStick) int {
+	if len(candles) < 2 {
+		return 0
+	}
+	closed := candles[:len(candles)-1]
+
+	// if the last candle fed is out of the window, all the window is new.
+	start := 0
+	if feed.last != nil {
+		for i := len(closed) - 1; i >= 0; i-- {
+			if sameCandle(closed[i], *feed.last) {
+				start = i + 1
+				break
+			}
+		}
+	}
+
+	for _, candle := range closed[start:] {
+		for _, indicator := range feed.indicators {
+			indicator.Update(candle)
+		}
+	}
+
+	if start < len(closed) {
+		last := closed[len(closed)-1]
+		feed.last = &last
+	}
+	return len(closed) - start
+}
+
+// sameCandle tells if two candles represent the same period.
+//
+// Candles are matched by open time when both carry it, otherwise by their values.
+func sameCandle(a environment.CandleStick, b environment.CandleStick) bool {
+	if !a.OpenTime.IsZero() && !b.OpenTime.IsZero() {
+		return a.OpenTime.Equal(b.OpenTime)
+	}
+	return a.Open.Equal(b.Open) && a.High.Equal(b.High) && a.Low.Equal(b.Low) && a.Close.Equal(b.Close) && a.Volume.Equal(b.Volume)
+}
+
+// Closes returns the close values of the candles.
+func Closes(candles []environment.CandleStick) []decimal.Decimal {
+	ret := make([]decimal.Decimal, len(candles))
+	for i, candle := range candles {
+		ret[i] = candle.Close
+	}
+	return ret
+}
+
+// validatePeriod panics if the period of an indicator is not positive.
+func validatePeriod(period int) {
+	if period <= 0 {
+		panic("Indicator period must be positive")
+	}
+}
+
+// round limits the precision of the values carried between updates, which would grow at each multiplication.
+func round(d decimal.Decimal) decimal.Decimal {
+	return d.Round(int32(decimal.DivisionPrecision))
+}
+
+// sqrt computes the square root of a non negative decimal.
+func sqrt(d decimal.Decimal) decimal.Decimal {
+	if !d.IsPositive() {
+		return decimal.Zero
+	}
+
+	// refine the float approximation with Newton's method.
+	approx, _ := d.Float64()
+	x := decimal.NewFromFloat(math.Sqrt(approx))
+	two := decimal.NewFromInt(2)
+	for i := 0; i < 4; i++ {
+		x = x.Add(d.Div(x)).Div(two)
+	}
+	return x
+}
@@ -0,0 +1,213 @@
+package indicators
+
+import (
+	"testing"
+
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/shopspring/decimal"
+)
+
+func decimals(values ...string) []decimal.Decimal {
+	ret := make([]decimal.Decimal, len(values))
+	for i, value := range values {
+		ret[i] = decimal.RequireFromString(value)
+	}
+	return ret
+}
+
+func equalSeries(got []decimal.Decimal, want []decimal.Decimal) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if !got[i].Equal(want[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSeries(t *testing.T) {
+	tests := []struct {
+		name   string
+		series func([]decimal.Decimal) []decimal.Decimal
+		values []decimal.Decimal
+		want   []decimal.Decimal
+	}{
+		{
+			name:   "SMA waits for a full window",
+			series: func(values []decimal.Decimal) []decimal.Decimal { return SMASeries(values, 3) },
+			values: decimals("1", "2"),
+			want:   decimals(),
+		},
+		{
+			name:   "SMA slides its window",
+			series: func(values []decimal.Decimal) []decimal.Decimal { return SMASeries(values, 2) },
+			values: decimals("1", "2", "3", "5"),
+			want:   decimals("1.5", "2.5", "4"),
+		},
+		{
+			name:   "EMA is seeded with the SMA of the first period",
+			series: func(values []decimal.Decimal) []decimal.Decimal { return EMASeries(values, 3) },
+			values: decimals("1", "2", "3"),
+			want:   decimals("2"),
+		},
+		{
+			name:   "EMA smooths after the seed",
+			series: func(values []decimal.Decimal) []decimal.Decimal { return EMASeries(values, 3) },
+			values: decimals("1", "2", "3", "4", "5"),
+			want:   decimals("2", "3", "4"),
+		},
+		{
+			name:   "RSI waits for period changes",
+			series: func(values []decimal.Decimal) []decimal.Decimal { return RSISeries(values, 2) },
+			values: decimals("1", "2"),
+			want:   decimals(),
+		},
+		{
+			name:   "RSI is seeded with the average changes of the first period",
+			series: func(values []decimal.Decimal) []decimal.Decimal { return RSISeries(values, 2) },
+			values: decimals("1", "3", "2"),
+			want:   decimals("66.6666666666666667"),
+		},
+		{
+			name:   "RSI without losses is 100",
+			series: func(values []decimal.Decimal) []decimal.Decimal { return RSISeries(values, 2) },
+			values: decimals("1", "2", "3"),
+			want:   decimals("100"),
+		},
+		{
+			name:   "RSI smooths the averages after the seed",
+			series: func(values []decimal.Decimal) []decimal.Decimal { return RSISeries(values, 2) },
+			values: decimals("1", "2", "3", "2"),
+			want:   decimals("100", "50"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.series(test.values); !equalSeries(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBollingerSeries(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []decimal.Decimal
+		k      decimal.Decimal
+		want   []Bands
+	}{
+		{"waits for a full window", decimals("1"), decimal.NewFromInt(2), nil},
+		{"flat values have no width", decimals("2", "2"), decimal.NewFromInt(2), []Bands{{Upper: decimal.NewFromInt(2), Middle: decimal.NewFromInt(2), Lower: decimal.NewFromInt(2)}}},
+		{"k standard deviations around the average", decimals("1", "3"), decimal.NewFromInt(2), []Bands{{Upper: decimal.NewFromInt(4), Middle: decimal.NewFromInt(2), Lower: decimal.Zero}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := BollingerSeries(test.values, 2, test.k)
+			if len(got) != len(test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if !got[i].Upper.Equal(test.want[i].Upper) || !got[i].Middle.Equal(test.want[i].Middle) || !got[i].Lower.Equal(test.want[i].Lower) {
+					t.Errorf("got %v, want %v", got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestATRSeries(t *testing.T) {
+	candle := func(high string, low string, close string) environment.CandleStick {
+		return environment.CandleStick{
+			High:  decimal.RequireFromString(high),
+			Low:   decimal.RequireFromString(low),
+			Close: decimal.RequireFromString(close),
+		}
+	}
+
+	tests := []struct {
+		name    string
+		candles []environment.CandleStick
+		want    []decimal.Decimal
+	}{
+		{"waits for period candles", []environment.CandleStick{candle("3", "1", "2")}, decimals()},
+		{"is seeded with the average range", []environment.CandleStick{candle("3", "1", "2"), candle("4", "2", "3")}, decimals("2")},
+		{"smooths the true ranges after the seed", []environment.CandleStick{candle("3", "1", "2"), candle("4", "2", "3"), candle("6", "3", "5")}, decimals("2", "2.5")},
+		{"true ranges include the gaps from the previous close", []environment.CandleStick{candle("3", "1", "2"), candle("4", "2", "3"), candle("9", "8", "8")}, decimals("2", "4")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ATRSeries(test.candles, 2); !equalSeries(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMACDSeries(t *testing.T) {
+	tests := []struct {
+		values int
+		ready  int
+	}{
+		{2, 0},
+		{3, 0}, // the slow average is ready, the signal needs another value.
+		{4, 1},
+		{6, 3},
+	}
+
+	for _, test := range tests {
+		values := make([]decimal.Decimal, test.values)
+		for i := range values {
+			values[i] = decimal.NewFromInt(int64(i + 1))
+		}
+		if got := MACDSeries(values, 2, 3, 2); len(got) != test.ready {
+			t.Errorf("%d values: %d ready, want %d", test.values, len(got), test.ready)
+		}
+	}
+}
+
+func TestCandleFeed(t *testing.T) {
+	candle := func(close int64) environment.CandleStick {
+		value := decimal.NewFromInt(close)
+		return environment.CandleStick{Open: value, High: value, Low: value, Close: value}
+	}
+	window := func(closes ...int64) []environment.CandleStick {
+		ret := make([]environment.CandleStick, len(closes))
+		for i, close := range closes {
+			ret[i] = candle(close)
+		}
+		return ret
+	}
+
+	tests := []struct {
+		name    string
+		windows [][]environment.CandleStick
+		fed     []int
+		want    decimal.Decimal // SMA of the last 2 closed candles fed.
+	}{
+		{"the last candle is in progress", [][]environment.CandleStick{window(1, 2, 3)}, []int{2}, decimal.RequireFromString("1.5")},
+		{"the same window is fed once", [][]environment.CandleStick{window(1, 2, 3), window(1, 2, 3)}, []int{2, 0}, decimal.RequireFromString("1.5")},
+		{"overlapping windows feed the new candles", [][]environment.CandleStick{window(1, 2, 3), window(2, 3, 4, 5)}, []int{2, 2}, decimal.RequireFromString("3.5")},
+		{"disjoint windows are fed whole", [][]environment.CandleStick{window(1, 2), window(7, 8, 9)}, []int{1, 2}, decimal.RequireFromString("7.5")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sma := NewSMA(2)
+			feed := NewCandleFeed(sma)
+			for i, candles := range test.windows {
+				if fed := feed.Update(candles); fed != test.fed[i] {
+					t.Errorf("window %d: fed %d candles, want %d", i, fed, test.fed[i])
+				}
+			}
+			if !sma.Value().Equal(test.want) {
+				t.Errorf("SMA %s, want %s", sma.Value(), test.want)
+			}
+		})
+	}
+}
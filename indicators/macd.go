@@ -0,0 +1,92 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package indicators
+
+import (
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/shopspring/decimal"
+)
+
+// MACDValue represents a value of the Moving Average Convergence Divergence.
+type MACDValue struct {
+	MACD      decimal.Decimal // Represents the difference between the fast and the slow average.
+	Signal    decimal.Decimal // Represents the average of the MACD.
+	Histogram decimal.Decimal // Represents the difference between the MACD and the signal.
+}
+
+// MACD represents a streaming Moving Average Convergence Divergence.
+type MACD struct {
+	fast   *EMA
+	slow   *EMA
+	signal *EMA
+	value  MACDValue
+}
+
+// NewMACD creates a Moving Average Convergence Divergence with the specified periods (usually 12, 26 and 9).
+func NewMACD(fastPeriod int, slowPeriod int, signalPeriod int) *MACD {
+	return &MACD{
+		fast:   NewEMA(fastPeriod),
+		slow:   NewEMA(slowPeriod),
+		signal: NewEMA(signalPeriod),
+	}
+}
+
+// Add updates the indicator with a new value, returning its value and whether it is ready.
+func (macd *MACD) Add(value decimal.Decimal) (MACDValue, bool) {
+	fast, fastReady := macd.fast.Add(value)
+	slow, slowReady := macd.slow.Add(value)
+	if !fastReady || !slowReady {
+		return MACDValue{}, false
+	}
+
+	macd.value.MACD = fast.Sub(slow)
+	signal, ready := macd.signal.Add(macd.value.MACD)
+	if !ready {
+		return MACDValue{}, false
+	}
+
+	macd.value.Signal = signal
+	macd.value.Histogram = macd.value.MACD.Sub(signal)
+	return macd.value, true
+}
+
+// Update updates the indicator with the close value of a candle.
+func (macd *MACD) Update(candle environment.CandleStick) {
+	macd.Add(candle.Close)
+}
+
+// Ready returns true when the signal line can be computed.
+func (macd *MACD) Ready() bool {
+	return macd.signal.Ready()
+}
+
+// Value returns the current value of the indicator.
+func (macd *MACD) Value() MACDValue {
+	return macd.value
+}
+
+// MACDSeries computes the Moving Average Convergence Divergence of the values, starting from the first value
+// with a signal.
+func MACDSeries(values []decimal.Decimal, fastPeriod int, slowPeriod int, signalPeriod int) []MACDValue {
+	macd := NewMACD(fastPeriod, slowPeriod, signalPeriod)
+	ret := make([]MACDValue, 0, len(values))
+	for _, value := range values {
+		if result, ready := macd.Add(value); ready {
+			ret = append(ret, result)
+		}
+	}
+	return ret
+}
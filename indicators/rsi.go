@@ -0,0 +1,97 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package indicators
+
+import (
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/shopspring/decimal"
+)
+
+// RSI represents a streaming Relative Strength Index, using the Wilder smoothing.
+type RSI struct {
+	period   int
+	previous *decimal.Decimal
+	count    int // number of changes received.
+	avgGain  decimal.Decimal
+	avgLoss  decimal.Decimal
+	value    decimal.Decimal
+}
+
+// NewRSI creates a Relative Strength Index of the specified period (usually 14).
+func NewRSI(period int) *RSI {
+	validatePeriod(period)
+	return &RSI{
+		period: period,
+	}
+}
+
+// Add updates the index with a new value, returning the index (0-100) and whether it is ready.
+func (rsi *RSI) Add(value decimal.Decimal) (decimal.Decimal, bool) {
+	if rsi.previous == nil {
+		rsi.previous = &value
+		return decimal.Zero, false
+	}
+
+	change := value.Sub(*rsi.previous)
+	rsi.previous = &value
+	gain := decimal.Max(change, decimal.Zero)
+	loss := decimal.Max(change.Neg(), decimal.Zero)
+
+	period := decimal.NewFromInt(int64(rsi.period))
+	rsi.count++
+	if rsi.count <= rsi.period {
+		// seed with the simple average of the first period changes.
+		rsi.avgGain = rsi.avgGain.Add(gain.Div(period))
+		rsi.avgLoss = rsi.avgLoss.Add(loss.Div(period))
+		if rsi.count < rsi.period {
+			return decimal.Zero, false
+		}
+	} else {
+		previousWeight := period.Sub(decimal.NewFromInt(1))
+		rsi.avgGain = round(rsi.avgGain.Mul(previousWeight).Add(gain).Div(period))
+		rsi.avgLoss = round(rsi.avgLoss.Mul(previousWeight).Add(loss).Div(period))
+	}
+
+	hundred := decimal.NewFromInt(100)
+	if rsi.avgLoss.IsZero() {
+		rsi.value = hundred
+	} else {
+		rs := rsi.avgGain.Div(rsi.avgLoss)
+		rsi.value = hundred.Sub(hundred.Div(rs.Add(decimal.NewFromInt(1))))
+	}
+	return rsi.value, true
+}
+
+// Update updates the index with the close value of a candle.
+func (rsi *RSI) Update(candle environment.CandleStick) {
+	rsi.Add(candle.Close)
+}
+
+// Ready returns true when period + 1 values have been added.
+func (rsi *RSI) Ready() bool {
+	return rsi.count >= rsi.period
+}
+
+// Value returns the current index.
+func (rsi *RSI) Value() decimal.Decimal {
+	return rsi.value
+}
+
+// RSISeries computes the Relative Strength Index of the values, starting from the first complete period
+// (len(values) - period values).
+func RSISeries(values []decimal.Decimal, period int) []decimal.Decimal {
+	return series(values, NewRSI(period).Add)
+}
@@ -0,0 +1,88 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package indicators
+
+import (
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/shopspring/decimal"
+)
+
+// SMA represents a streaming Simple Moving Average.
+type SMA struct {
+	period int
+	window []decimal.Decimal // last period values, as a ring buffer.
+	next   int               // position of the oldest value in the window.
+	count  int
+	sum    decimal.Decimal
+}
+
+// NewSMA creates a Simple Moving Average of the specified period.
+func NewSMA(period int) *SMA {
+	validatePeriod(period)
+	return &SMA{
+		period: period,
+		window: make([]decimal.Decimal, period),
+	}
+}
+
+// Add updates the average with a new value, returning the average and whether it is ready.
+func (sma *SMA) Add(value decimal.Decimal) (decimal.Decimal, bool) {
+	if sma.count == sma.period {
+		sma.sum = sma.sum.Sub(sma.window[sma.next])
+	} else {
+		sma.count++
+	}
+	sma.window[sma.next] = value
+	sma.next = (sma.next + 1) % sma.period
+	sma.sum = sma.sum.Add(value)
+
+	return sma.Value(), sma.Ready()
+}
+
+// Update updates the average with the close value of a candle.
+func (sma *SMA) Update(candle environment.CandleStick) {
+	sma.Add(candle.Close)
+}
+
+// Ready returns true when period values have been added.
+func (sma *SMA) Ready() bool {
+	return sma.count == sma.period
+}
+
+// Value returns the current average (of the values added so far, if not ready).
+func (sma *SMA) Value() decimal.Decimal {
+	if sma.count == 0 {
+		return decimal.Zero
+	}
+	return sma.sum.Div(decimal.NewFromInt(int64(sma.count)))
+}
+
+// SMASeries computes the Simple Moving Average of the values, starting from the first complete period
+// (len(values) - period + 1 values).
+func SMASeries(values []decimal.Decimal, period int) []decimal.Decimal {
+	return series(values, NewSMA(period).Add)
+}
+
+// series applies a streaming indicator to the values, returning its ready outputs.
+func series(values []decimal.Decimal, add func(decimal.Decimal) (decimal.Decimal, bool)) []decimal.Decimal {
+	ret := make([]decimal.Decimal, 0, len(values))
+	for _, value := range values {
+		if result, ready := add(value); ready {
+			ret = append(ret, result)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,368 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package portfolio
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/saniales/golang-crypto-trading-bot/exchanges"
+	"github.com/shopspring/decimal"
+)
+
+// Fill represents a quantity of an order filled on an exchange.
+type Fill struct {
+	Exchange    string                // Represents the name of the exchange.
+	Market      *environment.Market   // Represents the market of the order.
+	OrderID     string                // Represents the ID of the order.
+	Side        environment.OrderType // Represents the side of the order (Bid for buys, Ask for sells).
+	Quantity    decimal.Decimal       // Represents the quantity filled, in market currency.
+	Price       decimal.Decimal       // Represents the average price of the fill, in base currency.
+	Fee         decimal.Decimal       // Represents the fees paid by the fill, in fee currency.
+	FeeCurrency string                // Represents the currency the fees have been charged in, base currency if empty.
+	Time        time.Time             // Represents the time the fill has been recorded.
+}
+
+// feeInBaseCurrency returns the fees of the fill in the base currency of its market.
+func (fill Fill) feeInBaseCurrency() decimal.Decimal {
+	if fill.FeeCurrency != "" && fill.FeeCurrency == fill.Market.MarketCurrency {
+		return fill.Fee.Mul(fill.Price)
+	}
+	return fill.Fee
+}
+
+// Position represents the market currency bought on a market of an exchange and not sold yet, valued at its average cost.
+//
+// Coins sold beyond the quantity of the position (e.g. held before the portfolio was tracked) have no cost basis,
+// so they do not change the position nor its P&L.
+type Position struct {
+	Exchange    string              // Represents the name of the exchange.
+	Market      *environment.Market // Represents the market.
+	Quantity    decimal.Decimal     // Represents the quantity held, in market currency.
+	Cost        decimal.Decimal     // Represents the cost of the quantity held, fees included, in base currency.
+	RealizedPnL decimal.Decimal     // Represents the profit of the quantity sold, net of fees, in base currency.
+	Fees        decimal.Decimal     // Represents the fees paid on the market, in base currency.
+}
+
+// EntryPrice returns the average price paid for the quantity held, fees included, zero if the position is closed.
+func (position Position) EntryPrice() decimal.Decimal {
+	if !position.Quantity.IsPositive() {
+		return decimal.Zero
+	}
+	return position.Cost.Div(position.Quantity)
+}
+
+// UnrealizedPnL returns the profit of the quantity held if sold at the specified price, in base currency.
+func (position Position) UnrealizedPnL(price decimal.Decimal) decimal.Decimal {
+	if !position.Quantity.IsPositive() {
+		return decimal.Zero
+	}
+	return position.Quantity.Mul(price).Sub(position.Cost)
+}
+
+// apply updates the position with a fill.
+func (position *Position) apply(fill Fill) {
+	fee := fill.feeInBaseCurrency()
+	position.Fees = position.Fees.Add(fee)
+
+	if fill.Side == environment.Bid {
+		if fill.FeeCurrency != "" && fill.FeeCurrency == fill.Market.MarketCurrency {
+			// fees are taken from the coins received.
+			position.Quantity = position.Quantity.Add(fill.Quantity).Sub(fill.Fee)
+			position.Cost = position.Cost.Add(fill.Quantity.Mul(fill.Price))
+		} else {
+			position.Quantity = position.Quantity.Add(fill.Quantity)
+			position.Cost = position.Cost.Add(fill.Quantity.Mul(fill.Price)).Add(fee)
+		}
+		return
+	}
+
+	closed := decimal.Min(fill.Quantity, position.Quantity)
+	if !closed.IsPositive() {
+		return
+	}
+	cost := position.Cost.Mul(closed).Div(position.Quantity)
+	// fees are charged proportionally to the quantity with a cost basis.
+	closedFee := fee.Mul(closed).Div(fill.Quantity)
+	position.RealizedPnL = position.RealizedPnL.Add(closed.Mul(fill.Price)).Sub(closedFee).Sub(cost)
+	position.Quantity = position.Quantity.Sub(closed)
+	position.Cost = position.Cost.Sub(cost)
+	if position.Quantity.IsZero() {
+		position.Cost = decimal.Zero
+	}
+}
+
+// trackedOrder represents the quantities of an order already recorded as fills.
+type trackedOrder struct {
+	id      string
+	wrapper exchanges.ExchangeWrapper // wrapper the order has been placed through, nil if not placed by the bot.
+	market  *environment.Market
+	filled  decimal.Decimal
+	value   decimal.Decimal // filled quantity times average price.
+	fee     decimal.Decimal
+	open    bool
+}
+
+// Portfolio tracks the balances, the fills and the positions of the bot across the exchanges.
+//
+// Fills are recorded from the orders seen by the wrappers returned by Track: the orders they place are
+// followed until closed (see Sync), and every order they get from the exchange records its new fills.
+type Portfolio struct {
+	quoteCurrency string
+	mutex         sync.Mutex
+	wrappers      []exchanges.ExchangeWrapper
+	orders        map[string]*trackedOrder // by exchange and order ID.
+	positions     map[string]*Position     // by exchange and market name.
+	fills         []Fill
+}
+
+// New creates a portfolio whose equity is expressed in the specified quote currency (e.g. BTC or USDT).
+func New(quoteCurrency string) *Portfolio {
+	return &Portfolio{
+		quoteCurrency: quoteCurrency,
+		orders:        make(map[string]*trackedOrder),
+		positions:     make(map[string]*Position),
+	}
+}
+
+// QuoteCurrency returns the currency the equity is expressed in.
+func (portfolio *Portfolio) QuoteCurrency() string {
+	return portfolio.quoteCurrency
+}
+
+// Track returns a wrapper recording the fills of its orders in the portfolio, whose balances are aggregated in the reports.
+func (portfolio *Portfolio) Track(wrapper exchanges.ExchangeWrapper) exchanges.ExchangeWrapper {
+	portfolio.mutex.Lock()
+	portfolio.wrappers = append(portfolio.wrappers, wrapper)
+	portfolio.mutex.Unlock()
+
+	return &TrackedWrapper{
+		ExchangeWrapper: wrapper,
+		portfolio:       portfolio,
+	}
+}
+
+// TrackAll returns the wrappers recording the fills of their orders in the portfolio (see Track).
+func (portfolio *Portfolio) TrackAll(wrappers []exchanges.ExchangeWrapper) []exchanges.ExchangeWrapper {
+	ret := make([]exchanges.ExchangeWrapper, len(wrappers))
+	for i, wrapper := range wrappers {
+		ret[i] = portfolio.Track(wrapper)
+	}
+	return ret
+}
+
+// Of returns the portfolio tracking a wrapper, looking through its decorators (e.g. the wrappers bound to a context).
+func Of(wrapper exchanges.ExchangeWrapper) (*Portfolio, bool) {
+	for {
+		if tracked, isTracked := wrapper.(*TrackedWrapper); isTracked {
+			return tracked.portfolio, true
+		}
+
+		decorator, isDecorator := wrapper.(interface {
+			Unwrap() exchanges.ExchangeWrapper
+		})
+		if !isDecorator {
+			return nil, false
+		}
+		wrapper = decorator.Unwrap()
+	}
+}
+
+// follow starts following an order placed through a wrapper, until it is closed.
+func (portfolio *Portfolio) follow(wrapper exchanges.ExchangeWrapper, market *environment.Market, orderID string) {
+	portfolio.mutex.Lock()
+	defer portfolio.mutex.Unlock()
+
+	key := wrapper.Name() + "/" + orderID
+	if _, exists := portfolio.orders[key]; !exists {
+		portfolio.orders[key] = &trackedOrder{
+			id:      orderID,
+			wrapper: wrapper,
+			market:  market,
+			open:    true,
+		}
+	}
+}
+
+// RecordOrder records the quantity of an order filled since it was last recorded, as a fill at its average price.
+func (portfolio *Portfolio) RecordOrder(exchange string, order *environment.OrderInfo) {
+	if order == nil || order.Market == nil {
+		return
+	}
+
+	portfolio.mutex.Lock()
+	defer portfolio.mutex.Unlock()
+
+	key := exchange + "/" + order.ID
+	tracked, exists := portfolio.orders[key]
+	if !exists {
+		tracked = &trackedOrder{
+			id:     order.ID,
+			market: order.Market,
+		}
+		portfolio.orders[key] = tracked
+	}
+	tracked.open = order.Status.IsOpen()
+
+	price := order.AveragePrice
+	if price.IsZero() {
+		price = order.Limit
+	}
+	value := order.FilledQuantity.Mul(price)
+	quantity := order.FilledQuantity.Sub(tracked.filled)
+	if !quantity.IsPositive() {
+		return
+	}
+
+	fill := Fill{
+		Exchange:    exchange,
+		Market:      order.Market,
+		OrderID:     order.ID,
+		Side:        order.Type,
+		Quantity:    quantity,
+		Price:       value.Sub(tracked.value).Div(quantity),
+		Fee:         order.Fee.Sub(tracked.fee),
+		FeeCurrency: order.FeeCurrency,
+		Time:        time.Now(),
+	}
+	tracked.filled = order.FilledQuantity
+	tracked.value = value
+	tracked.fee = order.Fee
+	portfolio.recordFill(fill)
+}
+
+// RecordFill records a fill which has not been seen by the tracked wrappers (e.g. a fill notified by a feed).
+func (portfolio *Portfolio) RecordFill(fill Fill) {
+	portfolio.mutex.Lock()
+	defer portfolio.mutex.Unlock()
+
+	portfolio.recordFill(fill)
+}
+
+// recordFill records a fill, updating the position of its market.
+// Must be called with the mutex locked.
+func (portfolio *Portfolio) recordFill(fill Fill) {
+	if fill.Time.IsZero() {
+		fill.Time = time.Now()
+	}
+	portfolio.fills = append(portfolio.fills, fill)
+
+	key := fill.Exchange + "/" + fill.Market.Name
+	position, exists := portfolio.positions[key]
+	if !exists {
+		position = &Position{
+			Exchange: fill.Exchange,
+			Market:   fill.Market,
+		}
+		portfolio.positions[key] = position
+	}
+	position.apply(fill)
+}
+
+// Sync gets the orders placed through the tracked wrappers which are still open, recording their new fills.
+//
+// Orders are followed until closed, the first error is returned after checking all of them.
+func (portfolio *Portfolio) Sync() error {
+	portfolio.mutex.Lock()
+	var orders []trackedOrder
+	for _, order := range portfolio.orders {
+		if order.open && order.wrapper != nil {
+			orders = append(orders, *order)
+		}
+	}
+	portfolio.mutex.Unlock()
+
+	var firstErr error
+	for _, order := range orders {
+		info, err := order.wrapper.GetOrder(order.market, order.id)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s %s: cannot get order %s: %s", order.wrapper.Name(), order.market.Name, order.id, err)
+			}
+			continue
+		}
+		portfolio.RecordOrder(order.wrapper.Name(), withMarket(info, order.market))
+	}
+	return firstErr
+}
+
+// Fills returns the fills recorded so far, from the oldest.
+func (portfolio *Portfolio) Fills() []Fill {
+	portfolio.mutex.Lock()
+	defer portfolio.mutex.Unlock()
+
+	return append([]Fill(nil), portfolio.fills...)
+}
+
+// Positions returns a snapshot of the positions of the markets traded so far, sorted by exchange and market.
+func (portfolio *Portfolio) Positions() []Position {
+	portfolio.mutex.Lock()
+	defer portfolio.mutex.Unlock()
+
+	ret := make([]Position, 0, len(portfolio.positions))
+	for _, position := range portfolio.positions {
+		ret = append(ret, *position)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Exchange != ret[j].Exchange {
+			return ret[i].Exchange < ret[j].Exchange
+		}
+		return ret[i].Market.Name < ret[j].Market.Name
+	})
+	return ret
+}
+
+// Position returns a snapshot of the position of a market of an exchange.
+func (portfolio *Portfolio) Position(exchange string, market *environment.Market) (Position, bool) {
+	portfolio.mutex.Lock()
+	defer portfolio.mutex.Unlock()
+
+	position, exists := portfolio.positions[exchange+"/"+market.Name]
+	if !exists {
+		return Position{}, false
+	}
+	return *position, true
+}
+
+// Balances returns the balances of the specified currencies, summed across the tracked exchanges.
+//
+// The first error is returned along with the balances which could be got.
+func (portfolio *Portfolio) Balances(currencies ...string) (map[string]decimal.Decimal, error) {
+	portfolio.mutex.Lock()
+	wrappers := append([]exchanges.ExchangeWrapper(nil), portfolio.wrappers...)
+	portfolio.mutex.Unlock()
+
+	var firstErr error
+	ret := make(map[string]decimal.Decimal, len(currencies))
+	for _, currency := range currencies {
+		total := decimal.Zero
+		for _, wrapper := range wrappers {
+			balance, err := wrapper.GetBalance(currency)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s: cannot get %s balance: %s", wrapper.Name(), currency, err)
+				}
+				continue
+			}
+			total = total.Add(*balance)
+		}
+		ret[currency] = total
+	}
+	return ret, firstErr
+}
@@ -0,0 +1,205 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package portfolio
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/saniales/golang-crypto-trading-bot/exchanges"
+	"github.com/shopspring/decimal"
+)
+
+// MarketReport represents the P&L of a position at the current price of its market.
+type MarketReport struct {
+	Position
+	Price         decimal.Decimal // Represents the last price of the market, zero if it cannot be got.
+	UnrealizedPnL decimal.Decimal // Represents the profit of the quantity held if sold at the last price, in base currency.
+}
+
+// Report represents the state of the portfolio at a point in time.
+type Report struct {
+	Time          time.Time
+	QuoteCurrency string                     // Represents the currency the equity is expressed in.
+	Balances      map[string]decimal.Decimal // Represents the balances of each currency, summed across the exchanges.
+	Markets       []MarketReport             // Represents the positions of the markets traded so far.
+	Equity        decimal.Decimal            // Represents the value of the balances, in quote currency.
+	Unpriced      []string                   // Represents the currencies with a balance which cannot be converted to the quote currency, excluded from the equity.
+}
+
+// Report gets the balances of the currencies of the markets and of the traded positions, and values them at the last prices
+// (as got with GetMarketSummary), converting the balances to the quote currency through the markets pairing them with it.
+//
+// The first error is returned along with the report, whose values which could not be got are left out.
+func (portfolio *Portfolio) Report(markets []*environment.Market) (*Report, error) {
+	positions := portfolio.Positions()
+
+	portfolio.mutex.Lock()
+	wrappers := append([]exchanges.ExchangeWrapper(nil), portfolio.wrappers...)
+	portfolio.mutex.Unlock()
+
+	currencies := map[string]bool{portfolio.quoteCurrency: true}
+	allMarkets := append([]*environment.Market(nil), markets...)
+	for _, market := range markets {
+		currencies[market.BaseCurrency] = true
+		currencies[market.MarketCurrency] = true
+	}
+	for _, position := range positions {
+		currencies[position.Market.BaseCurrency] = true
+		currencies[position.Market.MarketCurrency] = true
+		allMarkets = append(allMarkets, position.Market)
+	}
+	names := make([]string, 0, len(currencies))
+	for currency := range currencies {
+		names = append(names, currency)
+	}
+	sort.Strings(names)
+
+	report := &Report{
+		Time:          time.Now(),
+		QuoteCurrency: portfolio.quoteCurrency,
+		Equity:        decimal.Zero,
+	}
+	balances, firstErr := portfolio.Balances(names...)
+	report.Balances = balances
+
+	prices := newPriceCache(wrappers)
+	for _, position := range positions {
+		marketReport := MarketReport{Position: position}
+		price, err := prices.exchangePrice(position.Exchange, position.Market)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+		} else {
+			marketReport.Price = price
+			marketReport.UnrealizedPnL = position.UnrealizedPnL(price)
+		}
+		report.Markets = append(report.Markets, marketReport)
+	}
+
+	for _, currency := range names {
+		balance := balances[currency]
+		if balance.IsZero() {
+			continue
+		}
+		value, converted := prices.convert(allMarkets, currency, portfolio.quoteCurrency, balance)
+		if !converted {
+			report.Unpriced = append(report.Unpriced, currency)
+			continue
+		}
+		report.Equity = report.Equity.Add(value)
+	}
+	return report, firstErr
+}
+
+// String returns the string representation of the object.
+func (report *Report) String() string {
+	var ret strings.Builder
+	fmt.Fprintln(&ret, "Time:", report.Time.Format(time.RFC3339))
+	fmt.Fprintln(&ret, "Equity:", report.Equity, report.QuoteCurrency)
+	if len(report.Unpriced) > 0 {
+		fmt.Fprintln(&ret, "Not valued:", strings.Join(report.Unpriced, ", "))
+	}
+
+	currencies := make([]string, 0, len(report.Balances))
+	for currency := range report.Balances {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	for _, currency := range currencies {
+		fmt.Fprintln(&ret, "Balance:", currency, report.Balances[currency])
+	}
+
+	for _, market := range report.Markets {
+		fmt.Fprintf(&ret, "Position: %s %s quantity %s, entry %s, price %s, realised %s, unrealised %s %s\n",
+			market.Exchange, market.Market.Name, market.Quantity, market.EntryPrice().StringFixed(8), market.Price,
+			market.RealizedPnL.StringFixed(8), market.UnrealizedPnL.StringFixed(8), market.Market.BaseCurrency)
+	}
+	return strings.TrimSpace(ret.String())
+}
+
+// priceCache gets the last prices of the markets once per report.
+type priceCache struct {
+	wrappers []exchanges.ExchangeWrapper
+	prices   map[string]decimal.Decimal // by exchange and market name.
+}
+
+// newPriceCache creates a cache getting the prices from the specified wrappers.
+func newPriceCache(wrappers []exchanges.ExchangeWrapper) *priceCache {
+	return &priceCache{
+		wrappers: wrappers,
+		prices:   make(map[string]decimal.Decimal),
+	}
+}
+
+// exchangePrice returns the last price of a market on an exchange.
+func (cache *priceCache) exchangePrice(exchange string, market *environment.Market) (decimal.Decimal, error) {
+	key := exchange + "/" + market.Name
+	if price, exists := cache.prices[key]; exists {
+		return price, nil
+	}
+
+	for _, wrapper := range cache.wrappers {
+		if wrapper.Name() != exchange {
+			continue
+		}
+		summary, err := wrapper.GetMarketSummary(market)
+		if err != nil {
+			return decimal.Zero, fmt.Errorf("%s %s: cannot get market summary: %s", exchange, market.Name, err)
+		}
+		cache.prices[key] = summary.Last
+		return summary.Last, nil
+	}
+	return decimal.Zero, fmt.Errorf("%s %s: exchange not tracked", exchange, market.Name)
+}
+
+// price returns the last price of a market on the first tracked exchange it is binded to.
+func (cache *priceCache) price(market *environment.Market) (decimal.Decimal, bool) {
+	for _, wrapper := range cache.wrappers {
+		if !exchanges.IsBinded(market, wrapper) {
+			continue
+		}
+		price, err := cache.exchangePrice(wrapper.Name(), market)
+		if err == nil && price.IsPositive() {
+			return price, true
+		}
+	}
+	return decimal.Zero, false
+}
+
+// convert converts an amount of currency to the quote currency, through a market pairing them in either direction.
+func (cache *priceCache) convert(markets []*environment.Market, currency string, quoteCurrency string, amount decimal.Decimal) (decimal.Decimal, bool) {
+	if currency == quoteCurrency {
+		return amount, true
+	}
+	for _, market := range markets {
+		switch {
+		case market.BaseCurrency == quoteCurrency && market.MarketCurrency == currency:
+			if price, found := cache.price(market); found {
+				return amount.Mul(price), true
+			}
+		case market.BaseCurrency == currency && market.MarketCurrency == quoteCurrency:
+			if price, found := cache.price(market); found {
+				return amount.Div(price), true
+			}
+		}
+	}
+	return decimal.Zero, false
+}
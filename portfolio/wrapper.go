@@ -0,0 +1,190 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package portfolio
+
+import (
+	"context"
+
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/saniales/golang-crypto-trading-bot/exchanges"
+	"github.com/shopspring/decimal"
+)
+
+// TrackedWrapper decorates an ExchangeWrapper, recording in a portfolio the fills of the orders it places and gets.
+type TrackedWrapper struct {
+	exchanges.ExchangeWrapper
+	portfolio *Portfolio
+}
+
+// Unwrap returns the wrapped ExchangeWrapper.
+func (wrapper *TrackedWrapper) Unwrap() exchanges.ExchangeWrapper {
+	return wrapper.ExchangeWrapper
+}
+
+// FeedConnectContext connects to the feed of the exchange, closing it when the context is done
+// if the exchange supports it.
+func (wrapper *TrackedWrapper) FeedConnectContext(ctx context.Context, markets []*environment.Market) error {
+	if connector, ok := wrapper.ExchangeWrapper.(interface {
+		FeedConnectContext(context.Context, []*environment.Market) error
+	}); ok {
+		return connector.FeedConnectContext(ctx, markets)
+	}
+	return wrapper.ExchangeWrapper.FeedConnect(markets)
+}
+
+// inner returns the wrapped wrapper, receiving the context of the calls.
+func (wrapper *TrackedWrapper) inner() exchanges.ContextExchangeWrapper {
+	return exchanges.AsContextExchangeWrapper(wrapper.ExchangeWrapper)
+}
+
+// GetMarketsContext gets all the markets of the exchange.
+func (wrapper *TrackedWrapper) GetMarketsContext(ctx context.Context) ([]*environment.Market, error) {
+	return wrapper.inner().GetMarketsContext(ctx)
+}
+
+// GetCandlesContext gets the candle data from the exchange.
+func (wrapper *TrackedWrapper) GetCandlesContext(ctx context.Context, market *environment.Market) ([]environment.CandleStick, error) {
+	return wrapper.inner().GetCandlesContext(ctx, market)
+}
+
+// GetMarketSummaryContext gets the current market summary.
+func (wrapper *TrackedWrapper) GetMarketSummaryContext(ctx context.Context, market *environment.Market) (*environment.MarketSummary, error) {
+	return wrapper.inner().GetMarketSummaryContext(ctx, market)
+}
+
+// GetOrderBookContext gets the order(ASK + BID) book of a market.
+func (wrapper *TrackedWrapper) GetOrderBookContext(ctx context.Context, market *environment.Market) (*environment.OrderBook, error) {
+	return wrapper.inner().GetOrderBookContext(ctx, market)
+}
+
+// CancelOrderContext cancels an open order.
+func (wrapper *TrackedWrapper) CancelOrderContext(ctx context.Context, market *environment.Market, orderID string) error {
+	return wrapper.inner().CancelOrderContext(ctx, market, orderID)
+}
+
+// GetBalanceContext gets the balance of the user of the specified currency.
+func (wrapper *TrackedWrapper) GetBalanceContext(ctx context.Context, symbol string) (*decimal.Decimal, error) {
+	return wrapper.inner().GetBalanceContext(ctx, symbol)
+}
+
+// WithdrawContext performs a withdraw operation from the exchange to a destination address.
+func (wrapper *TrackedWrapper) WithdrawContext(ctx context.Context, destinationAddress string, coinTicker string, amount decimal.Decimal) error {
+	return wrapper.inner().WithdrawContext(ctx, destinationAddress, coinTicker, amount)
+}
+
+// Portfolio returns the portfolio the fills are recorded in.
+func (wrapper *TrackedWrapper) Portfolio() *Portfolio {
+	return wrapper.portfolio
+}
+
+// BuyLimit performs a limit buy action, following the order until closed.
+func (wrapper *TrackedWrapper) BuyLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return wrapper.BuyLimitContext(context.Background(), market, amount, limit)
+}
+
+// BuyLimitContext performs a limit buy action, following the order until closed.
+func (wrapper *TrackedWrapper) BuyLimitContext(ctx context.Context, market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return wrapper.placed(market)(wrapper.inner().BuyLimitContext(ctx, market, amount, limit))
+}
+
+// SellLimit performs a limit sell action, following the order until closed.
+func (wrapper *TrackedWrapper) SellLimit(market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return wrapper.SellLimitContext(context.Background(), market, amount, limit)
+}
+
+// SellLimitContext performs a limit sell action, following the order until closed.
+func (wrapper *TrackedWrapper) SellLimitContext(ctx context.Context, market *environment.Market, amount decimal.Decimal, limit decimal.Decimal) (string, error) {
+	return wrapper.placed(market)(wrapper.inner().SellLimitContext(ctx, market, amount, limit))
+}
+
+// BuyMarket performs a market buy action, following the order until closed.
+func (wrapper *TrackedWrapper) BuyMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
+	return wrapper.BuyMarketContext(context.Background(), market, amount)
+}
+
+// BuyMarketContext performs a market buy action, following the order until closed.
+func (wrapper *TrackedWrapper) BuyMarketContext(ctx context.Context, market *environment.Market, amount decimal.Decimal) (string, error) {
+	return wrapper.placed(market)(wrapper.inner().BuyMarketContext(ctx, market, amount))
+}
+
+// SellMarket performs a market sell action, following the order until closed.
+func (wrapper *TrackedWrapper) SellMarket(market *environment.Market, amount decimal.Decimal) (string, error) {
+	return wrapper.SellMarketContext(context.Background(), market, amount)
+}
+
+// SellMarketContext performs a market sell action, following the order until closed.
+func (wrapper *TrackedWrapper) SellMarketContext(ctx context.Context, market *environment.Market, amount decimal.Decimal) (string, error) {
+	return wrapper.placed(market)(wrapper.inner().SellMarketContext(ctx, market, amount))
+}
+
+// PlaceOrder places an order with a client order ID, following the order until closed.
+func (wrapper *TrackedWrapper) PlaceOrder(ctx context.Context, order exchanges.OrderRequest) (string, error) {
+	placer, isPlacer := wrapper.ExchangeWrapper.(exchanges.ClientOrderExchangeWrapper)
+	if !isPlacer {
+		return "", exchanges.ErrClientOrderIDNotSupported
+	}
+	return wrapper.placed(order.Market)(placer.PlaceOrder(ctx, order))
+}
+
+// placed returns a function following the order placed on a market, if it has been placed.
+func (wrapper *TrackedWrapper) placed(market *environment.Market) func(string, error) (string, error) {
+	return func(orderID string, err error) (string, error) {
+		if err == nil {
+			wrapper.portfolio.follow(wrapper.ExchangeWrapper, market, orderID)
+		}
+		return orderID, err
+	}
+}
+
+// GetOrder gets the status of an order placed on the exchange, recording its new fills.
+func (wrapper *TrackedWrapper) GetOrder(market *environment.Market, orderID string) (*environment.OrderInfo, error) {
+	return wrapper.GetOrderContext(context.Background(), market, orderID)
+}
+
+// GetOrderContext gets the status of an order placed on the exchange, recording its new fills.
+func (wrapper *TrackedWrapper) GetOrderContext(ctx context.Context, market *environment.Market, orderID string) (*environment.OrderInfo, error) {
+	order, err := wrapper.inner().GetOrderContext(ctx, market, orderID)
+	if err == nil {
+		wrapper.portfolio.RecordOrder(wrapper.Name(), withMarket(order, market))
+	}
+	return order, err
+}
+
+// GetOpenOrders gets the open orders of the user on a market, recording their new fills.
+func (wrapper *TrackedWrapper) GetOpenOrders(market *environment.Market) ([]*environment.OrderInfo, error) {
+	return wrapper.GetOpenOrdersContext(context.Background(), market)
+}
+
+// GetOpenOrdersContext gets the open orders of the user on a market, recording their new fills.
+func (wrapper *TrackedWrapper) GetOpenOrdersContext(ctx context.Context, market *environment.Market) ([]*environment.OrderInfo, error) {
+	orders, err := wrapper.inner().GetOpenOrdersContext(ctx, market)
+	if err == nil {
+		for _, order := range orders {
+			wrapper.portfolio.RecordOrder(wrapper.Name(), withMarket(order, market))
+		}
+	}
+	return orders, err
+}
+
+// withMarket returns the order with the specified market, if the exchange did not report it.
+func withMarket(order *environment.OrderInfo, market *environment.Market) *environment.OrderInfo {
+	if order.Market != nil {
+		return order
+	}
+	ret := *order
+	ret.Market = market
+	return &ret
+}
@@ -0,0 +1,273 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Store represents a key/value store split in namespaces, whose values are encoded as JSON.
+//
+// A store opened on a file is written to disk after every change, replacing the file atomically,
+// so its content survives a restart of the bot; a memory store is lost when the bot stops.
+type Store struct {
+	path       string
+	mutex      sync.Mutex
+	namespaces map[string]map[string]json.RawMessage
+}
+
+// NewMemoryStore creates a store which is kept in memory only.
+func NewMemoryStore() *Store {
+	return &Store{
+		namespaces: make(map[string]map[string]json.RawMessage),
+	}
+}
+
+// Open opens the store saved in a JSON file, creating it on the first change if it does not exist.
+func Open(path string) (*Store, error) {
+	if path == "" {
+		return nil, errors.New("State file path cannot be empty")
+	}
+
+	store := NewMemoryStore()
+	store.path = path
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > 0 {
+		err = json.Unmarshal(content, &store.namespaces)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read state file %s: %s", path, err)
+		}
+	}
+	return store, nil
+}
+
+// Path returns the file the store is saved to, empty for memory stores.
+func (store *Store) Path() string {
+	return store.path
+}
+
+// Namespace returns a namespace of the store.
+func (store *Store) Namespace(name string) *Namespace {
+	return &Namespace{
+		store: store,
+		name:  name,
+	}
+}
+
+// Namespaces returns the names of the namespaces with at least a key, sorted.
+func (store *Store) Namespaces() []string {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	ret := make([]string, 0, len(store.namespaces))
+	for name := range store.namespaces {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// save writes the store to its file, if any, through a temporary file renamed over the old one.
+// Must be called with the mutex locked.
+func (store *Store) save() error {
+	if store.path == "" {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(store.namespaces, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(store.path)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(store.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(content); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), store.path)
+}
+
+// Namespace represents a set of keys of a store, isolated from the other namespaces (e.g. the state of a tactic on a market).
+type Namespace struct {
+	store *Store
+	name  string
+}
+
+// Name returns the name of the namespace.
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+// Get decodes the value of a key into value, returning false if the key is not set.
+func (ns *Namespace) Get(key string, value interface{}) (bool, error) {
+	var found bool
+	err := ns.View(func(tx *Tx) error {
+		var err error
+		found, err = tx.Get(key, value)
+		return err
+	})
+	return found, err
+}
+
+// Set sets the value of a key.
+func (ns *Namespace) Set(key string, value interface{}) error {
+	return ns.Update(func(tx *Tx) error {
+		return tx.Set(key, value)
+	})
+}
+
+// Delete removes a key.
+func (ns *Namespace) Delete(key string) error {
+	return ns.Update(func(tx *Tx) error {
+		tx.Delete(key)
+		return nil
+	})
+}
+
+// Keys returns the keys of the namespace, sorted.
+func (ns *Namespace) Keys() []string {
+	var ret []string
+	ns.View(func(tx *Tx) error {
+		ret = tx.Keys()
+		return nil
+	})
+	return ret
+}
+
+// View executes a function reading the namespace, while no other change can happen.
+func (ns *Namespace) View(fn func(*Tx) error) error {
+	ns.store.mutex.Lock()
+	defer ns.store.mutex.Unlock()
+
+	return fn(&Tx{values: ns.store.namespaces[ns.name]})
+}
+
+// Update executes a function changing the namespace atomically: the changes are applied
+// and saved all together if the function returns nil, discarded if it returns an error.
+func (ns *Namespace) Update(fn func(*Tx) error) error {
+	ns.store.mutex.Lock()
+	defer ns.store.mutex.Unlock()
+
+	old := ns.store.namespaces[ns.name]
+	tx := &Tx{
+		values:   make(map[string]json.RawMessage, len(old)),
+		writable: true,
+	}
+	for key, value := range old {
+		tx.values[key] = value
+	}
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if !tx.changed {
+		return nil
+	}
+
+	if len(tx.values) == 0 {
+		delete(ns.store.namespaces, ns.name)
+	} else {
+		ns.store.namespaces[ns.name] = tx.values
+	}
+	if err := ns.store.save(); err != nil {
+		// keep memory consistent with the file.
+		if old == nil {
+			delete(ns.store.namespaces, ns.name)
+		} else {
+			ns.store.namespaces[ns.name] = old
+		}
+		return fmt.Errorf("Cannot save state: %s", err)
+	}
+	return nil
+}
+
+// Tx represents a read or an update of a namespace.
+type Tx struct {
+	values   map[string]json.RawMessage
+	writable bool
+	changed  bool
+}
+
+// Get decodes the value of a key into value, returning false if the key is not set.
+func (tx *Tx) Get(key string, value interface{}) (bool, error) {
+	raw, exists := tx.values[key]
+	if !exists {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, value)
+}
+
+// Set sets the value of a key.
+func (tx *Tx) Set(key string, value interface{}) error {
+	if !tx.writable {
+		return errors.New("Cannot set a value in a read-only transaction")
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	tx.values[key] = raw
+	tx.changed = true
+	return nil
+}
+
+// Delete removes a key.
+func (tx *Tx) Delete(key string) {
+	if !tx.writable {
+		return
+	}
+	if _, exists := tx.values[key]; exists {
+		delete(tx.values, key)
+		tx.changed = true
+	}
+}
+
+// Keys returns the keys set, sorted.
+func (tx *Tx) Keys() []string {
+	ret := make([]string, 0, len(tx.values))
+	for key := range tx.values {
+		ret = append(ret, key)
+	}
+	sort.Strings(ret)
+	return ret
+}
@@ -0,0 +1,222 @@
+package state
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestUpdate(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func(*Tx) error
+		want map[string]int
+		err  bool
+	}{
+		{
+			name: "changes are applied together",
+			fn: func(tx *Tx) error {
+				if err := tx.Set("b", 2); err != nil {
+					return err
+				}
+				tx.Delete("a")
+				return nil
+			},
+			want: map[string]int{"b": 2},
+		},
+		{
+			name: "changes are discarded on error",
+			fn: func(tx *Tx) error {
+				if err := tx.Set("b", 2); err != nil {
+					return err
+				}
+				tx.Delete("a")
+				return errors.New("failed")
+			},
+			want: map[string]int{"a": 1},
+			err:  true,
+		},
+		{
+			name: "changes are seen by the transaction",
+			fn: func(tx *Tx) error {
+				var a int
+				if _, err := tx.Get("a", &a); err != nil {
+					return err
+				}
+				return tx.Set("a", a+1)
+			},
+			want: map[string]int{"a": 2},
+		},
+		{
+			name: "deleting the last key removes the namespace",
+			fn: func(tx *Tx) error {
+				tx.Delete("a")
+				return nil
+			},
+			want: map[string]int{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ns := NewMemoryStore().Namespace("tactic")
+			if err := ns.Set("a", 1); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := ns.Update(test.fn); (err != nil) != test.err {
+				t.Fatalf("error %v, want error %t", err, test.err)
+			}
+
+			got := make(map[string]int)
+			for _, key := range ns.Keys() {
+				var value int
+				if _, err := ns.Get(key, &value); err != nil {
+					t.Fatal(err)
+				}
+				got[key] = value
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUpdateIsAtomic(t *testing.T) {
+	ns := NewMemoryStore().Namespace("counter")
+
+	const increments = 50
+	var wg sync.WaitGroup
+	wg.Add(increments)
+	for i := 0; i < increments; i++ {
+		go func() {
+			defer wg.Done()
+			err := ns.Update(func(tx *Tx) error {
+				var count int
+				if _, err := tx.Get("count", &count); err != nil {
+					return err
+				}
+				return tx.Set("count", count+1)
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var count int
+	if _, err := ns.Get("count", &count); err != nil {
+		t.Fatal(err)
+	}
+	if count != increments {
+		t.Errorf("count %d, want %d", count, increments)
+	}
+}
+
+func TestView(t *testing.T) {
+	ns := NewMemoryStore().Namespace("tactic")
+	if err := ns.Set("a", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	err := ns.View(func(tx *Tx) error {
+		tx.Delete("a")
+		return tx.Set("b", 2)
+	})
+	if err == nil {
+		t.Error("set in a read-only transaction, want an error")
+	}
+	if keys := ns.Keys(); !reflect.DeepEqual(keys, []string{"a"}) {
+		t.Errorf("keys %v, want [a]", keys)
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	store := NewMemoryStore()
+	first := store.Namespace("grid@BTC-ETH/BTC-ETH")
+	second := store.Namespace("grid@BTC-ETH#wide/BTC-ETH")
+	if err := first.Set("levels", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Set("levels", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ns   *Namespace
+		want int
+	}{
+		{first, 1},
+		{second, 2},
+		{store.Namespace(first.Name()), 1},
+	}
+	for _, test := range tests {
+		var got int
+		if found, err := test.ns.Get("levels", &got); err != nil || !found || got != test.want {
+			t.Errorf("%s: got %d (found %t, error %v), want %d", test.ns.Name(), got, found, err, test.want)
+		}
+	}
+
+	want := []string{"grid@BTC-ETH#wide/BTC-ETH", "grid@BTC-ETH/BTC-ETH"}
+	if got := store.Namespaces(); !reflect.DeepEqual(got, want) {
+		t.Errorf("namespaces %v, want %v", got, want)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state", "bot.json")
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Namespace("tactic").Set("orders", []string{"1", "2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var orders []string
+	if found, err := reopened.Namespace("tactic").Get("orders", &orders); err != nil || !found {
+		t.Fatalf("found %t, error %v", found, err)
+	}
+	if !reflect.DeepEqual(orders, []string{"1", "2"}) {
+		t.Errorf("orders %v, want [1 2]", orders)
+	}
+
+	corrupted := filepath.Join(dir, "corrupted.json")
+	if err := os.WriteFile(corrupted, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(corrupted); err == nil {
+		t.Error("corrupted file opened, want an error")
+	}
+}
+
+func TestUpdateNotSaved(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	store, err := Open(filepath.Join(dir, "bot.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the directory of the state file cannot be created over a file.
+	if err := os.WriteFile(dir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ns := store.Namespace("tactic")
+	if err := ns.Set("a", 1); err == nil {
+		t.Fatal("value set without saving it, want an error")
+	}
+	if found, _ := ns.Get("a", new(int)); found {
+		t.Error("value kept in memory without saving it")
+	}
+}
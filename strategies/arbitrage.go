@@ -0,0 +1,501 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package strategies
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/saniales/golang-crypto-trading-bot/exchanges"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultArbitrageInterval is the interval between checks of the order books when not configured.
+const defaultArbitrageInterval = 10 * time.Second
+
+// Parameters of the arbitrage strategy, overriding its configuration for a binding.
+const (
+	ArbitrageMinProfitParam   = "min_profit"
+	ArbitrageMaxQuantityParam = "max_quantity"
+	ArbitrageLegChecksParam   = "leg_checks"
+)
+
+// ArbitrageConfig represents the configuration of a cross-exchange arbitrage strategy.
+type ArbitrageConfig struct {
+	MinProfit   decimal.Decimal // Represents the minimum profit of an opportunity, net of fees, as a fraction of its cost (e.g. 0.002 for 0.2%).
+	MaxQuantity decimal.Decimal // Represents the maximum quantity of market currency traded on each opportunity, zero for no limit.
+	Interval    time.Duration   // Represents the interval between checks of the order books (10 seconds by default).
+	LegChecks   int             // Represents the number of checks the legs of a trade are given to be filled before being cancelled and unwound (1 by default).
+}
+
+// ArbitrageStats represents the activity of an arbitrage strategy.
+type ArbitrageStats struct {
+	Opportunities int             // Represents the number of spreads found between the exchanges.
+	Skipped       int             // Represents the number of opportunities not executed.
+	Executed      int             // Represents the number of trades whose legs have been placed.
+	Completed     int             // Represents the number of trades whose legs have been both filled.
+	Failed        int             // Represents the number of trades whose legs failed and have been unwound.
+	Profit        decimal.Decimal // Represents the realised profit of the completed trades in base currency, net of the fees reported by the exchanges.
+}
+
+// arbitrageOpportunity represents a spread between the best ask of an exchange and the best bid of another.
+type arbitrageOpportunity struct {
+	buy       exchanges.ExchangeWrapper
+	sell      exchanges.ExchangeWrapper
+	buyPrice  decimal.Decimal
+	sellPrice decimal.Decimal
+	quantity  decimal.Decimal
+	profit    decimal.Decimal // net of the estimated fees.
+	cost      decimal.Decimal // value of the buy leg, fees excluded.
+}
+
+// arbitrageTrade represents the legs placed for an opportunity.
+type arbitrageTrade struct {
+	market       *environment.Market
+	buyExchange  string
+	buyOrderID   string
+	sellExchange string
+	sellOrderID  string
+	checks       int
+}
+
+// Arbitrage is a cross-exchange arbitrage strategy: it watches the order books of each market
+// on every exchange the market is binded to and, when the best bid of an exchange exceeds the best ask
+// of another by more than the trading fees, it buys on the latter and sells on the former at the same time.
+//
+// Legs which are not both filled within the configured checks are cancelled, and the filled difference
+// is unwound with a market order, so that no position is left open.
+//
+// Each tactic the strategy is applied with keeps its own pending trades.
+type Arbitrage struct {
+	config  ArbitrageConfig
+	mutex   sync.Mutex
+	pending map[*Tactic]map[string]*arbitrageTrade // pending trades of each tactic, by market name.
+	stats   ArbitrageStats
+}
+
+// NewArbitrage creates a cross-exchange arbitrage strategy from its configuration.
+func NewArbitrage(config ArbitrageConfig) (*Arbitrage, error) {
+	if config.MinProfit.IsNegative() {
+		return nil, errors.New("Arbitrage minimum profit must be >= 0")
+	}
+	if config.MaxQuantity.IsNegative() {
+		return nil, errors.New("Arbitrage maximum quantity must be >= 0")
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaultArbitrageInterval
+	}
+	if config.LegChecks <= 0 {
+		config.LegChecks = 1
+	}
+
+	return &Arbitrage{
+		config:  config,
+		pending: make(map[*Tactic]map[string]*arbitrageTrade),
+	}, nil
+}
+
+// Strategy returns the interval strategy performing the arbitrage, to be added with AddCustomStrategy.
+//
+// The bindings of the strategy can override the configuration of the arbitrage with its parameters
+// (min_profit, max_quantity and leg_checks).
+func (arbitrage *Arbitrage) Strategy(name string) IntervalStrategy {
+	return IntervalStrategy{
+		Model: StrategyModel{
+			Name:           name,
+			OnUpdateTactic: arbitrage.update,
+			OnError: func(err error) {
+				logrus.Errorf("Arbitrage %s: %s", name, err)
+			},
+			TearDownTactic: arbitrage.tearDown,
+			Params: []ParamSpec{
+				{Name: ArbitrageMinProfitParam, Type: ParamDecimal, Default: arbitrage.config.MinProfit, Validate: nonNegativeDecimal, Usage: "minimum profit net of fees, as a fraction of the cost"},
+				{Name: ArbitrageMaxQuantityParam, Type: ParamDecimal, Default: arbitrage.config.MaxQuantity, Validate: nonNegativeDecimal, Usage: "maximum quantity traded on each opportunity, 0 for no limit"},
+				{Name: ArbitrageLegChecksParam, Type: ParamInt, Default: arbitrage.config.LegChecks, Validate: positiveInt, Usage: "checks the legs are given to be filled"},
+			},
+		},
+		Interval: arbitrage.config.Interval,
+	}
+}
+
+// tacticConfig returns the configuration of the arbitrage performed by a tactic, overridden by its parameters.
+func (arbitrage *Arbitrage) tacticConfig(tactic *Tactic) ArbitrageConfig {
+	config := arbitrage.config
+	params := tactic.Params
+	if params.Has(ArbitrageMinProfitParam) {
+		config.MinProfit = params.Decimal(ArbitrageMinProfitParam)
+	}
+	if params.Has(ArbitrageMaxQuantityParam) {
+		config.MaxQuantity = params.Decimal(ArbitrageMaxQuantityParam)
+	}
+	if params.Has(ArbitrageLegChecksParam) {
+		config.LegChecks = params.Int(ArbitrageLegChecksParam)
+	}
+	return config
+}
+
+// Stats returns the activity of the strategy so far.
+func (arbitrage *Arbitrage) Stats() ArbitrageStats {
+	arbitrage.mutex.Lock()
+	defer arbitrage.mutex.Unlock()
+
+	return arbitrage.stats
+}
+
+// update checks the pending trades, then looks for new opportunities on the markets without pending trades.
+//
+// Errors of the exchanges are logged, so the strategy keeps running.
+func (arbitrage *Arbitrage) update(wrappers []exchanges.ExchangeWrapper, markets []*environment.Market, tactic *Tactic) error {
+	arbitrage.mutex.Lock()
+	defer arbitrage.mutex.Unlock()
+
+	config := arbitrage.tacticConfig(tactic)
+	pending := arbitrage.pending[tactic]
+	if pending == nil {
+		pending = make(map[string]*arbitrageTrade)
+		arbitrage.pending[tactic] = pending
+	}
+
+	for _, market := range markets {
+		if trade, exists := pending[market.Name]; exists {
+			arbitrage.checkTrade(config, wrappers, pending, trade)
+			continue
+		}
+
+		opportunities := arbitrage.findOpportunities(config, wrappers, market)
+		arbitrage.stats.Opportunities += len(opportunities)
+
+		var best *arbitrageOpportunity
+		for _, opportunity := range opportunities {
+			if reason := arbitrage.validate(config, market, opportunity); reason != "" {
+				arbitrage.skip(market, opportunity, reason)
+				continue
+			}
+			if best != nil {
+				if opportunity.profit.LessThanOrEqual(best.profit) {
+					arbitrage.skip(market, opportunity, "a more profitable opportunity has been found")
+					continue
+				}
+				arbitrage.skip(market, best, "a more profitable opportunity has been found")
+			}
+			best = opportunity
+		}
+
+		if best != nil {
+			arbitrage.execute(pending, market, best)
+		}
+	}
+	return nil
+}
+
+// findOpportunities compares the order books of a market on its binded exchanges, returning the pairs of
+// exchanges where the best bid of an exchange exceeds the best ask of another.
+func (arbitrage *Arbitrage) findOpportunities(config ArbitrageConfig, wrappers []exchanges.ExchangeWrapper, market *environment.Market) []*arbitrageOpportunity {
+	var binded []exchanges.ExchangeWrapper
+	var books []*environment.OrderBook
+	for _, wrapper := range wrappers {
+		if !exchanges.IsBinded(market, wrapper) {
+			continue
+		}
+
+		book, err := wrapper.GetOrderBook(market)
+		if err != nil {
+			logrus.Warnf("Arbitrage %s %s: cannot get order book: %s", wrapper.Name(), market.Name, err)
+			continue
+		}
+		binded = append(binded, wrapper)
+		books = append(books, book)
+	}
+
+	var ret []*arbitrageOpportunity
+	for i, buyBook := range books {
+		ask, hasAsk := bestOrder(buyBook.Asks, environment.Ask)
+		if !hasAsk {
+			continue
+		}
+		for j, sellBook := range books {
+			if i == j {
+				continue
+			}
+			bid, hasBid := bestOrder(sellBook.Bids, environment.Bid)
+			if !hasBid || !bid.Value.GreaterThan(ask.Value) {
+				continue
+			}
+
+			quantity := decimal.Min(ask.Quantity, bid.Quantity)
+			if config.MaxQuantity.IsPositive() {
+				quantity = decimal.Min(quantity, config.MaxQuantity)
+			}
+			opportunity := &arbitrageOpportunity{
+				buy:       binded[i],
+				sell:      binded[j],
+				buyPrice:  ask.Value,
+				sellPrice: bid.Value,
+			}
+			opportunity.estimate(market, quantity)
+			ret = append(ret, opportunity)
+		}
+	}
+	return ret
+}
+
+// estimate sets the quantity of an opportunity and estimates its cost and its profit net of the taker fees.
+func (opportunity *arbitrageOpportunity) estimate(market *environment.Market, quantity decimal.Decimal) {
+	buyFee := opportunity.buy.CalculateTradingFees(market, quantity, opportunity.buyPrice, exchanges.TakerTrade)
+	sellFee := opportunity.sell.CalculateTradingFees(market, quantity, opportunity.sellPrice, exchanges.TakerTrade)
+
+	opportunity.quantity = quantity
+	opportunity.cost = quantity.Mul(opportunity.buyPrice)
+	opportunity.profit = quantity.Mul(opportunity.sellPrice).Sub(opportunity.cost).Sub(buyFee).Sub(sellFee)
+}
+
+// validate checks the profit of an opportunity and the balances needed by its legs,
+// reducing its quantity to the available balances, and returns the reason to skip it (empty if valid).
+func (arbitrage *Arbitrage) validate(config ArbitrageConfig, market *environment.Market, opportunity *arbitrageOpportunity) string {
+	if !opportunity.profit.IsPositive() || opportunity.profit.LessThan(opportunity.cost.Mul(config.MinProfit)) {
+		return "profit below minimum after fees"
+	}
+
+	baseBalance, err := opportunity.buy.GetBalance(market.BaseCurrency)
+	if err != nil {
+		return "cannot get " + market.BaseCurrency + " balance: " + err.Error()
+	}
+	marketBalance, err := opportunity.sell.GetBalance(market.MarketCurrency)
+	if err != nil {
+		return "cannot get " + market.MarketCurrency + " balance: " + err.Error()
+	}
+
+	quantity := decimal.Min(opportunity.quantity, *marketBalance)
+	// the buy leg must also pay its fees, which are proportional to its cost.
+	needed := opportunity.cost.Add(opportunity.buy.CalculateTradingFees(market, opportunity.quantity, opportunity.buyPrice, exchanges.TakerTrade))
+	if needed.GreaterThan(*baseBalance) {
+		quantity = decimal.Min(quantity, opportunity.quantity.Mul(*baseBalance).Div(needed).Truncate(8))
+	}
+	if !quantity.IsPositive() {
+		return "not enough balance"
+	}
+
+	if !quantity.Equal(opportunity.quantity) {
+		opportunity.estimate(market, quantity)
+		if !opportunity.profit.IsPositive() || opportunity.profit.LessThan(opportunity.cost.Mul(config.MinProfit)) {
+			return "profit below minimum after fees with the available balance"
+		}
+	}
+	return ""
+}
+
+// skip logs an opportunity which is not executed.
+func (arbitrage *Arbitrage) skip(market *environment.Market, opportunity *arbitrageOpportunity, reason string) {
+	arbitrage.stats.Skipped++
+	logrus.Infof("Arbitrage %s: skipped buy %s on %s at %s, sell on %s at %s (estimated profit %s %s): %s",
+		market.Name, opportunity.quantity, opportunity.buy.Name(), opportunity.buyPrice,
+		opportunity.sell.Name(), opportunity.sellPrice, opportunity.profit, market.BaseCurrency, reason)
+}
+
+// execute places the legs of an opportunity at the same time, adding the trade to the pending ones.
+//
+// If a leg cannot be placed the other one is cancelled and unwound.
+func (arbitrage *Arbitrage) execute(pending map[string]*arbitrageTrade, market *environment.Market, opportunity *arbitrageOpportunity) {
+	var buyOrderID, sellOrderID string
+	var buyErr, sellErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		buyOrderID, buyErr = opportunity.buy.BuyLimit(market, opportunity.quantity, opportunity.buyPrice)
+	}()
+	go func() {
+		defer wg.Done()
+		sellOrderID, sellErr = opportunity.sell.SellLimit(market, opportunity.quantity, opportunity.sellPrice)
+	}()
+	wg.Wait()
+
+	if buyErr != nil || sellErr != nil {
+		arbitrage.stats.Failed++
+		if buyErr != nil {
+			logrus.Errorf("Arbitrage %s: cannot buy on %s: %s", market.Name, opportunity.buy.Name(), buyErr)
+		} else {
+			cancelAndUnwind(opportunity.buy, market, buyOrderID, environment.Bid)
+		}
+		if sellErr != nil {
+			logrus.Errorf("Arbitrage %s: cannot sell on %s: %s", market.Name, opportunity.sell.Name(), sellErr)
+		} else {
+			cancelAndUnwind(opportunity.sell, market, sellOrderID, environment.Ask)
+		}
+		return
+	}
+
+	arbitrage.stats.Executed++
+	logrus.Infof("Arbitrage %s: buying %s on %s at %s, selling on %s at %s (estimated profit %s %s)",
+		market.Name, opportunity.quantity, opportunity.buy.Name(), opportunity.buyPrice,
+		opportunity.sell.Name(), opportunity.sellPrice, opportunity.profit, market.BaseCurrency)
+	pending[market.Name] = &arbitrageTrade{
+		market:       market,
+		buyExchange:  opportunity.buy.Name(),
+		buyOrderID:   buyOrderID,
+		sellExchange: opportunity.sell.Name(),
+		sellOrderID:  sellOrderID,
+	}
+}
+
+// checkTrade checks the legs of a pending trade: when both are filled the realised profit is recorded,
+// when they are not filled within the configured checks they are cancelled and the difference is unwound.
+// Trades completed or unwound are removed from the pending ones.
+func (arbitrage *Arbitrage) checkTrade(config ArbitrageConfig, wrappers []exchanges.ExchangeWrapper, pending map[string]*arbitrageTrade, trade *arbitrageTrade) {
+	buyWrapper := wrapperNamed(wrappers, trade.buyExchange)
+	sellWrapper := wrapperNamed(wrappers, trade.sellExchange)
+	if buyWrapper == nil || sellWrapper == nil {
+		return
+	}
+
+	buy, buyErr := buyWrapper.GetOrder(trade.market, trade.buyOrderID)
+	sell, sellErr := sellWrapper.GetOrder(trade.market, trade.sellOrderID)
+	if buyErr == nil && sellErr == nil && buy.Status == environment.OrderFilled && sell.Status == environment.OrderFilled {
+		profit := legValue(sell).Sub(feeInBaseCurrency(sell, sell.AveragePrice)).
+			Sub(legValue(buy)).Sub(feeInBaseCurrency(buy, buy.AveragePrice))
+		arbitrage.stats.Completed++
+		arbitrage.stats.Profit = arbitrage.stats.Profit.Add(profit)
+		logrus.Infof("Arbitrage %s: trade completed, profit %s %s", trade.market.Name, profit, trade.market.BaseCurrency)
+		delete(pending, trade.market.Name)
+		return
+	}
+
+	trade.checks++
+	if trade.checks < config.LegChecks {
+		return
+	}
+
+	logrus.Warnf("Arbitrage %s: legs not filled in time, unwinding", trade.market.Name)
+	arbitrage.stats.Failed++
+	arbitrage.unwind(buyWrapper, sellWrapper, trade)
+	delete(pending, trade.market.Name)
+}
+
+// unwind cancels the open legs of a trade and closes the quantity filled on one leg but not on the other.
+func (arbitrage *Arbitrage) unwind(buyWrapper exchanges.ExchangeWrapper, sellWrapper exchanges.ExchangeWrapper, trade *arbitrageTrade) {
+	bought := cancelLeg(buyWrapper, trade.market, trade.buyOrderID)
+	sold := cancelLeg(sellWrapper, trade.market, trade.sellOrderID)
+
+	excess := bought.Sub(sold)
+	if excess.IsPositive() {
+		closePosition(buyWrapper, trade.market, environment.Bid, excess)
+	} else if excess.IsNegative() {
+		closePosition(sellWrapper, trade.market, environment.Ask, excess.Neg())
+	}
+}
+
+// cancelAndUnwind cancels a leg whose counterpart could not be placed and closes its filled quantity.
+func cancelAndUnwind(wrapper exchanges.ExchangeWrapper, market *environment.Market, orderID string, side environment.OrderType) {
+	if filled := cancelLeg(wrapper, market, orderID); filled.IsPositive() {
+		closePosition(wrapper, market, side, filled)
+	}
+}
+
+// tearDown cancels and unwinds the pending trades of a tactic on the markets torn down and logs the activity of the strategy.
+func (arbitrage *Arbitrage) tearDown(wrappers []exchanges.ExchangeWrapper, markets []*environment.Market, tactic *Tactic) error {
+	arbitrage.mutex.Lock()
+	defer arbitrage.mutex.Unlock()
+
+	config := arbitrage.tacticConfig(tactic)
+	pending := arbitrage.pending[tactic]
+	for _, market := range markets {
+		trade, exists := pending[market.Name]
+		if !exists {
+			continue
+		}
+
+		buyWrapper := wrapperNamed(wrappers, trade.buyExchange)
+		sellWrapper := wrapperNamed(wrappers, trade.sellExchange)
+		if buyWrapper != nil && sellWrapper != nil {
+			arbitrage.checkTrade(config, wrappers, pending, trade)
+			if _, stillPending := pending[market.Name]; stillPending {
+				arbitrage.stats.Failed++
+				arbitrage.unwind(buyWrapper, sellWrapper, trade)
+			}
+		}
+		delete(pending, market.Name)
+	}
+	if len(pending) == 0 {
+		delete(arbitrage.pending, tactic)
+	}
+
+	stats := arbitrage.stats
+	logrus.Infof("Arbitrage: %d opportunities, %d skipped, %d executed, %d completed, %d failed, realised profit %s",
+		stats.Opportunities, stats.Skipped, stats.Executed, stats.Completed, stats.Failed, stats.Profit)
+	return nil
+}
+
+// cancelLeg cancels a leg if still open, returning its filled quantity.
+func cancelLeg(wrapper exchanges.ExchangeWrapper, market *environment.Market, orderID string) decimal.Decimal {
+	order, err := wrapper.GetOrder(market, orderID)
+	if err == nil && !order.Status.IsOpen() {
+		return order.FilledQuantity
+	}
+
+	err = wrapper.CancelOrder(market, orderID)
+	if err != nil && err != exchanges.ErrOrderNotFound {
+		logrus.Errorf("Arbitrage %s %s: cannot cancel order %s: %s", wrapper.Name(), market.Name, orderID, err)
+	}
+
+	order, err = wrapper.GetOrder(market, orderID)
+	if err != nil {
+		logrus.Errorf("Arbitrage %s %s: cannot get order %s: %s", wrapper.Name(), market.Name, orderID, err)
+		return decimal.Zero
+	}
+	return order.FilledQuantity
+}
+
+// closePosition closes with a market order the quantity filled by a leg on the specified side.
+func closePosition(wrapper exchanges.ExchangeWrapper, market *environment.Market, side environment.OrderType, quantity decimal.Decimal) {
+	var err error
+	if side == environment.Bid {
+		_, err = wrapper.SellMarket(market, quantity)
+	} else {
+		_, err = wrapper.BuyMarket(market, quantity)
+	}
+	if err != nil {
+		logrus.Errorf("Arbitrage %s %s: cannot unwind %s: %s", wrapper.Name(), market.Name, quantity, err)
+		return
+	}
+	logrus.Warnf("Arbitrage %s %s: unwound %s", wrapper.Name(), market.Name, quantity)
+}
+
+// legValue returns the value of the filled quantity of a leg, in base currency.
+func legValue(order *environment.OrderInfo) decimal.Decimal {
+	price := order.AveragePrice
+	if price.IsZero() {
+		price = order.Limit
+	}
+	return order.FilledQuantity.Mul(price)
+}
+
+// bestOrder returns the best order of a side of an order book (the lowest ask or the highest bid).
+func bestOrder(orders []environment.Order, side environment.OrderType) (environment.Order, bool) {
+	if len(orders) == 0 {
+		return environment.Order{}, false
+	}
+
+	best := orders[0]
+	for _, order := range orders[1:] {
+		if side == environment.Ask && order.Value.LessThan(best.Value) || side == environment.Bid && order.Value.GreaterThan(best.Value) {
+			best = order
+		}
+	}
+	return best, true
+}
@@ -16,15 +16,19 @@
 package strategies
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"sync"
 
 	"github.com/saniales/golang-crypto-trading-bot/environment"
 	"github.com/saniales/golang-crypto-trading-bot/exchanges"
+	"github.com/saniales/golang-crypto-trading-bot/state"
 )
 
 var available map[string]Strategy //mapped name -> strategy
 var appliedTactics []Tactic
+var stateStore *state.Store // store of the state of the matched tactics, in memory if not set.
 
 // Strategy represents a generic strategy.
 type Strategy interface {
@@ -32,29 +36,154 @@ type Strategy interface {
 	Apply([]exchanges.ExchangeWrapper, []*environment.Market) // Apply applies the strategy when called, using the specified wrapper.
 }
 
+// ContextStrategy represents a strategy which stops when its context is done.
+type ContextStrategy interface {
+	Strategy
+	ApplyContext(context.Context, []exchanges.ExchangeWrapper, []*environment.Market) // ApplyContext applies the strategy until the context is done.
+}
+
+// TacticStrategy represents a strategy which reads the parameters of the tactic it is applied with.
+type TacticStrategy interface {
+	ContextStrategy
+	ParamSpecs() []ParamSpec                                           // ParamSpecs returns the parameters accepted by the strategy.
+	ApplyTactic(context.Context, []exchanges.ExchangeWrapper, *Tactic) // ApplyTactic applies the strategy with the markets and the parameters of a tactic, until the context is done.
+}
+
 // StrategyFunc represents a standard function binded to a strategy model execution.
 //
 //     Can define a Setup, TearDown and Update behaviour.
 type StrategyFunc func([]exchanges.ExchangeWrapper, []*environment.Market) error
 
+// TacticFunc represents a function binded to a strategy model execution which reads the tactic it is applied with
+// (e.g. its Params or its State).
+//
+//     Can define a Setup, TearDown and Update behaviour, in place of the corresponding StrategyFunc.
+type TacticFunc func([]exchanges.ExchangeWrapper, []*environment.Market, *Tactic) error
+
 //StrategyModel represents a strategy model used by strategies.
 type StrategyModel struct {
-	Name     string
-	Setup    StrategyFunc
-	TearDown StrategyFunc
-	OnUpdate StrategyFunc
-	OnError  func(error)
+	Name           string
+	Setup          StrategyFunc
+	TearDown       StrategyFunc
+	OnUpdate       StrategyFunc
+	SetupTactic    TacticFunc // Represents the Setup reading the tactic, executed instead of Setup if set.
+	TearDownTactic TacticFunc // Represents the TearDown reading the tactic, executed instead of TearDown if set.
+	OnUpdateTactic TacticFunc // Represents the OnUpdate reading the tactic, executed instead of OnUpdate if set.
+	OnError        func(error)
+	OnFeedStatus   func(exchanges.FeedStatus) // Represents the function notified when a supervised feed changes its state, used by websocket strategies.
+	Params         []ParamSpec                // Represents the parameters the bindings of the strategy can set in the configuration file.
+}
+
+// SetupFunc returns the Setup of the model, SetupTactic if set, nil if none is set.
+func (model StrategyModel) SetupFunc() TacticFunc {
+	return tacticFunc(model.SetupTactic, model.Setup)
+}
+
+// TearDownFunc returns the TearDown of the model, TearDownTactic if set, nil if none is set.
+func (model StrategyModel) TearDownFunc() TacticFunc {
+	return tacticFunc(model.TearDownTactic, model.TearDown)
+}
+
+// UpdateFunc returns the OnUpdate of the model, OnUpdateTactic if set, nil if none is set.
+func (model StrategyModel) UpdateFunc() TacticFunc {
+	return tacticFunc(model.OnUpdateTactic, model.OnUpdate)
+}
+
+// tacticFunc returns the function reading the tactic if set, otherwise the plain function ignoring it.
+func tacticFunc(withTactic TacticFunc, plain StrategyFunc) TacticFunc {
+	if withTactic != nil {
+		return withTactic
+	}
+	if plain == nil {
+		return nil
+	}
+	return func(wrappers []exchanges.ExchangeWrapper, markets []*environment.Market, _ *Tactic) error {
+		return plain(wrappers, markets)
+	}
 }
 
 // Tactic represents the effective appliance of a strategy.
 type Tactic struct {
 	Markets  []*environment.Market
 	Strategy Strategy
+	Params   Params       // Represents the parameters of the appliance, validated and completed with their defaults.
+	Store    *state.Store // Represents the store keeping the state of the appliance, see State.
+	Binding  string       // Represents the identifier telling apart the appliances of the strategy on the same markets, empty if not set.
+}
+
+// NewTactic creates the appliance of a strategy on the markets, validating the parameters against the ones accepted by the strategy.
+func NewTactic(s Strategy, markets []*environment.Market, params map[string]interface{}) (*Tactic, error) {
+	var specs []ParamSpec
+	if ts, ok := s.(TacticStrategy); ok {
+		specs = ts.ParamSpecs()
+	}
+	validParams, err := NewParams(specs, params)
+	if err != nil {
+		return nil, fmt.Errorf("Strategy %s: %s", s.Name(), err)
+	}
+
+	store := stateStore
+	if store == nil {
+		store = state.NewMemoryStore()
+	}
+
+	return &Tactic{
+		Markets:  markets,
+		Strategy: s,
+		Params:   validParams,
+		Store:    store,
+	}, nil
+}
+
+// ID returns the identifier of the tactic, made of the name of the strategy, the names of its markets
+// and its binding, if set (e.g. grid@BTC-ETH,BTC-LTC or grid@BTC-ETH#wide).
+//
+//     The parameters are not part of the ID, so tuning them keeps the state of the tactic.
+func (t *Tactic) ID() string {
+	names := make([]string, len(t.Markets))
+	for i, market := range t.Markets {
+		names[i] = market.Name
+	}
+	id := t.Strategy.Name() + "@" + strings.Join(names, ",")
+	if t.Binding != "" {
+		id += "#" + t.Binding
+	}
+	return id
+}
+
+// State returns the namespace of the store keeping the state of the tactic on a market,
+// or the state of the tactic itself if market is nil.
+//
+//     The state is namespaced by the ID of the tactic, so it is kept as long as the strategy is applied to the same markets
+//     with the same binding.
+func (t *Tactic) State(market *environment.Market) *state.Namespace {
+	if t.Store == nil {
+		t.Store = state.NewMemoryStore()
+	}
+	if market == nil {
+		return t.Store.Namespace(t.ID())
+	}
+	return t.Store.Namespace(t.ID() + "/" + market.Name)
 }
 
 // Execute executes effectively a tactic.
 func (t *Tactic) Execute(wrappers []exchanges.ExchangeWrapper) {
-	t.Strategy.Apply(wrappers, t.Markets)
+	t.ExecuteContext(context.Background(), wrappers)
+}
+
+// ExecuteContext executes effectively a tactic, stopping it when the context is done.
+//
+//     Strategies not implementing ContextStrategy get wrappers whose calls are aborted when the context is done.
+func (t *Tactic) ExecuteContext(ctx context.Context, wrappers []exchanges.ExchangeWrapper) {
+	if s, ok := t.Strategy.(TacticStrategy); ok {
+		s.ApplyTactic(ctx, wrappers, t)
+		return
+	}
+	if s, ok := t.Strategy.(ContextStrategy); ok {
+		s.ApplyContext(ctx, wrappers, t.Markets)
+		return
+	}
+	t.Strategy.Apply(exchanges.BindContext(ctx, wrappers), t.Markets)
 }
 
 func init() {
@@ -66,27 +195,64 @@ func AddCustomStrategy(s Strategy) {
 	available[s.Name()] = s
 }
 
+// SetStateStore sets the store keeping the state of the tactics matched afterwards.
+func SetStateStore(store *state.Store) {
+	stateStore = store
+}
+
+// GetStrategy gets a strategy from the available set, by name.
+func GetStrategy(name string) (Strategy, bool) {
+	s, exists := available[name]
+	return s, exists
+}
+
 // MatchWithMarkets matches a strategy with the markets.
 func MatchWithMarkets(strategyName string, markets []*environment.Market) error {
+	return MatchWithMarketsParams(strategyName, markets, nil)
+}
+
+// MatchWithMarketsParams matches a strategy with the markets, applying it with the specified parameters.
+//
+//     The same strategy can be matched more times, with different markets and parameters.
+func MatchWithMarketsParams(strategyName string, markets []*environment.Market, params map[string]interface{}) error {
+	return MatchWithMarketsBinding(strategyName, "", markets, params)
+}
+
+// MatchWithMarketsBinding matches a strategy with the markets, applying it with the specified binding and parameters.
+//
+//     Matching the strategy more times on the same markets requires a different binding for each tactic, which keeps their states apart.
+func MatchWithMarketsBinding(strategyName string, binding string, markets []*environment.Market, params map[string]interface{}) error {
 	s, exists := available[strategyName]
 	if !exists {
 		return fmt.Errorf("Strategy %s does not exist, cannot bind to markets %v", strategyName, markets)
 	}
-	appliedTactics = append(appliedTactics, Tactic{
-		Markets:  markets,
-		Strategy: s,
-	})
+	t, err := NewTactic(s, markets, params)
+	if err != nil {
+		return err
+	}
+	t.Binding = binding
+	for _, applied := range appliedTactics {
+		if applied.ID() == t.ID() {
+			return fmt.Errorf("Strategy %s is already bound as %s, set a different id to the binding", strategyName, t.ID())
+		}
+	}
+	appliedTactics = append(appliedTactics, *t)
 	return nil
 }
 
 // ApplyAllStrategies applies all matched strategies concurrently.
 func ApplyAllStrategies(wrappers []exchanges.ExchangeWrapper) {
+	ApplyAllStrategiesContext(context.Background(), wrappers)
+}
+
+// ApplyAllStrategiesContext applies all matched strategies concurrently, until the context is done.
+func ApplyAllStrategiesContext(ctx context.Context, wrappers []exchanges.ExchangeWrapper) {
 	var wg sync.WaitGroup
 	wg.Add(len(appliedTactics))
 	for _, t := range appliedTactics {
 		go func(wrappers []exchanges.ExchangeWrapper, t Tactic, wg *sync.WaitGroup) {
 			defer wg.Done()
-			t.Execute(wrappers)
+			t.ExecuteContext(ctx, wrappers)
 		}(wrappers, t, &wg)
 	}
 	wg.Wait()
@@ -0,0 +1,542 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package strategies
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/saniales/golang-crypto-trading-bot/exchanges"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultGridInterval is the interval between checks of the grid orders when not configured.
+const defaultGridInterval = time.Minute
+
+// geometricGridPrecision is the number of decimals geometric grid prices are rounded to.
+const geometricGridPrecision = 8
+
+// Parameters of the grid strategy, overriding its configuration for a binding.
+const (
+	GridLowerPriceParam       = "lower_price"
+	GridUpperPriceParam       = "upper_price"
+	GridLevelsParam           = "levels"
+	GridGeometricParam        = "geometric"
+	GridQuantityParam         = "quantity"
+	GridCancelOnTearDownParam = "cancel_on_tear_down"
+)
+
+// GridConfig represents the configuration of a grid trading strategy.
+type GridConfig struct {
+	LowerPrice       decimal.Decimal // Represents the lowest price of the grid, in base currency.
+	UpperPrice       decimal.Decimal // Represents the highest price of the grid, in base currency.
+	Levels           int             // Represents the number of levels (price ranges) the grid is split into.
+	Geometric        bool            // If true, the levels have the same ratio between upper and lower price, otherwise the same width.
+	Quantity         decimal.Decimal // Represents the quantity of market currency bought and sold on each level.
+	Interval         time.Duration   // Represents the interval between checks of the grid orders (1 minute by default).
+	CancelOnTearDown bool            // If true, the open orders of the grid are cancelled when the strategy is torn down.
+}
+
+// GridLevel represents a price range of a grid: coins are bought at the lower price and sold at the upper price.
+type GridLevel struct {
+	Lower      decimal.Decimal       // Represents the buy price of the level.
+	Upper      decimal.Decimal       // Represents the sell price of the level.
+	Side       environment.OrderType // Represents the side of the order placed (or to be placed) on the level.
+	OrderID    string                // Represents the ID of the order placed on the level, empty if the order is still to be placed.
+	RoundTrips int                   // Represents the number of buy-sell cycles completed on the level.
+	Profit     decimal.Decimal       // Represents the realised profit of the level in base currency, net of the fees reported by the exchange.
+
+	cost   decimal.Decimal // cost (including fees) of the coins bought on the level and not sold yet, zero if unknown.
+	filled decimal.Decimal // quantity already filled on the current side, by orders cancelled before their full fill.
+}
+
+// savedGridLevel represents a level of a grid as kept in the state of its tactic.
+type savedGridLevel struct {
+	Lower      decimal.Decimal
+	Upper      decimal.Decimal
+	Side       environment.OrderType
+	OrderID    string
+	RoundTrips int
+	Profit     decimal.Decimal
+	Cost       decimal.Decimal
+	Filled     decimal.Decimal
+}
+
+// gridBook represents the levels of a grid traded on a market of an exchange.
+type gridBook struct {
+	exchange string
+	market   *environment.Market
+	levels   []*GridLevel
+}
+
+// Grid is a grid trading strategy: it places a ladder of limit orders between two prices,
+// buying at the lower price of each level and selling at its upper price, and re-places
+// the opposite order of a level when its order is filled.
+//
+// A grid is traded on every market of the strategy, on each exchange the market is binded to,
+// keeping separate levels for each tactic the strategy is applied with.
+// Levels above the price at startup begin by selling, so the market currency they sell must be available.
+//
+// The levels are saved in the state of the tactic on each market, under the name of the exchange,
+// so a restarted grid resumes from its open orders and realised profit.
+type Grid struct {
+	config GridConfig
+	mutex  sync.Mutex
+	books  map[*Tactic][]*gridBook
+}
+
+// NewGrid creates a grid trading strategy from its configuration, used by the bindings which do not set its parameters.
+func NewGrid(config GridConfig) (*Grid, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaultGridInterval
+	}
+
+	return &Grid{
+		config: config,
+		books:  make(map[*Tactic][]*gridBook),
+	}, nil
+}
+
+// validate checks the prices, the levels and the quantity of a configuration.
+func (config GridConfig) validate() error {
+	if !config.LowerPrice.IsPositive() || !config.UpperPrice.GreaterThan(config.LowerPrice) {
+		return errors.New("Grid prices must be 0 < lower < upper")
+	}
+	if config.Levels < 1 {
+		return errors.New("Grid must have at least one level")
+	}
+	if !config.Quantity.IsPositive() {
+		return errors.New("Grid quantity must be > 0")
+	}
+	return nil
+}
+
+// Strategy returns the interval strategy trading the grid, to be added with AddCustomStrategy.
+//
+// The bindings of the strategy can override the configuration of the grid with its parameters
+// (e.g. lower_price, upper_price, levels, geometric, quantity and cancel_on_tear_down).
+func (grid *Grid) Strategy(name string) IntervalStrategy {
+	return IntervalStrategy{
+		Model: StrategyModel{
+			Name:           name,
+			SetupTactic:    grid.setup,
+			OnUpdateTactic: grid.update,
+			OnError: func(err error) {
+				logrus.Errorf("Grid %s: %s", name, err)
+			},
+			TearDownTactic: grid.tearDown,
+			Params: []ParamSpec{
+				{Name: GridLowerPriceParam, Type: ParamDecimal, Default: grid.config.LowerPrice, Validate: positiveDecimal, Usage: "lowest price of the grid"},
+				{Name: GridUpperPriceParam, Type: ParamDecimal, Default: grid.config.UpperPrice, Validate: positiveDecimal, Usage: "highest price of the grid"},
+				{Name: GridLevelsParam, Type: ParamInt, Default: grid.config.Levels, Validate: positiveInt, Usage: "number of levels of the grid"},
+				{Name: GridGeometricParam, Type: ParamBool, Default: grid.config.Geometric, Usage: "true for levels with the same ratio, false for the same width"},
+				{Name: GridQuantityParam, Type: ParamDecimal, Default: grid.config.Quantity, Validate: positiveDecimal, Usage: "quantity bought and sold on each level"},
+				{Name: GridCancelOnTearDownParam, Type: ParamBool, Default: grid.config.CancelOnTearDown, Usage: "true to cancel the open orders when the strategy stops"},
+			},
+		},
+		Interval: grid.config.Interval,
+	}
+}
+
+// tacticConfig returns the configuration of the grid traded by a tactic, overridden by its parameters.
+func (grid *Grid) tacticConfig(tactic *Tactic) (GridConfig, error) {
+	config := grid.config
+	params := tactic.Params
+	if params.Has(GridLowerPriceParam) {
+		config.LowerPrice = params.Decimal(GridLowerPriceParam)
+	}
+	if params.Has(GridUpperPriceParam) {
+		config.UpperPrice = params.Decimal(GridUpperPriceParam)
+	}
+	if params.Has(GridLevelsParam) {
+		config.Levels = params.Int(GridLevelsParam)
+	}
+	if params.Has(GridGeometricParam) {
+		config.Geometric = params.Bool(GridGeometricParam)
+	}
+	if params.Has(GridQuantityParam) {
+		config.Quantity = params.Decimal(GridQuantityParam)
+	}
+	if params.Has(GridCancelOnTearDownParam) {
+		config.CancelOnTearDown = params.Bool(GridCancelOnTearDownParam)
+	}
+	return config, config.validate()
+}
+
+// Prices returns the prices of the grid lines of the configuration of the strategy, from the lowest.
+func (grid *Grid) Prices() []decimal.Decimal {
+	return grid.config.prices()
+}
+
+// prices returns the prices of the grid lines of a configuration, from the lowest.
+func (config GridConfig) prices() []decimal.Decimal {
+	lower, upper, levels := config.LowerPrice, config.UpperPrice, config.Levels
+
+	prices := make([]decimal.Decimal, levels+1)
+	if config.Geometric {
+		ratio := math.Pow(upper.Div(lower).InexactFloat64(), 1/float64(levels))
+		for i := range prices {
+			prices[i] = lower.Mul(decimal.NewFromFloat(math.Pow(ratio, float64(i)))).Round(geometricGridPrecision)
+		}
+	} else {
+		step := upper.Sub(lower).Div(decimal.NewFromInt(int64(levels)))
+		for i := range prices {
+			prices[i] = lower.Add(step.Mul(decimal.NewFromInt(int64(i))))
+		}
+	}
+	prices[levels] = upper
+	return prices
+}
+
+// Levels returns a snapshot of the levels of the grid traded on a market of an exchange, from the lowest.
+//
+// If more tactics trade the grid on the same market, the levels of all of them are returned.
+func (grid *Grid) Levels(exchange string, market *environment.Market) []GridLevel {
+	grid.mutex.Lock()
+	defer grid.mutex.Unlock()
+
+	var ret []GridLevel
+	for _, books := range grid.books {
+		for _, book := range books {
+			if book.exchange == exchange && book.market.Name == market.Name {
+				for _, level := range book.levels {
+					ret = append(ret, *level)
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// Profit returns the realised profit of all the levels of the grid, in base currency.
+func (grid *Grid) Profit() decimal.Decimal {
+	grid.mutex.Lock()
+	defer grid.mutex.Unlock()
+
+	profit := decimal.Zero
+	for _, books := range grid.books {
+		for _, book := range books {
+			for _, level := range book.levels {
+				profit = profit.Add(level.Profit)
+			}
+		}
+	}
+	return profit
+}
+
+// setup creates the levels of the grid of a tactic on each binded market and places their first orders:
+// levels whose lower price is below the current price start buying, the others start selling.
+//
+// Levels saved in the state of the tactic are restored instead, if they match the prices of the grid.
+func (grid *Grid) setup(wrappers []exchanges.ExchangeWrapper, markets []*environment.Market, tactic *Tactic) error {
+	grid.mutex.Lock()
+	defer grid.mutex.Unlock()
+
+	config, err := grid.tacticConfig(tactic)
+	if err != nil {
+		return err
+	}
+	prices := config.prices()
+	var books []*gridBook
+	for _, market := range markets {
+		for _, wrapper := range wrappers {
+			if !exchanges.IsBinded(market, wrapper) {
+				continue
+			}
+
+			book := &gridBook{
+				exchange: wrapper.Name(),
+				market:   market,
+			}
+			restored, err := grid.load(tactic, book, prices)
+			if err != nil {
+				return err
+			}
+			if restored {
+				logrus.Infof("Grid %s %s: restored %d levels", book.exchange, book.market.Name, len(book.levels))
+			} else {
+				summary, err := wrapper.GetMarketSummary(market)
+				if err != nil {
+					return err
+				}
+
+				book.levels = make([]*GridLevel, len(prices)-1)
+				for i := range book.levels {
+					book.levels[i] = &GridLevel{
+						Lower: prices[i],
+						Upper: prices[i+1],
+						Side:  environment.Ask,
+					}
+					if prices[i].LessThan(summary.Last) {
+						book.levels[i].Side = environment.Bid
+					}
+				}
+			}
+			books = append(books, book)
+
+			grid.placeOrders(config, wrapper, book)
+			if err := grid.save(tactic, book); err != nil {
+				return err
+			}
+		}
+	}
+	grid.books[tactic] = books
+
+	if len(books) == 0 {
+		return errors.New("Grid has no market binded to the exchanges")
+	}
+	return nil
+}
+
+// update checks the orders of the grid, re-placing the opposite order of the filled levels.
+//
+// Errors of the exchanges are logged and the operation is retried on the next update, so the grid keeps running;
+// orders the exchange does not know anymore are placed again.
+func (grid *Grid) update(wrappers []exchanges.ExchangeWrapper, markets []*environment.Market, tactic *Tactic) error {
+	grid.mutex.Lock()
+	defer grid.mutex.Unlock()
+
+	config, err := grid.tacticConfig(tactic)
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, book := range grid.books[tactic] {
+		wrapper := wrapperNamed(wrappers, book.exchange)
+		if wrapper == nil {
+			continue
+		}
+
+		for _, level := range book.levels {
+			if level.OrderID == "" {
+				continue
+			}
+
+			order, err := wrapper.GetOrder(book.market, level.OrderID)
+			if errors.Is(err, exchanges.ErrOrderNotFound) {
+				// expired or purged by the exchange: placed again as a cancelled order with nothing filled.
+				logrus.Warnf("Grid %s %s: order %s not found, placing it again", book.exchange, book.market.Name, level.OrderID)
+				level.OrderID = ""
+				continue
+			}
+			if err != nil {
+				logrus.Warnf("Grid %s %s: cannot get order %s: %s", book.exchange, book.market.Name, level.OrderID, err)
+				continue
+			}
+
+			switch order.Status {
+			case environment.OrderFilled:
+				grid.filled(config, book, level, order)
+			case environment.OrderCancelled:
+				logrus.Warnf("Grid %s %s: order %s has been cancelled with %s filled, placing the rest again", book.exchange, book.market.Name, level.OrderID, order.FilledQuantity)
+				grid.filled(config, book, level, order)
+			}
+		}
+
+		grid.placeOrders(config, wrapper, book)
+		if err := grid.save(tactic, book); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// filled records the quantity filled by the order of a level, which is either filled or cancelled,
+// and flips the side of the level once its whole quantity is filled.
+//
+// The coins sold by a partial fill realise the profit of their share of the cost of the level.
+func (grid *Grid) filled(config GridConfig, book *gridBook, level *GridLevel, order *environment.OrderInfo) {
+	level.OrderID = ""
+
+	if order.FilledQuantity.IsPositive() {
+		price := order.AveragePrice
+		if price.IsZero() {
+			price = order.Limit
+		}
+		value := order.FilledQuantity.Mul(price)
+		fee := feeInBaseCurrency(order, price)
+
+		if level.Side == environment.Bid {
+			level.cost = level.cost.Add(value).Add(fee)
+			logrus.Infof("Grid %s %s: bought %s at %s", book.exchange, book.market.Name, order.FilledQuantity, price)
+		} else if unsold := config.Quantity.Sub(level.filled); level.cost.IsPositive() && unsold.IsPositive() {
+			cost := level.cost
+			if order.Status != environment.OrderFilled && order.FilledQuantity.LessThan(unsold) {
+				cost = cost.Mul(order.FilledQuantity).Div(unsold)
+			} else {
+				level.RoundTrips++
+			}
+			profit := value.Sub(fee).Sub(cost)
+			level.cost = level.cost.Sub(cost)
+			level.Profit = level.Profit.Add(profit)
+			logrus.Infof("Grid %s %s: sold %s at %s, level profit %s %s", book.exchange, book.market.Name, order.FilledQuantity, price, profit, book.market.BaseCurrency)
+		} else {
+			logrus.Infof("Grid %s %s: sold %s at %s", book.exchange, book.market.Name, order.FilledQuantity, price)
+		}
+		level.filled = level.filled.Add(order.FilledQuantity)
+	}
+
+	if order.Status != environment.OrderFilled && level.filled.LessThan(config.Quantity) {
+		return
+	}
+	if level.Side == environment.Bid {
+		level.Side = environment.Ask
+	} else {
+		level.cost = decimal.Zero
+		level.Side = environment.Bid
+	}
+	level.filled = decimal.Zero
+}
+
+// placeOrders places the orders of the levels which have none, for the quantity not filled yet on their side.
+func (grid *Grid) placeOrders(config GridConfig, wrapper exchanges.ExchangeWrapper, book *gridBook) {
+	for _, level := range book.levels {
+		if level.OrderID != "" {
+			continue
+		}
+
+		quantity := config.Quantity.Sub(level.filled)
+		var err error
+		if level.Side == environment.Bid {
+			level.OrderID, err = wrapper.BuyLimit(book.market, quantity, level.Lower)
+		} else {
+			level.OrderID, err = wrapper.SellLimit(book.market, quantity, level.Upper)
+		}
+		if err != nil {
+			logrus.Warnf("Grid %s %s: cannot place order on level %s-%s: %s", book.exchange, book.market.Name, level.Lower, level.Upper, err)
+		}
+	}
+}
+
+// tearDown logs the realised profit of the grid and, if configured, cancels its open orders.
+func (grid *Grid) tearDown(wrappers []exchanges.ExchangeWrapper, markets []*environment.Market, tactic *Tactic) error {
+	grid.mutex.Lock()
+	defer grid.mutex.Unlock()
+
+	// the books of a tactic with an invalid configuration are empty, so only the flag is needed.
+	config, _ := grid.tacticConfig(tactic)
+	cancelOrders := config.CancelOnTearDown
+
+	var firstErr error
+	for _, book := range grid.books[tactic] {
+		profit := decimal.Zero
+		roundTrips := 0
+		for _, level := range book.levels {
+			profit = profit.Add(level.Profit)
+			roundTrips += level.RoundTrips
+		}
+		logrus.Infof("Grid %s %s: %d round trips, realised profit %s %s", book.exchange, book.market.Name, roundTrips, profit, book.market.BaseCurrency)
+
+		wrapper := wrapperNamed(wrappers, book.exchange)
+		if !cancelOrders || wrapper == nil {
+			continue
+		}
+		for _, level := range book.levels {
+			if level.OrderID == "" {
+				continue
+			}
+			err := wrapper.CancelOrder(book.market, level.OrderID)
+			if err != nil && err != exchanges.ErrOrderNotFound && firstErr == nil {
+				firstErr = err
+			}
+			level.OrderID = ""
+		}
+		if err := grid.save(tactic, book); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// load restores the levels of a book from the state of the tactic, returning false if
+// they are not saved or do not match the prices of the grid (e.g. the configuration changed).
+func (grid *Grid) load(tactic *Tactic, book *gridBook, prices []decimal.Decimal) (bool, error) {
+	var saved []savedGridLevel
+	found, err := tactic.State(book.market).Get(book.exchange, &saved)
+	if err != nil || !found {
+		return false, err
+	}
+	if len(saved) != len(prices)-1 {
+		logrus.Warnf("Grid %s %s: saved levels do not match the grid, starting over", book.exchange, book.market.Name)
+		return false, nil
+	}
+	for i, level := range saved {
+		if !level.Lower.Equal(prices[i]) || !level.Upper.Equal(prices[i+1]) {
+			logrus.Warnf("Grid %s %s: saved levels do not match the grid, starting over", book.exchange, book.market.Name)
+			return false, nil
+		}
+	}
+
+	book.levels = make([]*GridLevel, len(saved))
+	for i, level := range saved {
+		book.levels[i] = &GridLevel{
+			Lower:      prices[i],
+			Upper:      prices[i+1],
+			Side:       level.Side,
+			OrderID:    level.OrderID,
+			RoundTrips: level.RoundTrips,
+			Profit:     level.Profit,
+			cost:       level.Cost,
+			filled:     level.Filled,
+		}
+	}
+	return true, nil
+}
+
+// save saves the levels of a book in the state of the tactic.
+func (grid *Grid) save(tactic *Tactic, book *gridBook) error {
+	saved := make([]savedGridLevel, len(book.levels))
+	for i, level := range book.levels {
+		saved[i] = savedGridLevel{
+			Lower:      level.Lower,
+			Upper:      level.Upper,
+			Side:       level.Side,
+			OrderID:    level.OrderID,
+			RoundTrips: level.RoundTrips,
+			Profit:     level.Profit,
+			Cost:       level.cost,
+			Filled:     level.filled,
+		}
+	}
+	return tactic.State(book.market).Set(book.exchange, saved)
+}
+
+// feeInBaseCurrency returns the fees paid by an order in the base currency of its market,
+// converting the fees charged in market currency at the specified price.
+func feeInBaseCurrency(order *environment.OrderInfo, price decimal.Decimal) decimal.Decimal {
+	if order.Market != nil && order.FeeCurrency == order.Market.MarketCurrency {
+		return order.Fee.Mul(price)
+	}
+	return order.Fee
+}
+
+// wrapperNamed returns the wrapper of the exchange with the specified name, nil if not found.
+func wrapperNamed(wrappers []exchanges.ExchangeWrapper, name string) exchanges.ExchangeWrapper {
+	for _, wrapper := range wrappers {
+		if wrapper.Name() == name {
+			return wrapper
+		}
+	}
+	return nil
+}
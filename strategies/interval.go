@@ -16,6 +16,7 @@
 package strategies
 
 import (
+	"context"
 	"errors"
 	"time"
 
@@ -41,15 +42,66 @@ func (is IntervalStrategy) String() string {
 
 // Apply executes Cyclically the On Update, basing on provided interval.
 func (is IntervalStrategy) Apply(wrappers []exchanges.ExchangeWrapper, markets []*environment.Market) {
+	is.ApplyContext(context.Background(), wrappers, markets)
+}
+
+// ApplyContext executes Cyclically the On Update, basing on provided interval, until the context is done.
+//
+// Setup and OnUpdate calls to the exchanges are aborted when the context is done, TearDown is executed afterwards.
+func (is IntervalStrategy) ApplyContext(ctx context.Context, wrappers []exchanges.ExchangeWrapper, markets []*environment.Market) {
+	tactic, err := NewTactic(is, markets, nil)
+	if err != nil {
+		if is.Model.OnError != nil {
+			is.Model.OnError(err)
+		}
+		return
+	}
+	is.ApplyTactic(ctx, wrappers, tactic)
+}
+
+// ParamSpecs returns the parameters declared by the model, along with the interval parameter
+// which overrides the interval of the strategy for a tactic.
+func (is IntervalStrategy) ParamSpecs() []ParamSpec {
+	specs := make([]ParamSpec, 0, len(is.Model.Params)+1)
+	hasInterval := false
+	for _, spec := range is.Model.Params {
+		hasInterval = hasInterval || spec.Name == IntervalParam
+		specs = append(specs, spec)
+	}
+	if !hasInterval {
+		specs = append(specs, ParamSpec{
+			Name:     IntervalParam,
+			Type:     ParamDuration,
+			Default:  is.Interval,
+			Validate: positiveDuration,
+			Usage:    "interval between updates, e.g. 30s",
+		})
+	}
+	return specs
+}
+
+// ApplyTactic executes Cyclically the On Update with the markets and the parameters of a tactic, until the context is done.
+//
+// The interval parameter of the tactic, if set, overrides the interval of the strategy.
+func (is IntervalStrategy) ApplyTactic(ctx context.Context, wrappers []exchanges.ExchangeWrapper, tactic *Tactic) {
 	var err error
 
-	hasSetupFunc := is.Model.Setup != nil
-	hasTearDownFunc := is.Model.TearDown != nil
-	hasUpdateFunc := is.Model.OnUpdate != nil
+	markets := tactic.Markets
+	interval := is.Interval
+	if tacticInterval := tactic.Params.Duration(IntervalParam); tacticInterval > 0 {
+		interval = tacticInterval
+	}
+
+	setup, update, tearDown := is.Model.SetupFunc(), is.Model.UpdateFunc(), is.Model.TearDownFunc()
+	hasSetupFunc := setup != nil
+	hasTearDownFunc := tearDown != nil
+	hasUpdateFunc := update != nil
 	hasErrorFunc := is.Model.OnError != nil
 
+	boundWrappers := exchanges.BindContext(ctx, wrappers)
+
 	if hasSetupFunc {
-		err = is.Model.Setup(wrappers, markets)
+		err = setup(boundWrappers, markets, tactic)
 		if err != nil && hasErrorFunc {
 			is.Model.OnError(err)
 		}
@@ -64,16 +116,32 @@ func (is IntervalStrategy) Apply(wrappers []exchanges.ExchangeWrapper, markets [
 		}
 	}
 	for err == nil {
-		err = is.Model.OnUpdate(wrappers, markets)
+		err = update(boundWrappers, markets, tactic)
+		if ctx.Err() != nil {
+			break
+		}
 		if err != nil && hasErrorFunc {
 			is.Model.OnError(err)
 		}
-		time.Sleep(is.Interval)
+
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-time.After(interval):
+		}
 	}
 	if hasTearDownFunc {
-		err = is.Model.TearDown(wrappers, markets)
+		err = tearDown(wrappers, markets, tactic)
 		if err != nil && hasErrorFunc {
 			is.Model.OnError(err)
 		}
 	}
 }
+
+// positiveDuration checks that a duration parameter is greater than zero.
+func positiveDuration(value interface{}) error {
+	if d, _ := value.(time.Duration); d <= 0 {
+		return errors.New("must be > 0")
+	}
+	return nil
+}
@@ -0,0 +1,325 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package strategies
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/shopspring/decimal"
+)
+
+// IntervalParam is the name of the parameter overriding the interval of an interval strategy.
+const IntervalParam = "interval"
+
+// ParamType represents the type of the value of a strategy parameter.
+type ParamType int
+
+const (
+	// ParamString represents a string parameter.
+	ParamString ParamType = iota
+	// ParamInt represents an integer parameter.
+	ParamInt
+	// ParamFloat represents a floating point parameter.
+	ParamFloat
+	// ParamDecimal represents a decimal parameter (e.g. prices, quantities and rates).
+	ParamDecimal
+	// ParamBool represents a boolean parameter.
+	ParamBool
+	// ParamDuration represents a duration parameter, written as a Go duration (e.g. 30s, 5m, 1h30m).
+	ParamDuration
+	// ParamTimeFrame represents a candle timeframe parameter (e.g. 15m, 4h, 1d).
+	ParamTimeFrame
+)
+
+// String returns the name of the type.
+func (t ParamType) String() string {
+	switch t {
+	case ParamString:
+		return "string"
+	case ParamInt:
+		return "int"
+	case ParamFloat:
+		return "float"
+	case ParamDecimal:
+		return "decimal"
+	case ParamBool:
+		return "bool"
+	case ParamDuration:
+		return "duration"
+	case ParamTimeFrame:
+		return "timeframe"
+	default:
+		return "ParamType(" + strconv.Itoa(int(t)) + ")"
+	}
+}
+
+// ParamSpec represents a parameter declared by a strategy model, which can be set in the params of its bindings in the configuration file.
+type ParamSpec struct {
+	Name     string                  // Represents the name of the parameter, as written in the configuration file.
+	Type     ParamType               // Represents the type of the value.
+	Default  interface{}             // Represents the value used when the parameter is not set, nil for none.
+	Required bool                    // If true, the parameter must be set.
+	Validate func(interface{}) error // [optional] Checks the value, already converted to the type of the parameter.
+	Usage    string                  // [optional] Describes the parameter, shown in validation errors.
+}
+
+// Params represents the parameters of a tactic, by name.
+//
+// Values are converted to the type of their spec and completed with the defaults when the params are validated with NewParams,
+// the accessors return the zero value of their type for parameters which are not set or cannot be converted.
+type Params map[string]interface{}
+
+// NewParams validates the values of a binding against the specs of a strategy, returning them converted to the type
+// of their spec and completed with the defaults.
+//
+// If specs are nil the values are not validated, and converted when accessed.
+func NewParams(specs []ParamSpec, values map[string]interface{}) (Params, error) {
+	ret := make(Params, len(values))
+	if specs == nil {
+		for name, value := range values {
+			ret[name] = value
+		}
+		return ret, nil
+	}
+
+	declared := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		declared[spec.Name] = true
+
+		value, isSet := values[spec.Name]
+		if !isSet || value == nil {
+			if spec.Required {
+				return nil, fmt.Errorf("Parameter %s is required%s", spec.Name, spec.usage())
+			}
+			value = spec.Default
+			if value == nil {
+				continue
+			}
+		}
+
+		converted, err := convertParam(spec.Type, value)
+		if err != nil {
+			return nil, fmt.Errorf("Parameter %s: %s%s", spec.Name, err, spec.usage())
+		}
+		if spec.Validate != nil {
+			if err := spec.Validate(converted); err != nil {
+				return nil, fmt.Errorf("Parameter %s: %s%s", spec.Name, err, spec.usage())
+			}
+		}
+		ret[spec.Name] = converted
+	}
+
+	var unknown []string
+	for name := range values {
+		if !declared[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("Unknown parameters %s", strings.Join(unknown, ", "))
+	}
+	return ret, nil
+}
+
+// usage returns the description of the parameter to append to errors.
+func (spec ParamSpec) usage() string {
+	if spec.Usage == "" {
+		return ""
+	}
+	return " (" + spec.Usage + ")"
+}
+
+// Has returns true if the parameter is set.
+func (params Params) Has(name string) bool {
+	_, isSet := params[name]
+	return isSet
+}
+
+// String returns the value of a string parameter.
+func (params Params) String(name string) string {
+	value, _ := params.get(name, ParamString).(string)
+	return value
+}
+
+// Int returns the value of an integer parameter.
+func (params Params) Int(name string) int {
+	value, _ := params.get(name, ParamInt).(int)
+	return value
+}
+
+// Float returns the value of a floating point parameter.
+func (params Params) Float(name string) float64 {
+	value, _ := params.get(name, ParamFloat).(float64)
+	return value
+}
+
+// Decimal returns the value of a decimal parameter.
+func (params Params) Decimal(name string) decimal.Decimal {
+	value, _ := params.get(name, ParamDecimal).(decimal.Decimal)
+	return value
+}
+
+// Bool returns the value of a boolean parameter.
+func (params Params) Bool(name string) bool {
+	value, _ := params.get(name, ParamBool).(bool)
+	return value
+}
+
+// Duration returns the value of a duration parameter.
+func (params Params) Duration(name string) time.Duration {
+	value, _ := params.get(name, ParamDuration).(time.Duration)
+	return value
+}
+
+// TimeFrame returns the value of a timeframe parameter.
+func (params Params) TimeFrame(name string) environment.TimeFrame {
+	value, _ := params.get(name, ParamTimeFrame).(environment.TimeFrame)
+	return value
+}
+
+// get returns the value of a parameter converted to the specified type, nil if not set or not convertible.
+func (params Params) get(name string, t ParamType) interface{} {
+	value, isSet := params[name]
+	if !isSet {
+		return nil
+	}
+	converted, err := convertParam(t, value)
+	if err != nil {
+		return nil
+	}
+	return converted
+}
+
+// convertParam converts a value, as read from the configuration file or set as default, to the specified type.
+func convertParam(t ParamType, value interface{}) (interface{}, error) {
+	switch t {
+	case ParamString:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case fmt.Stringer:
+			return v.String(), nil
+		case int, int64, float64, bool:
+			return fmt.Sprint(v), nil
+		}
+	case ParamInt:
+		switch v := value.(type) {
+		case int:
+			return v, nil
+		case int64:
+			return int(v), nil
+		case uint64:
+			return int(v), nil
+		case float64:
+			if v == float64(int(v)) {
+				return int(v), nil
+			}
+		case string:
+			if i, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+				return i, nil
+			}
+		}
+	case ParamFloat:
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case int:
+			return float64(v), nil
+		case int64:
+			return float64(v), nil
+		case decimal.Decimal:
+			return v.InexactFloat64(), nil
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				return f, nil
+			}
+		}
+	case ParamDecimal:
+		switch v := value.(type) {
+		case decimal.Decimal:
+			return v, nil
+		case float64:
+			return decimal.NewFromFloat(v), nil
+		case int:
+			return decimal.NewFromInt(int64(v)), nil
+		case int64:
+			return decimal.NewFromInt(v), nil
+		case string:
+			if d, err := decimal.NewFromString(strings.TrimSpace(v)); err == nil {
+				return d, nil
+			}
+		}
+	case ParamBool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+				return b, nil
+			}
+		}
+	case ParamDuration:
+		switch v := value.(type) {
+		case time.Duration:
+			return v, nil
+		case string:
+			if d, err := time.ParseDuration(strings.TrimSpace(v)); err == nil {
+				return d, nil
+			}
+		}
+	case ParamTimeFrame:
+		switch v := value.(type) {
+		case environment.TimeFrame:
+			return v, nil
+		case string:
+			if tf, err := environment.ParseTimeFrame(v); err == nil {
+				return tf, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("cannot use %v as %s", value, t)
+}
+
+// positiveInt checks that an integer parameter is greater than zero.
+func positiveInt(value interface{}) error {
+	if i, _ := value.(int); i <= 0 {
+		return errors.New("must be > 0")
+	}
+	return nil
+}
+
+// positiveDecimal checks that a decimal parameter is greater than zero.
+func positiveDecimal(value interface{}) error {
+	if d, _ := value.(decimal.Decimal); !d.IsPositive() {
+		return errors.New("must be > 0")
+	}
+	return nil
+}
+
+// nonNegativeDecimal checks that a decimal parameter is not negative.
+func nonNegativeDecimal(value interface{}) error {
+	if d, _ := value.(decimal.Decimal); d.IsNegative() {
+		return errors.New("must be >= 0")
+	}
+	return nil
+}
@@ -0,0 +1,761 @@
+// Copyright © 2017 Alessandro Sanino <saninoale@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package strategies
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saniales/golang-crypto-trading-bot/environment"
+	"github.com/saniales/golang-crypto-trading-bot/exchanges"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultTriangularInterval   = 10 * time.Second // interval between evaluations of the cycles when not configured.
+	defaultTriangularMaxMarkets = 20               // maximum number of markets traded by the cycles of an exchange when not configured.
+)
+
+// Parameters of the triangular arbitrage strategy, overriding its configuration for a binding.
+//
+// Start amounts are written as currency:amount pairs separated by commas (e.g. BTC:0.01,ETH:0.2),
+// currencies and exchanges as names separated by commas (e.g. BTC,ETH,USDT).
+const (
+	TriangularStartAmountsParam = "start_amounts"
+	TriangularCurrenciesParam   = "currencies"
+	TriangularExchangesParam    = "exchanges"
+	TriangularMinProfitParam    = "min_profit"
+	TriangularExecuteParam      = "execute"
+	TriangularLegChecksParam    = "leg_checks"
+	TriangularMaxMarketsParam   = "max_markets"
+)
+
+// TriangularConfig represents the configuration of a triangular arbitrage strategy.
+type TriangularConfig struct {
+	StartAmounts map[string]decimal.Decimal // Represents the amount traded by each cycle, by the currency the cycle starts from (e.g. BTC: 0.01).
+	Currencies   []string                   // Represents the currencies the cycles can go through, empty for any.
+	Exchanges    []string                   // Represents the exchanges scanned, empty for all the exchanges of the bot.
+	MinProfit    decimal.Decimal            // Represents the minimum return of a cycle, net of fees, as a fraction of the start amount (e.g. 0.002 for 0.2%).
+	Execute      bool                       // If true, the profitable cycles are traded, otherwise they are only logged.
+	Interval     time.Duration              // Represents the interval between evaluations of the cycles (10 seconds by default).
+	LegChecks    int                        // Represents the number of checks the legs of a trade are given to be filled before being cancelled (1 by default).
+	MaxMarkets   int                        // Represents the maximum number of markets traded by the cycles of an exchange, whose order books are read on each update (20 by default).
+}
+
+// TriangularLeg represents a conversion of a cycle, performed by trading on a market.
+type TriangularLeg struct {
+	Market *environment.Market   // Represents the market traded.
+	From   string                // Represents the currency spent.
+	To     string                // Represents the currency received.
+	Side   environment.OrderType // Represents the side of the order: Bid if the market currency is bought, Ask if it is sold.
+}
+
+// TriangularCycle represents three conversions on an exchange which start and end with the same currency.
+type TriangularCycle struct {
+	Legs [3]TriangularLeg
+}
+
+// String returns the currencies of the cycle, e.g. BTC -> ETH -> USDT -> BTC.
+func (cycle TriangularCycle) String() string {
+	return strings.Join([]string{cycle.Legs[0].From, cycle.Legs[1].From, cycle.Legs[2].From, cycle.Legs[2].To}, " -> ")
+}
+
+// TriangularLegQuote represents the order needed to perform a leg of a cycle on the current order book.
+type TriangularLegQuote struct {
+	Quantity  decimal.Decimal // Represents the quantity of market currency of the order.
+	Limit     decimal.Decimal // Represents the worst price reached in the order book, used as limit of the order.
+	AmountIn  decimal.Decimal // Represents the amount of currency spent.
+	AmountOut decimal.Decimal // Represents the amount of currency received, net of fees.
+}
+
+// TriangularQuote represents the evaluation of a cycle against the current order books.
+type TriangularQuote struct {
+	Cycle     TriangularCycle
+	Legs      [3]TriangularLegQuote
+	AmountIn  decimal.Decimal // Represents the amount of start currency spent.
+	AmountOut decimal.Decimal // Represents the amount of start currency received at the end of the cycle, net of fees.
+}
+
+// Return returns the return of the cycle as a fraction of the start amount (e.g. 0.002 for 0.2%).
+func (quote TriangularQuote) Return() decimal.Decimal {
+	return quote.AmountOut.Div(quote.AmountIn).Sub(decimal.NewFromInt(1))
+}
+
+// TriangularStats represents the activity of a triangular arbitrage strategy.
+type TriangularStats struct {
+	Cycles        int // Represents the number of cycles found on the exchanges.
+	Evaluations   int // Represents the number of evaluations of the cycles.
+	Opportunities int // Represents the number of evaluations with a return above the minimum.
+	Skipped       int // Represents the number of opportunities not executed.
+	Executed      int // Represents the number of trades whose legs have been placed.
+	Completed     int // Represents the number of trades whose legs have been all filled.
+	Failed        int // Represents the number of trades whose legs failed and have been cancelled.
+}
+
+// triangularExchange represents the cycles of an exchange.
+type triangularExchange struct {
+	name    string
+	books   *exchanges.OrderbookCache
+	markets []*environment.Market // markets traded by the cycles.
+	cycles  []TriangularCycle
+	pending *triangularTrade
+}
+
+// triangularTrade represents the legs placed for a cycle.
+type triangularTrade struct {
+	quote    *TriangularQuote
+	orderIDs [3]string
+	checks   int
+}
+
+// Triangular is a triangular arbitrage strategy: it discovers the cycles of three markets of an exchange
+// which start and end with the same currency (e.g. BTC -> ETH -> USDT -> BTC), evaluates them against the depth
+// of the order books including the taker fees and, when enabled, trades the profitable ones by placing the three legs at once.
+//
+// The legs are placed concurrently with the currencies already held, so the balance of the currency
+// spent by each leg must be available: legs not filled within the configured checks are cancelled
+// and the resulting imbalance is logged, since there is no reference market to unwind it.
+//
+// Markets are discovered with GetMarkets and, on the exchanges supervising their feeds, the markets of the cycles
+// are subscribed with FeedConnect, so that their order books are read from the cache of the feeds; they are
+// fetched on each update only while the feed of a market is down, or on the exchanges which do not stream order books.
+// Exchanges whose cycles trade more than MaxMarkets markets are not scanned: restrict the Currencies to scan them.
+//
+// Each tactic the strategy is applied with keeps its own cycles and pending trades.
+type Triangular struct {
+	config    TriangularConfig
+	mutex     sync.Mutex
+	exchanges map[*Tactic][]*triangularExchange
+	stats     TriangularStats
+}
+
+// NewTriangular creates a triangular arbitrage strategy from its configuration, used by the bindings which do not set its parameters.
+func NewTriangular(config TriangularConfig) (*Triangular, error) {
+	if err := validateStartAmounts(config.StartAmounts); err != nil {
+		return nil, err
+	}
+	if config.MinProfit.IsNegative() {
+		return nil, errors.New("Triangular arbitrage minimum profit must be >= 0")
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaultTriangularInterval
+	}
+	if config.LegChecks <= 0 {
+		config.LegChecks = 1
+	}
+	if config.MaxMarkets <= 0 {
+		config.MaxMarkets = defaultTriangularMaxMarkets
+	}
+
+	return &Triangular{
+		config:    config,
+		exchanges: make(map[*Tactic][]*triangularExchange),
+	}, nil
+}
+
+// validateStartAmounts checks that there is at least a start currency, with a positive amount.
+func validateStartAmounts(amounts map[string]decimal.Decimal) error {
+	if len(amounts) == 0 {
+		return errors.New("Triangular arbitrage must have at least a start currency")
+	}
+	for currency, amount := range amounts {
+		if !amount.IsPositive() {
+			return fmt.Errorf("Triangular arbitrage start amount of %s must be > 0", currency)
+		}
+	}
+	return nil
+}
+
+// Strategy returns the interval strategy performing the arbitrage, to be added with AddCustomStrategy.
+//
+// The bindings of the strategy can override the configuration of the arbitrage with its parameters
+// (start_amounts, currencies, exchanges, min_profit, execute, leg_checks and max_markets).
+func (triangular *Triangular) Strategy(name string) IntervalStrategy {
+	config := triangular.config
+
+	amounts := make([]string, 0, len(config.StartAmounts))
+	for currency, amount := range config.StartAmounts {
+		amounts = append(amounts, currency+":"+amount.String())
+	}
+	sort.Strings(amounts)
+
+	return IntervalStrategy{
+		Model: StrategyModel{
+			Name:           name,
+			SetupTactic:    triangular.setup,
+			OnUpdateTactic: triangular.update,
+			OnError: func(err error) {
+				logrus.Errorf("Triangular %s: %s", name, err)
+			},
+			TearDownTactic: triangular.tearDown,
+			Params: []ParamSpec{
+				{Name: TriangularStartAmountsParam, Type: ParamString, Default: strings.Join(amounts, ","), Validate: validStartAmountsParam, Usage: "amounts traded by the cycles, e.g. BTC:0.01,ETH:0.2"},
+				{Name: TriangularCurrenciesParam, Type: ParamString, Default: strings.Join(config.Currencies, ","), Usage: "currencies the cycles can go through, e.g. BTC,ETH,USDT, empty for any"},
+				{Name: TriangularExchangesParam, Type: ParamString, Default: strings.Join(config.Exchanges, ","), Usage: "exchanges scanned, e.g. binance,kraken, empty for all"},
+				{Name: TriangularMinProfitParam, Type: ParamDecimal, Default: config.MinProfit, Validate: nonNegativeDecimal, Usage: "minimum return of a cycle net of fees"},
+				{Name: TriangularExecuteParam, Type: ParamBool, Default: config.Execute, Usage: "true to trade the profitable cycles"},
+				{Name: TriangularLegChecksParam, Type: ParamInt, Default: config.LegChecks, Validate: positiveInt, Usage: "checks the legs are given to be filled"},
+				{Name: TriangularMaxMarketsParam, Type: ParamInt, Default: config.MaxMarkets, Validate: positiveInt, Usage: "maximum number of markets traded by the cycles of an exchange"},
+			},
+		},
+		Interval: config.Interval,
+	}
+}
+
+// tacticConfig returns the configuration of the arbitrage performed by a tactic, overridden by its parameters.
+func (triangular *Triangular) tacticConfig(tactic *Tactic) TriangularConfig {
+	config := triangular.config
+	params := tactic.Params
+	if params.Has(TriangularStartAmountsParam) {
+		// validated with the parameters.
+		config.StartAmounts, _ = parseStartAmounts(params.String(TriangularStartAmountsParam))
+	}
+	if params.Has(TriangularCurrenciesParam) {
+		config.Currencies = splitParamList(params.String(TriangularCurrenciesParam))
+	}
+	if params.Has(TriangularExchangesParam) {
+		config.Exchanges = splitParamList(params.String(TriangularExchangesParam))
+	}
+	if params.Has(TriangularMinProfitParam) {
+		config.MinProfit = params.Decimal(TriangularMinProfitParam)
+	}
+	if params.Has(TriangularExecuteParam) {
+		config.Execute = params.Bool(TriangularExecuteParam)
+	}
+	if params.Has(TriangularLegChecksParam) {
+		config.LegChecks = params.Int(TriangularLegChecksParam)
+	}
+	if params.Has(TriangularMaxMarketsParam) {
+		config.MaxMarkets = params.Int(TriangularMaxMarketsParam)
+	}
+	return config
+}
+
+// parseStartAmounts parses the start amounts written as currency:amount pairs separated by commas.
+func parseStartAmounts(value string) (map[string]decimal.Decimal, error) {
+	amounts := make(map[string]decimal.Decimal)
+	for _, pair := range splitParamList(value) {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s is not a currency:amount pair", pair)
+		}
+		amount, err := decimal.NewFromString(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a currency:amount pair", pair)
+		}
+		amounts[strings.TrimSpace(parts[0])] = amount
+	}
+	return amounts, validateStartAmounts(amounts)
+}
+
+// validStartAmountsParam checks the start amounts parameter.
+func validStartAmountsParam(value interface{}) error {
+	s, _ := value.(string)
+	_, err := parseStartAmounts(s)
+	return err
+}
+
+// splitParamList splits a list of names separated by commas, nil if empty.
+func splitParamList(value string) []string {
+	var ret []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
+
+// Stats returns the activity of the strategy so far.
+func (triangular *Triangular) Stats() TriangularStats {
+	triangular.mutex.Lock()
+	defer triangular.mutex.Unlock()
+
+	return triangular.stats
+}
+
+// Cycles returns the cycles found on an exchange, by all the tactics of the strategy.
+func (triangular *Triangular) Cycles(exchange string) []TriangularCycle {
+	triangular.mutex.Lock()
+	defer triangular.mutex.Unlock()
+
+	var ret []TriangularCycle
+	for _, exs := range triangular.exchanges {
+		for _, ex := range exs {
+			if ex.name == exchange {
+				ret = append(ret, ex.cycles...)
+			}
+		}
+	}
+	return ret
+}
+
+// FindTriangularCycles returns the cycles of three markets which start and end with one of the specified currencies.
+//
+// Cycles are returned in both directions, since each direction trades different sides of the order books.
+func FindTriangularCycles(markets []*environment.Market, startCurrencies []string) []TriangularCycle {
+	legs := make(map[string][]TriangularLeg)
+	for _, market := range markets {
+		if market.BaseCurrency == "" || market.MarketCurrency == "" || market.BaseCurrency == market.MarketCurrency {
+			continue
+		}
+		legs[market.BaseCurrency] = append(legs[market.BaseCurrency], TriangularLeg{
+			Market: market,
+			From:   market.BaseCurrency,
+			To:     market.MarketCurrency,
+			Side:   environment.Bid,
+		})
+		legs[market.MarketCurrency] = append(legs[market.MarketCurrency], TriangularLeg{
+			Market: market,
+			From:   market.MarketCurrency,
+			To:     market.BaseCurrency,
+			Side:   environment.Ask,
+		})
+	}
+
+	var ret []TriangularCycle
+	for _, start := range startCurrencies {
+		for _, first := range legs[start] {
+			for _, second := range legs[first.To] {
+				if second.To == start || second.Market == first.Market {
+					continue
+				}
+				for _, third := range legs[second.To] {
+					if third.To == start {
+						ret = append(ret, TriangularCycle{Legs: [3]TriangularLeg{first, second, third}})
+					}
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// QuoteTriangularCycle evaluates a cycle starting with the specified amount against the order books of the cache,
+// walking their depth and deducting the taker fees of the exchange from the currency received by each leg.
+func QuoteTriangularCycle(wrapper exchanges.ExchangeWrapper, books *exchanges.OrderbookCache, cycle TriangularCycle, amount decimal.Decimal) (*TriangularQuote, error) {
+	quote := &TriangularQuote{
+		Cycle:    cycle,
+		AmountIn: amount,
+	}
+	for i, leg := range cycle.Legs {
+		book, exists := books.Get(leg.Market)
+		if !exists {
+			return nil, fmt.Errorf("Order book of %s not loaded", leg.Market.Name)
+		}
+
+		legQuote, err := quoteTriangularLeg(wrapper, book, leg, amount)
+		if err != nil {
+			return nil, err
+		}
+		quote.Legs[i] = *legQuote
+		amount = legQuote.AmountOut
+	}
+	quote.AmountOut = amount
+	return quote, nil
+}
+
+// quoteTriangularLeg evaluates a leg spending the specified amount against an order book:
+// buying the market currency consumes the asks, selling it consumes the bids.
+func quoteTriangularLeg(wrapper exchanges.ExchangeWrapper, book *environment.OrderBook, leg TriangularLeg, amount decimal.Decimal) (*TriangularLegQuote, error) {
+	var orders []environment.Order
+	if leg.Side == environment.Bid {
+		orders = append(orders, book.Asks...)
+		sort.Slice(orders, func(i, j int) bool { return orders[i].Value.LessThan(orders[j].Value) })
+	} else {
+		orders = append(orders, book.Bids...)
+		sort.Slice(orders, func(i, j int) bool { return orders[i].Value.GreaterThan(orders[j].Value) })
+	}
+
+	// remaining is in base currency when buying, in market currency when selling.
+	remaining := amount
+	quantity, value, limit := decimal.Zero, decimal.Zero, decimal.Zero
+	for _, order := range orders {
+		if !remaining.IsPositive() {
+			break
+		}
+		if !order.Value.IsPositive() {
+			continue
+		}
+
+		limit = order.Value
+		if leg.Side == environment.Bid {
+			taken := decimal.Min(order.Quantity, remaining.Div(order.Value))
+			quantity = quantity.Add(taken)
+			value = value.Add(taken.Mul(order.Value))
+			remaining = remaining.Sub(taken.Mul(order.Value))
+		} else {
+			taken := decimal.Min(order.Quantity, remaining)
+			quantity = quantity.Add(taken)
+			value = value.Add(taken.Mul(order.Value))
+			remaining = remaining.Sub(taken)
+		}
+	}
+	if remaining.IsPositive() && remaining.GreaterThan(amount.Shift(-8)) {
+		return nil, fmt.Errorf("Order book of %s is not deep enough for %s %s", leg.Market.Name, amount, leg.From)
+	}
+
+	quantity = quantity.Truncate(8)
+	if !quantity.IsPositive() {
+		return nil, fmt.Errorf("Amount %s %s is too small for %s", amount, leg.From, leg.Market.Name)
+	}
+	averagePrice := value.Div(quantity)
+	fee := wrapper.CalculateTradingFees(leg.Market, quantity, averagePrice, exchanges.TakerTrade)
+
+	ret := &TriangularLegQuote{
+		Quantity: quantity,
+		Limit:    limit,
+		AmountIn: amount,
+	}
+	if leg.Side == environment.Bid {
+		// fees are expressed in base currency, so they are converted to the market currency received.
+		ret.AmountOut = quantity.Sub(fee.Div(averagePrice)).Truncate(8)
+	} else {
+		ret.AmountOut = quantity.Mul(averagePrice).Sub(fee).Truncate(8)
+	}
+	return ret, nil
+}
+
+// setup discovers the markets of the scanned exchanges and finds the cycles of a tactic.
+func (triangular *Triangular) setup(wrappers []exchanges.ExchangeWrapper, markets []*environment.Market, tactic *Tactic) error {
+	triangular.mutex.Lock()
+	defer triangular.mutex.Unlock()
+
+	config := triangular.tacticConfig(tactic)
+	startCurrencies := make([]string, 0, len(config.StartAmounts))
+	for currency := range config.StartAmounts {
+		startCurrencies = append(startCurrencies, currency)
+	}
+	sort.Strings(startCurrencies)
+
+	for _, ex := range triangular.exchanges[tactic] {
+		triangular.stats.Cycles -= len(ex.cycles)
+	}
+	var scanned []*triangularExchange
+	for _, wrapper := range wrappers {
+		if !config.scans(wrapper) {
+			continue
+		}
+
+		exchangeMarkets, err := wrapper.GetMarkets()
+		if err != nil {
+			logrus.Warnf("Triangular %s: cannot get markets: %s", wrapper.Name(), err)
+			continue
+		}
+
+		ex := &triangularExchange{
+			name:  wrapper.Name(),
+			books: exchanges.NewOrderbookCache(),
+		}
+		ex.cycles = FindTriangularCycles(config.tradableMarkets(wrapper, exchangeMarkets, markets), startCurrencies)
+		if len(ex.cycles) == 0 {
+			logrus.Warnf("Triangular %s: no cycle found", wrapper.Name())
+			continue
+		}
+
+		traded := make(map[*environment.Market]bool)
+		for _, cycle := range ex.cycles {
+			for _, leg := range cycle.Legs {
+				if !traded[leg.Market] {
+					traded[leg.Market] = true
+					ex.markets = append(ex.markets, leg.Market)
+				}
+			}
+		}
+		if len(ex.markets) > config.MaxMarkets {
+			logrus.Warnf("Triangular %s: %d cycles found on %d markets, more than %d: restrict the currencies to scan it",
+				wrapper.Name(), len(ex.cycles), len(ex.markets), config.MaxMarkets)
+			continue
+		}
+		logrus.Infof("Triangular %s: %d cycles found on %d markets", wrapper.Name(), len(ex.cycles), len(ex.markets))
+
+		if exchanges.GetFeedSupervisor(wrapper) != nil {
+			if err := wrapper.FeedConnect(ex.markets); err != nil {
+				logrus.Warnf("Triangular %s: cannot connect the feed, order books are fetched on each update: %s", wrapper.Name(), err)
+			}
+		}
+
+		triangular.stats.Cycles += len(ex.cycles)
+		scanned = append(scanned, ex)
+	}
+	triangular.exchanges[tactic] = scanned
+
+	if len(scanned) == 0 {
+		return errors.New("Triangular arbitrage found no cycle on the exchanges")
+	}
+	return nil
+}
+
+// scans tells if an exchange is scanned with a configuration.
+func (config TriangularConfig) scans(wrapper exchanges.ExchangeWrapper) bool {
+	if len(config.Exchanges) == 0 {
+		return true
+	}
+	for _, name := range config.Exchanges {
+		if exchanges.IsExchange(wrapper, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// tradableMarkets filters the markets of an exchange by the configured currencies,
+// replacing the ones which are also markets of the bot with the latter.
+func (config TriangularConfig) tradableMarkets(wrapper exchanges.ExchangeWrapper, exchangeMarkets []*environment.Market, botMarkets []*environment.Market) []*environment.Market {
+	allowed := make(map[string]bool)
+	for _, currency := range config.Currencies {
+		allowed[currency] = true
+	}
+	for currency := range config.StartAmounts {
+		allowed[currency] = true
+	}
+
+	ret := make([]*environment.Market, 0, len(exchangeMarkets))
+	for _, market := range exchangeMarkets {
+		if len(config.Currencies) > 0 && (!allowed[market.BaseCurrency] || !allowed[market.MarketCurrency]) {
+			continue
+		}
+		for _, botMarket := range botMarkets {
+			if botMarket.Name == market.Name && exchanges.IsBinded(botMarket, wrapper) {
+				market = botMarket
+				break
+			}
+		}
+		ret = append(ret, market)
+	}
+	return ret
+}
+
+// update checks the pending trades of a tactic, then reads the order books (from the cache of the feeds, when up)
+// and evaluates the cycles of the other exchanges, trading the most profitable one when enabled.
+//
+// Errors of the exchanges are logged, so the strategy keeps running.
+func (triangular *Triangular) update(wrappers []exchanges.ExchangeWrapper, markets []*environment.Market, tactic *Tactic) error {
+	triangular.mutex.Lock()
+	defer triangular.mutex.Unlock()
+
+	config := triangular.tacticConfig(tactic)
+	for _, ex := range triangular.exchanges[tactic] {
+		wrapper := wrapperNamed(wrappers, ex.name)
+		if wrapper == nil {
+			continue
+		}
+
+		if ex.pending != nil {
+			triangular.checkTrade(config, wrapper, ex)
+			continue
+		}
+
+		for _, market := range ex.markets {
+			if book, streamed := exchanges.GetStreamedOrderBook(wrapper, market); streamed {
+				ex.books.Set(market, book)
+				continue
+			}
+
+			// the feed of the market is down (or not streamed), so the order book is requested to the exchange.
+			book, err := wrapper.GetOrderBook(market)
+			if err != nil {
+				logrus.Warnf("Triangular %s %s: cannot get order book: %s", ex.name, market.Name, err)
+				continue
+			}
+			ex.books.Set(market, book)
+		}
+
+		var best *TriangularQuote
+		for _, cycle := range ex.cycles {
+			quote, err := QuoteTriangularCycle(wrapper, ex.books, cycle, config.StartAmounts[cycle.Legs[0].From])
+			if err != nil {
+				logrus.Debugf("Triangular %s %s: %s", ex.name, cycle, err)
+				continue
+			}
+			triangular.stats.Evaluations++
+
+			if quote.Return().LessThan(config.MinProfit) || !quote.AmountOut.GreaterThan(quote.AmountIn) {
+				continue
+			}
+			triangular.stats.Opportunities++
+
+			if best != nil {
+				if quote.Return().LessThanOrEqual(best.Return()) {
+					triangular.skip(ex, quote, "a more profitable cycle has been found")
+					continue
+				}
+				triangular.skip(ex, best, "a more profitable cycle has been found")
+			}
+			best = quote
+		}
+
+		if best == nil {
+			continue
+		}
+		if !config.Execute {
+			triangular.skip(ex, best, "execution disabled")
+			continue
+		}
+		if reason := triangular.validate(wrapper, best); reason != "" {
+			triangular.skip(ex, best, reason)
+			continue
+		}
+		triangular.execute(wrapper, ex, best)
+	}
+	return nil
+}
+
+// validate checks the balances needed by the legs of a quote, returning the reason to skip it (empty if valid).
+func (triangular *Triangular) validate(wrapper exchanges.ExchangeWrapper, quote *TriangularQuote) string {
+	for i, leg := range quote.Cycle.Legs {
+		balance, err := wrapper.GetBalance(leg.From)
+		if err != nil {
+			return "cannot get " + leg.From + " balance: " + err.Error()
+		}
+		if balance.LessThan(quote.Legs[i].AmountIn) {
+			return "not enough " + leg.From + " balance"
+		}
+	}
+	return ""
+}
+
+// skip logs a profitable cycle which is not traded.
+func (triangular *Triangular) skip(ex *triangularExchange, quote *TriangularQuote, reason string) {
+	triangular.stats.Skipped++
+	logrus.Infof("Triangular %s: skipped %s, %s -> %s %s (return %s): %s",
+		ex.name, quote.Cycle, quote.AmountIn, quote.AmountOut, quote.Cycle.Legs[0].From, quote.Return().StringFixed(6), reason)
+}
+
+// execute places the legs of a quote at the same time, as limit orders at the worst price of their estimate.
+//
+// If a leg cannot be placed the other ones are cancelled.
+func (triangular *Triangular) execute(wrapper exchanges.ExchangeWrapper, ex *triangularExchange, quote *TriangularQuote) {
+	var orderIDs [3]string
+	var errs [3]error
+	var wg sync.WaitGroup
+	for i, leg := range quote.Cycle.Legs {
+		wg.Add(1)
+		go func(i int, leg TriangularLeg) {
+			defer wg.Done()
+			legQuote := quote.Legs[i]
+			if leg.Side == environment.Bid {
+				orderIDs[i], errs[i] = wrapper.BuyLimit(leg.Market, legQuote.Quantity, legQuote.Limit)
+			} else {
+				orderIDs[i], errs[i] = wrapper.SellLimit(leg.Market, legQuote.Quantity, legQuote.Limit)
+			}
+		}(i, leg)
+	}
+	wg.Wait()
+
+	failed := false
+	for i, err := range errs {
+		if err != nil {
+			failed = true
+			logrus.Errorf("Triangular %s %s: cannot place leg %s -> %s: %s", ex.name, quote.Cycle, quote.Cycle.Legs[i].From, quote.Cycle.Legs[i].To, err)
+		}
+	}
+	if failed {
+		triangular.stats.Failed++
+		for i, orderID := range orderIDs {
+			if errs[i] == nil {
+				triangular.cancelLeg(wrapper, ex, quote, i, orderID)
+			}
+		}
+		return
+	}
+
+	triangular.stats.Executed++
+	logrus.Infof("Triangular %s: trading %s, %s -> %s %s (return %s)",
+		ex.name, quote.Cycle, quote.AmountIn, quote.AmountOut, quote.Cycle.Legs[0].From, quote.Return().StringFixed(6))
+	ex.pending = &triangularTrade{
+		quote:    quote,
+		orderIDs: orderIDs,
+	}
+}
+
+// checkTrade checks the legs of the pending trade of an exchange: when all are filled the trade is completed,
+// when they are not filled within the configured checks the open ones are cancelled.
+func (triangular *Triangular) checkTrade(config TriangularConfig, wrapper exchanges.ExchangeWrapper, ex *triangularExchange) {
+	trade := ex.pending
+	filled := 0
+	for i, leg := range trade.quote.Cycle.Legs {
+		order, err := wrapper.GetOrder(leg.Market, trade.orderIDs[i])
+		if err == nil && order.Status == environment.OrderFilled {
+			filled++
+		}
+	}
+	if filled == len(trade.quote.Cycle.Legs) {
+		triangular.stats.Completed++
+		logrus.Infof("Triangular %s: trade %s completed, estimated profit %s %s",
+			ex.name, trade.quote.Cycle, trade.quote.AmountOut.Sub(trade.quote.AmountIn), trade.quote.Cycle.Legs[0].From)
+		ex.pending = nil
+		return
+	}
+
+	trade.checks++
+	if trade.checks < config.LegChecks {
+		return
+	}
+
+	logrus.Warnf("Triangular %s: legs of %s not filled in time, cancelling them", ex.name, trade.quote.Cycle)
+	triangular.stats.Failed++
+	triangular.cancelTrade(wrapper, ex)
+}
+
+// cancelTrade cancels the open legs of the pending trade of an exchange.
+func (triangular *Triangular) cancelTrade(wrapper exchanges.ExchangeWrapper, ex *triangularExchange) {
+	trade := ex.pending
+	for i, orderID := range trade.orderIDs {
+		triangular.cancelLeg(wrapper, ex, trade.quote, i, orderID)
+	}
+	ex.pending = nil
+}
+
+// cancelLeg cancels a leg of a trade, logging the imbalance left by its partial fill.
+func (triangular *Triangular) cancelLeg(wrapper exchanges.ExchangeWrapper, ex *triangularExchange, quote *TriangularQuote, i int, orderID string) {
+	leg := quote.Cycle.Legs[i]
+	filled := cancelLeg(wrapper, leg.Market, orderID)
+	if !filled.Equal(quote.Legs[i].Quantity) {
+		logrus.Warnf("Triangular %s %s: leg %s -> %s filled %s of %s %s, balances left unbalanced",
+			ex.name, quote.Cycle, leg.From, leg.To, filled, quote.Legs[i].Quantity, leg.Market.MarketCurrency)
+	}
+}
+
+// tearDown cancels the pending trades of a tactic and logs the activity of the strategy.
+func (triangular *Triangular) tearDown(wrappers []exchanges.ExchangeWrapper, markets []*environment.Market, tactic *Tactic) error {
+	triangular.mutex.Lock()
+	defer triangular.mutex.Unlock()
+
+	config := triangular.tacticConfig(tactic)
+	for _, ex := range triangular.exchanges[tactic] {
+		wrapper := wrapperNamed(wrappers, ex.name)
+		if ex.pending == nil || wrapper == nil {
+			continue
+		}
+
+		triangular.checkTrade(config, wrapper, ex)
+		if ex.pending != nil {
+			triangular.stats.Failed++
+			triangular.cancelTrade(wrapper, ex)
+		}
+	}
+
+	stats := triangular.stats
+	logrus.Infof("Triangular: %d cycles, %d evaluations, %d opportunities, %d skipped, %d executed, %d completed, %d failed",
+		stats.Cycles, stats.Evaluations, stats.Opportunities, stats.Skipped, stats.Executed, stats.Completed, stats.Failed)
+	return nil
+}
@@ -1,6 +1,7 @@
 package strategies
 
 import (
+	"context"
 	"errors"
 
 	"github.com/saniales/golang-crypto-trading-bot/environment"
@@ -26,15 +27,50 @@ func (wss WebsocketStrategy) String() string {
 
 // Apply executes Cyclically the On Update, basing on provided interval.
 func (wss WebsocketStrategy) Apply(wrappers []exchanges.ExchangeWrapper, markets []*environment.Market) {
+	wss.ApplyContext(context.Background(), wrappers, markets)
+}
+
+// ApplyContext executes the Setup, keeping the feeds connected until the context is done, then executes the TearDown.
+func (wss WebsocketStrategy) ApplyContext(ctx context.Context, wrappers []exchanges.ExchangeWrapper, markets []*environment.Market) {
+	tactic, err := NewTactic(wss, markets, nil)
+	if err != nil {
+		if wss.Model.OnError != nil {
+			wss.Model.OnError(err)
+		}
+		return
+	}
+	wss.ApplyTactic(ctx, wrappers, tactic)
+}
+
+// ParamSpecs returns the parameters declared by the model.
+func (wss WebsocketStrategy) ParamSpecs() []ParamSpec {
+	return wss.Model.Params
+}
+
+// ApplyTactic executes the Setup with the markets and the parameters of a tactic, keeping the feeds connected
+// until the context is done, then executes the TearDown.
+func (wss WebsocketStrategy) ApplyTactic(ctx context.Context, wrappers []exchanges.ExchangeWrapper, tactic *Tactic) {
 	var err error
 
-	hasSetupFunc := wss.Model.Setup != nil
-	hasTearDownFunc := wss.Model.TearDown != nil
-	hasUpdateFunc := wss.Model.OnUpdate != nil
+	markets := tactic.Markets
+
+	setup, tearDown := wss.Model.SetupFunc(), wss.Model.TearDownFunc()
+	hasSetupFunc := setup != nil
+	hasTearDownFunc := tearDown != nil
+	hasUpdateFunc := wss.Model.UpdateFunc() != nil
 	hasErrorFunc := wss.Model.OnError != nil
 
+	if wss.Model.OnFeedStatus != nil {
+		for _, wrapper := range wrappers {
+			if supervisor := exchanges.GetFeedSupervisor(wrapper); supervisor != nil {
+				stopWatching := supervisor.Watch(wss.Model.OnFeedStatus)
+				defer stopWatching()
+			}
+		}
+	}
+
 	if hasSetupFunc {
-		err = wss.Model.Setup(wrappers, markets)
+		err = setup(exchanges.BindContext(ctx, wrappers), markets, tactic)
 		if err != nil && hasErrorFunc {
 			wss.Model.OnError(err)
 		}
@@ -50,8 +86,13 @@ func (wss WebsocketStrategy) Apply(wrappers []exchanges.ExchangeWrapper, markets
 		}
 	}
 
+	// feeds are bound to the context, keep them open until it is done.
+	if done := ctx.Done(); done != nil {
+		<-done
+	}
+
 	if hasTearDownFunc {
-		err = wss.Model.TearDown(wrappers, markets)
+		err = tearDown(wrappers, markets, tactic)
 		if err != nil && hasErrorFunc {
 			wss.Model.OnError(err)
 		}